@@ -3,6 +3,7 @@ package bus
 import (
 	"bytes"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/lugu/qiloop/bus/net"
@@ -20,6 +21,16 @@ const (
 	KeyToken = "auth_token"
 	// KeyNewToken is the key with the new token sent in the capability map
 	KeyNewToken = "auth_newToken"
+	// KeyCertSubject is the key of the subject of the verified client
+	// certificate in the capability map. It is set by the server.
+	KeyCertSubject = "auth_certSubject"
+	// KeyHeartbeat is the capability indicating the support of the
+	// Ping and Pong messages used as heartbeats.
+	KeyHeartbeat = "QiloopHeartbeat"
+	// KeyCompression is the capability listing the compression
+	// algorithms supported by the client (comma separated, by order
+	// of preference). The server responds with the selected one.
+	KeyCompression = "QiloopCompression"
 
 	// StateError indicates an authentication failure.
 	StateError uint32 = 1
@@ -56,6 +67,77 @@ func (c CapabilityMap) Authenticated() bool {
 	return false
 }
 
+// Enabled returns true if the boolean capability is set.
+func (c CapabilityMap) Enabled(name string) bool {
+	enabled, ok := c[name].(value.BoolValue)
+	return ok && enabled.Value()
+}
+
+// negotiate disables the boolean capabilities of prefered which are
+// not enabled by resp.
+func (c CapabilityMap) negotiate(resp CapabilityMap) {
+	for name, v := range c {
+		if _, ok := v.(value.BoolValue); ok && !resp.Enabled(name) {
+			c[name] = value.Bool(false)
+		}
+	}
+}
+
+// keepAlive monitors the connection with net.DefaultKeepAlive if both
+// sides enabled the capability KeyHeartbeat. The peers without this
+// capability (libqi) are not monitored: they might not answer the
+// heartbeats.
+func keepAlive(endpoint net.EndPoint, c CapabilityMap) {
+	if !c.Enabled(KeyHeartbeat) {
+		return
+	}
+	k := net.DefaultKeepAlive
+	k.Ping = true
+	// the endpoint might not support keep alive or already be
+	// monitored.
+	net.SetKeepAlive(endpoint, k)
+}
+
+// algorithms returns the compression algorithms of the capability
+// KeyCompression.
+func (c CapabilityMap) algorithms() []string {
+	list, ok := c[KeyCompression].(value.StringValue)
+	if !ok || list.Value() == "" {
+		return nil
+	}
+	return strings.Split(list.Value(), ",")
+}
+
+// selectCompression returns the first algorithm of offered which is
+// supported. It returns an empty string if none is supported.
+func selectCompression(offered, supported []string) string {
+	for _, algo := range offered {
+		for _, s := range supported {
+			if algo == s {
+				return algo
+			}
+		}
+	}
+	return ""
+}
+
+// compression enables the compression of the messages sent to the
+// peer with the algorithm selected during the authentication. The
+// selected algorithm is recorded in c or KeyCompression is removed.
+func compression(endpoint net.EndPoint, c CapabilityMap, selected string) {
+	if selected == "" {
+		delete(c, KeyCompression)
+		return
+	}
+	comp := net.DefaultCompression
+	comp.Algorithm = selected
+	if err := net.SetCompression(endpoint, comp); err != nil {
+		delete(c, KeyCompression)
+		return
+	}
+	c[KeyCompression] = value.String(selected)
+}
+
 // SetAuthenticated force the done status in the capability map.
 func (c CapabilityMap) SetAuthenticated() {
 	c[KeyState] = value.Uint(StateDone)
@@ -70,6 +152,11 @@ func PreferedCap(user, token string) CapabilityMap {
 		"MetaObjectCache":       value.Bool(false),
 		"RemoteCancelableCalls": value.Bool(false),
 		"ObjectPtrUID":          value.Bool(false),
+		KeyHeartbeat:            value.Bool(true),
+	}
+	if len(net.CompressionAlgorithms) != 0 {
+		permissions[KeyCompression] = value.String(
+			strings.Join(net.CompressionAlgorithms, ","))
 	}
 	if user != "" {
 		permissions[KeyUser] = value.String(user)
@@ -191,14 +278,55 @@ func AuthenticateUser(endpoint net.EndPoint, user, token string) error {
 
 // Authenticate runs the authentication procedure of a given
 // connection. If the clients fails to authenticate itself, it returns
-// an error. In response the connection shall be closed.
+// an error. In response the connection shall be closed. The
+// credentials are read from token.AuthFile: if the server issues a new
+// token, the file is updated with it.
 func Authenticate(endpoint net.EndPoint) error {
-	user, token := token.GetUserToken()
-	return AuthenticateUser(endpoint, user, token)
+	user, oldToken := token.GetUserToken()
+	prefered := PreferedCap(user, oldToken)
+	err := Authentication(endpoint, prefered)
+	if err != nil {
+		return err
+	}
+	newToken, ok := prefered[KeyNewToken].(value.StringValue)
+	if !ok || newToken.Value() == oldToken {
+		return nil
+	}
+	err = token.WriteUserToken(user, newToken.Value())
+	if err != nil {
+		return fmt.Errorf("save new token: %s", err)
+	}
+	return nil
+}
+
+// AuthenticateCredentials runs the authentication procedure with the
+// credentials. If the server issues a new token, the credentials are
+// updated and saved in their profile.
+func AuthenticateCredentials(endpoint net.EndPoint, c *token.Credentials) error {
+	prefered := PreferedCap(c.User, c.Token)
+	err := Authentication(endpoint, prefered)
+	if err != nil {
+		return err
+	}
+	newToken, ok := prefered[KeyNewToken].(value.StringValue)
+	if !ok || newToken.Value() == c.Token {
+		return nil
+	}
+	c.Token = newToken.Value()
+	if c.Profile == "" {
+		return nil
+	}
+	err = token.WriteProfile(*c)
+	if err != nil {
+		return fmt.Errorf("save new token: %s", err)
+	}
+	return nil
 }
 
 // Authentication runs the authentication procedure. The prefered
-// CapabilityMap is updated with the negociated capabilities.
+// CapabilityMap is updated with the negociated capabilities: the
+// KeyCompression entry contains the compression algorithm selected
+// by the server, if any.
 // If a new token was issued, it is store in the KeyNewToken entry of
 // the prefered capapbility map.
 func Authentication(endpoint net.EndPoint, prefered CapabilityMap) error {
@@ -222,9 +350,20 @@ func Authentication(endpoint net.EndPoint, prefered CapabilityMap) error {
 	}
 	switch uint32(status) {
 	case StateDone:
+		prefered.negotiate(resp)
+		keepAlive(endpoint, prefered)
+		compression(endpoint, prefered, selectCompression(
+			resp.algorithms(), prefered.algorithms()))
 		return nil
 	case StateContinue:
-		return authenticateContinue(endpoint, prefered, resp)
+		err = authenticateContinue(endpoint, prefered, resp)
+		if err == nil {
+			prefered.negotiate(resp)
+			keepAlive(endpoint, prefered)
+			compression(endpoint, prefered, selectCompression(
+				resp.algorithms(), prefered.algorithms()))
+		}
+		return err
 	case StateError:
 		return fmt.Errorf("Authentication failed")
 	default:
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"testing"
+	"time"
 
 	"github.com/lugu/qiloop/bus"
 	"github.com/lugu/qiloop/bus/net"
@@ -374,3 +375,38 @@ func TestAuthError(t *testing.T) {
 	}
 	endpoint.Close()
 }
+
+func TestAuthKeepAlive(t *testing.T) {
+	// the peer does not advertise the heartbeats (libqi).
+	endpoint := NewServer(func(user, token string) bus.CapabilityMap {
+		return bus.CapabilityMap{
+			bus.KeyState: value.Uint(bus.StateDone),
+		}
+	})
+	defer endpoint.Close()
+	if err := bus.AuthenticateUser(endpoint, "a", "b"); err != nil {
+		t.Fatal(err)
+	}
+	// the endpoint is not monitored.
+	if err := net.SetKeepAlive(endpoint, net.KeepAlive{
+		Timeout: time.Hour,
+	}); err != nil {
+		t.Errorf("keep alive armed: %s", err)
+	}
+
+	endpoint = NewServer(func(user, token string) bus.CapabilityMap {
+		return bus.CapabilityMap{
+			bus.KeyState:     value.Uint(bus.StateDone),
+			bus.KeyHeartbeat: value.Bool(true),
+		}
+	})
+	defer endpoint.Close()
+	if err := bus.AuthenticateUser(endpoint, "a", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := net.SetKeepAlive(endpoint, net.KeepAlive{
+		Timeout: time.Hour,
+	}); err == nil {
+		t.Errorf("keep alive not armed")
+	}
+}
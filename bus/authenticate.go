@@ -27,6 +27,41 @@ type Authenticator interface {
 	Authenticate(user, token string) bool
 }
 
+// CapabilityAuthenticator is an Authenticator which decides using
+// the capability map sent by the client. The map includes the subject
+// of the client certificate (KeyCertSubject) when the connection uses
+// a verified client certificate.
+type CapabilityAuthenticator interface {
+	Authenticator
+	AuthenticateCapability(cap CapabilityMap) bool
+}
+
+// CertificateSubjects is a CapabilityAuthenticator which accepts the
+// clients presenting a verified certificate with one of the given
+// subjects (for example "CN=robot").
+func CertificateSubjects(subjects ...string) CapabilityAuthenticator {
+	return certificateSubjects(subjects)
+}
+
+type certificateSubjects []string
+
+func (c certificateSubjects) Authenticate(user, token string) bool {
+	return false
+}
+
+func (c certificateSubjects) AuthenticateCapability(cap CapabilityMap) bool {
+	subject, ok := cap[KeyCertSubject].(value.StringValue)
+	if !ok {
+		return false
+	}
+	for _, s := range c {
+		if s == subject.Value() {
+			return true
+		}
+	}
+	return false
+}
+
 // Dictionary is an Authenticator which reads its permission from a
 // dictionnary.
 func Dictionary(passwords map[string]string) Authenticator {
@@ -143,7 +178,40 @@ func (s *serviceAuthenticate) capError() CapabilityMap {
 	}
 }
 
+// Authenticate verifies the capability map of the client. Once
+// authenticated, the connection is monitored if the client supports
+// heartbeats and the messages are compressed with the first algorithm
+// of the client also supported by the server.
 func (s *serviceAuthenticate) Authenticate(from Channel, cap CapabilityMap) CapabilityMap {
+	ret := s.authenticate(from, cap)
+	if !ret.Authenticated() {
+		return ret
+	}
+	if cap.Enabled(KeyHeartbeat) && from.Cap().Enabled(KeyHeartbeat) {
+		keepAlive(from.EndPoint(), from.Cap())
+	}
+	compression(from.EndPoint(), from.Cap(), selectCompression(
+		cap.algorithms(), from.Cap().algorithms()))
+	return ret
+}
+
+func (s *serviceAuthenticate) authenticate(from Channel, cap CapabilityMap) CapabilityMap {
+	// the certificate subject can not be set by the client.
+	delete(cap, KeyCertSubject)
+	if c := net.PeerCertificate(from.EndPoint()); c != nil {
+		subject := value.String(c.Subject.String())
+		cap[KeyCertSubject] = subject
+		from.Cap()[KeyCertSubject] = subject
+	}
+	if auth, ok := s.auth.(CapabilityAuthenticator); ok {
+		if auth.AuthenticateCapability(cap) {
+			// the user name sent by the client is not verified:
+			// the client is identified by its certificate.
+			from.SetAuthenticated()
+			return from.Cap()
+		}
+		return s.capError()
+	}
 	var user, token string
 	if userValue, ok := cap[KeyUser]; ok {
 		if userStr, ok := userValue.(value.StringValue); ok {
@@ -159,7 +227,19 @@ func (s *serviceAuthenticate) Authenticate(from Channel, cap CapabilityMap) Capa
 			return s.capError()
 		}
 	}
+	if auth, ok := s.auth.(TokenAuthenticator); ok {
+		if newToken, ok := auth.IssueToken(user, token); ok {
+			return CapabilityMap{
+				KeyState:    value.Uint(StateContinue),
+				KeyNewToken: value.String(newToken),
+			}
+		}
+	}
 	if s.auth.Authenticate(user, token) {
+		// the user name is recorded for the Authorizer.
+		if user != "" {
+			from.Cap()[KeyUser] = value.String(user)
+		}
 		from.SetAuthenticated()
 		return from.Cap()
 	}
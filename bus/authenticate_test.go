@@ -6,8 +6,11 @@ import (
 	"github.com/lugu/qiloop/bus"
 	"github.com/lugu/qiloop/bus/net"
 	"github.com/lugu/qiloop/bus/util"
+	"github.com/lugu/qiloop/type/object"
 	"github.com/lugu/qiloop/type/value"
 	"io"
+	gonet "net"
+	"strings"
 	"testing"
 )
 
@@ -146,3 +149,116 @@ func TestAuthenticateYesNo(t *testing.T) {
 		panic("error")
 	}
 }
+
+func TestCertificateSubjects(t *testing.T) {
+	auth := bus.CertificateSubjects("CN=client")
+	if auth.Authenticate("CN=client", "") {
+		t.Errorf("user and token are not accepted")
+	}
+	if !auth.AuthenticateCapability(bus.CapabilityMap{
+		bus.KeyCertSubject: value.String("CN=client"),
+	}) {
+		t.Errorf("subject shall be accepted")
+	}
+
+	addr := util.NewUnixAddr()
+	listener, err := net.Listen(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, err := bus.StandAloneServer(listener, auth, bus.PrivateNamespace())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Terminate()
+	ep, err := net.DialEndPoint(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep.Close()
+	// the subject sent by the client is ignored.
+	cap := bus.PreferedCap("", "")
+	cap[bus.KeyCertSubject] = value.String("CN=client")
+	if err := bus.Authentication(ep, cap); err == nil {
+		t.Errorf("shall not pass")
+	}
+}
+
+// rawAuthenticate sends the capability map to the server and returns
+// its response without processing it.
+func rawAuthenticate(t *testing.T, addr string, cap bus.CapabilityMap) bus.CapabilityMap {
+	conn, err := gonet.Dial("unix", strings.TrimPrefix(addr, "unix://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var buf bytes.Buffer
+	if err = bus.WriteCapabilityMap(cap, &buf); err != nil {
+		t.Fatal(err)
+	}
+	hdr := net.NewHeader(net.Call, 0, 0, object.AuthenticateActionID, 1)
+	msg := net.NewMessage(hdr, buf.Bytes())
+	if err = msg.Write(conn); err != nil {
+		t.Fatal(err)
+	}
+	if err = msg.Read(conn); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Header.Type != net.Reply || msg.Header.Flags != 0 {
+		t.Fatalf("unexpected response: %v", msg.Header)
+	}
+	resp, err := bus.ReadCapabilityMap(bytes.NewBuffer(msg.Payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestCompressionNegotiation(t *testing.T) {
+	addr := util.NewUnixAddr()
+	listener, err := net.Listen(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, err := bus.StandAloneServer(listener, bus.Yes{},
+		bus.PrivateNamespace())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Terminate()
+
+	ep, err := net.DialEndPoint(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep.Close()
+	cap := bus.PreferedCap("", "")
+	if err = bus.Authentication(ep, cap); err != nil {
+		t.Fatal(err)
+	}
+	expected := value.String(net.CompressionAlgorithms[0])
+	if cap[bus.KeyCompression] != expected {
+		t.Errorf("unexpected compression: %v", cap[bus.KeyCompression])
+	}
+
+	// the server selects the prefered algorithm of the client.
+	cap = bus.PreferedCap("", "")
+	cap[bus.KeyCompression] = value.String("unknown,zlib,gzip")
+	resp := rawAuthenticate(t, addr, cap)
+	if resp[bus.KeyCompression] != value.String("zlib") {
+		t.Errorf("unexpected compression: %v", resp[bus.KeyCompression])
+	}
+
+	// peers which do not support compression are not advertised.
+	cap = bus.PreferedCap("", "")
+	delete(cap, bus.KeyCompression)
+	resp = rawAuthenticate(t, addr, cap)
+	if algo, ok := resp[bus.KeyCompression]; ok {
+		t.Errorf("unexpected compression: %v", algo)
+	}
+	cap[bus.KeyCompression] = value.String("unknown")
+	resp = rawAuthenticate(t, addr, cap)
+	if algo, ok := resp[bus.KeyCompression]; ok {
+		t.Errorf("unexpected compression: %v", algo)
+	}
+}
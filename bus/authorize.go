@@ -0,0 +1,291 @@
+package bus
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/lugu/qiloop/bus/net"
+	"github.com/lugu/qiloop/type/basic"
+	"github.com/lugu/qiloop/type/object"
+	"github.com/lugu/qiloop/type/value"
+)
+
+// ErrNotAuthorized is returned when the Authorizer of a server denies
+// a call.
+var ErrNotAuthorized = errors.New("Not authorized")
+
+// Access kinds of a Request.
+const (
+	// AccessCall is a call to a method of a service.
+	AccessCall = "call"
+	// AccessRead reads a property or the description of an object.
+	AccessRead = "read"
+	// AccessWrite modifies a property or the state of an object.
+	AccessWrite = "write"
+	// AccessSubscribe subscribes to a signal or a property.
+	AccessSubscribe = "subscribe"
+)
+
+// genericAccess contains the access kinds of the generic methods of
+// the objects (see object.ObjectMetaObject).
+var genericAccess = map[string]string{
+	"registerEvent":              AccessSubscribe,
+	"unregisterEvent":            AccessSubscribe,
+	"registerEventWithSignature": AccessSubscribe,
+	"metaObject":                 AccessRead,
+	"property":                   AccessRead,
+	"properties":                 AccessRead,
+	"isStatsEnabled":             AccessRead,
+	"stats":                      AccessRead,
+	"isTraceEnabled":             AccessRead,
+	"terminate":                  AccessWrite,
+	"setProperty":                AccessWrite,
+	"enableStats":                AccessWrite,
+	"clearStats":                 AccessWrite,
+	"enableTrace":                AccessWrite,
+}
+
+// Request describes a message submitted to an Authorizer.
+type Request struct {
+	// Cap is the capability map of the connection.
+	Cap CapabilityMap
+	// User is the user name verified during the authentication. It
+	// is empty when the client is authenticated by its certificate.
+	User string
+	// Subject is the subject of the verified client certificate.
+	Subject   string
+	Service   string
+	ServiceID uint32
+	ObjectID  uint32
+	ActionID  uint32
+	// Action is the name of the method called. When a property is
+	// accessed, Action is the name of the property. When a signal
+	// is subscribed, Action is the name of the signal.
+	Action string
+	// Access is one of AccessCall, AccessRead, AccessWrite and
+	// AccessSubscribe.
+	Access string
+}
+
+// Authorizer decides if an authenticated connection can send a
+// message to a service. It is consulted by the Router of a server for
+// each call and post.
+type Authorizer interface {
+	Authorize(r *Request) bool
+}
+
+// newRequest describes the message m sent by from to the service
+// named name. meta is the meta object of the destination object.
+func newRequest(m *net.Message, from Channel, name string,
+	meta object.MetaObject) *Request {
+
+	r := &Request{
+		Cap:       from.Cap(),
+		Service:   name,
+		ServiceID: m.Header.Service,
+		ObjectID:  m.Header.Object,
+		ActionID:  m.Header.Action,
+		Action:    strconv.FormatUint(uint64(m.Header.Action), 10),
+		Access:    AccessCall,
+	}
+	if user, ok := r.Cap[KeyUser].(value.StringValue); ok {
+		r.User = user.Value()
+	}
+	if subject, ok := r.Cap[KeyCertSubject].(value.StringValue); ok {
+		r.Subject = subject.Value()
+	}
+	method, ok := meta.Methods[m.Header.Action]
+	if !ok {
+		return r
+	}
+	r.Action = method.Name
+	if m.Header.Action >= object.MinUserActionID {
+		return r
+	}
+	if access, ok := genericAccess[method.Name]; ok {
+		r.Access = access
+	}
+	// resolve the name of the property or the signal.
+	buf := bytes.NewBuffer(m.Payload)
+	switch method.Name {
+	case "property", "setProperty":
+		v, err := value.NewValue(buf)
+		if err != nil {
+			return r
+		}
+		switch name := v.(type) {
+		case value.StringValue:
+			r.Action = name.Value()
+		case value.UintValue:
+			if prop, err := meta.PropertyName(name.Value()); err == nil {
+				r.Action = prop
+			}
+		}
+	case "registerEvent", "unregisterEvent", "registerEventWithSignature":
+		if _, err := basic.ReadUint32(buf); err != nil {
+			return r
+		}
+		id, err := basic.ReadUint32(buf)
+		if err != nil {
+			return r
+		}
+		if name, err := meta.ActionName(id); err == nil {
+			r.Action = name
+		}
+	}
+	return r
+}
+
+// Rule is an entry of a Policy. The Principal, Service and Action
+// patterns use the syntax of path.Match.
+type Rule struct {
+	Allow bool
+	// Principal is "*", "user:<pattern>" or "cert:<pattern>".
+	Principal string
+	Service   string
+	Action    string
+	// Access lists the access kinds matched by the rule. An empty
+	// list matches all access kinds.
+	Access []string
+}
+
+// match returns true if the rule applies to the request.
+func (rule Rule) match(r *Request) bool {
+	glob := func(pattern, name string) bool {
+		ok, _ := path.Match(pattern, name)
+		return ok
+	}
+	switch {
+	case rule.Principal == "*":
+	case strings.HasPrefix(rule.Principal, "user:"):
+		if r.User == "" || !glob(rule.Principal[5:], r.User) {
+			return false
+		}
+	case strings.HasPrefix(rule.Principal, "cert:"):
+		if r.Subject == "" || !glob(rule.Principal[5:], r.Subject) {
+			return false
+		}
+	default:
+		return false
+	}
+	if !glob(rule.Service, r.Service) || !glob(rule.Action, r.Action) {
+		return false
+	}
+	if len(rule.Access) == 0 {
+		return true
+	}
+	for _, access := range rule.Access {
+		if access == r.Access {
+			return true
+		}
+	}
+	return false
+}
+
+// Policy is an Authorizer which applies the first rule matching a
+// request. The requests matching no rule are denied.
+type Policy []Rule
+
+// Authorize returns true if the first matching rule allows the
+// request.
+func (p Policy) Authorize(r *Request) bool {
+	for _, rule := range p {
+		if rule.match(r) {
+			return rule.Allow
+		}
+	}
+	return false
+}
+
+// ReadPolicy parses a policy file. Each line describes a rule:
+//
+//	allow|deny <principal> <service> <action> [<access>,...]
+//
+// For example:
+//
+//	allow user:nao * *
+//	allow * ServiceDirectory *
+//	allow user:guest ALMemory * read,subscribe
+//	deny cert:CN=tablet ALMotion set*
+//
+// Empty lines and lines starting with # are ignored.
+func ReadPolicy(r io.Reader) (Policy, error) {
+	var policy Policy
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		rule, err := parseRule(strings.Fields(text))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err)
+		}
+		policy = append(policy, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read policy: %s", err)
+	}
+	return policy, nil
+}
+
+func parseRule(fields []string) (rule Rule, err error) {
+	if len(fields) != 4 && len(fields) != 5 {
+		return rule, fmt.Errorf("invalid rule: %s",
+			strings.Join(fields, " "))
+	}
+	switch fields[0] {
+	case "allow":
+		rule.Allow = true
+	case "deny":
+	default:
+		return rule, fmt.Errorf("unknown effect: %s", fields[0])
+	}
+	rule.Principal, rule.Service, rule.Action = fields[1], fields[2], fields[3]
+	patterns := []string{rule.Service, rule.Action}
+	switch {
+	case rule.Principal == "*":
+	case strings.HasPrefix(rule.Principal, "user:"),
+		strings.HasPrefix(rule.Principal, "cert:"):
+		patterns = append(patterns, rule.Principal[5:])
+	default:
+		return rule, fmt.Errorf("invalid principal: %s", rule.Principal)
+	}
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return rule, fmt.Errorf("invalid pattern: %s", pattern)
+		}
+	}
+	if len(fields) == 5 {
+		for _, access := range strings.Split(fields[4], ",") {
+			switch access {
+			case AccessCall, AccessRead, AccessWrite, AccessSubscribe:
+				rule.Access = append(rule.Access, access)
+			default:
+				return rule, fmt.Errorf("unknown access: %s", access)
+			}
+		}
+	}
+	return rule, nil
+}
+
+// LoadPolicy reads a policy file.
+func LoadPolicy(filename string) (Policy, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("policy: %s", err)
+	}
+	defer file.Close()
+	policy, err := ReadPolicy(file)
+	if err != nil {
+		return nil, fmt.Errorf("policy: %s: %s", filename, err)
+	}
+	return policy, nil
+}
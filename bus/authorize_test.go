@@ -0,0 +1,151 @@
+package bus_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/lugu/qiloop/bus"
+	"github.com/lugu/qiloop/bus/net"
+	"github.com/lugu/qiloop/bus/util"
+)
+
+const testPolicy = `
+# nao can do anything
+allow user:nao * *
+allow cert:CN=robot* Bomb *
+deny user:guest Bomb delay write
+allow user:guest Bomb * read,subscribe
+`
+
+func TestReadPolicy(t *testing.T) {
+	policy, err := bus.ReadPolicy(strings.NewReader(testPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(policy) != 4 {
+		t.Fatalf("unexpected policy: %v", policy)
+	}
+	invalid := []string{
+		"allow * *",
+		"permit * * *",
+		"allow nao * *",
+		"allow * [ *",
+		"allow * * * execute",
+	}
+	for _, s := range invalid {
+		if _, err = bus.ReadPolicy(strings.NewReader(s)); err == nil {
+			t.Errorf("invalid policy accepted: %s", s)
+		}
+	}
+}
+
+func TestPolicyAuthorize(t *testing.T) {
+	policy, err := bus.ReadPolicy(strings.NewReader(testPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		req     bus.Request
+		allowed bool
+	}{
+		{bus.Request{User: "nao", Service: "Any", Action: "any",
+			Access: bus.AccessCall}, true},
+		{bus.Request{Subject: "CN=robot1", Service: "Bomb",
+			Action: "delay", Access: bus.AccessWrite}, true},
+		{bus.Request{Subject: "CN=tablet", Service: "Bomb",
+			Action: "delay", Access: bus.AccessWrite}, false},
+		{bus.Request{User: "guest", Service: "Bomb", Action: "delay",
+			Access: bus.AccessRead}, true},
+		{bus.Request{User: "guest", Service: "Bomb", Action: "boom",
+			Access: bus.AccessSubscribe}, true},
+		{bus.Request{User: "guest", Service: "Bomb", Action: "delay",
+			Access: bus.AccessWrite}, false},
+		{bus.Request{User: "guest", Service: "Bomb", Action: "explode",
+			Access: bus.AccessCall}, false},
+		{bus.Request{Service: "Bomb", Action: "metaObject",
+			Access: bus.AccessRead}, false},
+	}
+	for _, test := range tests {
+		if policy.Authorize(&test.req) != test.allowed {
+			t.Errorf("unexpected decision: %#v", test.req)
+		}
+	}
+}
+
+func TestAuthorizer(t *testing.T) {
+	addr := util.NewUnixAddr()
+	listener, err := net.Listen(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := bus.Dictionary(map[string]string{
+		"nao":   "nao",
+		"guest": "guest",
+	})
+	srv, err := bus.StandAloneServer(listener, auth, bus.PrivateNamespace())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Terminate()
+	policy, err := bus.ReadPolicy(strings.NewReader(testPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.SetAuthorizer(policy)
+	service, err := srv.NewService("Bomb", NewBombObject())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bomb := func(user string) BombProxy {
+		ep, err := net.DialEndPoint(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = bus.AuthenticateUser(ep, user, user); err != nil {
+			t.Fatal(err)
+		}
+		client := bus.NewClient(ep)
+		meta, err := bus.GetMetaObject(client, service.ServiceID(), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		proxy := bus.NewProxy(client, meta, service.ServiceID(), 1)
+		return MakeBomb(nil, proxy)
+	}
+
+	guest := bomb("guest")
+	if _, err = guest.GetDelay(); err != nil {
+		t.Error(err)
+	}
+	cancel, _, err := guest.SubscribeBoom()
+	if err != nil {
+		t.Error(err)
+	} else {
+		cancel()
+	}
+	err = guest.SetDelay(12)
+	if err == nil || !strings.Contains(err.Error(), "Not authorized") {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	nao := bomb("nao")
+	if err = nao.SetDelay(12); err != nil {
+		t.Error(err)
+	}
+	delay, err := guest.GetDelay()
+	if err != nil {
+		t.Error(err)
+	} else if delay != 12 {
+		t.Errorf("unexpected delay: %d", delay)
+	}
+
+	// the local clients are not subject to the policy.
+	proxy, err := srv.Session().Proxy("Bomb", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = MakeBomb(nil, proxy).SetDelay(13); err != nil {
+		t.Error(err)
+	}
+}
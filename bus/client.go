@@ -7,9 +7,28 @@ import (
 	"sync"
 
 	"github.com/lugu/qiloop/bus/net"
+	"github.com/lugu/qiloop/bus/session/token"
 	"github.com/lugu/qiloop/type/value"
 )
 
+// connectionError is returned when a call cannot be sent.
+type connectionError struct {
+	error
+}
+
+// IsConnectionError returns true if a call could not be sent: the
+// call has not reached the service and can be sent again.
+func IsConnectionError(err error) bool {
+	_, ok := err.(connectionError)
+	return ok
+}
+
+// IsDisconnected returns true if a call failed because its connection
+// was lost after it was sent: the call may have been executed.
+func IsDisconnected(err error) bool {
+	return err == ErrConnectionClosed || err == net.ErrPeerUnresponsive
+}
+
 type client struct {
 	endpoint       net.EndPoint
 	messageID      uint32
@@ -37,17 +56,10 @@ func (c *client) Call(serviceID uint32, objectID uint32, actionID uint32,
 	payload []byte) ([]byte, error) {
 
 	msg := c.newMessage(serviceID, objectID, actionID, payload)
-	messageID := msg.Header.ID
 
-	reply := make(chan *net.Message)
-
-	filter := func(hdr *net.Header) (matched bool, keep bool) {
-		if hdr.Service == serviceID && hdr.Object == objectID &&
-			hdr.Action == actionID && hdr.ID == messageID {
-			return true, false
-		}
-		return false, true
-	}
+	// the consumer must not block.
+	reply := make(chan *net.Message, 1)
+	var closeErr error
 
 	consumer := func(msg *net.Message) error {
 		reply <- msg
@@ -55,24 +67,28 @@ func (c *client) Call(serviceID uint32, objectID uint32, actionID uint32,
 	}
 
 	closer := func(err error) {
+		closeErr = err
 		close(reply)
 	}
 
 	// 1. starts listening for an answer.
-	id := c.endpoint.AddHandler(filter, consumer, closer)
+	id := c.endpoint.AddReplyHandler(msg.Header, consumer, closer)
 
 	// 2. send the call message.
 	if err := c.endpoint.Send(msg); err != nil {
 		c.endpoint.RemoveHandler(id)
-		return nil, fmt.Errorf(
+		return nil, connectionError{fmt.Errorf(
 			"call service %d, object %d, action %d: %s",
-			serviceID, objectID, actionID, err)
+			serviceID, objectID, actionID, err)}
 	}
 
 	// 3. wait for a response
 	response, ok := <-reply
 	if !ok {
-		return nil, fmt.Errorf("Remote connection closed")
+		if closeErr == net.ErrPeerUnresponsive {
+			return nil, closeErr
+		}
+		return nil, ErrConnectionClosed
 	}
 	switch response.Header.Type {
 	case net.Reply:
@@ -117,17 +133,7 @@ func (c *client) Subscribe(serviceID, objectID, actionID uint32) (
 		close(abort)
 	}
 
-	filter := func(hdr *net.Header) (matched bool, keep bool) {
-		if hdr.Service == serviceID && hdr.Object == objectID &&
-			hdr.Action == actionID {
-			if hdr.Type == net.Error {
-				// unsubscribe on error
-				return true, false
-			}
-			return true, true
-		}
-		return false, true
-	}
+	// the handler is removed on error.
 	consumer := func(msg *net.Message) error {
 		if msg.Header.Type == net.Error {
 			return nil
@@ -146,7 +152,8 @@ func (c *client) Subscribe(serviceID, objectID, actionID uint32) (
 		case <-abort:
 			c.endpoint.RemoveHandler(id)
 		}
-	}(c.endpoint.AddHandler(filter, consumer, closer))
+	}(c.endpoint.AddEventHandler(serviceID, objectID, actionID,
+		consumer, closer))
 
 	return cancel, events, nil
 }
@@ -191,9 +198,11 @@ func NewClient(endpoint net.EndPoint) Client {
 // SelectEndPoint connect to a remote peer using the list of
 // addresses. It tries local addresses first and refuses to connect
 // invalid IP addresses such as test ranges (198.18.0.x).
-// User and token are user during Authentication. If user and token
-// are empty, the file .qiloop-auth.conf is read.
-func SelectEndPoint(addrs []string, user, token string) (addr string, endpoint net.EndPoint, err error) {
+// The credentials are used during the authentication. If they are
+// nil, the credentials are read from the profiles (see token.Lookup).
+// The tokens issued by the server are saved in the profile of the
+// credentials and the credentials are updated.
+func SelectEndPoint(addrs []string, credentials *token.Credentials) (addr string, endpoint net.EndPoint, err error) {
 	if len(addrs) == 0 {
 		return "", nil, fmt.Errorf("empty address list")
 	}
@@ -209,12 +218,14 @@ func SelectEndPoint(addrs []string, user, token string) (addr string, endpoint n
 		if err != nil {
 			continue
 		}
-		if user == "" && token == "" {
-			err = Authenticate(endpoint)
-		} else {
-			err = AuthenticateUser(endpoint, user, token)
+		creds := credentials
+		if creds == nil {
+			lookup := token.Lookup(addr)
+			creds = &lookup
 		}
+		err = AuthenticateCredentials(endpoint, creds)
 		if err != nil {
+			endpoint.Close()
 			return "", nil, fmt.Errorf("authentication error: %s",
 				err)
 		}
@@ -1,8 +1,12 @@
 package bus_test
 
 import (
+	"io"
+	"io/ioutil"
+	gonet "net"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/lugu/qiloop/bus"
 	"github.com/lugu/qiloop/bus/directory"
@@ -130,7 +134,7 @@ func TestSelectEndPoint(t *testing.T) {
 		"tcp://198.18.0.1:12",
 		addr,
 		"tcps://192.168.0.1:12",
-	}, "", "")
+	}, nil)
 	if err != nil {
 		t.Error(err)
 	}
@@ -142,7 +146,7 @@ func TestSelectEndPoint(t *testing.T) {
 	naddr, endpoint, err = bus.SelectEndPoint([]string{
 		"tcp://198.18.1.0",
 		"tcps://192.168.0.0",
-	}, "", "")
+	}, nil)
 	if err == nil {
 		t.Error("shall not be able to connect")
 	}
@@ -153,7 +157,7 @@ func TestSelectEndPoint(t *testing.T) {
 		t.Error("non empty address")
 	}
 	// shall refuse to connect to empty list
-	_, _, err = bus.SelectEndPoint(make([]string, 0), "", "")
+	_, _, err = bus.SelectEndPoint(make([]string, 0), nil)
 	if err == nil {
 		t.Fatalf("empty list")
 	}
@@ -243,3 +247,142 @@ func TestClientDisconnectionSuccess(t *testing.T) {
 		t.Errorf("not expecting a disconnection error: %s", err)
 	}
 }
+
+func TestClientPeerUnresponsive(t *testing.T) {
+	conn, peer := gonet.Pipe()
+	defer peer.Close()
+	// the peer reads the messages and never replies.
+	go io.Copy(ioutil.Discard, peer)
+
+	endpoint := net.ConnEndPoint(conn)
+	err := net.SetKeepAlive(endpoint, net.KeepAlive{
+		Interval: 10 * time.Millisecond,
+		Timeout:  50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := bus.NewClient(endpoint)
+	_, err = c.Call(1, 1, 100, nil)
+	if err != net.ErrPeerUnresponsive {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+// BenchmarkConcurrentCalls measures the throughput of a client with
+// thousands of calls in flight.
+func BenchmarkConcurrentCalls(b *testing.B) {
+	serviceEndpoint, clientEndpoint := net.Pipe()
+	defer serviceEndpoint.Close()
+	defer clientEndpoint.Close()
+
+	filter := func(hdr *net.Header) (bool, bool) {
+		return true, true
+	}
+	consumer := func(msg *net.Message) error {
+		msg.Header.Type = net.Reply
+		return serviceEndpoint.Send(*msg)
+	}
+	serviceEndpoint.AddHandler(filter, consumer, func(error) {})
+
+	c := bus.NewClient(clientEndpoint)
+	b.SetParallelism(1000)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := c.Call(1, 1, 100, nil); err != nil {
+				b.Error(err)
+			}
+		}
+	})
+}
+
+func TestClientFaulty(t *testing.T) {
+	addr := util.NewUnixAddr()
+	server, err := directory.NewServer(addr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Terminate()
+
+	endpoint, err := net.DialEndPoint("faulty://" + addr +
+		"?seed=1&latency=2ms&jitter=3ms&duplicate=0.5&types=reply,event")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer endpoint.Close()
+	if err = bus.Authenticate(endpoint); err != nil {
+		t.Fatal(err)
+	}
+	c := bus.NewClient(endpoint)
+	meta, err := bus.GetMetaObject(c, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := services.MakeServiceDirectory(nil, bus.NewProxy(c, meta, 1, 1))
+	for i := 0; i < 10; i++ {
+		list, err := dir.Services()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) != 1 {
+			t.Fatalf("unexpected services: %v", list)
+		}
+	}
+	unsubscribe, removed, err := dir.SubscribeServiceRemoved()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unsubscribe()
+	if err = dir.UnregisterService(1); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case event := <-removed:
+		if event.ServiceID != 1 {
+			t.Errorf("unexpected event: %v", event)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("missing event")
+	}
+}
+
+func TestClientFaultyClose(t *testing.T) {
+	addr := util.NewUnixAddr()
+	server, err := directory.NewServer(addr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Terminate()
+
+	endpoint, err := net.DialEndPoint(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = bus.Authenticate(endpoint); err != nil {
+		t.Fatal(err)
+	}
+	// the connection is closed once the call is sent: the reply is
+	// never received.
+	faulty := net.FaultyEndPoint(endpoint, net.FaultPolicy{
+		Drop:       1,
+		Types:      []uint8{net.Reply},
+		CloseAfter: 1,
+	})
+	c := bus.NewClient(faulty)
+	_, events, err := c.Subscribe(1, 1, 106)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.Call(1, 1, 101, nil); err == nil {
+		t.Errorf("expecting an error")
+	}
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Errorf("unexpected event")
+		}
+	case <-time.After(time.Second):
+		t.Errorf("subscription not closed")
+	}
+}
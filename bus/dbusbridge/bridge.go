@@ -0,0 +1,764 @@
+// Package dbusbridge exports QiMessaging services onto a D-Bus bus.
+//
+// A service named Foo is exported at the path /qiloop/Foo with the
+// interface org.qiloop.Foo. Its methods, signals and properties are
+// translated into D-Bus methods, signals and properties (using the
+// interface org.freedesktop.DBus.Properties). Objects returned by the
+// service are exported at /qiloop/<service name>/<object id>.
+package dbusbridge
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+	"github.com/lugu/qiloop/bus"
+	"github.com/lugu/qiloop/bus/services"
+	"github.com/lugu/qiloop/meta/signature"
+	"github.com/lugu/qiloop/type/object"
+	"github.com/lugu/qiloop/type/value"
+)
+
+const (
+	// PathPrefix is the root of the objects exported by the bridge.
+	PathPrefix = "/qiloop"
+	// InterfacePrefix is the prefix of the exported interfaces.
+	InterfacePrefix = "org.qiloop."
+
+	introspectableInterface = "org.freedesktop.DBus.Introspectable"
+	propertiesInterface     = "org.freedesktop.DBus.Properties"
+)
+
+// Bridge exports the services of a session onto a D-Bus connection.
+// It implements dbus.Handler.
+type Bridge struct {
+	session bus.Session
+	conn    *dbus.Conn
+	objects map[dbus.ObjectPath]*exportedObject
+	mutex   sync.Mutex
+}
+
+// NewBridge connects the D-Bus daemon at address and returns a bridge
+// for the services of sess. If address is empty, the session bus is
+// used.
+func NewBridge(sess bus.Session, address string) (*Bridge, error) {
+	b := &Bridge{
+		session: sess,
+		objects: make(map[dbus.ObjectPath]*exportedObject),
+	}
+	var conn *dbus.Conn
+	var err error
+	if address == "" {
+		conn, err = dbus.SessionBusPrivate(dbus.WithHandler(b))
+	} else {
+		conn, err = dbus.Dial(address, dbus.WithHandler(b))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("connect D-Bus: %s", err)
+	}
+	if err = conn.Auth(nil); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("D-Bus authentication: %s", err)
+	}
+	if err = conn.Hello(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("D-Bus hello: %s", err)
+	}
+	b.conn = conn
+	return b, nil
+}
+
+// RequestName requests a well-known name on the bus.
+func (b *Bridge) RequestName(name string) error {
+	reply, err := b.conn.RequestName(name, dbus.NameFlagDoNotQueue)
+	if err != nil {
+		return fmt.Errorf("request name %s: %s", name, err)
+	}
+	if reply != dbus.RequestNameReplyPrimaryOwner {
+		return fmt.Errorf("name %s already taken", name)
+	}
+	return nil
+}
+
+// Names returns the names of the bridge connection on the bus, the
+// first one being its unique name.
+func (b *Bridge) Names() []string {
+	return b.conn.Names()
+}
+
+// Export exports the service named name and returns its path.
+func (b *Bridge) Export(name string) (dbus.ObjectPath, error) {
+	proxy, err := b.session.Proxy(name, 1)
+	if err != nil {
+		return "", fmt.Errorf("connect %s: %s", name, err)
+	}
+	meta, err := bus.MakeObject(proxy).MetaObject(1)
+	if err != nil {
+		return "", fmt.Errorf("get meta object %s: %s", name, err)
+	}
+	ref := object.ObjectReference{
+		MetaObject: meta,
+		ServiceID:  proxy.ServiceID(),
+		ObjectID:   1,
+	}
+	path := dbus.ObjectPath(PathPrefix + "/" + sanitize(name))
+	if err := b.export(path, name, proxy, ref); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// Terminate unsubscribes the signals and closes the D-Bus connection.
+func (b *Bridge) Terminate() error {
+	b.mutex.Lock()
+	objects := b.objects
+	b.objects = make(map[dbus.ObjectPath]*exportedObject)
+	b.mutex.Unlock()
+	for _, obj := range objects {
+		obj.terminate()
+	}
+	return b.conn.Close()
+}
+
+// sanitize returns a valid element of a D-Bus path or interface name.
+func sanitize(name string) string {
+	clean := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') ||
+			(r >= '0' && r <= '9') || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+	if clean == "" || (clean[0] >= '0' && clean[0] <= '9') {
+		clean = "_" + clean
+	}
+	return clean
+}
+
+func (b *Bridge) export(path dbus.ObjectPath, name string, proxy bus.Proxy,
+	ref object.ObjectReference) error {
+
+	b.mutex.Lock()
+	_, ok := b.objects[path]
+	b.mutex.Unlock()
+	if ok {
+		return nil
+	}
+	if ref.MetaObject.Description != "" {
+		name = ref.MetaObject.Description
+	}
+	obj, err := newObject(b, path, InterfacePrefix+sanitize(name), proxy, ref)
+	if err != nil {
+		return err
+	}
+	b.mutex.Lock()
+	if _, ok := b.objects[path]; ok {
+		b.mutex.Unlock()
+		obj.terminate()
+		return nil
+	}
+	b.objects[path] = obj
+	b.mutex.Unlock()
+
+	proxy.OnDisconnect(func(error) {
+		b.mutex.Lock()
+		delete(b.objects, path)
+		b.mutex.Unlock()
+		obj.terminate()
+	})
+	return obj.subscribe()
+}
+
+// exportReference exports an object returned by a service and
+// returns its path.
+func (b *Bridge) exportReference(ref object.ObjectReference) (dbus.ObjectPath, error) {
+	name := fmt.Sprintf("service%d", ref.ServiceID)
+	directory, err := services.Services(b.session).ServiceDirectory(nil)
+	if err == nil {
+		list, err := directory.Services()
+		if err == nil {
+			for _, info := range list {
+				if info.ServiceId == ref.ServiceID {
+					name = info.Name
+					break
+				}
+			}
+		}
+	}
+	path := dbus.ObjectPath(fmt.Sprintf("%s/%s/%d", PathPrefix,
+		sanitize(name), ref.ObjectID))
+	proxy, err := b.session.Object(ref)
+	if err != nil {
+		return "", fmt.Errorf("connect object: %s", err)
+	}
+	if err := b.export(path, name, proxy, ref); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// reference returns the object reference exported at path.
+func (b *Bridge) reference(path dbus.ObjectPath) (object.ObjectReference, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	obj, ok := b.objects[path]
+	if !ok {
+		return object.ObjectReference{}, fmt.Errorf("unknown object: %s", path)
+	}
+	return obj.ref, nil
+}
+
+// LookupObject implements dbus.Handler. The parents of the exported
+// objects are introspectable.
+func (b *Bridge) LookupObject(path dbus.ObjectPath) (dbus.ServerObject, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if obj, ok := b.objects[path]; ok {
+		return obj, true
+	}
+	if len(b.children(path)) != 0 {
+		return &parentObject{b, path}, true
+	}
+	return nil, false
+}
+
+// children returns the names of the nodes under path. It must be
+// called with the mutex held.
+func (b *Bridge) children(path dbus.ObjectPath) []string {
+	prefix := strings.TrimSuffix(string(path), "/") + "/"
+	names := make(map[string]bool)
+	for p := range b.objects {
+		if strings.HasPrefix(string(p), prefix) {
+			child := strings.SplitN(strings.TrimPrefix(string(p), prefix), "/", 2)[0]
+			names[child] = true
+		}
+	}
+	list := make([]string, 0, len(names))
+	for name := range names {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// introspect returns the introspection XML of the node at path.
+func (b *Bridge) introspect(path dbus.ObjectPath, interfaces []introspect.Interface) (string, error) {
+	node := introspect.Node{
+		Name:       string(path),
+		Interfaces: interfaces,
+	}
+	b.mutex.Lock()
+	for _, child := range b.children(path) {
+		node.Children = append(node.Children, introspect.Node{Name: child})
+	}
+	b.mutex.Unlock()
+	data, err := xml.MarshalIndent(node, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return introspect.IntrospectDeclarationString + string(data), nil
+}
+
+// interfaceTable implements dbus.Interface.
+type interfaceTable map[string]dbus.Method
+
+func (t interfaceTable) LookupMethod(name string) (dbus.Method, bool) {
+	m, ok := t[name]
+	return m, ok
+}
+
+// method implements dbus.Method with a function.
+type method struct {
+	in   []reflect.Type
+	out  []reflect.Type
+	call func(args []interface{}) ([]interface{}, error)
+}
+
+func (m *method) Call(args ...interface{}) ([]interface{}, error) {
+	return m.call(args)
+}
+
+func (m *method) NumArguments() int {
+	return len(m.in)
+}
+
+func (m *method) NumReturns() int {
+	return len(m.out)
+}
+
+func (m *method) ArgumentValue(i int) interface{} {
+	return reflect.Zero(m.in[i]).Interface()
+}
+
+func (m *method) ReturnValue(i int) interface{} {
+	return reflect.Zero(m.out[i]).Interface()
+}
+
+func signatureOf(types []reflect.Type) string {
+	sig := ""
+	for _, t := range types {
+		sig += dbus.SignatureOfType(t).String()
+	}
+	return sig
+}
+
+// DecodeArguments implements dbus.ArgumentDecoder: the arguments are
+// used as decoded by godbus after the verification of their
+// signature.
+func (m *method) DecodeArguments(conn *dbus.Conn, sender string,
+	msg *dbus.Message, args []interface{}) ([]interface{}, error) {
+
+	sig := ""
+	if v, ok := msg.Headers[dbus.FieldSignature]; ok {
+		if s, ok := v.Value().(dbus.Signature); ok {
+			sig = s.String()
+		}
+	}
+	if expected := signatureOf(m.in); sig != expected {
+		return nil, dbus.MakeFailedError(fmt.Errorf(
+			"invalid signature: %s (expecting %s)", sig, expected))
+	}
+	return args, nil
+}
+
+var (
+	stringType = reflect.TypeOf("")
+	stringList = reflect.TypeOf([]string{})
+	variantMap = reflect.TypeOf(map[string]dbus.Variant{})
+)
+
+func introspectMethod(b *Bridge, path dbus.ObjectPath,
+	interfaces []introspect.Interface) *method {
+
+	return &method{
+		out: []reflect.Type{stringType},
+		call: func(args []interface{}) ([]interface{}, error) {
+			data, err := b.introspect(path, interfaces)
+			if err != nil {
+				return nil, err
+			}
+			return []interface{}{data}, nil
+		},
+	}
+}
+
+// parentObject is an intermediate node of the exported tree.
+type parentObject struct {
+	bridge *Bridge
+	path   dbus.ObjectPath
+}
+
+func (p *parentObject) LookupInterface(name string) (dbus.Interface, bool) {
+	if name != introspectableInterface {
+		return nil, false
+	}
+	return interfaceTable{
+		"Introspect": introspectMethod(p.bridge, p.path,
+			[]introspect.Interface{introspect.IntrospectData}),
+	}, true
+}
+
+// propertyType returns the type of a property: a tuple of a single
+// element is the type of this element.
+func propertyType(sig string) (signature.Type, error) {
+	t, err := signature.Parse(sig)
+	if err != nil {
+		return nil, fmt.Errorf("parse signature %s: %s", sig, err)
+	}
+	if tuple, ok := t.(*signature.TupleType); ok && len(tuple.Members) == 1 {
+		return tuple.Members[0].Type, nil
+	}
+	return t, nil
+}
+
+// argTypes returns the list of the types of a signature: tuples are
+// flattened and void is an empty list.
+func argTypes(sig string) ([]signature.Type, []reflect.Type, error) {
+	t, err := signature.Parse(sig)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parse signature %s: %s", sig, err)
+	}
+	var members []signature.Type
+	if tuple, ok := t.(*signature.TupleType); ok {
+		members = memberTypes(tuple.Members)
+	} else if t.Signature() != "v" {
+		members = []signature.Type{t}
+	}
+	types, err := goTypes(t)
+	if err != nil {
+		return nil, nil, err
+	}
+	return members, types, nil
+}
+
+func introspectArgs(types []reflect.Type, names []string, direction string) []introspect.Arg {
+	args := make([]introspect.Arg, len(types))
+	for i, t := range types {
+		args[i] = introspect.Arg{
+			Type:      dbus.SignatureOfType(t).String(),
+			Direction: direction,
+		}
+		if i < len(names) {
+			args[i].Name = names[i]
+		}
+	}
+	return args
+}
+
+type signal struct {
+	name  string
+	uid   uint32
+	types []signature.Type
+}
+
+type property struct {
+	name string
+	meta object.MetaProperty
+	typ  signature.Type
+}
+
+// exportedObject is a QiMessaging object exported on D-Bus.
+type exportedObject struct {
+	bridge     *Bridge
+	path       dbus.ObjectPath
+	iface      string
+	proxy      bus.Proxy
+	ref        object.ObjectReference
+	methods    interfaceTable
+	signals    []signal
+	properties map[string]property
+	interfaces []introspect.Interface
+	cancels    []func()
+	mutex      sync.Mutex
+}
+
+func newObject(b *Bridge, path dbus.ObjectPath, iface string,
+	proxy bus.Proxy, ref object.ObjectReference) (*exportedObject, error) {
+
+	obj := &exportedObject{
+		bridge:     b,
+		path:       path,
+		iface:      iface,
+		proxy:      proxy,
+		ref:        ref,
+		methods:    make(interfaceTable),
+		properties: make(map[string]property),
+	}
+	desc := introspect.Interface{Name: iface}
+	skip := func(uid uint32, name string, err error) error {
+		log.Printf("%s: skip %s: %s", iface, name, err)
+		return nil
+	}
+
+	methodCall := func(m object.MetaMethod, name string) error {
+		if m.Uid < object.MinUserActionID {
+			return nil
+		}
+		params, in, err := argTypes(m.ParametersSignature)
+		if err != nil {
+			return skip(m.Uid, m.Name, err)
+		}
+		rets, out, err := argTypes(m.ReturnSignature)
+		if err != nil {
+			return skip(m.Uid, m.Name, err)
+		}
+		names := make([]string, len(m.Parameters))
+		for i, p := range m.Parameters {
+			names[i] = p.Name
+		}
+		uid := m.Uid
+		obj.methods[name] = &method{
+			in:  in,
+			out: out,
+			call: func(args []interface{}) ([]interface{}, error) {
+				return obj.call(uid, params, rets, args)
+			},
+		}
+		desc.Methods = append(desc.Methods, introspect.Method{
+			Name: name,
+			Args: append(introspectArgs(in, names, "in"),
+				introspectArgs(out, nil, "out")...),
+		})
+		return nil
+	}
+	signalCall := func(s object.MetaSignal, name string) error {
+		if s.Uid < object.MinUserActionID {
+			return nil
+		}
+		// signals are sent as the list of their parameters.
+		types, goTypes, err := argTypes(s.Signature)
+		if err != nil {
+			return skip(s.Uid, s.Name, err)
+		}
+		obj.signals = append(obj.signals, signal{name, s.Uid, types})
+		desc.Signals = append(desc.Signals, introspect.Signal{
+			Name: name,
+			Args: introspectArgs(goTypes, nil, ""),
+		})
+		return nil
+	}
+	propertyCall := func(p object.MetaProperty, name string) error {
+		if p.Uid < object.MinUserActionID {
+			return nil
+		}
+		t, err := propertyType(p.Signature)
+		if err != nil {
+			return skip(p.Uid, p.Name, err)
+		}
+		typ, err := goType(t)
+		if err != nil {
+			return skip(p.Uid, p.Name, err)
+		}
+		obj.properties[name] = property{name, p, t}
+		desc.Properties = append(desc.Properties, introspect.Property{
+			Name:   name,
+			Type:   dbus.SignatureOfType(typ).String(),
+			Access: "readwrite",
+		})
+		return nil
+	}
+	meta := ref.MetaObject
+	err := meta.ForEachMethodAndSignal(methodCall, signalCall, propertyCall)
+	if err != nil {
+		return nil, err
+	}
+	obj.interfaces = []introspect.Interface{
+		introspect.IntrospectData,
+		propertiesData,
+		desc,
+	}
+	return obj, nil
+}
+
+// propertiesData is the introspection data of the
+// org.freedesktop.DBus.Properties interface.
+var propertiesData = introspect.Interface{
+	Name: propertiesInterface,
+	Methods: []introspect.Method{
+		{
+			Name: "Get",
+			Args: []introspect.Arg{
+				{Name: "interface", Type: "s", Direction: "in"},
+				{Name: "property", Type: "s", Direction: "in"},
+				{Name: "value", Type: "v", Direction: "out"},
+			},
+		},
+		{
+			Name: "GetAll",
+			Args: []introspect.Arg{
+				{Name: "interface", Type: "s", Direction: "in"},
+				{Name: "properties", Type: "a{sv}", Direction: "out"},
+			},
+		},
+		{
+			Name: "Set",
+			Args: []introspect.Arg{
+				{Name: "interface", Type: "s", Direction: "in"},
+				{Name: "property", Type: "s", Direction: "in"},
+				{Name: "value", Type: "v", Direction: "in"},
+			},
+		},
+	},
+	Signals: []introspect.Signal{
+		{
+			Name: "PropertiesChanged",
+			Args: []introspect.Arg{
+				{Name: "interface", Type: "s"},
+				{Name: "changed", Type: "a{sv}"},
+				{Name: "invalidated", Type: "as"},
+			},
+		},
+	},
+}
+
+// LookupInterface implements dbus.ServerObject.
+func (o *exportedObject) LookupInterface(name string) (dbus.Interface, bool) {
+	switch name {
+	case "", o.iface:
+		return o.methods, true
+	case introspectableInterface:
+		return interfaceTable{
+			"Introspect": introspectMethod(o.bridge, o.path, o.interfaces),
+		}, true
+	case propertiesInterface:
+		return interfaceTable{
+			"Get": &method{
+				in:   []reflect.Type{stringType, stringType},
+				out:  []reflect.Type{variantType},
+				call: o.getProperty,
+			},
+			"GetAll": &method{
+				in:   []reflect.Type{stringType},
+				out:  []reflect.Type{variantMap},
+				call: o.getAllProperties,
+			},
+			"Set": &method{
+				in:   []reflect.Type{stringType, stringType, variantType},
+				call: o.setProperty,
+			},
+		}, true
+	}
+	return nil, false
+}
+
+func (o *exportedObject) call(uid uint32, params, rets []signature.Type,
+	args []interface{}) ([]interface{}, error) {
+
+	var buf bytes.Buffer
+	for i, t := range params {
+		if err := o.bridge.write(t, reflect.ValueOf(args[i]), &buf); err != nil {
+			return nil, fmt.Errorf("parameter %d: %s", i, err)
+		}
+	}
+	response, err := o.proxy.CallID(uid, buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return o.bridge.readArgs(rets, response)
+}
+
+func (o *exportedObject) property(iface, name string) (property, error) {
+	if iface != "" && iface != o.iface {
+		return property{}, fmt.Errorf("unknown interface: %s", iface)
+	}
+	p, ok := o.properties[name]
+	if !ok {
+		return p, fmt.Errorf("unknown property: %s", name)
+	}
+	return p, nil
+}
+
+func (o *exportedObject) readProperty(p property) (dbus.Variant, error) {
+	v, err := bus.MakeObject(o.proxy).Property(value.String(p.meta.Name))
+	if err != nil {
+		return dbus.Variant{}, err
+	}
+	t, err := signature.Parse(v.Signature())
+	if err != nil {
+		return dbus.Variant{}, err
+	}
+	ret, err := o.bridge.read(t, bytes.NewBuffer(value.Bytes(v)))
+	if err != nil {
+		return dbus.Variant{}, err
+	}
+	return dbus.MakeVariant(ret.Interface()), nil
+}
+
+func (o *exportedObject) getProperty(args []interface{}) ([]interface{}, error) {
+	p, err := o.property(args[0].(string), args[1].(string))
+	if err != nil {
+		return nil, err
+	}
+	v, err := o.readProperty(p)
+	if err != nil {
+		return nil, err
+	}
+	return []interface{}{v}, nil
+}
+
+func (o *exportedObject) getAllProperties(args []interface{}) ([]interface{}, error) {
+	iface := args[0].(string)
+	if iface != "" && iface != o.iface {
+		return nil, fmt.Errorf("unknown interface: %s", iface)
+	}
+	all := make(map[string]dbus.Variant, len(o.properties))
+	for name, p := range o.properties {
+		v, err := o.readProperty(p)
+		if err != nil {
+			return nil, err
+		}
+		all[name] = v
+	}
+	return []interface{}{all}, nil
+}
+
+func (o *exportedObject) setProperty(args []interface{}) ([]interface{}, error) {
+	p, err := o.property(args[0].(string), args[1].(string))
+	if err != nil {
+		return nil, err
+	}
+	variant := args[2].(dbus.Variant)
+	var buf bytes.Buffer
+	err = o.bridge.write(p.typ, reflect.ValueOf(variant.Value()), &buf)
+	if err != nil {
+		return nil, err
+	}
+	err = bus.MakeObject(o.proxy).SetProperty(value.String(p.meta.Name),
+		value.Opaque(p.typ.Signature(), buf.Bytes()))
+	if err != nil {
+		return nil, err
+	}
+	return []interface{}{}, nil
+}
+
+// subscribe forwards the signals and the property updates to D-Bus.
+func (o *exportedObject) subscribe() error {
+	for _, s := range o.signals {
+		name := o.iface + "." + s.name
+		types := s.types
+		err := o.forward(s.uid, func(payload []byte) error {
+			args, err := o.bridge.readArgs(types, payload)
+			if err != nil {
+				return err
+			}
+			return o.bridge.conn.Emit(o.path, name, args...)
+		})
+		if err != nil {
+			return fmt.Errorf("subscribe %s: %s", name, err)
+		}
+	}
+	for _, p := range o.properties {
+		p := p
+		err := o.forward(p.meta.Uid, func(payload []byte) error {
+			args, err := o.bridge.readArgs([]signature.Type{p.typ}, payload)
+			if err != nil {
+				return err
+			}
+			changed := map[string]dbus.Variant{
+				p.name: dbus.MakeVariant(args[0]),
+			}
+			return o.bridge.conn.Emit(o.path,
+				propertiesInterface+".PropertiesChanged",
+				o.iface, changed, []string{})
+		})
+		if err != nil {
+			return fmt.Errorf("subscribe %s: %s", p.name, err)
+		}
+	}
+	return nil
+}
+
+func (o *exportedObject) forward(uid uint32, emit func([]byte) error) error {
+	cancel, events, err := o.proxy.SubscribeID(uid)
+	if err != nil {
+		return err
+	}
+	o.mutex.Lock()
+	o.cancels = append(o.cancels, cancel)
+	o.mutex.Unlock()
+	go func() {
+		for payload := range events {
+			if err := emit(payload); err != nil {
+				log.Printf("%s: forward event: %s", o.path, err)
+			}
+		}
+	}()
+	return nil
+}
+
+func (o *exportedObject) terminate() {
+	o.mutex.Lock()
+	cancels := o.cancels
+	o.cancels = nil
+	o.mutex.Unlock()
+	for _, cancel := range cancels {
+		cancel()
+	}
+}
@@ -0,0 +1,214 @@
+package dbusbridge_test
+
+import (
+	"bufio"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/lugu/qiloop/bus/dbusbridge"
+	"github.com/lugu/qiloop/bus/directory"
+	"github.com/lugu/qiloop/bus/logger"
+	"github.com/lugu/qiloop/bus/session"
+	"github.com/lugu/qiloop/bus/util"
+)
+
+// startDaemon starts a private D-Bus daemon and returns its address.
+func startDaemon(t *testing.T) (string, func()) {
+	path, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon not found")
+	}
+	cmd := exec.Command(path, "--session", "--nofork", "--print-address")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	address, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(address), func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}
+}
+
+func newBridge(t *testing.T) (*dbus.Conn, dbus.BusObject, func()) {
+	address, stop := startDaemon(t)
+	addr := util.NewUnixAddr()
+	srv, err := directory.NewServer(addr, nil)
+	if err != nil {
+		stop()
+		t.Fatal(err)
+	}
+	_, err = srv.NewService("LogManager", logger.NewLogManager())
+	if err != nil {
+		srv.Terminate()
+		stop()
+		t.Fatal(err)
+	}
+	sess, err := session.NewSession(addr)
+	if err != nil {
+		srv.Terminate()
+		stop()
+		t.Fatal(err)
+	}
+	bridge, err := dbusbridge.NewBridge(sess, address)
+	if err != nil {
+		sess.Terminate()
+		srv.Terminate()
+		stop()
+		t.Fatal(err)
+	}
+	cleanup := func() {
+		bridge.Terminate()
+		sess.Terminate()
+		srv.Terminate()
+		stop()
+	}
+	path, err := bridge.Export("LogManager")
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	if path != "/qiloop/LogManager" {
+		cleanup()
+		t.Fatalf("unexpected path: %s", path)
+	}
+	conn, err := dbus.Dial(address)
+	if err == nil {
+		err = conn.Auth(nil)
+	}
+	if err == nil {
+		err = conn.Hello()
+	}
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	manager := conn.Object(bridge.Names()[0], path)
+	return conn, manager, func() {
+		conn.Close()
+		cleanup()
+	}
+}
+
+type logMessage struct {
+	Source   string
+	Level    struct{ Level int32 }
+	Category string
+	Location string
+	Message  string
+	ID       uint32
+	Date     struct{ Ns uint64 }
+	System   struct{ Ns uint64 }
+}
+
+func TestIntrospect(t *testing.T) {
+	conn, manager, cleanup := newBridge(t)
+	defer cleanup()
+
+	var xml string
+	err := manager.Call("org.freedesktop.DBus.Introspectable.Introspect",
+		0).Store(&xml)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{
+		`<interface name="org.qiloop.LogManager">`,
+		`<method name="CreateListener">`,
+		`<arg type="a(s(i)sssu(t)(t))" direction="in"`,
+	} {
+		if !strings.Contains(xml, name) {
+			t.Errorf("missing %s: %s", name, xml)
+		}
+	}
+	root := conn.Object(manager.Destination(), "/qiloop")
+	err = root.Call("org.freedesktop.DBus.Introspectable.Introspect",
+		0).Store(&xml)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(xml, `<node name="LogManager">`) {
+		t.Errorf("missing child node: %s", xml)
+	}
+}
+
+func TestListener(t *testing.T) {
+	conn, manager, cleanup := newBridge(t)
+	defer cleanup()
+
+	var path dbus.ObjectPath
+	err := manager.Call("org.qiloop.LogManager.CreateListener", 0).Store(&path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(path), "/qiloop/LogManager/") {
+		t.Fatalf("unexpected path: %s", path)
+	}
+	listener := conn.Object(manager.Destination(), path)
+
+	level, err := listener.GetProperty("org.qiloop.LogListener.LogLevel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if level.Signature().String() != "(i)" {
+		t.Errorf("unexpected level: %s", level)
+	}
+	err = listener.SetProperty("org.qiloop.LogListener.LogLevel",
+		dbus.MakeVariant(struct{ Level int32 }{5}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	level, err = listener.GetProperty("org.qiloop.LogListener.LogLevel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fields, ok := level.Value().([]interface{}); !ok ||
+		len(fields) != 1 || fields[0] != int32(5) {
+		t.Errorf("unexpected level: %s", level)
+	}
+	err = listener.Call("org.qiloop.LogListener.SetLevel", 0, "bad").Err
+	if err == nil {
+		t.Errorf("expecting an invalid signature error")
+	}
+
+	err = conn.AddMatchSignal(dbus.WithMatchObjectPath(path),
+		dbus.WithMatchInterface("org.qiloop.LogListener"),
+		dbus.WithMatchMember("OnLogMessage"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signals := make(chan *dbus.Signal, 10)
+	conn.Signal(signals)
+
+	var msg logMessage
+	msg.Source = "test"
+	msg.Level.Level = 2
+	msg.Message = "hello"
+	err = manager.Call("org.qiloop.LogManager.Log", 0,
+		[]logMessage{msg}).Err
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case signal := <-signals:
+		var event logMessage
+		if err := dbus.Store(signal.Body, &event); err != nil {
+			t.Fatal(err)
+		}
+		if event.Message != "hello" {
+			t.Errorf("unexpected event: %v", signal.Body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("missing signal")
+	}
+}
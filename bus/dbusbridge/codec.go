@@ -0,0 +1,409 @@
+package dbusbridge
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/lugu/qiloop/meta/signature"
+	"github.com/lugu/qiloop/type/basic"
+	"github.com/lugu/qiloop/type/object"
+)
+
+// read reads a QiMessaging value of type t and returns its D-Bus
+// representation.
+func (b *Bridge) read(t signature.Type, r io.Reader) (reflect.Value, error) {
+	var none reflect.Value
+	typ, err := goType(t)
+	if err != nil {
+		return none, err
+	}
+	switch qiType := t.(type) {
+	case *signature.ListType:
+		size, err := basic.ReadUint32(r)
+		if err != nil {
+			return none, fmt.Errorf("read list size: %s", err)
+		}
+		list := reflect.MakeSlice(typ, 0, 0)
+		for i := uint32(0); i < size; i++ {
+			elem, err := b.read(qiType.Elem(), r)
+			if err != nil {
+				return none, fmt.Errorf("list element %d: %s", i, err)
+			}
+			list = reflect.Append(list, elem)
+		}
+		return list, nil
+	case *signature.MapType:
+		size, err := basic.ReadUint32(r)
+		if err != nil {
+			return none, fmt.Errorf("read map size: %s", err)
+		}
+		// maps with non basic keys are lists of pairs.
+		dict := typ.Kind() == reflect.Map
+		var m, pairs reflect.Value
+		if dict {
+			m = reflect.MakeMapWithSize(typ, int(size))
+		} else {
+			pairs = reflect.MakeSlice(typ, 0, int(size))
+		}
+		for i := uint32(0); i < size; i++ {
+			key, err := b.read(qiType.Key(), r)
+			if err != nil {
+				return none, fmt.Errorf("map key %d: %s", i, err)
+			}
+			value, err := b.read(qiType.Value(), r)
+			if err != nil {
+				return none, fmt.Errorf("map value %d: %s", i, err)
+			}
+			if dict {
+				m.SetMapIndex(key, value)
+				continue
+			}
+			pair := reflect.New(typ.Elem()).Elem()
+			pair.Field(0).Set(key)
+			pair.Field(1).Set(value)
+			pairs = reflect.Append(pairs, pair)
+		}
+		if dict {
+			return m, nil
+		}
+		return pairs, nil
+	case *signature.TupleType:
+		return b.readStruct(typ, memberTypes(qiType.Members), r)
+	case *signature.StructType:
+		return b.readStruct(typ, memberTypes(qiType.Members), r)
+	}
+	var v interface{}
+	switch t.Signature() {
+	case "b":
+		v, err = basic.ReadBool(r)
+	case "c":
+		var i int8
+		i, err = basic.ReadInt8(r)
+		v = int16(i)
+	case "C":
+		v, err = basic.ReadUint8(r)
+	case "w":
+		v, err = basic.ReadInt16(r)
+	case "W":
+		v, err = basic.ReadUint16(r)
+	case "i":
+		v, err = basic.ReadInt32(r)
+	case "I":
+		v, err = basic.ReadUint32(r)
+	case "l":
+		v, err = basic.ReadInt64(r)
+	case "L":
+		v, err = basic.ReadUint64(r)
+	case "f":
+		var f float32
+		f, err = basic.ReadFloat32(r)
+		v = float64(f)
+	case "d":
+		v, err = basic.ReadFloat64(r)
+	case "s":
+		v, err = basic.ReadString(r)
+	case "r":
+		v, err = basic.ReadRaw(r)
+	case "m":
+		v, err = b.readValue(r)
+	case "o":
+		var ref object.ObjectReference
+		ref, err = object.ReadObjectReference(r)
+		if err == nil {
+			v, err = b.exportReference(ref)
+		}
+	}
+	if err != nil {
+		return none, err
+	}
+	return reflect.ValueOf(v), nil
+}
+
+func (b *Bridge) readStruct(typ reflect.Type, members []signature.Type, r io.Reader) (reflect.Value, error) {
+	st := reflect.New(typ).Elem()
+	for i, m := range members {
+		v, err := b.read(m, r)
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("member %d: %s", i, err)
+		}
+		st.Field(i).Set(v)
+	}
+	return st, nil
+}
+
+// readValue reads a dynamic value and returns a variant.
+func (b *Bridge) readValue(r io.Reader) (dbus.Variant, error) {
+	sig, err := basic.ReadString(r)
+	if err != nil {
+		return dbus.Variant{}, fmt.Errorf("read value signature: %s", err)
+	}
+	t, err := signature.Parse(sig)
+	if err != nil {
+		return dbus.Variant{}, fmt.Errorf("parse signature %s: %s", sig, err)
+	}
+	v, err := b.read(t, r)
+	if err != nil {
+		return dbus.Variant{}, err
+	}
+	return dbus.MakeVariant(v.Interface()), nil
+}
+
+// readArgs reads a list of values from a payload.
+func (b *Bridge) readArgs(types []signature.Type, payload []byte) ([]interface{}, error) {
+	r := bytes.NewBuffer(payload)
+	args := make([]interface{}, len(types))
+	for i, t := range types {
+		v, err := b.read(t, r)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v.Interface()
+	}
+	return args, nil
+}
+
+// fields returns the members of a D-Bus structure. Structures are
+// either Go structures or []interface{} once decoded by godbus.
+func fields(v reflect.Value) ([]reflect.Value, error) {
+	switch v.Kind() {
+	case reflect.Struct:
+		list := make([]reflect.Value, v.NumField())
+		for i := range list {
+			list[i] = v.Field(i)
+		}
+		return list, nil
+	case reflect.Slice:
+		list := make([]reflect.Value, v.Len())
+		for i := range list {
+			list[i] = v.Index(i)
+		}
+		return list, nil
+	default:
+		return nil, fmt.Errorf("expecting a structure, got %s", v.Type())
+	}
+}
+
+func toInt(v reflect.Value, min, max int64) (int64, error) {
+	var i int64
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i = v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if v.Uint() > math.MaxInt64 {
+			return 0, fmt.Errorf("value out of range: %d", v.Uint())
+		}
+		i = int64(v.Uint())
+	default:
+		return 0, fmt.Errorf("expecting an integer, got %s", v.Type())
+	}
+	if i < min || i > max {
+		return 0, fmt.Errorf("value out of range: %d", i)
+	}
+	return i, nil
+}
+
+func toUint(v reflect.Value, max uint64) (uint64, error) {
+	var i uint64
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.Int() < 0 {
+			return 0, fmt.Errorf("value out of range: %d", v.Int())
+		}
+		i = uint64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		i = v.Uint()
+	default:
+		return 0, fmt.Errorf("expecting an integer, got %s", v.Type())
+	}
+	if i > max {
+		return 0, fmt.Errorf("value out of range: %d", i)
+	}
+	return i, nil
+}
+
+// write writes the D-Bus value v as a QiMessaging value of type t.
+func (b *Bridge) write(t signature.Type, v reflect.Value, w io.Writer) error {
+	if v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return fmt.Errorf("missing value")
+	}
+	switch qiType := t.(type) {
+	case *signature.ListType:
+		if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+			return fmt.Errorf("expecting an array, got %s", v.Type())
+		}
+		if err := basic.WriteUint32(uint32(v.Len()), w); err != nil {
+			return err
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := b.write(qiType.Elem(), v.Index(i), w); err != nil {
+				return fmt.Errorf("list element %d: %s", i, err)
+			}
+		}
+		return nil
+	case *signature.MapType:
+		return b.writeMap(qiType, v, w)
+	case *signature.TupleType:
+		return b.writeStruct(memberTypes(qiType.Members), v, w)
+	case *signature.StructType:
+		return b.writeStruct(memberTypes(qiType.Members), v, w)
+	}
+	switch sig := t.Signature(); sig {
+	case "b":
+		if v.Kind() != reflect.Bool {
+			return fmt.Errorf("expecting a boolean, got %s", v.Type())
+		}
+		return basic.WriteBool(v.Bool(), w)
+	case "c":
+		i, err := toInt(v, math.MinInt8, math.MaxInt8)
+		if err != nil {
+			return err
+		}
+		return basic.WriteInt8(int8(i), w)
+	case "C":
+		i, err := toUint(v, math.MaxUint8)
+		if err != nil {
+			return err
+		}
+		return basic.WriteUint8(uint8(i), w)
+	case "w":
+		i, err := toInt(v, math.MinInt16, math.MaxInt16)
+		if err != nil {
+			return err
+		}
+		return basic.WriteInt16(int16(i), w)
+	case "W":
+		i, err := toUint(v, math.MaxUint16)
+		if err != nil {
+			return err
+		}
+		return basic.WriteUint16(uint16(i), w)
+	case "i":
+		i, err := toInt(v, math.MinInt32, math.MaxInt32)
+		if err != nil {
+			return err
+		}
+		return basic.WriteInt32(int32(i), w)
+	case "I":
+		i, err := toUint(v, math.MaxUint32)
+		if err != nil {
+			return err
+		}
+		return basic.WriteUint32(uint32(i), w)
+	case "l":
+		i, err := toInt(v, math.MinInt64, math.MaxInt64)
+		if err != nil {
+			return err
+		}
+		return basic.WriteInt64(i, w)
+	case "L":
+		i, err := toUint(v, math.MaxUint64)
+		if err != nil {
+			return err
+		}
+		return basic.WriteUint64(i, w)
+	case "f", "d":
+		if v.Kind() != reflect.Float32 && v.Kind() != reflect.Float64 {
+			return fmt.Errorf("expecting a double, got %s", v.Type())
+		}
+		if sig == "f" {
+			return basic.WriteFloat32(float32(v.Float()), w)
+		}
+		return basic.WriteFloat64(v.Float(), w)
+	case "s":
+		if v.Kind() != reflect.String {
+			return fmt.Errorf("expecting a string, got %s", v.Type())
+		}
+		return basic.WriteString(v.String(), w)
+	case "r":
+		data, ok := v.Interface().([]byte)
+		if !ok {
+			return fmt.Errorf("expecting bytes, got %s", v.Type())
+		}
+		return basic.WriteRaw(data, w)
+	case "m":
+		variant, ok := v.Interface().(dbus.Variant)
+		if !ok {
+			return fmt.Errorf("expecting a variant, got %s", v.Type())
+		}
+		qiSig, err := QiSignature(variant.Signature().String())
+		if err != nil {
+			return err
+		}
+		t, err := signature.Parse(qiSig)
+		if err != nil {
+			return fmt.Errorf("parse signature %s: %s", qiSig, err)
+		}
+		if err := basic.WriteString(qiSig, w); err != nil {
+			return err
+		}
+		return b.write(t, reflect.ValueOf(variant.Value()), w)
+	case "o":
+		path, ok := v.Interface().(dbus.ObjectPath)
+		if !ok {
+			return fmt.Errorf("expecting an object path, got %s", v.Type())
+		}
+		ref, err := b.reference(path)
+		if err != nil {
+			return err
+		}
+		return object.WriteObjectReference(ref, w)
+	default:
+		return fmt.Errorf("unsupported signature: %s", sig)
+	}
+}
+
+func (b *Bridge) writeStruct(members []signature.Type, v reflect.Value, w io.Writer) error {
+	list, err := fields(v)
+	if err != nil {
+		return err
+	}
+	if len(list) != len(members) {
+		return fmt.Errorf("expecting %d members, got %d",
+			len(members), len(list))
+	}
+	for i, m := range members {
+		if err := b.write(m, list[i], w); err != nil {
+			return fmt.Errorf("member %d: %s", i, err)
+		}
+	}
+	return nil
+}
+
+func (b *Bridge) writeMap(m *signature.MapType, v reflect.Value, w io.Writer) error {
+	switch v.Kind() {
+	case reflect.Map:
+		if err := basic.WriteUint32(uint32(v.Len()), w); err != nil {
+			return err
+		}
+		iter := v.MapRange()
+		for iter.Next() {
+			if err := b.write(m.Key(), iter.Key(), w); err != nil {
+				return fmt.Errorf("map key: %s", err)
+			}
+			if err := b.write(m.Value(), iter.Value(), w); err != nil {
+				return fmt.Errorf("map value: %s", err)
+			}
+		}
+		return nil
+	case reflect.Slice, reflect.Array:
+		if err := basic.WriteUint32(uint32(v.Len()), w); err != nil {
+			return err
+		}
+		members := []signature.Type{m.Key(), m.Value()}
+		for i := 0; i < v.Len(); i++ {
+			if err := b.writeStruct(members, v.Index(i), w); err != nil {
+				return fmt.Errorf("map entry %d: %s", i, err)
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("expecting a dictionary, got %s", v.Type())
+	}
+}
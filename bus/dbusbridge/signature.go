@@ -0,0 +1,244 @@
+package dbusbridge
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/lugu/qiloop/meta/signature"
+)
+
+// QiMessaging types are mapped to D-Bus types as follow:
+//
+//	bool          -> b
+//	int8, int16   -> n (int8 is widened)
+//	uint8         -> y
+//	uint16        -> q
+//	int32         -> i
+//	uint32        -> u
+//	int64         -> x
+//	uint64        -> t
+//	float32/64    -> d (float32 is widened)
+//	str           -> s
+//	any           -> v
+//	obj           -> o (path of the object exported by the bridge)
+//	Vec<T>        -> aT
+//	Map<K,V>      -> a{KV} if K is a basic type, a(KV) otherwise
+//	tuple, struct -> (...)
+
+var (
+	variantType    = reflect.TypeOf(dbus.Variant{})
+	objectPathType = reflect.TypeOf(dbus.ObjectPath(""))
+)
+
+// isDictKey returns true if the D-Bus type can be used as a
+// dictionary key.
+func isDictKey(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Bool, reflect.Uint8, reflect.Int16, reflect.Uint16,
+		reflect.Int32, reflect.Uint32, reflect.Int64, reflect.Uint64,
+		reflect.Float64, reflect.String:
+		return true
+	}
+	return false
+}
+
+// structType returns a Go structure whose D-Bus signature is the
+// concatenation of the signatures of members.
+func structType(members []signature.Type) (reflect.Type, error) {
+	if len(members) == 0 {
+		return nil, fmt.Errorf("empty structure not supported")
+	}
+	fields := make([]reflect.StructField, len(members))
+	for i, m := range members {
+		t, err := goType(m)
+		if err != nil {
+			return nil, err
+		}
+		fields[i] = reflect.StructField{
+			Name: fmt.Sprintf("F%d", i),
+			Type: t,
+		}
+	}
+	return reflect.StructOf(fields), nil
+}
+
+func memberTypes(list []signature.MemberType) []signature.Type {
+	types := make([]signature.Type, len(list))
+	for i, m := range list {
+		types[i] = m.Type
+	}
+	return types
+}
+
+// goType returns the Go type used to represent a value of type t on
+// D-Bus.
+func goType(t signature.Type) (reflect.Type, error) {
+	switch typ := t.(type) {
+	case *signature.ListType:
+		elem, err := goType(typ.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return reflect.SliceOf(elem), nil
+	case *signature.MapType:
+		key, err := goType(typ.Key())
+		if err != nil {
+			return nil, err
+		}
+		value, err := goType(typ.Value())
+		if err != nil {
+			return nil, err
+		}
+		if isDictKey(key) {
+			return reflect.MapOf(key, value), nil
+		}
+		pair, err := structType([]signature.Type{typ.Key(), typ.Value()})
+		if err != nil {
+			return nil, err
+		}
+		return reflect.SliceOf(pair), nil
+	case *signature.TupleType:
+		return structType(memberTypes(typ.Members))
+	case *signature.StructType:
+		return structType(memberTypes(typ.Members))
+	}
+	switch sig := t.Signature(); sig {
+	case "b":
+		return reflect.TypeOf(false), nil
+	case "c", "w":
+		return reflect.TypeOf(int16(0)), nil
+	case "C":
+		return reflect.TypeOf(uint8(0)), nil
+	case "W":
+		return reflect.TypeOf(uint16(0)), nil
+	case "i":
+		return reflect.TypeOf(int32(0)), nil
+	case "I":
+		return reflect.TypeOf(uint32(0)), nil
+	case "l":
+		return reflect.TypeOf(int64(0)), nil
+	case "L":
+		return reflect.TypeOf(uint64(0)), nil
+	case "f", "d":
+		return reflect.TypeOf(float64(0)), nil
+	case "s":
+		return reflect.TypeOf(""), nil
+	case "r":
+		return reflect.TypeOf([]byte{}), nil
+	case "m":
+		return variantType, nil
+	case "o":
+		return objectPathType, nil
+	default:
+		return nil, fmt.Errorf("unsupported signature: %s", sig)
+	}
+}
+
+// DBusSignature converts a QiMessaging signature into a D-Bus
+// signature. The elements of a tuple are converted into a list of
+// D-Bus types, "v" (void) is converted into an empty signature.
+func DBusSignature(qiSig string) (string, error) {
+	t, err := signature.Parse(qiSig)
+	if err != nil {
+		return "", fmt.Errorf("parse signature %s: %s", qiSig, err)
+	}
+	types, err := goTypes(t)
+	if err != nil {
+		return "", err
+	}
+	sig := ""
+	for _, typ := range types {
+		sig += dbus.SignatureOfType(typ).String()
+	}
+	return sig, nil
+}
+
+// goTypes returns the Go types of a list of arguments: tuples are
+// flattened and void is an empty list.
+func goTypes(t signature.Type) ([]reflect.Type, error) {
+	var members []signature.Type
+	if tuple, ok := t.(*signature.TupleType); ok {
+		members = memberTypes(tuple.Members)
+	} else if t.Signature() != "v" {
+		members = []signature.Type{t}
+	}
+	types := make([]reflect.Type, len(members))
+	for i, m := range members {
+		typ, err := goType(m)
+		if err != nil {
+			return nil, err
+		}
+		types[i] = typ
+	}
+	return types, nil
+}
+
+// QiSignature converts a D-Bus signature of a single complete type
+// into a QiMessaging signature.
+func QiSignature(dbusSig string) (string, error) {
+	sig, rest, err := qiSignature(dbusSig)
+	if err != nil {
+		return "", fmt.Errorf("convert %s: %s", dbusSig, err)
+	}
+	if rest != "" {
+		return "", fmt.Errorf("convert %s: not a single type", dbusSig)
+	}
+	return sig, nil
+}
+
+// qiSignature converts the first complete type of a D-Bus signature
+// and returns the remaining signature.
+func qiSignature(s string) (string, string, error) {
+	if s == "" {
+		return "", "", fmt.Errorf("empty signature")
+	}
+	basics := map[byte]string{
+		'y': "C", 'b': "b", 'n': "w", 'q': "W", 'i': "i", 'u': "I",
+		'x': "l", 't': "L", 'd': "d", 's': "s", 'o': "o", 'g': "s",
+		'v': "m",
+	}
+	if sig, ok := basics[s[0]]; ok {
+		return sig, s[1:], nil
+	}
+	switch s[0] {
+	case 'a':
+		if len(s) > 1 && s[1] == '{' {
+			key, rest, err := qiSignature(s[2:])
+			if err != nil {
+				return "", "", err
+			}
+			value, rest, err := qiSignature(rest)
+			if err != nil {
+				return "", "", err
+			}
+			if rest == "" || rest[0] != '}' {
+				return "", "", fmt.Errorf("unterminated dictionary")
+			}
+			return "{" + key + value + "}", rest[1:], nil
+		}
+		elem, rest, err := qiSignature(s[1:])
+		if err != nil {
+			return "", "", err
+		}
+		return "[" + elem + "]", rest, nil
+	case '(':
+		sig := "("
+		rest := s[1:]
+		for rest != "" && rest[0] != ')' {
+			var member string
+			var err error
+			member, rest, err = qiSignature(rest)
+			if err != nil {
+				return "", "", err
+			}
+			sig += member
+		}
+		if rest == "" {
+			return "", "", fmt.Errorf("unterminated structure")
+		}
+		return sig + ")", rest[1:], nil
+	default:
+		return "", "", fmt.Errorf("unsupported type: %c", s[0])
+	}
+}
@@ -0,0 +1,73 @@
+package dbusbridge
+
+import (
+	"testing"
+)
+
+func TestDBusSignature(t *testing.T) {
+	tests := []struct {
+		qi   string
+		dbus string
+	}{
+		{"v", ""},
+		{"b", "b"},
+		{"c", "n"},
+		{"C", "y"},
+		{"I", "u"},
+		{"L", "t"},
+		{"f", "d"},
+		{"s", "s"},
+		{"m", "v"},
+		{"o", "o"},
+		{"[s]", "as"},
+		{"{sI}", "a{su}"},
+		{"{(ii)s}", "a((ii)s)"},
+		{"(is)", "is"},
+		{"(s(i)<Level,level>(L)<Time,ns>)<Message,source,level,date>",
+			"(s(i)(t))"},
+		{"([(ib)<Foo,a,b>])", "a(ib)"},
+	}
+	for _, test := range tests {
+		sig, err := DBusSignature(test.qi)
+		if err != nil {
+			t.Errorf("%s: %s", test.qi, err)
+		} else if sig != test.dbus {
+			t.Errorf("%s: expecting %s, got %s", test.qi, test.dbus, sig)
+		}
+	}
+	for _, sig := range []string{"()<Empty>", "[()]", "X"} {
+		if _, err := DBusSignature(sig); err == nil {
+			t.Errorf("%s: expecting an error", sig)
+		}
+	}
+}
+
+func TestQiSignature(t *testing.T) {
+	tests := []struct {
+		dbus string
+		qi   string
+	}{
+		{"y", "C"},
+		{"n", "w"},
+		{"t", "L"},
+		{"g", "s"},
+		{"v", "m"},
+		{"as", "[s]"},
+		{"a{sv}", "{sm}"},
+		{"a(is)", "[(is)]"},
+		{"(ia{s(bb)})", "(i{s(bb)})"},
+	}
+	for _, test := range tests {
+		sig, err := QiSignature(test.dbus)
+		if err != nil {
+			t.Errorf("%s: %s", test.dbus, err)
+		} else if sig != test.qi {
+			t.Errorf("%s: expecting %s, got %s", test.dbus, test.qi, sig)
+		}
+	}
+	for _, sig := range []string{"", "ii", "a{s", "(i", "h"} {
+		if _, err := QiSignature(sig); err == nil {
+			t.Errorf("%s: expecting an error", sig)
+		}
+	}
+}
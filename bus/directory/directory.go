@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"sort"
+	"sync"
 
 	"github.com/lugu/qiloop/bus"
 	"github.com/lugu/qiloop/bus/util"
@@ -14,8 +15,17 @@ import (
 type serviceDirectory struct {
 	staging  map[uint32]ServiceInfo
 	services map[uint32]ServiceInfo
+	// mirrors are the services of the peer directories indexed by
+	// their prefixed name and their ID (see Federate).
+	mirrors map[mirrorKey]ServiceInfo
+	health  map[uint32]ServiceHealth
+	// metadata of the services staging or ready.
+	metadata map[uint32]map[string]string
+	check    HealthCheck
+	stop     chan struct{}
 	lastID   uint32
 	signal   ServiceDirectorySignalHelper
+	mutex    sync.Mutex
 }
 
 // serviceDirectoryImpl returns an implementation of ServiceDirectory
@@ -23,17 +33,33 @@ func serviceDirectoryImpl() *serviceDirectory {
 	return &serviceDirectory{
 		staging:  make(map[uint32]ServiceInfo),
 		services: make(map[uint32]ServiceInfo),
+		mirrors:  make(map[mirrorKey]ServiceInfo),
+		health:   make(map[uint32]ServiceHealth),
+		metadata: make(map[uint32]map[string]string),
+		check:    DefaultHealthCheck,
 		lastID:   0,
 	}
 }
 
 func (s *serviceDirectory) Activate(activation bus.Activation,
 	helper ServiceDirectorySignalHelper) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 	s.signal = helper
+	if s.check.Period > 0 && s.stop == nil {
+		s.stop = make(chan struct{})
+		go s.checkHealth(s.stop)
+	}
 	return nil
 }
 
 func (s *serviceDirectory) OnTerminate() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.stop != nil {
+		close(s.stop)
+		s.stop = nil
+	}
 }
 
 func checkServiceInfo(i ServiceInfo) error {
@@ -58,6 +84,8 @@ func checkServiceInfo(i ServiceInfo) error {
 }
 
 func (s *serviceDirectory) info(serviceID uint32) (ServiceInfo, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 	info, ok := s.services[serviceID]
 	if !ok {
 		return info, fmt.Errorf("service %d not found", serviceID)
@@ -65,13 +93,56 @@ func (s *serviceDirectory) info(serviceID uint32) (ServiceInfo, error) {
 	return info, nil
 }
 
-func (s *serviceDirectory) Service(service string) (info ServiceInfo, err error) {
-	for _, info = range s.services {
-		if info.Name == service {
-			return info, nil
+// instances returns the local instances of a service sorted by ID
+// followed by its mirrored instances sorted by ID. It must be called
+// with the mutex locked.
+func (s *serviceDirectory) instances(name string) []ServiceInfo {
+	list := make([]ServiceInfo, 0, 1)
+	for _, info := range s.services {
+		if info.Name == name {
+			list = append(list, info)
+		}
+	}
+	sort.Sort(serviceList(list))
+	mirrors := make([]ServiceInfo, 0)
+	for key, info := range s.mirrors {
+		if key.name == name {
+			mirrors = append(mirrors, info)
 		}
 	}
-	return info, fmt.Errorf("Service not found: %s", service)
+	sort.Sort(serviceList(mirrors))
+	return append(list, mirrors...)
+}
+
+// registered returns true if info describes a local service and not
+// a mirrored one: the IDs of the mirrored services are the ones of
+// their peer and can collide with the local IDs. It must be called
+// with the mutex locked.
+func (s *serviceDirectory) registered(info ServiceInfo) bool {
+	local, ok := s.services[info.ServiceId]
+	return ok && local.Name == info.Name
+}
+
+// Service returns the instance of the service with the lowest ID.
+func (s *serviceDirectory) Service(service string) (info ServiceInfo, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	list := s.instances(service)
+	if len(list) == 0 {
+		return info, fmt.Errorf("Service not found: %s", service)
+	}
+	return list[0], nil
+}
+
+// ServicesByName returns the instances of a service sorted by ID.
+func (s *serviceDirectory) ServicesByName(name string) ([]ServiceInfo, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	list := s.instances(name)
+	if len(list) == 0 {
+		return nil, fmt.Errorf("Service not found: %s", name)
+	}
+	return list, nil
 }
 
 type serviceList []ServiceInfo
@@ -80,26 +151,59 @@ func (a serviceList) Len() int           { return len(a) }
 func (a serviceList) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 func (a serviceList) Less(i, j int) bool { return a[i].ServiceId < a[j].ServiceId }
 
+// Services returns the local services sorted by ID followed by the
+// mirrored services sorted by name and ID.
 func (s *serviceDirectory) Services() ([]ServiceInfo, error) {
-	list := make([]ServiceInfo, 0, len(s.services))
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	list := make([]ServiceInfo, 0, len(s.services)+len(s.mirrors))
 	for _, info := range s.services {
 		list = append(list, info)
 	}
 	sort.Sort(serviceList(list))
+	keys := make([]mirrorKey, 0, len(s.mirrors))
+	for key := range s.mirrors {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].name != keys[j].name {
+			return keys[i].name < keys[j].name
+		}
+		return keys[i].id < keys[j].id
+	})
+	for _, key := range keys {
+		list = append(list, s.mirrors[key])
+	}
 	return list, nil
 }
 
+// sameInstance returns true if the services are registered by the
+// same process with the same name.
+func sameInstance(a, b ServiceInfo) bool {
+	return a.Name == b.Name && a.MachineId == b.MachineId &&
+		a.ProcessId == b.ProcessId
+}
+
 func (s *serviceDirectory) RegisterService(newInfo ServiceInfo) (uint32, error) {
 	if err := checkServiceInfo(newInfo); err != nil {
 		return 0, err
 	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for key := range s.mirrors {
+		if key.name == newInfo.Name {
+			return 0, fmt.Errorf("Service name already mirrored: %s",
+				newInfo.Name)
+		}
+	}
+	// several processes can register an instance of a service.
 	for _, info := range s.staging {
-		if info.Name == newInfo.Name {
+		if sameInstance(info, newInfo) {
 			return 0, fmt.Errorf("Service name already staging: %s", info.Name)
 		}
 	}
 	for _, info := range s.services {
-		if info.Name == newInfo.Name {
+		if sameInstance(info, newInfo) {
 			return 0, fmt.Errorf("Service name already ready: %s", info.Name)
 		}
 	}
@@ -110,34 +214,49 @@ func (s *serviceDirectory) RegisterService(newInfo ServiceInfo) (uint32, error)
 }
 
 func (s *serviceDirectory) UnregisterService(id uint32) error {
+	s.mutex.Lock()
 	i, ok := s.services[id]
 	if ok {
 		delete(s.services, id)
+		delete(s.health, id)
+		delete(s.metadata, id)
 		signal := s.signal
+		s.mutex.Unlock()
 		if signal != nil {
 			signal.SignalServiceRemoved(id, i.Name)
 		}
 		return nil
 	}
+	defer s.mutex.Unlock()
 	_, ok = s.staging[id]
 	if ok {
 		delete(s.staging, id)
+		delete(s.metadata, id)
 		return nil
 	}
 	return fmt.Errorf("Service not found: %d", id)
 }
 
 func (s *serviceDirectory) ServiceReady(id uint32) error {
+	s.mutex.Lock()
 	i, ok := s.staging[id]
 	if ok {
 		delete(s.staging, id)
 		s.services[id] = i
+		state := HealthUnknown
+		if local(i) {
+			// the services of the process are not probed.
+			state = HealthHealthy
+		}
+		s.health[id] = ServiceHealth{State: state}
 		signal := s.signal
+		s.mutex.Unlock()
 		if signal != nil {
 			signal.SignalServiceAdded(id, i.Name)
 		}
 		return nil
 	}
+	s.mutex.Unlock()
 	return fmt.Errorf("Service id not found: %d", id)
 }
 
@@ -145,6 +264,8 @@ func (s *serviceDirectory) UpdateServiceInfo(i ServiceInfo) error {
 	if err := checkServiceInfo(i); err != nil {
 		return err
 	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 
 	info, ok := s.services[i.ServiceId]
 	if !ok {
@@ -202,6 +323,12 @@ func (ns *directoryNamespace) Remove(serviceID uint32) error {
 func (ns *directoryNamespace) Enable(serviceID uint32) error {
 	return ns.directory.ServiceReady(serviceID)
 }
+
+func (ns *directoryNamespace) SetMetadata(serviceID uint32,
+	metadata map[string]string) error {
+	return ns.directory.SetServiceMetadata(serviceID, metadata)
+}
+
 func (ns *directoryNamespace) Resolve(name string) (uint32, error) {
 	info, err := ns.directory.Service(name)
 	if err != nil {
@@ -254,7 +381,7 @@ func (s *directorySession) client(info ServiceInfo) (bus.Client, error) {
 			}
 		}
 	}
-	_, endpoint, err := bus.SelectEndPoint(info.Endpoints, "", "")
+	_, endpoint, err := bus.SelectEndPoint(info.Endpoints, nil)
 	if err != nil {
 		return nil, fmt.Errorf("object connection error (%s): %s",
 			info.Name, err)
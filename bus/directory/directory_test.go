@@ -63,6 +63,11 @@ func (h *mockServiceDirectorySignalHelper) SignalServiceRemoved(
 	return nil
 }
 
+func (h *mockServiceDirectorySignalHelper) SignalServiceStateChanged(
+	serviceID uint32, name string, state string) error {
+	return nil
+}
+
 func newObjectRef(serviceID uint32) object.ObjectReference {
 	return object.ObjectReference{
 		MetaObject: object.ObjectMetaObject,
@@ -0,0 +1,294 @@
+package directory
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lugu/qiloop/bus"
+	"github.com/lugu/qiloop/bus/net"
+	"github.com/lugu/qiloop/bus/services"
+)
+
+// PeerRetryPeriod is the delay between two connection attempts to a
+// peer directory.
+var PeerRetryPeriod = 5 * time.Second
+
+// mirrorKey identifies a mirrored service: the instances of a service
+// share the same name.
+type mirrorKey struct {
+	name string
+	id   uint32
+}
+
+// addMirror adds or replaces a service of a peer directory.
+func (s *serviceDirectory) addMirror(info ServiceInfo) {
+	s.mutex.Lock()
+	for _, local := range s.services {
+		if local.Name == info.Name {
+			s.mutex.Unlock()
+			log.Printf("mirror %s: name already used", info.Name)
+			return
+		}
+	}
+	key := mirrorKey{info.Name, info.ServiceId}
+	_, replaced := s.mirrors[key]
+	s.mirrors[key] = info
+	signal := s.signal
+	s.mutex.Unlock()
+	if signal != nil && !replaced {
+		signal.SignalServiceAdded(info.ServiceId, info.Name)
+	}
+}
+
+// removeMirrors removes the mirrored services matching the
+// description.
+func (s *serviceDirectory) removeMirrors(match func(info ServiceInfo) bool) {
+	s.mutex.Lock()
+	removed := make([]ServiceInfo, 0)
+	for key, info := range s.mirrors {
+		if match(info) {
+			delete(s.mirrors, key)
+			removed = append(removed, info)
+		}
+	}
+	signal := s.signal
+	s.mutex.Unlock()
+	if signal == nil {
+		return
+	}
+	for _, info := range removed {
+		signal.SignalServiceRemoved(info.ServiceId, info.Name)
+	}
+}
+
+// peer mirrors the services of a peer directory.
+type peer struct {
+	prefix    string
+	addr      string
+	directory *serviceDirectory
+	retry     time.Duration
+	closeChan chan struct{}
+	endpoint  net.EndPoint
+	mutex     sync.Mutex
+}
+
+// parsePeer parses a peer description: [prefix=]url. The prefix
+// defaults to the host name of the URL.
+func parsePeer(desc string) (prefix, addr string, err error) {
+	addr = desc
+	if i := strings.Index(desc, "="); i > 0 &&
+		!strings.Contains(desc[:i], "://") {
+		prefix, addr = desc[:i], desc[i+1:]
+	} else if u, err := url.Parse(desc); err == nil {
+		prefix = u.Hostname()
+	}
+	if prefix == "" {
+		return "", "", fmt.Errorf("missing prefix for peer %s", desc)
+	}
+	if strings.Contains(prefix, "/") {
+		return "", "", fmt.Errorf("invalid prefix: %s", prefix)
+	}
+	return prefix, addr, nil
+}
+
+// mirror returns the local description of a service of the peer. The
+// service keeps its ID and its endpoints: the clients connect
+// directly to the peer.
+func (p *peer) mirror(info services.ServiceInfo) ServiceInfo {
+	return ServiceInfo{
+		Name:      p.prefix + "/" + info.Name,
+		ServiceId: info.ServiceId,
+		MachineId: info.MachineId,
+		ProcessId: info.ProcessId,
+		Endpoints: info.Endpoints,
+		SessionId: info.SessionId,
+	}
+}
+
+// run mirrors the peer until close is called. It reconnects to the
+// peer after PeerRetryPeriod when the connection is lost.
+func (p *peer) run() {
+	for {
+		err := p.follow()
+		select {
+		case <-p.closeChan:
+			return
+		default:
+		}
+		log.Printf("peer %s (%s): %s", p.prefix, p.addr, err)
+		select {
+		case <-p.closeChan:
+			return
+		case <-time.After(p.retry):
+		}
+	}
+}
+
+func (p *peer) connect() (net.EndPoint, error) {
+	_, endpoint, err := bus.SelectEndPoint([]string{p.addr}, nil)
+	if err != nil {
+		return nil, err
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	select {
+	case <-p.closeChan:
+		endpoint.Close()
+		return nil, fmt.Errorf("closed")
+	default:
+	}
+	p.endpoint = endpoint
+	return endpoint, nil
+}
+
+// follow mirrors the services of the peer until the connection is
+// lost. The mirrored services are removed when it returns. The
+// services mirrored by the peer are not mirrored.
+func (p *peer) follow() error {
+	endpoint, err := p.connect()
+	if err != nil {
+		return err
+	}
+	defer endpoint.Close()
+	defer p.directory.removeMirrors(func(info ServiceInfo) bool {
+		return strings.HasPrefix(info.Name, p.prefix+"/")
+	})
+
+	client := bus.NewClient(endpoint)
+	meta, err := bus.GetMetaObject(client, 1, 1)
+	if err != nil {
+		return fmt.Errorf("directory meta object: %s", err)
+	}
+	directory := services.MakeServiceDirectory(nil,
+		bus.NewProxy(client, meta, 1, 1))
+
+	cancelAdded, added, err := directory.SubscribeServiceAdded()
+	if err != nil {
+		return fmt.Errorf("subscribe serviceAdded: %s", err)
+	}
+	defer cancelAdded()
+	cancelRemoved, removed, err := directory.SubscribeServiceRemoved()
+	if err != nil {
+		return fmt.Errorf("subscribe serviceRemoved: %s", err)
+	}
+	defer cancelRemoved()
+
+	list, err := directory.Services()
+	if err != nil {
+		return fmt.Errorf("list services: %s", err)
+	}
+	for _, info := range list {
+		if !strings.Contains(info.Name, "/") {
+			p.directory.addMirror(p.mirror(info))
+		}
+	}
+	for {
+		select {
+		case event, ok := <-added:
+			if !ok {
+				return fmt.Errorf("disconnected")
+			}
+			if strings.Contains(event.Name, "/") {
+				continue
+			}
+			list, err := directory.Services()
+			if err != nil {
+				return fmt.Errorf("list services: %s", err)
+			}
+			for _, info := range list {
+				if info.ServiceId == event.ServiceID &&
+					info.Name == event.Name {
+					p.directory.addMirror(p.mirror(info))
+				}
+			}
+		case event, ok := <-removed:
+			if !ok {
+				return fmt.Errorf("disconnected")
+			}
+			name := p.prefix + "/" + event.Name
+			p.directory.removeMirrors(func(info ServiceInfo) bool {
+				return info.Name == name &&
+					info.ServiceId == event.ServiceID
+			})
+		case <-p.closeChan:
+			return nil
+		}
+	}
+}
+
+func (p *peer) close() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	close(p.closeChan)
+	if p.endpoint != nil {
+		p.endpoint.Close()
+	}
+}
+
+// directoryServer gives access to the service directory of a server.
+type directoryServer interface {
+	serviceDirectory() *serviceDirectory
+}
+
+// federatedServer stops the mirroring of the peers when the server is
+// terminated.
+type federatedServer struct {
+	bus.Server
+	directory *serviceDirectory
+	peers     []*peer
+}
+
+func (s *federatedServer) serviceDirectory() *serviceDirectory {
+	return s.directory
+}
+
+func (s *federatedServer) Terminate() error {
+	for _, p := range s.peers {
+		p.close()
+	}
+	return s.Server.Terminate()
+}
+
+// Federate mirrors the services of the peer directories into the
+// directory of server so that its clients can resolve them. A peer is
+// described as [prefix=]url, its services are named <prefix>/<name>
+// and the prefix defaults to the host name of the peer URL. The
+// mirrored services are removed when a peer is disconnected until it
+// reconnects. The services mirrored by a peer are not mirrored again.
+// The server must be created with NewServer or NewAdvertisedServer.
+func Federate(server bus.Server, peers []string) (bus.Server, error) {
+	s, ok := server.(directoryServer)
+	if !ok {
+		return nil, fmt.Errorf("not a service directory server")
+	}
+	federated := &federatedServer{
+		Server:    server,
+		directory: s.serviceDirectory(),
+	}
+	prefixes := make(map[string]bool)
+	for _, desc := range peers {
+		prefix, addr, err := parsePeer(desc)
+		if err != nil {
+			return nil, err
+		}
+		if prefixes[prefix] {
+			return nil, fmt.Errorf("duplicated prefix: %s", prefix)
+		}
+		prefixes[prefix] = true
+		federated.peers = append(federated.peers, &peer{
+			prefix:    prefix,
+			addr:      addr,
+			directory: federated.directory,
+			retry:     PeerRetryPeriod,
+			closeChan: make(chan struct{}),
+		})
+	}
+	for _, p := range federated.peers {
+		go p.run()
+	}
+	return federated, nil
+}
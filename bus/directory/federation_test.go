@@ -0,0 +1,230 @@
+package directory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/lugu/qiloop/bus"
+	proxy "github.com/lugu/qiloop/bus/services"
+	sess "github.com/lugu/qiloop/bus/session"
+	"github.com/lugu/qiloop/bus/util"
+	"github.com/lugu/qiloop/examples/space"
+)
+
+func TestParsePeer(t *testing.T) {
+	tests := map[string][2]string{
+		"tcp://robot2:9559":             {"robot2", "tcp://robot2:9559"},
+		"nao=tcp://10.0.0.2:9559":       {"nao", "tcp://10.0.0.2:9559"},
+		"tcps://robot3:9443?ca=ca.pem":  {"robot3", "tcps://robot3:9443?ca=ca.pem"},
+		"local=unix:///tmp/qiloop.sock": {"local", "unix:///tmp/qiloop.sock"},
+	}
+	for desc, expected := range tests {
+		prefix, addr, err := parsePeer(desc)
+		if err != nil {
+			t.Errorf("%s: %s", desc, err)
+		} else if prefix != expected[0] || addr != expected[1] {
+			t.Errorf("%s: unexpected peer %s %s", desc, prefix, addr)
+		}
+	}
+	for _, desc := range []string{"unix:///tmp/qiloop.sock", "a/b=tcp://robot:9559"} {
+		if _, _, err := parsePeer(desc); err == nil {
+			t.Errorf("%s: shall fail", desc)
+		}
+	}
+}
+
+// waitService waits until the presence of the service in the
+// directory is as expected.
+// waitService waits until the session learns the service name is
+// present (or absent).
+func waitService(t *testing.T, session bus.Session, name string,
+	present bool) {
+
+	ctx, cancel := context.WithTimeout(context.Background(),
+		5*time.Second)
+	defer cancel()
+	events := sess.WatchServices(ctx, session)
+	if !present {
+		// the service may be removed before the subscription.
+		directory, err := proxy.Services(session).ServiceDirectory(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := directory.Service(name); err != nil {
+			return
+		}
+	}
+	expected := sess.ServiceAvailable
+	if !present {
+		expected = sess.ServiceUnavailable
+	}
+	for event := range events {
+		if event.Type == expected && event.Info.Name == name {
+			return
+		}
+	}
+	t.Fatalf("service %s: expected presence %v", name, present)
+}
+
+func TestFederate(t *testing.T) {
+	retryPeriod := PeerRetryPeriod
+	PeerRetryPeriod = 10 * time.Millisecond
+	defer func() { PeerRetryPeriod = retryPeriod }()
+
+	addr1, addr2 := util.NewUnixAddr(), util.NewUnixAddr()
+	server1, err := NewServer(addr1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server1, err = Federate(server1, []string{"robot2=" + addr2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server1.Terminate()
+
+	session, err := sess.NewSession(addr1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Terminate()
+	directory, err := proxy.Services(session).ServiceDirectory(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the peer is not yet started.
+	server2, err := NewServer(addr2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitService(t, session, "robot2/ServiceDirectory", true)
+
+	service, err := server2.NewService("Test",
+		ServiceDirectoryObject(serviceDirectoryImpl()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitService(t, session, "robot2/Test", true)
+
+	// the mirrored services are called on the peer.
+	p, err := session.Proxy("robot2/ServiceDirectory", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer := proxy.MakeServiceDirectory(session, p)
+	info, err := peer.Service("Test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ServiceId != service.ServiceID() {
+		t.Errorf("unexpected service: %#v", info)
+	}
+
+	if err = service.Terminate(); err != nil {
+		t.Fatal(err)
+	}
+	waitService(t, session, "robot2/Test", false)
+
+	list, err := directory.Services()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].Name != "ServiceDirectory" ||
+		list[1].Name != "robot2/ServiceDirectory" {
+		t.Errorf("unexpected services: %v", list)
+	}
+
+	// the disconnection removes the mirrored services.
+	server2.Terminate()
+	waitService(t, session, "robot2/ServiceDirectory", false)
+}
+
+func TestFederateSameID(t *testing.T) {
+	retryPeriod := PeerRetryPeriod
+	PeerRetryPeriod = 10 * time.Millisecond
+	defer func() { PeerRetryPeriod = retryPeriod }()
+
+	addr1, addr2 := util.NewUnixAddr(), util.NewUnixAddr()
+	server2, err := NewServer(addr2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server2.Terminate()
+	server1, err := NewServer(addr1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server1, err = Federate(server1, []string{"robot2=" + addr2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server1.Terminate()
+
+	// the local service and the mirrored one have the same ID.
+	local, err := server1.NewService("Spacecraft", space.NewSpacecraftObject())
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote, err := server2.NewService("Spacecraft", space.NewSpacecraftObject())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if local.ServiceID() != remote.ServiceID() {
+		t.Fatalf("unexpected IDs: %d, %d", local.ServiceID(),
+			remote.ServiceID())
+	}
+
+	session, err := sess.NewSession(addr1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Terminate()
+	directory, err := proxy.Services(session).ServiceDirectory(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitService(t, session, "robot2/Spacecraft", true)
+
+	// the objects returned by the mirrored service are on the peer.
+	p, err := session.Proxy("robot2/Spacecraft", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bomb, err := space.MakeSpacecraft(session, p).Shoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = bomb.SetDelay(7); err != nil {
+		t.Fatal(err)
+	}
+	spacecraft, err := space.Services(session).Spacecraft(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bomb, err = spacecraft.Shoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if delay, err := bomb.GetDelay(); err != nil {
+		t.Fatal(err)
+	} else if delay == 7 {
+		t.Errorf("local bomb modified")
+	}
+
+	// the mirrored service is not confused with the local one.
+	health, err := directory.ServiceHealth("robot2/Spacecraft")
+	if err != nil {
+		t.Fatal(err)
+	} else if health.State != HealthUnknown {
+		t.Errorf("unexpected health: %#v", health)
+	}
+	list, err := directory.FindServices(proxy.ServiceFilter{
+		Name: "robot2/*",
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if len(list) != 2 || list[1].Name != "robot2/Spacecraft" {
+		t.Errorf("unexpected services: %v", list)
+	}
+}
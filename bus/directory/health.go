@@ -0,0 +1,186 @@
+package directory
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/lugu/qiloop/bus"
+	"github.com/lugu/qiloop/bus/session/token"
+	"github.com/lugu/qiloop/bus/util"
+)
+
+// Health states of the services.
+const (
+	// HealthUnknown is the state of a service not yet probed.
+	HealthUnknown = "unknown"
+	// HealthHealthy is the state of a service answering the probes.
+	HealthHealthy = "healthy"
+	// HealthUnhealthy is the state of a service failing the probes.
+	HealthUnhealthy = "unhealthy"
+)
+
+// HealthCheck configures the probes of the registered services. A
+// probe connects a service and calls the metaObject method of its
+// object 1.
+type HealthCheck struct {
+	// Period between two probes. Zero disables the probes.
+	Period time.Duration
+	// Timeout of a probe.
+	Timeout time.Duration
+	// Evict is the number of consecutive failed probes after which
+	// a service is unregistered. Zero never unregisters.
+	Evict int
+	// Credentials used to authenticate the probes. The tokens
+	// issued to the probes are not saved in the profiles.
+	Credentials token.Credentials
+}
+
+// DefaultHealthCheck is the configuration of the service directories
+// created after its modification. The probes are disabled by default:
+// the remote services are in the state HealthUnknown.
+var DefaultHealthCheck = HealthCheck{
+	Period:  0,
+	Timeout: 5 * time.Second,
+}
+
+// ServiceHealth returns the health of the instance of a service with
+// the lowest ID. The mirrored services are not probed: their state is
+// unknown.
+func (s *serviceDirectory) ServiceHealth(name string) (ServiceHealth, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	list := s.instances(name)
+	if len(list) == 0 {
+		return ServiceHealth{}, fmt.Errorf("Service not found: %s", name)
+	}
+	if !s.registered(list[0]) {
+		return ServiceHealth{State: HealthUnknown}, nil
+	}
+	if health, ok := s.health[list[0].ServiceId]; ok {
+		return health, nil
+	}
+	return ServiceHealth{State: HealthUnknown}, nil
+}
+
+// local returns true if the service is part of the process of the
+// directory.
+func local(info ServiceInfo) bool {
+	return info.MachineId == util.MachineID() &&
+		info.ProcessId == util.ProcessID()
+}
+
+// probe calls the metaObject method of the service and returns its
+// duration.
+func probe(info ServiceInfo, check HealthCheck) (time.Duration, error) {
+	start := time.Now()
+	result := make(chan error, 1)
+	// the profile is not updated when a token is issued.
+	creds := check.Credentials
+	creds.Profile = ""
+	go func() {
+		_, endpoint, err := bus.SelectEndPoint(info.Endpoints, &creds)
+		if err != nil {
+			result <- err
+			return
+		}
+		defer endpoint.Close()
+		_, err = bus.GetMetaObject(bus.NewClient(endpoint),
+			info.ServiceId, 1)
+		result <- err
+	}()
+	timer := time.NewTimer(check.Timeout)
+	defer timer.Stop()
+	select {
+	case err := <-result:
+		return time.Since(start), err
+	case <-timer.C:
+		return 0, fmt.Errorf("timeout after %s", check.Timeout)
+	}
+}
+
+// probeAll probes the registered services. The services of the
+// process of the directory are healthy as long as the directory
+// answers.
+func (s *serviceDirectory) probeAll() {
+	s.mutex.Lock()
+	list := make([]ServiceInfo, 0, len(s.services))
+	for _, info := range s.services {
+		list = append(list, info)
+	}
+	s.mutex.Unlock()
+
+	var wait sync.WaitGroup
+	for _, info := range list {
+		if local(info) {
+			s.updateHealth(info, 0, nil)
+			continue
+		}
+		wait.Add(1)
+		go func(info ServiceInfo) {
+			defer wait.Done()
+			latency, err := probe(info, s.check)
+			s.updateHealth(info, latency, err)
+		}(info)
+	}
+	wait.Wait()
+}
+
+// updateHealth records the result of a probe. It signals the changes
+// of state and unregisters the service after check.Evict failures.
+func (s *serviceDirectory) updateHealth(info ServiceInfo,
+	latency time.Duration, err error) {
+
+	s.mutex.Lock()
+	health, ok := s.health[info.ServiceId]
+	if !ok {
+		// unregistered during the probe.
+		s.mutex.Unlock()
+		return
+	}
+	previous := health.State
+	health.LastProbe = time.Now().UnixNano()
+	if err == nil {
+		health.State = HealthHealthy
+		health.Failures = 0
+		health.Latency = latency.Nanoseconds()
+	} else {
+		health.State = HealthUnhealthy
+		health.Failures++
+	}
+	s.health[info.ServiceId] = health
+	signal := s.signal
+	s.mutex.Unlock()
+
+	if health.State != previous {
+		if err != nil {
+			log.Printf("service %s (%d) %s: %s", info.Name,
+				info.ServiceId, health.State, err)
+		}
+		if signal != nil {
+			signal.SignalServiceStateChanged(info.ServiceId,
+				info.Name, health.State)
+		}
+	}
+	if err != nil && s.check.Evict > 0 &&
+		health.Failures >= uint32(s.check.Evict) {
+		log.Printf("service %s (%d) evicted after %d failed probes",
+			info.Name, info.ServiceId, health.Failures)
+		s.UnregisterService(info.ServiceId)
+	}
+}
+
+// checkHealth probes the services periodically until stop is closed.
+func (s *serviceDirectory) checkHealth(stop chan struct{}) {
+	ticker := time.NewTicker(s.check.Period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			s.probeAll()
+		}
+	}
+}
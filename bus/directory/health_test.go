@@ -0,0 +1,157 @@
+package directory
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lugu/qiloop/bus"
+	proxy "github.com/lugu/qiloop/bus/services"
+	sess "github.com/lugu/qiloop/bus/session"
+	"github.com/lugu/qiloop/bus/session/token"
+	"github.com/lugu/qiloop/bus/util"
+)
+
+func TestHealthCheck(t *testing.T) {
+	check := DefaultHealthCheck
+	DefaultHealthCheck = HealthCheck{
+		Period:  20 * time.Millisecond,
+		Timeout: time.Second,
+		Evict:   3,
+	}
+	defer func() { DefaultHealthCheck = check }()
+
+	addr1, addr2 := util.NewUnixAddr(), util.NewUnixAddr()
+	server1, err := NewServer(addr1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server1.Terminate()
+	server2, err := NewServer(addr2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	service, err := server2.NewService("Test",
+		ServiceDirectoryObject(serviceDirectoryImpl()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := sess.NewSession(addr1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Terminate()
+	directory, err := proxy.Services(session).ServiceDirectory(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel, changes, err := directory.SubscribeServiceStateChanged()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+
+	// register the service of server2 as if it was from another
+	// process.
+	info := newClientInfo("Remote")
+	info.ProcessId = util.ProcessID() + 1
+	info.Endpoints = []string{addr2}
+	id, err := directory.RegisterService(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != service.ServiceID() {
+		t.Fatalf("unexpected service id: %d", id)
+	}
+	if err = directory.ServiceReady(id); err != nil {
+		t.Fatal(err)
+	}
+
+	waitState := func(state string) {
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case change := <-changes:
+				if change.ServiceID == id && change.State == state {
+					return
+				}
+			case <-timeout:
+				t.Fatalf("missing state %s", state)
+			}
+		}
+	}
+	waitState(HealthHealthy)
+	health, err := directory.ServiceHealth("Remote")
+	if err != nil {
+		t.Fatal(err)
+	} else if health.State != HealthHealthy || health.Failures != 0 ||
+		health.LastProbe == 0 {
+		t.Errorf("unexpected health: %#v", health)
+	}
+	health, err = directory.ServiceHealth("ServiceDirectory")
+	if err != nil {
+		t.Fatal(err)
+	} else if health.State != HealthHealthy {
+		t.Errorf("unexpected health: %#v", health)
+	}
+	if _, err = directory.ServiceHealth("Unknown"); err == nil {
+		t.Errorf("shall fail")
+	}
+
+	server2.Terminate()
+	waitState(HealthUnhealthy)
+	for i := 0; i < 100; i++ {
+		if _, err = directory.Service("Remote"); err != nil {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Errorf("service not evicted")
+}
+
+func TestProbeCredentials(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "qiloop-probe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	defer func(name string) {
+		token.ProfileFile = name
+	}(token.ProfileFile)
+	token.ProfileFile = filepath.Join(tmp, "profiles.conf")
+
+	addr := util.NewUnixAddr()
+	server, err := NewServer(addr, &bus.TokenStore{
+		Login: bus.Dictionary(map[string]string{"probe": "secret"}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Terminate()
+
+	info := newInfo("ServiceDirectory")
+	info.ServiceId = 1
+	info.Endpoints = []string{addr}
+	check := HealthCheck{
+		Timeout: time.Second,
+		Credentials: token.Credentials{
+			User:    "probe",
+			Token:   "secret",
+			Profile: addr,
+		},
+	}
+	if _, err = probe(info, check); err != nil {
+		t.Fatal(err)
+	}
+	// the token issued to the probe is not saved.
+	if _, err = os.Stat(token.ProfileFile); !os.IsNotExist(err) {
+		t.Errorf("profile written: %v", err)
+	}
+	check.Credentials.Token = "wrong"
+	if _, err = probe(info, check); err == nil {
+		t.Errorf("shall fail")
+	}
+}
@@ -0,0 +1,108 @@
+package directory
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/lugu/qiloop/bus"
+)
+
+// SetServiceMetadata replaces the metadata of a service staging or
+// ready. The metadata is kept apart from ServiceInfo which is shared
+// with libqi.
+func (s *serviceDirectory) SetServiceMetadata(serviceID uint32,
+	metadata map[string]string) error {
+
+	for key := range metadata {
+		if key == "" {
+			return fmt.Errorf("empty metadata key not allowed")
+		}
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, staging := s.staging[serviceID]
+	_, ready := s.services[serviceID]
+	if !staging && !ready {
+		return fmt.Errorf("Service not found: %d", serviceID)
+	}
+	copied := make(map[string]string, len(metadata))
+	for key, value := range metadata {
+		copied[key] = value
+	}
+	s.metadata[serviceID] = copied
+	return nil
+}
+
+// ServiceMetadata returns the metadata of a service. The mirrored
+// services have no metadata.
+func (s *serviceDirectory) ServiceMetadata(serviceID uint32) (map[string]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.services[serviceID]; !ok {
+		return nil, fmt.Errorf("Service not found: %d", serviceID)
+	}
+	metadata := make(map[string]string, len(s.metadata[serviceID]))
+	for key, value := range s.metadata[serviceID] {
+		metadata[key] = value
+	}
+	return metadata, nil
+}
+
+// matchFilter returns true if a service with the given name and
+// metadata matches the filter.
+func matchFilter(filter ServiceFilter, name string,
+	metadata map[string]string) (bool, error) {
+
+	if filter.Name != "" {
+		matched, err := path.Match(filter.Name, name)
+		if err != nil || !matched {
+			return false, err
+		}
+	}
+	tags := make(map[string]bool)
+	for _, tag := range bus.Tags(metadata) {
+		tags[tag] = true
+	}
+	for _, tag := range filter.Tags {
+		if !tags[tag] {
+			return false, nil
+		}
+	}
+	for key, value := range filter.Metadata {
+		if metadata[key] != value {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// FindServices returns the services ready matching the filter in the
+// order of Services. The name of the filter is a glob pattern (see
+// path.Match), the services must have every tag and every metadata
+// entry of the filter. Empty fields match every service.
+func (s *serviceDirectory) FindServices(filter ServiceFilter) ([]ServiceInfo, error) {
+	if _, err := path.Match(filter.Name, ""); err != nil {
+		return nil, fmt.Errorf("invalid name pattern %s: %s",
+			filter.Name, err)
+	}
+	list, err := s.Services()
+	if err != nil {
+		return nil, err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	found := make([]ServiceInfo, 0)
+	for _, info := range list {
+		var metadata map[string]string
+		if s.registered(info) {
+			metadata = s.metadata[info.ServiceId]
+		}
+		matched, err := matchFilter(filter, info.Name, metadata)
+		if err != nil {
+			return nil, err
+		} else if matched {
+			found = append(found, info)
+		}
+	}
+	return found, nil
+}
@@ -0,0 +1,133 @@
+package directory
+
+import (
+	"testing"
+
+	"github.com/lugu/qiloop/bus"
+	proxy "github.com/lugu/qiloop/bus/services"
+	sess "github.com/lugu/qiloop/bus/session"
+	"github.com/lugu/qiloop/bus/util"
+)
+
+func TestFindServices(t *testing.T) {
+	addr := util.NewUnixAddr()
+	server, err := NewServer(addr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Terminate()
+
+	_, err = server.NewService("Camera",
+		ServiceDirectoryObject(serviceDirectoryImpl()),
+		bus.WithTags("robot", "video"),
+		bus.WithMetadata(bus.MetadataVersion, "1.2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := sess.NewSession(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Terminate()
+	directory, err := proxy.Services(session).ServiceDirectory(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a service from another server sets its metadata remotely.
+	remote, err := proxy.NewServer(session, util.NewUnixAddr(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer remote.Terminate()
+	service, err := remote.NewService("Cameraman",
+		ServiceDirectoryObject(serviceDirectoryImpl()),
+		bus.WithTags("robot"), bus.WithMetadata(bus.MetadataOwner, "team"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata, err := directory.ServiceMetadata(service.ServiceID())
+	if err != nil {
+		t.Fatal(err)
+	} else if metadata[bus.MetadataOwner] != "team" ||
+		metadata[bus.MetadataTags] != "robot" {
+		t.Errorf("unexpected metadata: %v", metadata)
+	}
+
+	find := func(filter proxy.ServiceFilter, expected ...string) {
+		list, err := directory.FindServices(filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := make([]string, len(list))
+		for i, info := range list {
+			names[i] = info.Name
+		}
+		if len(names) != len(expected) {
+			t.Errorf("%#v: unexpected services %v", filter, names)
+			return
+		}
+		for i := range names {
+			if names[i] != expected[i] {
+				t.Errorf("%#v: unexpected services %v", filter, names)
+			}
+		}
+	}
+	find(proxy.ServiceFilter{}, "ServiceDirectory", "Camera", "Cameraman")
+	find(proxy.ServiceFilter{Tags: []string{"robot"}}, "Camera", "Cameraman")
+	find(proxy.ServiceFilter{Tags: []string{"robot", "video"}}, "Camera")
+	find(proxy.ServiceFilter{Name: "Camera*", Tags: []string{"robot"}},
+		"Camera", "Cameraman")
+	find(proxy.ServiceFilter{Name: "Cam?ra"}, "Camera")
+	find(proxy.ServiceFilter{
+		Metadata: map[string]string{bus.MetadataVersion: "1.2"},
+	}, "Camera")
+	find(proxy.ServiceFilter{Tags: []string{"audio"}})
+	if _, err = directory.FindServices(proxy.ServiceFilter{Name: "["}); err == nil {
+		t.Errorf("shall fail")
+	}
+
+	// the metadata is removed with the service.
+	if err = service.Terminate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = directory.ServiceMetadata(service.ServiceID()); err == nil {
+		t.Errorf("shall fail")
+	}
+	find(proxy.ServiceFilter{Tags: []string{"robot"}}, "Camera")
+}
+
+func TestSetServiceMetadata(t *testing.T) {
+	directory := serviceDirectoryImpl()
+	id, err := directory.RegisterService(newInfo("A"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = directory.SetServiceMetadata(id, map[string]string{"": "a"}); err == nil {
+		t.Errorf("shall fail")
+	}
+	if err = directory.SetServiceMetadata(id+1, nil); err == nil {
+		t.Errorf("shall fail")
+	}
+	if err = directory.SetServiceMetadata(id, map[string]string{
+		bus.MetadataTags: " a, b ,,c",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err = directory.ServiceReady(id); err != nil {
+		t.Fatal(err)
+	}
+	list, err := directory.FindServices(ServiceFilter{Tags: []string{"b", "c"}})
+	if err != nil {
+		t.Fatal(err)
+	} else if len(list) != 1 || list[0].ServiceId != id {
+		t.Errorf("unexpected services: %v", list)
+	}
+	if err = directory.UnregisterService(id); err != nil {
+		t.Fatal(err)
+	}
+	if len(directory.metadata) != 0 {
+		t.Errorf("metadata not removed: %v", directory.metadata)
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"fmt"
 
 	"github.com/lugu/qiloop/bus"
+	"github.com/lugu/qiloop/bus/mdns"
 	"github.com/lugu/qiloop/bus/net"
+	"github.com/lugu/qiloop/bus/util"
 )
 
 // NewServer starts a server listening on addr. If parameter auth is
@@ -21,7 +23,7 @@ func NewServer(addr string, auth bus.Authenticator) (bus.Server, error) {
 	}
 
 	sd := serviceDirectoryImpl()
-	namespace := sd.Namespace(addr)
+	namespace := sd.Namespace(net.PublicAddress(addr))
 	service1 := ServiceDirectoryObject(sd)
 
 	s, err := bus.NewServer(listener, auth, namespace, service1)
@@ -29,5 +31,50 @@ func NewServer(addr string, auth bus.Authenticator) (bus.Server, error) {
 		listener.Close()
 		return nil, err
 	}
-	return s, nil
+	return &server{s, sd}, nil
+}
+
+// server gives access to its service directory (see Federate).
+type server struct {
+	bus.Server
+	directory *serviceDirectory
+}
+
+func (s *server) serviceDirectory() *serviceDirectory {
+	return s.directory
+}
+
+// advertisedServer stops the advertisement when the server is
+// terminated.
+type advertisedServer struct {
+	bus.Server
+	responder *mdns.Responder
+}
+
+func (s *advertisedServer) serviceDirectory() *serviceDirectory {
+	return s.Server.(directoryServer).serviceDirectory()
+}
+
+func (s *advertisedServer) Terminate() error {
+	s.responder.Close()
+	return s.Server.Terminate()
+}
+
+// NewAdvertisedServer starts a server like NewServer and advertises
+// it on the local network with mDNS until it is terminated. The
+// address is advertised with the machine ID (see session.Discover).
+func NewAdvertisedServer(addr string, auth bus.Authenticator) (bus.Server, error) {
+	s, err := NewServer(addr, auth)
+	if err != nil {
+		return nil, err
+	}
+	machineID := util.MachineID()
+	instance := fmt.Sprintf("qiloop-%.8s-%d", machineID, util.ProcessID())
+	responder, err := mdns.Advertise(instance,
+		[]string{net.PublicAddress(addr)}, machineID)
+	if err != nil {
+		s.Terminate()
+		return nil, fmt.Errorf("advertise %s: %s", addr, err)
+	}
+	return &advertisedServer{s, responder}, nil
 }
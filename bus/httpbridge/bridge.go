@@ -0,0 +1,523 @@
+// Package httpbridge exposes the services of a bus session over
+// HTTP with JSON encoded values.
+//
+// Routes:
+//
+//	GET  /services                          list of the services
+//	GET  /services/{name}                   meta object of the service
+//	GET  /services/{name}/{object}          meta object of an object
+//	POST /services/{name}/{object}/{method} call a method
+//	GET  /services/{name}/{object}/{prop}   read a property
+//	PUT  /services/{name}/{object}/{prop}   update a property
+//	GET  /services/{name}/{object}/{signal} stream of signal events
+//
+// The parameters of a method are sent as a JSON array. Signals and
+// property updates (with "Accept: text/event-stream") are streamed as
+// Server-Sent Events. Object references returned by the service are
+// registered by the bridge and can be accessed using their href until
+// the connection to their service is lost.
+// The documents generated by "qiloop schema" describe those routes.
+package httpbridge
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/lugu/qiloop/bus"
+	"github.com/lugu/qiloop/bus/services"
+	"github.com/lugu/qiloop/meta/signature"
+	"github.com/lugu/qiloop/type/object"
+	"github.com/lugu/qiloop/type/value"
+)
+
+// remoteObject is a proxy with its meta object.
+type remoteObject struct {
+	proxy bus.Proxy
+	meta  object.MetaObject
+}
+
+// Bridge is an http.Handler which translates HTTP requests into calls
+// to the services of a session.
+type Bridge struct {
+	session bus.Session
+	objects map[string]remoteObject
+	refs    map[string]object.ObjectReference
+	// names caches the names of the services of the references
+	// indexed by service ID.
+	names map[uint32]string
+	mutex sync.Mutex
+}
+
+// NewBridge returns a Bridge backed by the session sess.
+func NewBridge(sess bus.Session) *Bridge {
+	return &Bridge{
+		session: sess,
+		objects: make(map[string]remoteObject),
+		refs:    make(map[string]object.ObjectReference),
+		names:   make(map[uint32]string),
+	}
+}
+
+func objectKey(name string, objectID uint32) string {
+	return fmt.Sprintf("%s/%d", name, objectID)
+}
+
+func href(name string, objectID uint32) string {
+	return "/services/" + objectKey(name, objectID)
+}
+
+// object returns a proxy to an object. Object references previously
+// returned by a service are used when available.
+func (b *Bridge) object(name string, objectID uint32) (remoteObject, error) {
+	key := objectKey(name, objectID)
+	b.mutex.Lock()
+	obj, ok := b.objects[key]
+	ref, isRef := b.refs[key]
+	b.mutex.Unlock()
+	if ok {
+		return obj, nil
+	}
+	if isRef {
+		proxy, err := b.session.Object(ref)
+		if err != nil {
+			return obj, err
+		}
+		obj = remoteObject{proxy, ref.MetaObject}
+	} else {
+		proxy, err := b.session.Proxy(name, objectID)
+		if err != nil {
+			return obj, err
+		}
+		meta, err := bus.MakeObject(proxy).MetaObject(objectID)
+		if err != nil {
+			return obj, fmt.Errorf("get meta object: %s", err)
+		}
+		obj = remoteObject{proxy, meta}
+	}
+	serviceID := obj.proxy.ServiceID()
+	err := obj.proxy.OnDisconnect(func(error) {
+		b.mutex.Lock()
+		delete(b.objects, key)
+		delete(b.refs, key)
+		delete(b.names, serviceID)
+		b.mutex.Unlock()
+	})
+	if err != nil {
+		return obj, err
+	}
+	b.mutex.Lock()
+	b.objects[key] = obj
+	b.mutex.Unlock()
+	return obj, nil
+}
+
+func (b *Bridge) directory() (services.ServiceDirectoryProxy, error) {
+	return services.Services(b.session).ServiceDirectory(nil)
+}
+
+// serviceName returns the name of a service. The name is cached
+// until the connection to the service is lost.
+func (b *Bridge) serviceName(serviceID uint32) (string, error) {
+	b.mutex.Lock()
+	name, ok := b.names[serviceID]
+	b.mutex.Unlock()
+	if ok {
+		return name, nil
+	}
+	directory, err := b.directory()
+	if err != nil {
+		return "", err
+	}
+	list, err := directory.Services()
+	if err != nil {
+		return "", err
+	}
+	for _, info := range list {
+		if info.ServiceId == serviceID {
+			b.mutex.Lock()
+			b.names[serviceID] = info.Name
+			b.mutex.Unlock()
+			return info.Name, nil
+		}
+	}
+	return "", fmt.Errorf("service not found: %d", serviceID)
+}
+
+// reference registers an object reference and returns its JSON
+// representation. The reference is forgotten when the connection to
+// its service is lost.
+func (b *Bridge) reference(ref object.ObjectReference) map[string]interface{} {
+	v := map[string]interface{}{
+		"serviceID": ref.ServiceID,
+		"objectID":  ref.ObjectID,
+	}
+	name, err := b.serviceName(ref.ServiceID)
+	if err != nil {
+		return v
+	}
+	key := objectKey(name, ref.ObjectID)
+	b.mutex.Lock()
+	b.refs[key] = ref
+	b.mutex.Unlock()
+	// the proxy removes the reference when it is disconnected.
+	if _, err := b.object(name, ref.ObjectID); err != nil {
+		b.mutex.Lock()
+		delete(b.refs, key)
+		b.mutex.Unlock()
+		return v
+	}
+	v["href"] = href(name, ref.ObjectID)
+	return v
+}
+
+// lookupReference returns the object reference represented by v.
+func (b *Bridge) lookupReference(v interface{}) (ref object.ObjectReference, err error) {
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return ref, fmt.Errorf("expecting an object reference, got %T", v)
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if h, ok := obj["href"].(string); ok {
+		if ref, ok := b.refs[strings.TrimPrefix(h, "/services/")]; ok {
+			return ref, nil
+		}
+		return ref, fmt.Errorf("unknown object: %s", h)
+	}
+	serviceID, _ := obj["serviceID"].(json.Number)
+	objectID, _ := obj["objectID"].(json.Number)
+	for _, ref := range b.refs {
+		if serviceID.String() == fmt.Sprint(ref.ServiceID) &&
+			objectID.String() == fmt.Sprint(ref.ObjectID) {
+			return ref, nil
+		}
+	}
+	return ref, fmt.Errorf("unknown object reference: %v", v)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(data)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	data, _ := json.Marshal(map[string]string{
+		"error": err.Error(),
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(data)
+}
+
+func methodNotAllowed(w http.ResponseWriter, allow ...string) {
+	w.Header().Set("Allow", strings.Join(allow, ", "))
+	writeError(w, http.StatusMethodNotAllowed,
+		fmt.Errorf("method not allowed"))
+}
+
+// readBody decodes the JSON body of a request. An empty body is
+// returned as nil.
+func readBody(r *http.Request) (interface{}, error) {
+	decoder := json.NewDecoder(r.Body)
+	decoder.UseNumber()
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("invalid JSON body: %s", err)
+	}
+	return v, nil
+}
+
+// ServeHTTP implements http.Handler.
+func (b *Bridge) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if path[0] != "services" || len(path) > 4 {
+		writeError(w, http.StatusNotFound,
+			fmt.Errorf("not found: %s", r.URL.Path))
+		return
+	}
+	if len(path) == 1 {
+		b.serveServices(w, r)
+		return
+	}
+	objectID := uint32(1)
+	if len(path) > 2 {
+		id, err := strconv.ParseUint(path[2], 10, 32)
+		if err != nil {
+			writeError(w, http.StatusBadRequest,
+				fmt.Errorf("invalid object id: %s", path[2]))
+			return
+		}
+		objectID = uint32(id)
+	}
+	obj, err := b.object(path[1], objectID)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if len(path) < 4 {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, obj.meta.JSON())
+		return
+	}
+	b.serveAction(w, r, obj, path[3])
+}
+
+func (b *Bridge) serveServices(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	directory, err := b.directory()
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	list, err := directory.Services()
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (b *Bridge) serveAction(w http.ResponseWriter, r *http.Request,
+	obj remoteObject, action string) {
+
+	if id, err := obj.meta.PropertyID(action); err == nil {
+		switch r.Method {
+		case http.MethodGet:
+			b.getProperty(w, r, obj, obj.meta.Properties[id])
+		case http.MethodPut:
+			b.setProperty(w, r, obj, obj.meta.Properties[id])
+		default:
+			methodNotAllowed(w, http.MethodGet, http.MethodPut)
+		}
+		return
+	}
+	if id, err := obj.meta.SignalID(action); err == nil {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		// events are sent as the array of the signal parameters.
+		params, err := members(obj.meta.Signals[id].Signature)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		b.stream(w, r, obj, id, signature.NewTupleType(params))
+		return
+	}
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+	b.call(w, r, obj, action)
+}
+
+// method returns the method named name which accepts count
+// parameters. Overloaded methods are resolved using the number of
+// parameters.
+func method(meta object.MetaObject, name string, count int) (object.MetaMethod, []signature.Type, error) {
+	ids := make([]int, 0)
+	for id, m := range meta.Methods {
+		if m.Name == name {
+			ids = append(ids, int(id))
+		}
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		m := meta.Methods[uint32(id)]
+		params, err := members(m.ParametersSignature)
+		if err != nil {
+			return m, nil, err
+		}
+		if len(params) == count {
+			return m, params, nil
+		}
+	}
+	return object.MetaMethod{}, nil,
+		fmt.Errorf("%s does not accept %d parameters", name, count)
+}
+
+func (b *Bridge) call(w http.ResponseWriter, r *http.Request,
+	obj remoteObject, name string) {
+
+	body, err := readBody(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	args, ok := body.([]interface{})
+	if !ok && body != nil {
+		writeError(w, http.StatusBadRequest,
+			fmt.Errorf("expecting an array of parameters"))
+		return
+	}
+	if _, err := obj.meta.MethodID(name); err != nil {
+		writeError(w, http.StatusNotFound,
+			fmt.Errorf("unknown action: %s", name))
+		return
+	}
+	m, params, err := method(obj.meta, name, len(args))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	var buf bytes.Buffer
+	for i, t := range params {
+		if err := b.encode(t, args[i], &buf); err != nil {
+			writeError(w, http.StatusBadRequest,
+				fmt.Errorf("parameter %d: %s", i, err))
+			return
+		}
+	}
+	response, err := obj.proxy.CallID(m.Uid, buf.Bytes())
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	t, err := typeOf(m.ReturnSignature)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if t.Signature() == "v" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	ret, err := b.decode(t, bytes.NewBuffer(response))
+	if err != nil {
+		writeError(w, http.StatusBadGateway,
+			fmt.Errorf("decode response: %s", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, ret)
+}
+
+func wantEvents(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
+
+func (b *Bridge) getProperty(w http.ResponseWriter, r *http.Request,
+	obj remoteObject, prop object.MetaProperty) {
+
+	if wantEvents(r) {
+		t, err := propertyType(prop.Signature)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		b.stream(w, r, obj, prop.Uid, t)
+		return
+	}
+	v, err := bus.MakeObject(obj.proxy).Property(value.String(prop.Name))
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	t, err := typeOf(v.Signature())
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	ret, err := b.decode(t, bytes.NewBuffer(value.Bytes(v)))
+	if err != nil {
+		writeError(w, http.StatusBadGateway,
+			fmt.Errorf("decode property: %s", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, ret)
+}
+
+func (b *Bridge) setProperty(w http.ResponseWriter, r *http.Request,
+	obj remoteObject, prop object.MetaProperty) {
+
+	t, err := propertyType(prop.Signature)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	body, err := readBody(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	var buf bytes.Buffer
+	if err := b.encode(t, body, &buf); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	err = bus.MakeObject(obj.proxy).SetProperty(value.String(prop.Name),
+		value.Opaque(t.Signature(), buf.Bytes()))
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// stream sends the events of a signal or a property as Server-Sent
+// Events until the client disconnects.
+func (b *Bridge) stream(w http.ResponseWriter, r *http.Request,
+	obj remoteObject, action uint32, t signature.Type) {
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError,
+			fmt.Errorf("streaming not supported"))
+		return
+	}
+	cancel, events, err := obj.proxy.SubscribeID(action)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case payload, ok := <-events:
+			if !ok {
+				return
+			}
+			v, err := b.decode(t, bytes.NewBuffer(payload))
+			if err != nil {
+				log.Printf("decode event: %s", err)
+				continue
+			}
+			data, err := json.Marshal(v)
+			if err != nil {
+				log.Printf("encode event: %s", err)
+				continue
+			}
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package httpbridge_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lugu/qiloop/bus/directory"
+	"github.com/lugu/qiloop/bus/httpbridge"
+	"github.com/lugu/qiloop/bus/logger"
+	"github.com/lugu/qiloop/bus/session"
+	"github.com/lugu/qiloop/bus/util"
+)
+
+func newBridge(t *testing.T) (*httptest.Server, func()) {
+	addr := util.NewUnixAddr()
+	srv, err := directory.NewServer(addr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = srv.NewService("LogManager", logger.NewLogManager())
+	if err != nil {
+		srv.Terminate()
+		t.Fatal(err)
+	}
+	sess, err := session.NewSession(addr)
+	if err != nil {
+		srv.Terminate()
+		t.Fatal(err)
+	}
+	web := httptest.NewServer(httpbridge.NewBridge(sess))
+	return web, func() {
+		web.Close()
+		sess.Terminate()
+		srv.Terminate()
+	}
+}
+
+func request(t *testing.T, method, url, body string, code int) string {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != code {
+		t.Fatalf("%s %s: status %d (expecting %d): %s", method, url,
+			resp.StatusCode, code, data)
+	}
+	return string(data)
+}
+
+func TestServices(t *testing.T) {
+	web, cleanup := newBridge(t)
+	defer cleanup()
+
+	list := request(t, "GET", web.URL+"/services", "", http.StatusOK)
+	if !strings.Contains(list, "LogManager") {
+		t.Errorf("missing LogManager: %s", list)
+	}
+	meta := request(t, "GET", web.URL+"/services/LogManager", "",
+		http.StatusOK)
+	if !strings.Contains(meta, "createListener") {
+		t.Errorf("missing createListener: %s", meta)
+	}
+	request(t, "GET", web.URL+"/services/Unknown", "", http.StatusNotFound)
+	request(t, "GET", web.URL+"/services/LogManager/x", "",
+		http.StatusBadRequest)
+	request(t, "POST", web.URL+"/services/LogManager/1/unknown", "",
+		http.StatusNotFound)
+	request(t, "POST", web.URL+"/services/LogManager/1/log", `"bad"`,
+		http.StatusBadRequest)
+	request(t, "POST", web.URL+"/services/LogManager/1/log", `[[1]]`,
+		http.StatusBadRequest)
+	request(t, "DELETE", web.URL+"/services", "",
+		http.StatusMethodNotAllowed)
+}
+
+func TestObjectReference(t *testing.T) {
+	web, cleanup := newBridge(t)
+	defer cleanup()
+
+	resp := request(t, "POST", web.URL+"/services/LogManager/1/createListener",
+		"", http.StatusOK)
+	var ref struct {
+		Href string `json:"href"`
+	}
+	if err := json.Unmarshal([]byte(resp), &ref); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(ref.Href, "/services/LogManager/") {
+		t.Fatalf("unexpected reference: %s", resp)
+	}
+	listener := web.URL + ref.Href
+
+	level := request(t, "GET", listener+"/logLevel", "", http.StatusOK)
+	if level != `{"level":4}` {
+		t.Errorf("unexpected level: %s", level)
+	}
+	request(t, "PUT", listener+"/logLevel", `{"level":5}`,
+		http.StatusNoContent)
+	level = request(t, "GET", listener+"/logLevel", "", http.StatusOK)
+	if level != `{"level":5}` {
+		t.Errorf("unexpected level: %s", level)
+	}
+	request(t, "POST", listener+"/setLevel", `[{"level":3}]`,
+		http.StatusNoContent)
+	request(t, "POST", listener+"/setLevel", `[{"level":"3"}]`,
+		http.StatusBadRequest)
+}
+
+func TestSignalStream(t *testing.T) {
+	web, cleanup := newBridge(t)
+	defer cleanup()
+
+	resp := request(t, "POST", web.URL+"/services/LogManager/1/createListener",
+		"", http.StatusOK)
+	var ref struct {
+		Href string `json:"href"`
+	}
+	if err := json.Unmarshal([]byte(resp), &ref); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequest("GET", web.URL+ref.Href+"/onLogMessage", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Body.Close()
+	if ct := stream.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("unexpected content type: %s", ct)
+	}
+
+	message := `{"source":"test","level":{"level":2},"category":"qi.test",
+		"location":"here","message":"hello","id":1,"date":{"ns":0},
+		"systemDate":{"ns":0}}`
+	request(t, "POST", web.URL+"/services/LogManager/1/log",
+		"[["+message+"]]", http.StatusNoContent)
+
+	scanner := bufio.NewScanner(stream.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		var event []map[string]interface{}
+		err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")),
+			&event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(event) != 1 || event[0]["message"] != "hello" {
+			t.Fatalf("unexpected event: %s", line)
+		}
+		return
+	}
+	t.Fatalf("missing event: %v", scanner.Err())
+}
@@ -0,0 +1,478 @@
+package httpbridge
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/lugu/qiloop/meta/signature"
+	"github.com/lugu/qiloop/type/basic"
+	"github.com/lugu/qiloop/type/object"
+)
+
+// The JSON representation of the values follows the one of the
+// schema generated by the package meta/schema: lists and tuples are
+// arrays, structures are objects, maps with string or integer keys
+// are objects and other maps are arrays of [key, value] pairs.
+// Object references are objects with an href member pointing to
+// the URL of the object.
+
+// typeOf parses a signature.
+func typeOf(sig string) (signature.Type, error) {
+	t, err := signature.Parse(sig)
+	if err != nil {
+		return nil, fmt.Errorf("parse signature %s: %s", sig, err)
+	}
+	return t, nil
+}
+
+// members returns the types of the elements of a tuple signature.
+func members(sig string) ([]signature.Type, error) {
+	t, err := typeOf(sig)
+	if err != nil {
+		return nil, err
+	}
+	tuple, ok := t.(*signature.TupleType)
+	if !ok {
+		return []signature.Type{t}, nil
+	}
+	types := make([]signature.Type, len(tuple.Members))
+	for i, m := range tuple.Members {
+		types[i] = m.Type
+	}
+	return types, nil
+}
+
+// propertyType returns the type of a property: a tuple of a single
+// element is the type of this element.
+func propertyType(sig string) (signature.Type, error) {
+	t, err := typeOf(sig)
+	if err != nil {
+		return nil, err
+	}
+	if tuple, ok := t.(*signature.TupleType); ok && len(tuple.Members) == 1 {
+		return tuple.Members[0].Type, nil
+	}
+	return t, nil
+}
+
+func isIntegerKey(sig string) bool {
+	switch sig {
+	case "c", "C", "w", "W", "i", "I", "l", "L":
+		return true
+	}
+	return false
+}
+
+// maxEmptyElements is the number of elements of a list or a map
+// accepted regardless of the size of the payload since some elements
+// (such as void) have no content.
+const maxEmptyElements = 4096
+
+// checkSize verifies the elements of a list or a map can be read from
+// r before allocating them: beyond maxEmptyElements, each element
+// shall take at least one byte of the payload.
+func checkSize(size uint32, r *bytes.Buffer) error {
+	if size > maxEmptyElements && int64(size) > int64(r.Len()) {
+		return fmt.Errorf("size %d exceeds the payload (%d bytes)",
+			size, r.Len())
+	}
+	return nil
+}
+
+// decode reads a value of type t and returns its JSON
+// representation.
+func (b *Bridge) decode(t signature.Type, r *bytes.Buffer) (interface{}, error) {
+	switch typ := t.(type) {
+	case *signature.ListType:
+		size, err := basic.ReadUint32(r)
+		if err != nil {
+			return nil, fmt.Errorf("read list size: %s", err)
+		}
+		if err = checkSize(size, r); err != nil {
+			return nil, fmt.Errorf("read list: %s", err)
+		}
+		list := make([]interface{}, size)
+		for i := range list {
+			list[i], err = b.decode(typ.Elem(), r)
+			if err != nil {
+				return nil, fmt.Errorf("list element %d: %s", i, err)
+			}
+		}
+		return list, nil
+	case *signature.MapType:
+		return b.decodeMap(typ, r)
+	case *signature.TupleType:
+		list := make([]interface{}, len(typ.Members))
+		for i, m := range typ.Members {
+			var err error
+			list[i], err = b.decode(m.Type, r)
+			if err != nil {
+				return nil, fmt.Errorf("tuple element %d: %s", i, err)
+			}
+		}
+		return list, nil
+	case *signature.StructType:
+		st := make(map[string]interface{}, len(typ.Members))
+		for _, m := range typ.Members {
+			v, err := b.decode(m.Type, r)
+			if err != nil {
+				return nil, fmt.Errorf("%s member %s: %s",
+					typ.Name, m.Name, err)
+			}
+			st[m.Name] = v
+		}
+		return st, nil
+	}
+	switch sig := t.Signature(); sig {
+	case "b":
+		return basic.ReadBool(r)
+	case "c":
+		return basic.ReadInt8(r)
+	case "C":
+		return basic.ReadUint8(r)
+	case "w":
+		return basic.ReadInt16(r)
+	case "W":
+		return basic.ReadUint16(r)
+	case "i":
+		return basic.ReadInt32(r)
+	case "I":
+		return basic.ReadUint32(r)
+	case "l":
+		return basic.ReadInt64(r)
+	case "L":
+		return basic.ReadUint64(r)
+	case "f":
+		f, err := basic.ReadFloat32(r)
+		return finite(float64(f), err)
+	case "d":
+		return finite(basic.ReadFloat64(r))
+	case "s":
+		return basic.ReadString(r)
+	case "r":
+		// encoded in base64 by encoding/json.
+		return basic.ReadRaw(r)
+	case "v":
+		return nil, nil
+	case "m":
+		sig, err := basic.ReadString(r)
+		if err != nil {
+			return nil, fmt.Errorf("read value signature: %s", err)
+		}
+		t, err := typeOf(sig)
+		if err != nil {
+			return nil, err
+		}
+		return b.decode(t, r)
+	case "o":
+		ref, err := object.ReadObjectReference(r)
+		if err != nil {
+			return nil, err
+		}
+		return b.reference(ref), nil
+	default:
+		return nil, fmt.Errorf("unsupported signature: %s", sig)
+	}
+}
+
+// finite returns nil for the values without JSON representation.
+func finite(f float64, err error) (interface{}, error) {
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (b *Bridge) decodeMap(m *signature.MapType, r *bytes.Buffer) (interface{}, error) {
+	size, err := basic.ReadUint32(r)
+	if err != nil {
+		return nil, fmt.Errorf("read map size: %s", err)
+	}
+	if err = checkSize(size, r); err != nil {
+		return nil, fmt.Errorf("read map: %s", err)
+	}
+	keySig := m.Key().Signature()
+	keyed := keySig == "s" || isIntegerKey(keySig)
+	entries := make(map[string]interface{}, size)
+	pairs := make([]interface{}, 0, size)
+	for i := uint32(0); i < size; i++ {
+		k, err := b.decode(m.Key(), r)
+		if err != nil {
+			return nil, fmt.Errorf("map key %d: %s", i, err)
+		}
+		v, err := b.decode(m.Value(), r)
+		if err != nil {
+			return nil, fmt.Errorf("map value %d: %s", i, err)
+		}
+		if keyed {
+			entries[fmt.Sprint(k)] = v
+		} else {
+			pairs = append(pairs, []interface{}{k, v})
+		}
+	}
+	if keyed {
+		return entries, nil
+	}
+	return pairs, nil
+}
+
+func number(v interface{}) (json.Number, error) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return "", fmt.Errorf("expecting a number, got %T", v)
+	}
+	return n, nil
+}
+
+func writeInt(v interface{}, bits int, w io.Writer) error {
+	n, err := number(v)
+	if err != nil {
+		return err
+	}
+	i, err := strconv.ParseInt(n.String(), 10, bits)
+	if err != nil {
+		return err
+	}
+	switch bits {
+	case 8:
+		return basic.WriteInt8(int8(i), w)
+	case 16:
+		return basic.WriteInt16(int16(i), w)
+	case 32:
+		return basic.WriteInt32(int32(i), w)
+	default:
+		return basic.WriteInt64(i, w)
+	}
+}
+
+func writeUint(v interface{}, bits int, w io.Writer) error {
+	n, err := number(v)
+	if err != nil {
+		return err
+	}
+	i, err := strconv.ParseUint(n.String(), 10, bits)
+	if err != nil {
+		return err
+	}
+	switch bits {
+	case 8:
+		return basic.WriteUint8(uint8(i), w)
+	case 16:
+		return basic.WriteUint16(uint16(i), w)
+	case 32:
+		return basic.WriteUint32(uint32(i), w)
+	default:
+		return basic.WriteUint64(i, w)
+	}
+}
+
+func writeFloat(v interface{}, bits int, w io.Writer) error {
+	n, err := number(v)
+	if err != nil {
+		return err
+	}
+	f, err := strconv.ParseFloat(n.String(), bits)
+	if err != nil {
+		return err
+	}
+	if bits == 32 {
+		return basic.WriteFloat32(float32(f), w)
+	}
+	return basic.WriteFloat64(f, w)
+}
+
+// infer returns the type used to serialize a dynamic value.
+func infer(v interface{}) (signature.Type, error) {
+	switch val := v.(type) {
+	case nil:
+		return signature.NewVoidType(), nil
+	case bool:
+		return signature.NewBoolType(), nil
+	case string:
+		return signature.NewStringType(), nil
+	case json.Number:
+		if i, err := val.Int64(); err == nil {
+			if i >= math.MinInt32 && i <= math.MaxInt32 {
+				return signature.NewIntType(), nil
+			}
+			return signature.NewLongType(), nil
+		}
+		return signature.NewDoubleType(), nil
+	case []interface{}:
+		return signature.NewListType(signature.NewValueType()), nil
+	case map[string]interface{}:
+		return signature.NewMapType(signature.NewStringType(),
+			signature.NewValueType()), nil
+	default:
+		return nil, fmt.Errorf("unexpected JSON value: %T", v)
+	}
+}
+
+// encode writes the JSON value v using the type t. Numbers are
+// expected to be decoded as json.Number.
+func (b *Bridge) encode(t signature.Type, v interface{}, w io.Writer) error {
+	switch typ := t.(type) {
+	case *signature.ListType:
+		list, ok := v.([]interface{})
+		if !ok {
+			return fmt.Errorf("expecting an array, got %T", v)
+		}
+		if err := basic.WriteUint32(uint32(len(list)), w); err != nil {
+			return err
+		}
+		for i, elem := range list {
+			if err := b.encode(typ.Elem(), elem, w); err != nil {
+				return fmt.Errorf("list element %d: %s", i, err)
+			}
+		}
+		return nil
+	case *signature.MapType:
+		return b.encodeMap(typ, v, w)
+	case *signature.TupleType:
+		list, ok := v.([]interface{})
+		if !ok {
+			return fmt.Errorf("expecting an array, got %T", v)
+		}
+		if len(list) != len(typ.Members) {
+			return fmt.Errorf("expecting %d elements, got %d",
+				len(typ.Members), len(list))
+		}
+		for i, m := range typ.Members {
+			if err := b.encode(m.Type, list[i], w); err != nil {
+				return fmt.Errorf("tuple element %d: %s", i, err)
+			}
+		}
+		return nil
+	case *signature.StructType:
+		st, ok := v.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("expecting an object, got %T", v)
+		}
+		for _, m := range typ.Members {
+			member, ok := st[m.Name]
+			if !ok {
+				return fmt.Errorf("%s: missing member %s",
+					typ.Name, m.Name)
+			}
+			if err := b.encode(m.Type, member, w); err != nil {
+				return fmt.Errorf("%s member %s: %s",
+					typ.Name, m.Name, err)
+			}
+		}
+		return nil
+	}
+	switch sig := t.Signature(); sig {
+	case "b":
+		val, ok := v.(bool)
+		if !ok {
+			return fmt.Errorf("expecting a boolean, got %T", v)
+		}
+		return basic.WriteBool(val, w)
+	case "c":
+		return writeInt(v, 8, w)
+	case "C":
+		return writeUint(v, 8, w)
+	case "w":
+		return writeInt(v, 16, w)
+	case "W":
+		return writeUint(v, 16, w)
+	case "i":
+		return writeInt(v, 32, w)
+	case "I":
+		return writeUint(v, 32, w)
+	case "l":
+		return writeInt(v, 64, w)
+	case "L":
+		return writeUint(v, 64, w)
+	case "f":
+		return writeFloat(v, 32, w)
+	case "d":
+		return writeFloat(v, 64, w)
+	case "s":
+		val, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("expecting a string, got %T", v)
+		}
+		return basic.WriteString(val, w)
+	case "r":
+		val, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("expecting a base64 string, got %T", v)
+		}
+		data, err := base64.StdEncoding.DecodeString(val)
+		if err != nil {
+			return fmt.Errorf("invalid base64 string: %s", err)
+		}
+		return basic.WriteRaw(data, w)
+	case "v":
+		return nil
+	case "m":
+		t, err := infer(v)
+		if err != nil {
+			return err
+		}
+		if err := basic.WriteString(t.Signature(), w); err != nil {
+			return err
+		}
+		return b.encode(t, v, w)
+	case "o":
+		ref, err := b.lookupReference(v)
+		if err != nil {
+			return err
+		}
+		return object.WriteObjectReference(ref, w)
+	default:
+		return fmt.Errorf("unsupported signature: %s", sig)
+	}
+}
+
+func (b *Bridge) encodeMap(m *signature.MapType, v interface{}, w io.Writer) error {
+	keySig := m.Key().Signature()
+	if keySig == "s" || isIntegerKey(keySig) {
+		entries, ok := v.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("expecting an object, got %T", v)
+		}
+		keys := make([]string, 0, len(entries))
+		for k := range entries {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		if err := basic.WriteUint32(uint32(len(keys)), w); err != nil {
+			return err
+		}
+		for _, k := range keys {
+			var key interface{} = k
+			if keySig != "s" {
+				key = json.Number(k)
+			}
+			if err := b.encode(m.Key(), key, w); err != nil {
+				return fmt.Errorf("map key %s: %s", k, err)
+			}
+			if err := b.encode(m.Value(), entries[k], w); err != nil {
+				return fmt.Errorf("map value %s: %s", k, err)
+			}
+		}
+		return nil
+	}
+	pairs, ok := v.([]interface{})
+	if !ok {
+		return fmt.Errorf("expecting an array, got %T", v)
+	}
+	if err := basic.WriteUint32(uint32(len(pairs)), w); err != nil {
+		return err
+	}
+	pair := signature.NewTupleType([]signature.Type{m.Key(), m.Value()})
+	for i, p := range pairs {
+		if err := b.encode(pair, p, w); err != nil {
+			return fmt.Errorf("map entry %d: %s", i, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package httpbridge
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestCodec(t *testing.T) {
+	b := NewBridge(nil)
+	tests := []struct {
+		sig  string
+		json string
+	}{
+		{"b", `true`},
+		{"c", `-3`},
+		{"L", `18446744073709551615`},
+		{"f", `1.5`},
+		{"s", `"hello"`},
+		{"[i]", `[1,2,3]`},
+		{"{si}", `{"a":1,"b":2}`},
+		{"{Is}", `{"1":"one","2":"two"}`},
+		{"{(ii)s}", `[[[1,2],"pair"]]`},
+		{"(is)", `[1,"a"]`},
+		{"(ib)<Foo,a,b>", `{"a":1,"b":false}`},
+		{"m", `"dynamic"`},
+		{"m", `[1,"a",{"b":true}]`},
+	}
+	for _, test := range tests {
+		typ, err := typeOf(test.sig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoder := json.NewDecoder(bytes.NewBufferString(test.json))
+		decoder.UseNumber()
+		var v interface{}
+		if err := decoder.Decode(&v); err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := b.encode(typ, v, &buf); err != nil {
+			t.Errorf("encode %s %s: %s", test.sig, test.json, err)
+			continue
+		}
+		out, err := b.decode(typ, &buf)
+		if err != nil {
+			t.Errorf("decode %s %s: %s", test.sig, test.json, err)
+			continue
+		}
+		data, _ := json.Marshal(out)
+		if string(data) != test.json {
+			t.Errorf("%s: expecting %s, got %s", test.sig, test.json, data)
+		}
+		if buf.Len() != 0 {
+			t.Errorf("%s: %d bytes left", test.sig, buf.Len())
+		}
+	}
+}
+
+func TestCodecError(t *testing.T) {
+	b := NewBridge(nil)
+	tests := []struct {
+		sig  string
+		json string
+	}{
+		{"c", `300`},
+		{"I", `-1`},
+		{"i", `1.5`},
+		{"s", `1`},
+		{"b", `"true"`},
+		{"[i]", `{}`},
+		{"(is)", `[1]`},
+		{"(ib)<Foo,a,b>", `{"a":1}`},
+		{"{Is}", `{"x":"one"}`},
+		{"o", `{"serviceID":1,"objectID":2}`},
+	}
+	for _, test := range tests {
+		typ, err := typeOf(test.sig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoder := json.NewDecoder(bytes.NewBufferString(test.json))
+		decoder.UseNumber()
+		var v interface{}
+		if err := decoder.Decode(&v); err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := b.encode(typ, v, &buf); err == nil {
+			t.Errorf("%s: %s: expecting an error", test.sig, test.json)
+		}
+	}
+}
+
+func TestDecodeTooLong(t *testing.T) {
+	b := NewBridge(nil)
+	for _, sig := range []string{"[v]", "[i]", "{vv}", "{si}"} {
+		typ, err := typeOf(sig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 50,000,000 elements announced with 4 bytes of content.
+		buf := bytes.NewBuffer([]byte{0x80, 0xf0, 0xfa, 0x02, 1, 0, 0, 0})
+		if _, err = b.decode(typ, buf); err == nil {
+			t.Errorf("%s: expecting an error", sig)
+		}
+	}
+}
@@ -9,6 +9,10 @@ import (
 // ErrCancelled is returned when the call was cancelled.
 var ErrCancelled = errors.New("Cancelled")
 
+// ErrConnectionClosed is returned when the connection is closed
+// before the reply of a call.
+var ErrConnectionClosed = errors.New("Remote connection closed")
+
 // Client represents a client connection to a service.
 type Client interface {
 	// Call initiates a remote procedure call.
@@ -85,7 +89,8 @@ type Session interface {
 // already connected.
 type Server interface {
 	// NewService register a new service to the service directory.
-	NewService(name string, object Actor) (Service, error)
+	// The options set the metadata of the service.
+	NewService(name string, object Actor, options ...ServiceOption) (Service, error)
 	// Session returns a local session object which can be used to
 	// access the server without authentication.
 	Session() Session
@@ -96,6 +101,14 @@ type Server interface {
 
 	// Client returns a direct Client.
 	Client() Client
+
+	// SetAuthorizer sets the Authorizer deciding which actions the
+	// remote clients can call. A nil Authorizer allows everything.
+	SetAuthorizer(a Authorizer)
+
+	// SetLimiter sets the Limiter enforcing the limits of the new
+	// remote connections. A nil Limiter disables the limits.
+	SetLimiter(l *Limiter)
 }
 
 // Namespace is used by a server to register new services. It allows
@@ -0,0 +1,401 @@
+package bus
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/lugu/qiloop/bus/net"
+	"github.com/lugu/qiloop/type/basic"
+	"github.com/lugu/qiloop/type/value"
+)
+
+// ErrRateLimited is returned when a client exceeds its rate of calls.
+var ErrRateLimited = errors.New("Rate limit exceeded")
+
+// ErrTooManyCalls is returned when a client exceeds its number of
+// concurrent calls.
+var ErrTooManyCalls = errors.New("Too many concurrent calls")
+
+// ErrTooManySubscriptions is returned when a client exceeds its
+// number of signal subscriptions.
+var ErrTooManySubscriptions = errors.New("Too many subscriptions")
+
+// ErrPayloadTooLarge is returned when a message exceeds the payload
+// size limit.
+var ErrPayloadTooLarge = errors.New("Payload too large")
+
+// Limits describes the resources a client can use. Zero values are
+// not limited.
+type Limits struct {
+	// CallsPerSecond is the sustained rate of calls and posts.
+	CallsPerSecond float64
+	// Burst is the number of calls accepted at once. It defaults to
+	// CallsPerSecond.
+	Burst int
+	// InFlight is the number of calls waiting for their reply.
+	InFlight int
+	// Subscriptions is the number of signals and properties
+	// subscribed with registerEvent.
+	Subscriptions int
+	// PayloadSize is the size of the largest payload accepted.
+	PayloadSize uint32
+}
+
+// ClientStatistics describes the usage of a client.
+type ClientStatistics struct {
+	// Calls is the number of calls and posts accepted.
+	Calls uint32
+	// Rejected is the number of calls and posts refused.
+	Rejected uint32
+	// InFlight is the number of calls waiting for their reply.
+	InFlight uint32
+	// Subscriptions is the number of active subscriptions.
+	Subscriptions uint32
+}
+
+// usage tracks the resources used by a connection or a user.
+type usage struct {
+	tokens        float64
+	last          time.Time
+	inFlight      int
+	subscriptions int
+	connections   int
+}
+
+// take consumes a token of the bucket of the rate limit.
+func (u *usage) take(l Limits, now time.Time) bool {
+	if l.CallsPerSecond <= 0 {
+		return true
+	}
+	burst := float64(l.Burst)
+	if burst <= 0 {
+		burst = math.Max(1, math.Ceil(l.CallsPerSecond))
+	}
+	if u.last.IsZero() {
+		u.tokens = burst
+	} else {
+		u.tokens += now.Sub(u.last).Seconds() * l.CallsPerSecond
+		u.tokens = math.Min(u.tokens, burst)
+	}
+	u.last = now
+	if u.tokens < 1 {
+		return false
+	}
+	u.tokens--
+	return true
+}
+
+// Limiter enforces the limits of the clients of a server. The limits
+// apply to each connection and to each authenticated user (all the
+// connections of a user are accounted together).
+type Limiter struct {
+	connection Limits
+	user       Limits
+	mutex      sync.Mutex
+	users      map[string]*usage
+	stats      map[string]ClientStatistics
+}
+
+// NewLimiter returns a Limiter with the limits of each connection and
+// of each user.
+func NewLimiter(connection, user Limits) *Limiter {
+	return &Limiter{
+		connection: connection,
+		user:       user,
+		users:      make(map[string]*usage),
+		stats:      make(map[string]ClientStatistics),
+	}
+}
+
+// Stats returns the statistics of each user. The clients
+// authenticated by certificate are accounted with the certificate
+// subject. The connections not yet authenticated and the anonymous
+// users are accounted with an empty user name.
+func (l *Limiter) Stats() (map[string]ClientStatistics, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	stats := make(map[string]ClientStatistics, len(l.stats))
+	for user, stat := range l.stats {
+		if u, ok := l.users[user]; ok {
+			stat.InFlight = uint32(u.inFlight)
+			stat.Subscriptions = uint32(u.subscriptions)
+		}
+		stats[user] = stat
+	}
+	return stats, nil
+}
+
+// ClearStats resets the counters of calls.
+func (l *Limiter) ClearStats() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.stats = make(map[string]ClientStatistics)
+	return nil
+}
+
+// pendingCall is a call waiting for its reply. subscribe and
+// unsubscribe are set for registerEvent and unregisterEvent.
+type pendingCall struct {
+	user        *usage
+	subscribe   bool
+	unsubscribe bool
+	signal      signalKey
+}
+
+// signalKey identifies the signal of a subscription.
+type signalKey struct {
+	service, object, signal uint32
+}
+
+// signalOf returns the signal of a call to registerEvent or
+// unregisterEvent whose parameters start with the object ID and the
+// signal ID.
+func signalOf(m *net.Message) signalKey {
+	r := bytes.NewReader(m.Payload)
+	object, _ := basic.ReadUint32(r)
+	signal, _ := basic.ReadUint32(r)
+	return signalKey{m.Header.Service, object, signal}
+}
+
+// callKey identifies a call and its reply.
+type callKey struct {
+	service, object, action, id uint32
+}
+
+func keyOf(hdr net.Header) callKey {
+	return callKey{hdr.Service, hdr.Object, hdr.Action, hdr.ID}
+}
+
+// connectionLimiter enforces the limits of a connection.
+type connectionLimiter struct {
+	limiter *Limiter
+	conn    usage
+	user    *usage
+	name    string
+	pending map[callKey]pendingCall
+	// subscriptions of the user made by this connection.
+	subscriptions int
+	// signals counts the subscriptions accounted by signal.
+	signals map[signalKey]int
+}
+
+func (l *Limiter) newConnection() *connectionLimiter {
+	return &connectionLimiter{
+		limiter: l,
+		pending: make(map[callKey]pendingCall),
+		signals: make(map[signalKey]int),
+	}
+}
+
+// bind accounts the connection to the user once authenticated.
+func (c *connectionLimiter) bind(from Channel) {
+	if c.user != nil || !from.Authenticated() {
+		return
+	}
+	if user, ok := from.Cap()[KeyUser].(value.StringValue); ok {
+		c.name = user.Value()
+	} else if subject, ok := from.Cap()[KeyCertSubject].(value.StringValue); ok {
+		c.name = subject.Value()
+	}
+	u, ok := c.limiter.users[c.name]
+	if !ok {
+		u = &usage{}
+		c.limiter.users[c.name] = u
+	}
+	u.connections++
+	c.user = u
+}
+
+// admit decides if a message can be processed. The service zero
+// (authentication) is only subject to the payload size limit.
+func (c *connectionLimiter) admit(m *net.Message, from Channel) error {
+	if m.Header.Type != net.Call && m.Header.Type != net.Post {
+		return nil
+	}
+	l := c.limiter
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	c.bind(from)
+	err := c.check(m)
+	stat := l.stats[c.name]
+	if err != nil {
+		stat.Rejected++
+	} else {
+		stat.Calls++
+	}
+	l.stats[c.name] = stat
+	return err
+}
+
+// subscription returns true if the message subscribes to a signal.
+func subscription(m *net.Message) bool {
+	return m.Header.Service != 0 && (m.Header.Action == 0 ||
+		m.Header.Action == 8)
+}
+
+// unsubscription returns true if the message unsubscribes from a
+// signal.
+func unsubscription(m *net.Message) bool {
+	return m.Header.Service != 0 && m.Header.Action == 1
+}
+
+func (c *connectionLimiter) check(m *net.Message) error {
+	l := c.limiter
+	for _, limit := range []uint32{l.connection.PayloadSize, l.user.PayloadSize} {
+		if limit != 0 && m.Header.Size > limit {
+			return ErrPayloadTooLarge
+		}
+	}
+	if m.Header.Service == 0 {
+		return nil
+	}
+	now := time.Now()
+	if !c.conn.take(l.connection, now) {
+		return ErrRateLimited
+	}
+	if c.user != nil && !c.user.take(l.user, now) {
+		return ErrRateLimited
+	}
+	if m.Header.Type != net.Call {
+		return nil
+	}
+	if l.connection.InFlight > 0 && c.conn.inFlight >= l.connection.InFlight {
+		return ErrTooManyCalls
+	}
+	if c.user != nil && l.user.InFlight > 0 &&
+		c.user.inFlight >= l.user.InFlight {
+		return ErrTooManyCalls
+	}
+	call := pendingCall{
+		user:        c.user,
+		subscribe:   subscription(m),
+		unsubscribe: unsubscription(m),
+	}
+	if call.subscribe || call.unsubscribe {
+		call.signal = signalOf(m)
+	}
+	if call.subscribe {
+		if l.connection.Subscriptions > 0 &&
+			c.conn.subscriptions >= l.connection.Subscriptions {
+			return ErrTooManySubscriptions
+		}
+		if c.user != nil && l.user.Subscriptions > 0 &&
+			c.user.subscriptions >= l.user.Subscriptions {
+			return ErrTooManySubscriptions
+		}
+		c.acquire(call)
+	}
+	c.conn.inFlight++
+	if c.user != nil {
+		c.user.inFlight++
+	}
+	c.pending[keyOf(m.Header)] = call
+	return nil
+}
+
+// acquire accounts the subscription of call. It must be called with
+// the mutex locked.
+func (c *connectionLimiter) acquire(call pendingCall) {
+	c.signals[call.signal]++
+	c.conn.subscriptions++
+	if call.user != nil {
+		call.user.subscriptions++
+		c.subscriptions++
+	}
+}
+
+// release releases a subscription to the signal of call if one is
+// accounted. It must be called with the mutex locked.
+func (c *connectionLimiter) release(call pendingCall) {
+	if c.signals[call.signal] == 0 {
+		return
+	}
+	if c.signals[call.signal]--; c.signals[call.signal] == 0 {
+		delete(c.signals, call.signal)
+	}
+	c.conn.subscriptions--
+	if call.user != nil && c.subscriptions > 0 {
+		call.user.subscriptions--
+		c.subscriptions--
+	}
+}
+
+// done releases the call answered by m. A failed subscription is
+// released and a successful unsubscription releases a subscription
+// to the same signal.
+func (c *connectionLimiter) done(m *net.Message) {
+	if m.Header.Type != net.Reply && m.Header.Type != net.Error &&
+		m.Header.Type != net.Cancelled {
+		return
+	}
+	l := c.limiter
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	key := keyOf(m.Header)
+	call, ok := c.pending[key]
+	if !ok {
+		return
+	}
+	delete(c.pending, key)
+	c.conn.inFlight--
+	if call.user != nil {
+		call.user.inFlight--
+	}
+	if call.subscribe && m.Header.Type != net.Reply ||
+		call.unsubscribe && m.Header.Type == net.Reply {
+		c.release(call)
+	}
+}
+
+// close releases the resources of the connection accounted to its
+// user.
+func (c *connectionLimiter) close() {
+	l := c.limiter
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if c.user == nil {
+		return
+	}
+	for key, call := range c.pending {
+		if call.user != nil {
+			call.user.inFlight--
+		}
+		delete(c.pending, key)
+	}
+	c.user.subscriptions -= c.subscriptions
+	c.subscriptions = 0
+	c.user.connections--
+	if c.user.connections == 0 {
+		delete(l.users, c.name)
+	}
+	c.user = nil
+}
+
+// limitedChannel releases the calls of a connection when they are
+// answered.
+type limitedChannel struct {
+	Channel
+	limiter *connectionLimiter
+}
+
+func (c *limitedChannel) Send(msg *net.Message) error {
+	c.limiter.done(msg)
+	return c.Channel.Send(msg)
+}
+
+func (c *limitedChannel) SendError(msg *net.Message, err error) error {
+	hdr := net.NewHeader(net.Error, msg.Header.Service, msg.Header.Object,
+		msg.Header.Action, msg.Header.ID)
+	mError := net.NewMessage(hdr, errorPaylad(err))
+	return c.Send(&mError)
+}
+
+func (c *limitedChannel) SendReply(msg *net.Message, response []byte) error {
+	hdr := msg.Header
+	hdr.Type = net.Reply
+	reply := net.NewMessage(hdr, response)
+	return c.Send(&reply)
+}
@@ -0,0 +1,173 @@
+package bus_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/lugu/qiloop/bus"
+	"github.com/lugu/qiloop/bus/net"
+	"github.com/lugu/qiloop/bus/util"
+	"github.com/lugu/qiloop/type/basic"
+)
+
+func TestLimiter(t *testing.T) {
+	addr := util.NewUnixAddr()
+	listener, err := net.Listen(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := bus.Dictionary(map[string]string{
+		"nao": "nao",
+	})
+	srv, err := bus.StandAloneServer(listener, auth, bus.PrivateNamespace())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Terminate()
+	limiter := bus.NewLimiter(bus.Limits{
+		Subscriptions: 1,
+		PayloadSize:   1024,
+	}, bus.Limits{
+		CallsPerSecond: 0.001,
+		Burst:          8,
+	})
+	srv.SetLimiter(limiter)
+	service, err := srv.NewService("Bomb", NewBombObject())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep, err := net.DialEndPoint(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep.Close()
+	if err = bus.AuthenticateUser(ep, "nao", "nao"); err != nil {
+		t.Fatal(err)
+	}
+	client := bus.NewClient(ep)
+	// 1 call
+	meta, err := bus.GetMetaObject(client, service.ServiceID(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := bus.NewProxy(client, meta, service.ServiceID(), 1)
+	bomb := MakeBomb(nil, proxy)
+
+	// the burst of the user is 8 calls.
+	// 1 call: registerEvent
+	cancel, _, err := bomb.SubscribeBoom()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 1 call refused: the subscription is limited per connection.
+	_, _, err = bomb.SubscribeDelay()
+	if err == nil || !strings.Contains(err.Error(), "Too many subscriptions") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	// 3 calls: unregisterEvent, registerEvent and unregisterEvent
+	cancel()
+	cancel, _, err = bomb.SubscribeBoom()
+	if err != nil {
+		t.Error(err)
+	} else {
+		cancel()
+	}
+	// 1 call refused before the rate limit
+	_, err = proxy.CallID(2, make([]byte, 2048))
+	if err == nil || !strings.Contains(err.Error(), "Payload too large") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	// 3 calls: the last is refused
+	for i := 0; i < 2; i++ {
+		if _, err = bomb.GetDelay(); err != nil {
+			t.Error(err)
+		}
+	}
+	_, err = bomb.GetDelay()
+	if err == nil || !strings.Contains(err.Error(), "Rate limit exceeded") {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	stats, err := limiter.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stat := stats["nao"]
+	if stat.Calls != 7 || stat.Rejected != 3 || stat.InFlight != 0 ||
+		stat.Subscriptions != 0 {
+		t.Errorf("unexpected statistics: %#v", stat)
+	}
+	if err = limiter.ClearStats(); err != nil {
+		t.Fatal(err)
+	}
+	if stats, _ = limiter.Stats(); len(stats) != 0 {
+		t.Errorf("statistics not cleared: %v", stats)
+	}
+
+	// the local clients are not limited.
+	local, err := srv.Session().Proxy("Bomb", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = MakeBomb(nil, local).GetDelay(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLimiterUnsubscribe(t *testing.T) {
+	addr := util.NewUnixAddr()
+	listener, err := net.Listen(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, err := bus.StandAloneServer(listener, bus.Yes{},
+		bus.PrivateNamespace())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Terminate()
+	srv.SetLimiter(bus.NewLimiter(bus.Limits{Subscriptions: 1},
+		bus.Limits{Subscriptions: 1}))
+	service, err := srv.NewService("Bomb", NewBombObject())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep, err := net.DialEndPoint(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep.Close()
+	if err = bus.Authenticate(ep); err != nil {
+		t.Fatal(err)
+	}
+	client := bus.NewClient(ep)
+	meta, err := bus.GetMetaObject(client, service.ServiceID(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := bus.NewProxy(client, meta, service.ServiceID(), 1)
+	bomb := MakeBomb(nil, proxy)
+	if _, _, err = bomb.SubscribeBoom(); err != nil {
+		t.Fatal(err)
+	}
+	// unregisterEvent of signals never subscribed do not release
+	// the subscription.
+	signal, err := proxy.PropertyID("delay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []uint32{signal, 12345} {
+		var buf bytes.Buffer
+		basic.WriteUint32(1, &buf)
+		basic.WriteUint32(id, &buf)
+		basic.WriteUint64(0, &buf)
+		proxy.CallID(1, buf.Bytes())
+	}
+	_, _, err = bomb.SubscribeDelay()
+	if err == nil || !strings.Contains(err.Error(), "Too many subscriptions") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -1334,7 +1334,7 @@ func (p *proxyLogManager) CreateListener() (LogListenerProxy, error) {
 		if err != nil {
 			return nil, fmt.Errorf("get meta: %s", err)
 		}
-		proxy, err := p.session.Object(ref)
+		proxy, err := bus.ObjectFrom(p.session, p.ObjectProxy, ref)
 		if err != nil {
 			return nil, fmt.Errorf("get proxy: %s", err)
 		}
@@ -1361,7 +1361,7 @@ func (p *proxyLogManager) GetListener() (LogListenerProxy, error) {
 		if err != nil {
 			return nil, fmt.Errorf("get meta: %s", err)
 		}
-		proxy, err := p.session.Object(ref)
+		proxy, err := bus.ObjectFrom(p.session, p.ObjectProxy, ref)
 		if err != nil {
 			return nil, fmt.Errorf("get proxy: %s", err)
 		}
@@ -0,0 +1,477 @@
+// Package mdns advertises and discovers the service directories on the
+// local network using multicast DNS (RFC 6762) and DNS based service
+// discovery (RFC 6763).
+//
+// A service directory is advertised as an instance of the service
+// type _naoqi._tcp. The TXT record of the instance contains the URLs
+// of the directory and the machine ID of the server.
+package mdns
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// ServiceType is the DNS-SD service type of the service directories.
+const ServiceType = "_naoqi._tcp"
+
+// domain is the domain of the multicast DNS names.
+const domain = "local."
+
+// defaultPort is used in the SRV record when no URL has a port.
+const defaultPort = 9559
+
+// ttl is the time to live of the records (in seconds).
+const ttl = 120
+
+// Group is the multicast address and port used to exchange the
+// queries and the responses.
+var Group = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// Entry describes a service directory found on the network.
+type Entry struct {
+	// Instance is the name of the instance of the service.
+	Instance string
+	// MachineID is the machine ID of the server.
+	MachineID string
+	// URLs are the addresses of the service directory.
+	URLs []string
+}
+
+// serviceName returns the DNS name of the service type.
+func serviceName() string {
+	return ServiceType + "." + domain
+}
+
+// Responder answers the queries for an instance of the service type.
+type Responder struct {
+	conn     *net.UDPConn
+	instance dnsmessage.Name
+	host     dnsmessage.Name
+	port     uint16
+	txt      []string
+	closed   chan struct{}
+	once     sync.Once
+}
+
+// Advertise responds to the queries of the browsers with the URLs and
+// the machine ID of a service directory until the Responder is closed.
+// The URLs using the unix and pipe schemes are not advertised.
+func Advertise(instance string, urls []string, machineID string) (*Responder, error) {
+	instanceName, err := dnsmessage.NewName(instance + "." + serviceName())
+	if err != nil {
+		return nil, fmt.Errorf("invalid instance %s: %s", instance, err)
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("hostname: %s", err)
+	}
+	hostname = strings.SplitN(hostname, ".", 2)[0]
+	host, err := dnsmessage.NewName(hostname + "." + domain)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hostname %s: %s", hostname, err)
+	}
+	r := &Responder{
+		instance: instanceName,
+		host:     host,
+		port:     defaultPort,
+		txt:      []string{"machine_id=" + machineID},
+		closed:   make(chan struct{}),
+	}
+	portSet := false
+	for _, addr := range urls {
+		u, err := url.Parse(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid URL %s: %s", addr, err)
+		}
+		if u.Scheme == "unix" || u.Scheme == "pipe" {
+			continue
+		}
+		if port, err := strconv.Atoi(u.Port()); err == nil && !portSet {
+			r.port = uint16(port)
+			portSet = true
+		}
+		r.txt = append(r.txt, "url="+addr)
+	}
+	r.conn, err = net.ListenMulticastUDP("udp4", nil, Group)
+	if err != nil {
+		return nil, fmt.Errorf("listen %s: %s", Group, err)
+	}
+	go r.serve()
+	return r, nil
+}
+
+// Close stops responding to the queries.
+func (r *Responder) Close() error {
+	var err error
+	r.once.Do(func() {
+		close(r.closed)
+		err = r.conn.Close()
+	})
+	return err
+}
+
+func (r *Responder) serve() {
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := r.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-r.closed:
+				return
+			default:
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return
+		}
+		r.respond(buf[:n], from)
+	}
+}
+
+// matches returns true if the question asks for the records of the
+// service type or of the instance.
+func (r *Responder) matches(q dnsmessage.Question) bool {
+	name := strings.ToLower(q.Name.String())
+	switch q.Type {
+	case dnsmessage.TypePTR:
+		return name == strings.ToLower(serviceName())
+	case dnsmessage.TypeSRV, dnsmessage.TypeTXT:
+		return name == strings.ToLower(r.instance.String())
+	case dnsmessage.TypeALL:
+		return name == strings.ToLower(serviceName()) ||
+			name == strings.ToLower(r.instance.String())
+	}
+	return false
+}
+
+// respond answers a query for the service type. The legacy queries
+// (not sent from the mDNS port) are answered with unicast.
+func (r *Responder) respond(query []byte, from *net.UDPAddr) {
+	var p dnsmessage.Parser
+	hdr, err := p.Start(query)
+	if err != nil || hdr.Response {
+		return
+	}
+	questions, err := p.AllQuestions()
+	if err != nil {
+		return
+	}
+	var matched []dnsmessage.Question
+	for _, q := range questions {
+		if r.matches(q) {
+			matched = append(matched, q)
+		}
+	}
+	if len(matched) == 0 {
+		return
+	}
+	legacy := from.Port != Group.Port
+	resp := r.response()
+	if legacy {
+		resp.Header.ID = hdr.ID
+		resp.Questions = matched
+	}
+	packet, err := resp.Pack()
+	if err != nil {
+		return
+	}
+	to := Group
+	if legacy {
+		to = from
+	}
+	r.conn.WriteToUDP(packet, to)
+}
+
+// response returns the records describing the instance.
+func (r *Responder) response() dnsmessage.Message {
+	service := dnsmessage.MustNewName(serviceName())
+	header := func(name dnsmessage.Name, typ dnsmessage.Type) dnsmessage.ResourceHeader {
+		return dnsmessage.ResourceHeader{
+			Name:  name,
+			Type:  typ,
+			Class: dnsmessage.ClassINET,
+			TTL:   ttl,
+		}
+	}
+	msg := dnsmessage.Message{
+		Header: dnsmessage.Header{
+			Response:      true,
+			Authoritative: true,
+		},
+		Answers: []dnsmessage.Resource{
+			{
+				Header: header(service, dnsmessage.TypePTR),
+				Body:   &dnsmessage.PTRResource{PTR: r.instance},
+			},
+		},
+		Additionals: []dnsmessage.Resource{
+			{
+				Header: header(r.instance, dnsmessage.TypeSRV),
+				Body: &dnsmessage.SRVResource{
+					Target: r.host,
+					Port:   r.port,
+				},
+			},
+			{
+				Header: header(r.instance, dnsmessage.TypeTXT),
+				Body:   &dnsmessage.TXTResource{TXT: r.txt},
+			},
+		},
+	}
+	for _, ip := range localIPs() {
+		var a dnsmessage.AResource
+		copy(a.A[:], ip)
+		msg.Additionals = append(msg.Additionals, dnsmessage.Resource{
+			Header: header(r.host, dnsmessage.TypeA),
+			Body:   &a,
+		})
+	}
+	return msg
+}
+
+// localIPs returns the IPv4 addresses of the interfaces.
+func localIPs() []net.IP {
+	var ips []net.IP
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok {
+			if ip := ipnet.IP.To4(); ip != nil && !ip.IsLoopback() {
+				ips = append(ips, ip)
+			}
+		}
+	}
+	return ips
+}
+
+// instanceInfo accumulates the records of an instance.
+type instanceInfo struct {
+	name      string
+	from      net.IP
+	host      string
+	port      uint16
+	machineID string
+	urls      []string
+}
+
+// browser collects the responses to a query.
+type browser struct {
+	instances map[string]*instanceInfo
+	order     []string
+	hosts     map[string]net.IP
+}
+
+func (b *browser) instance(name string, from net.IP) *instanceInfo {
+	info, ok := b.instances[strings.ToLower(name)]
+	if !ok {
+		info = &instanceInfo{name: name, from: from}
+		b.instances[strings.ToLower(name)] = info
+		b.order = append(b.order, name)
+	}
+	return info
+}
+
+// parse reads the records of a response.
+func (b *browser) parse(packet []byte, from net.IP) error {
+	var p dnsmessage.Parser
+	hdr, err := p.Start(packet)
+	if err != nil {
+		return err
+	}
+	if !hdr.Response {
+		return nil
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return err
+	}
+	sections := []struct {
+		header func() (dnsmessage.ResourceHeader, error)
+		skip   func() error
+	}{
+		{p.AnswerHeader, p.SkipAnswer},
+		{p.AuthorityHeader, p.SkipAuthority},
+		{p.AdditionalHeader, p.SkipAdditional},
+	}
+	for _, section := range sections {
+		for {
+			h, err := section.header()
+			if err == dnsmessage.ErrSectionDone {
+				break
+			} else if err != nil {
+				return err
+			}
+			if err := b.record(&p, h, from, section.skip); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// record reads the resource of header h.
+func (b *browser) record(p *dnsmessage.Parser, h dnsmessage.ResourceHeader,
+	from net.IP, skip func() error) error {
+	name := h.Name.String()
+	instance := strings.HasSuffix(strings.ToLower(name),
+		"."+strings.ToLower(serviceName()))
+	switch h.Type {
+	case dnsmessage.TypePTR:
+		r, err := p.PTRResource()
+		if err != nil {
+			return err
+		}
+		if strings.EqualFold(name, serviceName()) {
+			b.instance(r.PTR.String(), from)
+		}
+	case dnsmessage.TypeSRV:
+		r, err := p.SRVResource()
+		if err != nil {
+			return err
+		}
+		if instance {
+			info := b.instance(name, from)
+			info.host = strings.ToLower(r.Target.String())
+			info.port = r.Port
+		}
+	case dnsmessage.TypeTXT:
+		r, err := p.TXTResource()
+		if err != nil {
+			return err
+		}
+		if instance {
+			info := b.instance(name, from)
+			info.urls = nil
+			for _, txt := range r.TXT {
+				if strings.HasPrefix(txt, "url=") {
+					info.urls = append(info.urls,
+						strings.TrimPrefix(txt, "url="))
+				} else if strings.HasPrefix(txt, "machine_id=") {
+					info.machineID = strings.TrimPrefix(txt,
+						"machine_id=")
+				}
+			}
+		}
+	case dnsmessage.TypeA:
+		r, err := p.AResource()
+		if err != nil {
+			return err
+		}
+		b.hosts[strings.ToLower(name)] = net.IP(r.A[:])
+	default:
+		return skip()
+	}
+	return nil
+}
+
+// address returns the URL with the host replaced by the address of the
+// responder if the server listens on all the interfaces.
+func address(addr string, from net.IP) string {
+	u, err := url.Parse(addr)
+	if err != nil || from == nil || u.Host == "" {
+		return addr
+	}
+	ip := net.ParseIP(u.Hostname())
+	if ip == nil || !ip.IsUnspecified() {
+		return addr
+	}
+	if port := u.Port(); port != "" {
+		u.Host = net.JoinHostPort(from.String(), port)
+	} else {
+		u.Host = from.String()
+	}
+	return u.String()
+}
+
+// entries returns the instances found.
+func (b *browser) entries() []Entry {
+	entries := make([]Entry, 0, len(b.order))
+	for _, name := range b.order {
+		info := b.instances[strings.ToLower(name)]
+		entry := Entry{
+			Instance:  strings.TrimSuffix(info.name, "."+serviceName()),
+			MachineID: info.machineID,
+		}
+		for _, addr := range info.urls {
+			entry.URLs = append(entry.URLs, address(addr, info.from))
+		}
+		// responders which do not advertise URLs (such as NAOqi)
+		if len(entry.URLs) == 0 && info.port != 0 {
+			ip, ok := b.hosts[info.host]
+			if !ok {
+				ip = info.from
+			}
+			entry.URLs = []string{fmt.Sprintf("tcp://%s",
+				net.JoinHostPort(ip.String(),
+					strconv.Itoa(int(info.port))))}
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// query returns a query for the instances of the service type.
+func query() ([]byte, error) {
+	msg := dnsmessage.Message{
+		Header: dnsmessage.Header{ID: uint16(os.Getpid())},
+		Questions: []dnsmessage.Question{
+			{
+				Name:  dnsmessage.MustNewName(serviceName()),
+				Type:  dnsmessage.TypePTR,
+				Class: dnsmessage.ClassINET,
+			},
+		},
+	}
+	return msg.Pack()
+}
+
+// Browse sends a query for the service directories and returns the
+// ones which responded before the timeout.
+func Browse(timeout time.Duration) ([]Entry, error) {
+	packet, err := query()
+	if err != nil {
+		return nil, fmt.Errorf("query: %s", err)
+	}
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, fmt.Errorf("browse: %s", err)
+	}
+	defer conn.Close()
+	deadline := time.Now().Add(timeout)
+	conn.SetReadDeadline(deadline)
+	// the query is sent twice in case it is lost.
+	if _, err := conn.WriteToUDP(packet, Group); err != nil {
+		return nil, fmt.Errorf("browse: %s", err)
+	}
+	resend := time.AfterFunc(timeout/2, func() {
+		conn.WriteToUDP(packet, Group)
+	})
+	defer resend.Stop()
+
+	b := &browser{
+		instances: make(map[string]*instanceInfo),
+		hosts:     make(map[string]net.IP),
+	}
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return b.entries(), nil
+		} else if err != nil {
+			return nil, fmt.Errorf("browse: %s", err)
+		}
+		// ignore the invalid responses.
+		b.parse(buf[:n], from.IP)
+	}
+}
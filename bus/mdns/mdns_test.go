@@ -0,0 +1,76 @@
+package mdns_test
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lugu/qiloop/bus/mdns"
+)
+
+func init() {
+	// do not interfere with the mDNS responders of the host.
+	mdns.Group = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 55353}
+}
+
+func find(entries []mdns.Entry, instance string) (mdns.Entry, bool) {
+	for _, entry := range entries {
+		if entry.Instance == instance {
+			return entry, true
+		}
+	}
+	return mdns.Entry{}, false
+}
+
+func TestBrowse(t *testing.T) {
+	responder, err := mdns.Advertise("test-browse", []string{
+		"tcp://192.168.1.10:9559",
+		"tcps://0.0.0.0:9503",
+		"unix:///tmp/qiloop.sock",
+	}, "machine-a")
+	if err != nil {
+		t.Skipf("multicast not available: %s", err)
+	}
+	entries, err := mdns.Browse(500 * time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, ok := find(entries, "test-browse")
+	if !ok {
+		t.Fatalf("instance not found: %v", entries)
+	}
+	if entry.MachineID != "machine-a" {
+		t.Errorf("unexpected machine id: %s", entry.MachineID)
+	}
+	if len(entry.URLs) != 2 || entry.URLs[0] != "tcp://192.168.1.10:9559" {
+		t.Fatalf("unexpected URLs: %v", entry.URLs)
+	}
+	// the unspecified address is replaced with the address of the
+	// responder.
+	if !strings.HasPrefix(entry.URLs[1], "tcps://") ||
+		strings.Contains(entry.URLs[1], "0.0.0.0") ||
+		!strings.HasSuffix(entry.URLs[1], ":9503") {
+		t.Errorf("unexpected URL: %s", entry.URLs[1])
+	}
+
+	responder.Close()
+	entries, err = mdns.Browse(200 * time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := find(entries, "test-browse"); ok {
+		t.Errorf("instance still advertised")
+	}
+}
+
+func TestAdvertiseError(t *testing.T) {
+	_, err := mdns.Advertise("test", []string{"::invalid"}, "")
+	if err == nil {
+		t.Errorf("invalid URL accepted")
+	}
+	_, err = mdns.Advertise(strings.Repeat("a", 300), nil, "")
+	if err == nil {
+		t.Errorf("invalid instance accepted")
+	}
+}
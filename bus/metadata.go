@@ -0,0 +1,57 @@
+package bus
+
+import (
+	"strings"
+)
+
+// Keys of the service metadata commonly used. The metadata is an
+// extensible map: any other key can be used.
+const (
+	// MetadataVersion is the version of the service.
+	MetadataVersion = "version"
+	// MetadataOwner is the team or the person responsible for the
+	// service.
+	MetadataOwner = "owner"
+	// MetadataPackage is the name of the IDL package of the service.
+	MetadataPackage = "package"
+	// MetadataTags is the list of tags of the service separated
+	// by commas.
+	MetadataTags = "tags"
+)
+
+// ServiceOption configures a service created with Server.NewService.
+type ServiceOption func(metadata map[string]string)
+
+// WithMetadata sets an entry of the metadata of the service.
+func WithMetadata(key, value string) ServiceOption {
+	return func(metadata map[string]string) {
+		metadata[key] = value
+	}
+}
+
+// WithTags adds tags to the metadata of the service.
+func WithTags(tags ...string) ServiceOption {
+	return func(metadata map[string]string) {
+		metadata[MetadataTags] = strings.Join(
+			append(Tags(metadata), tags...), ",")
+	}
+}
+
+// Tags returns the tags of the metadata of a service.
+func Tags(metadata map[string]string) []string {
+	tags := make([]string, 0)
+	for _, tag := range strings.Split(metadata[MetadataTags], ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// MetadataNamespace is a Namespace which records the metadata of the
+// services. The metadata of a service is set after its reservation
+// and before its activation.
+type MetadataNamespace interface {
+	Namespace
+	SetMetadata(serviceID uint32, metadata map[string]string) error
+}
@@ -0,0 +1,210 @@
+package net
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// Header flags indicating the algorithm used to compress the payload.
+// Those flags are only set when the peer supports compression (see the
+// capability QiloopCompression).
+const (
+	// FlagCompression is the mask of the compression flags.
+	FlagCompression uint8 = 0xc0
+	// FlagFlate indicates a payload compressed with DEFLATE.
+	FlagFlate uint8 = 0x40
+	// FlagGzip indicates a payload compressed with gzip.
+	FlagGzip uint8 = 0x80
+	// FlagZlib indicates a payload compressed with zlib.
+	FlagZlib uint8 = 0xc0
+)
+
+// CompressionAlgorithms lists the supported compression algorithms by
+// order of preference. It is advertised during the authentication:
+// an empty list disables compression.
+var CompressionAlgorithms = []string{"flate", "gzip", "zlib"}
+
+// Compression describes how the payloads sent by an endpoint are
+// compressed.
+type Compression struct {
+	// Algorithm is one of CompressionAlgorithms. An empty algorithm
+	// disables the compression.
+	Algorithm string
+	// Level is the compression level (see compress/flate).
+	Level int
+	// Threshold is the size under which the payloads are not
+	// compressed.
+	Threshold int
+}
+
+// DefaultCompression is used by the clients and the servers once a
+// compression algorithm is negotiated.
+var DefaultCompression = Compression{
+	Level:     flate.BestSpeed,
+	Threshold: 1024,
+}
+
+// compressWriter is implemented by the writers of compress/flate,
+// compress/gzip and compress/zlib.
+type compressWriter interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+}
+
+// codec describes a compression algorithm.
+type codec struct {
+	flag   uint8
+	writer func(w io.Writer, level int) (compressWriter, error)
+	reader func(r io.Reader) (io.ReadCloser, error)
+}
+
+var codecs = map[string]codec{
+	"flate": {
+		flag: FlagFlate,
+		writer: func(w io.Writer, level int) (compressWriter, error) {
+			return flate.NewWriter(w, level)
+		},
+		reader: func(r io.Reader) (io.ReadCloser, error) {
+			return flate.NewReader(r), nil
+		},
+	},
+	"gzip": {
+		flag: FlagGzip,
+		writer: func(w io.Writer, level int) (compressWriter, error) {
+			return gzip.NewWriterLevel(w, level)
+		},
+		reader: func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		},
+	},
+	"zlib": {
+		flag: FlagZlib,
+		writer: func(w io.Writer, level int) (compressWriter, error) {
+			return zlib.NewWriterLevel(w, level)
+		},
+		reader: func(r io.Reader) (io.ReadCloser, error) {
+			return zlib.NewReader(r)
+		},
+	},
+}
+
+// codecByFlag returns the codec indicated by the flags of a header.
+func codecByFlag(flags uint8) (codec, bool) {
+	for _, c := range codecs {
+		if c.flag == flags&FlagCompression {
+			return c, true
+		}
+	}
+	return codec{}, false
+}
+
+// compressor compresses the payloads of an endpoint. The writers are
+// reused since they are expensive to allocate.
+type compressor struct {
+	Compression
+	codec   codec
+	writers sync.Pool
+}
+
+func newCompressor(c Compression) (*compressor, error) {
+	codec, ok := codecs[c.Algorithm]
+	if !ok {
+		return nil, fmt.Errorf("unknown compression: %s", c.Algorithm)
+	}
+	// verify the level.
+	if _, err := codec.writer(ioutil.Discard, c.Level); err != nil {
+		return nil, fmt.Errorf("%s: %s", c.Algorithm, err)
+	}
+	return &compressor{Compression: c, codec: codec}, nil
+}
+
+// compress replaces the payload of m with its compressed version if
+// it is large enough and if the compression reduces its size.
+func (c *compressor) compress(m *Message) error {
+	if len(m.Payload) < c.Threshold || m.Header.Flags&FlagCompression != 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+	buf.Grow(len(m.Payload) / 2)
+	w, ok := c.writers.Get().(compressWriter)
+	if ok {
+		w.Reset(&buf)
+	} else {
+		var err error
+		w, err = c.codec.writer(&buf, c.Level)
+		if err != nil {
+			return fmt.Errorf("compress: %s", err)
+		}
+	}
+	defer c.writers.Put(w)
+	if _, err := w.Write(m.Payload); err != nil {
+		return fmt.Errorf("compress: %s", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("compress: %s", err)
+	}
+	if buf.Len() >= len(m.Payload) {
+		return nil
+	}
+	m.Payload = buf.Bytes()
+	m.Header.Size = uint32(len(m.Payload))
+	m.Header.Flags |= c.codec.flag
+	return nil
+}
+
+// decompress restores the payload of a message compressed by the
+// peer. The decompressed payload is limited to MaxPayloadSize.
+func decompress(m *Message) error {
+	if m.Header.Flags&FlagCompression == 0 {
+		return nil
+	}
+	codec, ok := codecByFlag(m.Header.Flags)
+	if !ok {
+		return fmt.Errorf("unknown compression flags: %x",
+			m.Header.Flags)
+	}
+	r, err := codec.reader(bytes.NewReader(m.Payload))
+	if err != nil {
+		return fmt.Errorf("decompress: %s", err)
+	}
+	defer r.Close()
+	payload, err := ioutil.ReadAll(io.LimitReader(r,
+		int64(MaxPayloadSize)+1))
+	if err != nil {
+		return fmt.Errorf("decompress: %s", err)
+	}
+	if uint32(len(payload)) > MaxPayloadSize {
+		return fmt.Errorf("decompressed payload too large")
+	}
+	m.Payload = payload
+	m.Header.Size = uint32(len(payload))
+	m.Header.Flags &^= FlagCompression
+	return nil
+}
+
+// SetCompression sets the compression of the payloads sent by the
+// endpoint. It must only be enabled if the peer supports the
+// algorithm. The payloads received are decompressed regardless of
+// this setting.
+func SetCompression(e EndPoint, c Compression) error {
+	end, ok := unwrap(e).(*endPoint)
+	if !ok {
+		return fmt.Errorf("compression not supported by %s", e)
+	}
+	if c.Algorithm == "" {
+		end.compressor.Store((*compressor)(nil))
+		return nil
+	}
+	comp, err := newCompressor(c)
+	if err != nil {
+		return err
+	}
+	end.compressor.Store(comp)
+	return nil
+}
@@ -0,0 +1,145 @@
+package net_test
+
+import (
+	"bytes"
+	"math/rand"
+	gonet "net"
+	"testing"
+
+	"github.com/lugu/qiloop/bus/net"
+)
+
+// compressible returns a payload with few distinct values.
+func compressible(size int) []byte {
+	payload := make([]byte, size)
+	for i := range payload {
+		payload[i] = byte(i % 16)
+	}
+	return payload
+}
+
+// sendRaw sends the message with e and returns the message read on
+// the wire.
+func sendRaw(t *testing.T, e net.EndPoint, conn gonet.Conn, m net.Message) net.Message {
+	errs := make(chan error, 1)
+	go func() {
+		errs <- e.Send(m)
+	}()
+	var raw net.Message
+	if err := raw.Read(conn); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestCompressionWire(t *testing.T) {
+	a, b := gonet.Pipe()
+	defer b.Close()
+	e := net.ConnEndPoint(a)
+	defer e.Close()
+
+	hdr := net.NewHeader(net.Call, 1, 2, 3, 4)
+	large := net.NewMessage(hdr, compressible(64*1024))
+
+	raw := sendRaw(t, e, b, large)
+	if raw.Header.Flags != 0 || raw.Header.Size != large.Header.Size {
+		t.Errorf("compression not enabled: %v", raw.Header)
+	}
+	for _, algo := range net.CompressionAlgorithms {
+		c := net.DefaultCompression
+		c.Algorithm = algo
+		if err := net.SetCompression(e, c); err != nil {
+			t.Fatal(err)
+		}
+		raw = sendRaw(t, e, b, large)
+		if raw.Header.Flags&net.FlagCompression == 0 {
+			t.Errorf("%s: not compressed", algo)
+		}
+		if raw.Header.Size >= large.Header.Size {
+			t.Errorf("%s: size %d", algo, raw.Header.Size)
+		}
+		// small payloads are sent as is.
+		small := net.NewMessage(hdr, compressible(100))
+		raw = sendRaw(t, e, b, small)
+		if raw.Header.Flags != 0 || raw.Header.Size != 100 {
+			t.Errorf("%s: small compressed: %v", algo, raw.Header)
+		}
+	}
+	// incompressible payloads are sent as is.
+	random := make([]byte, 64*1024)
+	rand.Read(random)
+	raw = sendRaw(t, e, b, net.NewMessage(hdr, random))
+	if raw.Header.Flags != 0 || !bytes.Equal(raw.Payload, random) {
+		t.Errorf("random data compressed: %v", raw.Header)
+	}
+	if err := net.SetCompression(e, net.Compression{}); err != nil {
+		t.Fatal(err)
+	}
+	raw = sendRaw(t, e, b, large)
+	if raw.Header.Flags != 0 {
+		t.Errorf("compression not disabled: %v", raw.Header)
+	}
+	c := net.DefaultCompression
+	c.Algorithm = "unknown"
+	if err := net.SetCompression(e, c); err == nil {
+		t.Errorf("unknown algorithm accepted")
+	}
+}
+
+func TestCompressionRoundTrip(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	messages := make(chan *net.Message, 10)
+	closedB := closed(b, messages)
+
+	payload := compressible(int(net.MaxPayloadSize))
+	hdr := net.NewHeader(net.Event, 1, 2, 3, 4)
+	for _, algo := range net.CompressionAlgorithms {
+		c := net.DefaultCompression
+		c.Algorithm = algo
+		if err := net.SetCompression(a, c); err != nil {
+			t.Fatal(err)
+		}
+		if err := a.Send(net.NewMessage(hdr, payload)); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case msg := <-messages:
+			if msg.Header.Flags != 0 {
+				t.Errorf("%s: flags %x", algo, msg.Header.Flags)
+			}
+			if msg.Header.Size != uint32(len(payload)) ||
+				!bytes.Equal(msg.Payload, payload) {
+				t.Errorf("%s: corrupted payload", algo)
+			}
+		case err := <-closedB:
+			t.Fatalf("%s: closed: %v", algo, err)
+		}
+	}
+}
+
+func TestCompressionCorrupted(t *testing.T) {
+	a, b := gonet.Pipe()
+	defer a.Close()
+	e := net.ConnEndPoint(b)
+	defer e.Close()
+	messages := make(chan *net.Message, 10)
+	closedE := closed(e, messages)
+
+	hdr := net.NewHeader(net.Event, 1, 2, 3, 4)
+	hdr.Flags = net.FlagGzip
+	msg := net.NewMessage(hdr, []byte("not compressed"))
+	go msg.Write(a)
+	select {
+	case msg := <-messages:
+		t.Errorf("unexpected message: %v", msg.Header)
+	case err := <-closedE:
+		if err == nil {
+			t.Errorf("expecting an error")
+		}
+	}
+}
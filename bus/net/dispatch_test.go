@@ -0,0 +1,265 @@
+package net_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lugu/qiloop/bus/net"
+)
+
+// replier answers the calls received by the endpoint.
+func replier(e net.EndPoint) {
+	filter := func(hdr *net.Header) (bool, bool) {
+		return hdr.Type == net.Call, true
+	}
+	consumer := func(msg *net.Message) error {
+		msg.Header.Type = net.Reply
+		return e.Send(*msg)
+	}
+	e.AddHandler(filter, consumer, func(err error) {})
+}
+
+func TestReplyHandler(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	replier(b)
+
+	replies := make(chan *net.Message, 10)
+	consumer := func(msg *net.Message) error {
+		replies <- msg
+		return nil
+	}
+	hdr := net.NewHeader(net.Call, 1, 2, 3, 4)
+	other := net.NewHeader(net.Call, 1, 2, 3, 5)
+	id := a.AddReplyHandler(hdr, consumer, func(err error) {})
+	a.AddReplyHandler(other, consumer, func(err error) {})
+	if err := a.Send(net.NewMessage(hdr, nil)); err != nil {
+		t.Fatal(err)
+	}
+	if reply := <-replies; reply.Header.ID != 4 ||
+		reply.Header.Type != net.Reply {
+		t.Errorf("unexpected reply: %v", reply.Header)
+	}
+	// the handler is removed once the reply is received.
+	if err := a.RemoveHandler(id); err == nil {
+		t.Errorf("handler not removed")
+	}
+}
+
+func TestReplyHandlerUsesEndPoint(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	replier(b)
+
+	hdr := net.NewHeader(net.Call, 1, 2, 3, 4)
+	next := net.NewHeader(net.Call, 1, 2, 3, 5)
+	other := a.AddReplyHandler(net.NewHeader(net.Call, 1, 2, 3, 6),
+		func(msg *net.Message) error { return nil }, func(error) {})
+	done := make(chan error, 1)
+	// the consumer of a reply sends the next call.
+	a.AddReplyHandler(hdr, func(msg *net.Message) error {
+		a.AddReplyHandler(next, func(msg *net.Message) error {
+			done <- nil
+			return nil
+		}, func(error) {})
+		return a.Send(net.NewMessage(next, nil))
+	}, func(error) {
+		if err := a.RemoveHandler(other); err != nil {
+			done <- err
+		}
+	})
+	if err := a.Send(net.NewMessage(hdr, nil)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("dead lock")
+	}
+}
+
+func TestEventHandler(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+
+	events := make(chan *net.Message, 10)
+	closed := make(chan error, 1)
+	a.AddEventHandler(1, 2, 3, func(msg *net.Message) error {
+		events <- msg
+		return nil
+	}, func(err error) {
+		closed <- err
+	})
+	send := func(typ uint8, action uint32) {
+		msg := net.NewMessage(net.NewHeader(typ, 1, 2, action, 0), nil)
+		if err := b.Send(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	send(net.Event, 4)
+	send(net.Event, 3)
+	send(net.Event, 3)
+	for i := 0; i < 2; i++ {
+		if event := <-events; event.Header.Action != 3 {
+			t.Errorf("unexpected event: %v", event.Header)
+		}
+	}
+	// an error terminates the subscription.
+	send(net.Error, 3)
+	<-events
+	if err := <-closed; err != nil {
+		t.Error(err)
+	}
+	a.Close()
+}
+
+// benchmarkDispatch measures the round trip of a call while pending
+// calls are waiting for their response.
+func benchmarkDispatch(b *testing.B, pending int, indexed bool) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	replier(server)
+
+	add := func(hdr net.Header, c net.Consumer) {
+		if indexed {
+			client.AddReplyHandler(hdr, c, func(error) {})
+			return
+		}
+		filter := func(h *net.Header) (bool, bool) {
+			if h.Service == hdr.Service && h.Object == hdr.Object &&
+				h.Action == hdr.Action && h.ID == hdr.ID {
+				return true, false
+			}
+			return false, true
+		}
+		client.AddHandler(filter, c, func(error) {})
+	}
+	ignore := func(msg *net.Message) error { return nil }
+	for i := 0; i < pending; i++ {
+		add(net.NewHeader(net.Call, 1, 1, 1, uint32(1<<30+i)), ignore)
+	}
+	reply := make(chan *net.Message)
+	consumer := func(msg *net.Message) error {
+		reply <- msg
+		return nil
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		hdr := net.NewHeader(net.Call, 1, 1, 1, uint32(i))
+		add(hdr, consumer)
+		if err := client.Send(net.NewMessage(hdr, nil)); err != nil {
+			b.Fatal(err)
+		}
+		<-reply
+	}
+}
+
+func BenchmarkDispatch(b *testing.B) {
+	for _, pending := range []int{10, 1000, 10000} {
+		b.Run(fmt.Sprintf("filter-%d", pending), func(b *testing.B) {
+			benchmarkDispatch(b, pending, false)
+		})
+		b.Run(fmt.Sprintf("indexed-%d", pending), func(b *testing.B) {
+			benchmarkDispatch(b, pending, true)
+		})
+	}
+}
+
+// BenchmarkEndPointRoundTrip measures the latency of a call over a
+// socket pair.
+func BenchmarkEndPointRoundTrip(b *testing.B) {
+	client, server, err := net.SocketPair()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer client.Close()
+	defer server.Close()
+	replier(server)
+
+	reply := make(chan *net.Message, 1)
+	consumer := func(msg *net.Message) error {
+		reply <- msg
+		return nil
+	}
+	payload := make([]byte, 128)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		hdr := net.NewHeader(net.Call, 1, 1, 1, uint32(i))
+		client.AddReplyHandler(hdr, consumer, func(error) {})
+		if err := client.Send(net.NewMessage(hdr, payload)); err != nil {
+			b.Fatal(err)
+		}
+		<-reply
+	}
+}
+
+// BenchmarkEndPointBurst measures the throughput of small messages
+// sent concurrently over a socket pair.
+func BenchmarkEndPointBurst(b *testing.B) {
+	client, server, err := net.SocketPair()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer client.Close()
+	defer server.Close()
+
+	var received sync.WaitGroup
+	received.Add(b.N)
+	server.AddHandler(func(hdr *net.Header) (bool, bool) {
+		return true, true
+	}, func(msg *net.Message) error {
+		received.Done()
+		return nil
+	}, func(error) {})
+
+	payload := make([]byte, 64)
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		hdr := net.NewHeader(net.Post, 1, 1, 1, 0)
+		for pb.Next() {
+			if err := client.Send(net.NewMessage(hdr, payload)); err != nil {
+				b.Error(err)
+			}
+		}
+	})
+	received.Wait()
+}
+
+func TestPayloadIsolation(t *testing.T) {
+	a, b, err := net.SocketPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	defer b.Close()
+
+	received := make(chan *net.Message, 2)
+	b.AddHandler(func(hdr *net.Header) (bool, bool) {
+		return true, true
+	}, func(msg *net.Message) error {
+		received <- msg
+		return nil
+	}, func(error) {})
+	for i := 0; i < 2; i++ {
+		msg := net.NewMessage(net.NewHeader(net.Post, 1, 1, 1, 0),
+			[]byte{byte(i), byte(i)})
+		if err := a.Send(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	first, second := <-received, <-received
+	_ = append(first.Payload, 9, 9)
+	if second.Payload[0] != 1 || second.Payload[1] != 1 {
+		t.Errorf("payload overwritten: %v", second.Payload)
+	}
+}
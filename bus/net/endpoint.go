@@ -1,8 +1,8 @@
 package net
 
 import (
+	"bufio"
 	"bytes"
-	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
@@ -12,9 +12,10 @@ import (
 	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/ftrvxmtrx/fd"
-	quic "github.com/lucas-clemente/quic-go"
 	"github.com/lugu/qiloop/type/value"
 )
 
@@ -46,6 +47,19 @@ type EndPoint interface {
 	// Filter.
 	AddHandler(f Filter, c Consumer, cl Closer) int
 
+	// AddReplyHandler registers a Consumer which receives the
+	// response (Reply, Error or Cancelled) to the call described by
+	// hdr. The handler is removed once the response is received.
+	// The Consumer and the Closer are called from the goroutine
+	// reading the messages: they must not block but they can add
+	// and remove handlers.
+	AddReplyHandler(hdr Header, c Consumer, cl Closer) int
+
+	// AddEventHandler registers a Consumer which receives the events
+	// of a signal. The handler is removed when an error is received
+	// for the signal.
+	AddEventHandler(service, object, action uint32, c Consumer, cl Closer) int
+
 	// RemoveHandler removes the associated Filter and Consumer.
 	// RemoveHandler must not be called from within the Filter: use
 	// the Filter returned value keep for this purpose.
@@ -66,6 +80,12 @@ type Handler struct {
 	queue    chan *Message
 	cancel   chan struct{}
 	err      error
+	// route is set when the handler is indexed (see AddReplyHandler
+	// and AddEventHandler).
+	route *route
+	// direct handlers have no queue: the consumer is called by the
+	// dispatch (see AddReplyHandler).
+	direct bool
 }
 
 // NewHandler returns an Handler: f is call on each incomming message,
@@ -83,10 +103,35 @@ func NewHandler(f Filter, c Consumer, cl Closer) *Handler {
 	return h
 }
 
+// newDirectHandler returns an Handler without queue nor goroutine. It
+// is used for the consumers which do not block.
+func newDirectHandler(c Consumer, cl Closer) *Handler {
+	return &Handler{
+		consumer: c,
+		closer:   cl,
+		direct:   true,
+	}
+}
+
+// deliver sends the message to the consumer.
+func (h *Handler) deliver(msg *Message) {
+	if !h.direct {
+		h.queue <- msg
+		return
+	}
+	if err := h.consumer(msg); err != nil {
+		log.Printf("consumer: %s", err)
+	}
+}
+
 // Stop stops the handler main loop. If immediatly, the pending
 // messages in the queue will be dropped, else the queue will be
 // processed before terminating the handler.
 func (h *Handler) Stop(immediatly bool) {
+	if h.direct {
+		h.closer(h.err)
+		return
+	}
 	if immediatly {
 		close(h.cancel)
 	} else {
@@ -113,20 +158,77 @@ loop:
 	h.closer(h.err)
 }
 
+// route identifies the messages of an indexed handler: the responses
+// to a call or the events of a signal.
+type route struct {
+	typ     uint8 // Reply or Event
+	id      uint32
+	service uint32
+	object  uint32
+	action  uint32
+}
+
+// replyRoute returns the route of the response to hdr.
+func replyRoute(hdr *Header) route {
+	return route{Reply, hdr.ID, hdr.Service, hdr.Object, hdr.Action}
+}
+
+// eventRoute returns the route of the events of a signal.
+func eventRoute(service, object, action uint32) route {
+	return route{Event, 0, service, object, action}
+}
+
+// bufferSize is the size of the read and write buffers of an
+// endpoint. Larger messages are not copied.
+const bufferSize = 16 * 1024
+
 type endPoint struct {
-	stream        Stream
-	handlers      []*Handler
+	// lastReceived is the time (in nanoseconds) of the last message
+	// received. Accessed atomically.
+	lastReceived int64
+	keepAlive    int32
+	stream       Stream
+	// reader and payloads are used by the process goroutine.
+	reader   *bufio.Reader
+	header   [HeaderSize]byte
+	payloads payloadPool
+	// writer buffers the messages sent concurrently: the last sender
+	// flushes it. pending counts the senders.
+	writer      *bufio.Writer
+	writerMutex sync.Mutex
+	pending     int32
+	// handlers contains the handlers indexed by their id. free
+	// contains the unused ids.
+	handlers []*Handler
+	free     []int
+	// filters contains the handlers using a Filter and routes the ids
+	// of the indexed handlers.
+	filters       map[int]*Handler
+	routes        map[route][]int
 	handlersMutex sync.Mutex
+	closed        chan struct{}
+	closeOnce     sync.Once
+	// compressor contains the *compressor of the payloads sent.
+	compressor atomic.Value
+}
+
+func newEndPoint(stream Stream) *endPoint {
+	return &endPoint{
+		lastReceived: time.Now().UnixNano(),
+		stream:       stream,
+		reader:       bufio.NewReaderSize(stream, bufferSize),
+		writer:       bufio.NewWriterSize(stream, bufferSize),
+		filters:      make(map[int]*Handler),
+		routes:       make(map[route][]int),
+		closed:       make(chan struct{}),
+	}
 }
 
 // EndPointFinalizer creates a new EndPoint and let you process it
 // before it start handling messages. This allows you to add handler
 // or/and avoid data races.
 func EndPointFinalizer(stream Stream, finalizer func(EndPoint)) EndPoint {
-	e := &endPoint{
-		stream:   stream,
-		handlers: make([]*Handler, 10),
-	}
+	e := newEndPoint(stream)
 	finalizer(e)
 	go e.process()
 	return e
@@ -138,10 +240,7 @@ func EndPointFinalizer(stream Stream, finalizer func(EndPoint)) EndPoint {
 // and Handler is registered. Prefer EndPointFinalizer for a safe way
 // to construct EndPoint.
 func NewEndPoint(stream Stream) EndPoint {
-	e := &endPoint{
-		stream:   stream,
-		handlers: make([]*Handler, 10),
-	}
+	e := newEndPoint(stream)
 	go e.process()
 	return e
 }
@@ -161,21 +260,24 @@ func dialUNIX(name string) (EndPoint, error) {
 }
 
 func dialTCP(addr string) (EndPoint, error) {
-	conn, err := gonet.Dial("tcp", addr)
+	dialer := gonet.Dialer{KeepAlive: TCPKeepAlive}
+	conn, err := dialer.Dial("tcp", addr)
 	if err != nil {
 		return nil, fmt.Errorf("connect %s: %s", addr, err)
 	}
 	return ConnEndPoint(conn), nil
 }
 
-// dialTLS connects regardless of the certificate.
-func dialTLS(addr string) (EndPoint, error) {
-	conf := &tls.Config{
-		InsecureSkipVerify: true,
+// dialTLS connects using the TLS options of the URL.
+func dialTLS(u *url.URL) (EndPoint, error) {
+	conf, err := dialConfig(u)
+	if err != nil {
+		return nil, err
 	}
-	conn, err := tls.Dial("tcp", addr, conf)
+	dialer := gonet.Dialer{KeepAlive: TCPKeepAlive}
+	conn, err := tls.DialWithDialer(&dialer, "tcp", u.Host, conf)
 	if err != nil {
-		return nil, fmt.Errorf("connect %s: %s", addr, err)
+		return nil, fmt.Errorf("connect %s: %s", u.Host, err)
 	}
 	return ConnEndPoint(conn), nil
 }
@@ -221,26 +323,12 @@ const (
 	ListenAddress
 )
 
-// dialQUIC connects regardless of the certificate.
-// TOOD: does not multiplex sessions
-func dialQUIC(addr string) (EndPoint, error) {
-	conf := &tls.Config{
-		InsecureSkipVerify: true,
-		NextProtos:         []string{"qi-messaging"},
-	}
-	session, err := quic.DialAddr(addr, conf, nil)
-	if err != nil {
-		return nil, err
-	}
-	ctx := context.WithValue(context.TODO(), DialAddress, addr)
-	stream, err := session.OpenStreamSync(ctx)
-	if err != nil {
-		return nil, err
-	}
-	return NewEndPoint(newQuicStream(stream)), nil
-}
-
 // DialEndPoint construct an endpoint by contacting a given address.
+// An inherited socket (see StartChild) is addressed with fd://<fd>.
+// Faults are injected in the connection to an address prefixed with
+// faulty:// (see FaultyEndPoint).
+// TLS transports (tcps:// and quic://) accept options in the query of
+// the URL such as ca=<file> to verify the server certificate.
 func DialEndPoint(addr string) (EndPoint, error) {
 	u, err := url.Parse(addr)
 	if err != nil {
@@ -250,38 +338,65 @@ func DialEndPoint(addr string) (EndPoint, error) {
 	case "tcp":
 		return dialTCP(u.Host)
 	case "tcps":
-		return dialTLS(u.Host)
+		return dialTLS(u)
 	case "quic":
-		return dialQUIC(u.Host)
+		return dialQUIC(u)
 	case "unix":
 		return dialUNIX(strings.TrimPrefix(addr, "unix://"))
 	case "pipe":
 		return dialPipe(strings.TrimPrefix(addr, "pipe://"))
+	case "fd":
+		return dialFD(u)
+	case "faulty":
+		return dialFaulty(addr)
 	default:
 		return nil, fmt.Errorf("unknown URL scheme: %s", addr)
 	}
 }
 
-// Send post a message to the other side of the endpoint.
+// Send post a message to the other side of the endpoint. The messages
+// sent concurrently are written together. The payload is compressed
+// if compression is enabled (see SetCompression).
 func (e *endPoint) Send(m Message) error {
-	return m.Write(e.stream)
+	if c, _ := e.compressor.Load().(*compressor); c != nil {
+		if err := c.compress(&m); err != nil {
+			return err
+		}
+	}
+	atomic.AddInt32(&e.pending, 1)
+	e.writerMutex.Lock()
+	defer e.writerMutex.Unlock()
+	err := m.writeTo(e.writer)
+	// the last sender of a burst flushes the buffer.
+	if atomic.AddInt32(&e.pending, -1) == 0 && e.writer.Buffered() != 0 {
+		if ferr := e.writer.Flush(); err == nil {
+			err = m.writeError(ferr)
+		}
+	}
+	return err
 }
 
 // closeWith close all handler
 func (e *endPoint) closeWith(err error) error {
 
 	ret := e.stream.Close()
+	e.closeOnce.Do(func() { close(e.closed) })
 
 	e.handlersMutex.Lock()
-	defer e.handlersMutex.Unlock()
-
+	stopped := make([]*Handler, 0, len(e.handlers))
 	for id, handler := range e.handlers {
 		if handler != nil {
 			handler.err = err
-			handler.Stop(false)
-			e.handlers[id] = nil
+			stopped = append(stopped, handler)
+			e.remove(id)
 		}
 	}
+	e.handlersMutex.Unlock()
+
+	// the closers can use the endpoint.
+	for _, handler := range stopped {
+		handler.Stop(false)
+	}
 	return ret
 }
 
@@ -291,17 +406,62 @@ func (e *endPoint) Close() error {
 }
 
 // RemoveHandler unregister the associated Filter and Consumer.
-// WARNING: RemoveHandler must not be called from within the Filter or
-// the Consumer.
+// WARNING: RemoveHandler must not be called from within the Filter.
 func (e *endPoint) RemoveHandler(id int) error {
 	e.handlersMutex.Lock()
-	defer e.handlersMutex.Unlock()
-	if id >= 0 && id < len(e.handlers) && e.handlers[id] != nil {
-		e.handlers[id].Stop(true)
-		e.handlers[id] = nil
-		return nil
+	if id < 0 || id >= len(e.handlers) || e.handlers[id] == nil {
+		e.handlersMutex.Unlock()
+		return fmt.Errorf("invalid handler id: %d", id)
+	}
+	handler := e.handlers[id]
+	e.remove(id)
+	e.handlersMutex.Unlock()
+	handler.Stop(true)
+	return nil
+}
+
+// add stores the handler and returns its id. The handler is indexed
+// by route if not nil. handlersMutex must be locked.
+func (e *endPoint) add(h *Handler, r *route) int {
+	var id int
+	if n := len(e.free); n > 0 {
+		id = e.free[n-1]
+		e.free = e.free[:n-1]
+		e.handlers[id] = h
+	} else {
+		id = len(e.handlers)
+		e.handlers = append(e.handlers, h)
+	}
+	if r != nil {
+		h.route = r
+		e.routes[*r] = append(e.routes[*r], id)
+	} else {
+		e.filters[id] = h
+	}
+	return id
+}
+
+// remove forgets the handler id. handlersMutex must be locked.
+func (e *endPoint) remove(id int) {
+	h := e.handlers[id]
+	e.handlers[id] = nil
+	e.free = append(e.free, id)
+	if h.route == nil {
+		delete(e.filters, id)
+		return
+	}
+	ids := e.routes[*h.route]
+	for i, routed := range ids {
+		if routed == id {
+			ids = append(ids[:i], ids[i+1:]...)
+			break
+		}
+	}
+	if len(ids) == 0 {
+		delete(e.routes, *h.route)
+	} else {
+		e.routes[*h.route] = ids
 	}
-	return fmt.Errorf("invalid handler id: %d", id)
 }
 
 // AddHandler register the associated Filter and Consumer to the
@@ -310,14 +470,27 @@ func (e *endPoint) AddHandler(f Filter, c Consumer, cl Closer) int {
 	newHandler := NewHandler(f, c, cl)
 	e.handlersMutex.Lock()
 	defer e.handlersMutex.Unlock()
-	for i, handler := range e.handlers {
-		if handler == nil {
-			e.handlers[i] = newHandler
-			return i
-		}
-	}
-	e.handlers = append(e.handlers, newHandler)
-	return len(e.handlers) - 1
+	return e.add(newHandler, nil)
+}
+
+// AddReplyHandler registers a Consumer for the response to hdr. The
+// consumer and the closer are called by the dispatch: they must not
+// block but they can add and remove handlers.
+func (e *endPoint) AddReplyHandler(hdr Header, c Consumer, cl Closer) int {
+	newHandler := newDirectHandler(c, cl)
+	r := replyRoute(&hdr)
+	e.handlersMutex.Lock()
+	defer e.handlersMutex.Unlock()
+	return e.add(newHandler, &r)
+}
+
+// AddEventHandler registers a Consumer for the events of a signal.
+func (e *endPoint) AddEventHandler(service, object, action uint32, c Consumer, cl Closer) int {
+	newHandler := NewHandler(nil, c, cl)
+	r := eventRoute(service, object, action)
+	e.handlersMutex.Lock()
+	defer e.handlersMutex.Unlock()
+	return e.add(newHandler, &r)
 }
 
 // ErrNoMatch is returned when the message did not match any handler
@@ -326,29 +499,94 @@ var ErrNoMatch = errors.New("message dropped: no handler match")
 // ErrNoHandler is returned when there is no handler registered.
 var ErrNoHandler = errors.New("message dropped: no handler registered")
 
-// dispatch requests each handler if it match the message, if so it
+// dispatchRoute sends the message to the handlers indexed by the
+// route. If once, the handlers are removed. It returns true if a
+// handler matched. The direct handlers are added to direct: they are
+// called once handlersMutex is released since their consumer and
+// their closer can use the endpoint. handlersMutex must be locked.
+func (e *endPoint) dispatchRoute(msg *Message, r route, once bool,
+	direct *[]func()) bool {
+
+	ids := e.routes[r]
+	if len(ids) == 0 {
+		return false
+	}
+	if once {
+		// the handlers are removed from e.routes[r].
+		ids = append([]int(nil), ids...)
+	}
+	for _, id := range ids {
+		h := e.handlers[id]
+		if once {
+			e.remove(id)
+		}
+		if !h.direct {
+			h.deliver(msg)
+			if once {
+				h.Stop(false)
+			}
+			continue
+		}
+		*direct = append(*direct, func() {
+			h.deliver(msg)
+			if once {
+				h.Stop(false)
+			}
+		})
+	}
+	return true
+}
+
+// dispatch sends the responses and the events to the indexed handlers
+// and requests each other handler if it match the message, if so it
 // sends it to the handler queue. If no handler is registered, it
 // returns ErrNoHandler and if no handler match the message it returns
 // ErrNoMatch.
 func (e *endPoint) dispatch(msg *Message) error {
+	var direct []func()
 	e.handlersMutex.Lock()
-	defer e.handlersMutex.Unlock()
-	if len(e.handlers) == 0 {
+	ret := e.dispatchLocked(msg, &direct)
+	e.handlersMutex.Unlock()
+	for _, deliver := range direct {
+		deliver()
+	}
+	return ret
+}
+
+// dispatchLocked dispatches the message with handlersMutex locked.
+func (e *endPoint) dispatchLocked(msg *Message, direct *[]func()) error {
+	if len(e.filters) == 0 && len(e.routes) == 0 {
 		return ErrNoHandler
 	}
 	ret := ErrNoMatch
-	for i, h := range e.handlers {
-		if h == nil {
-			continue
+	hdr := &msg.Header
+	switch hdr.Type {
+	case Reply, Error, Cancelled:
+		if e.dispatchRoute(msg, replyRoute(hdr), true, direct) {
+			ret = nil
+		}
+		// an error also terminates the subscriptions.
+		if hdr.Type == Error && e.dispatchRoute(msg,
+			eventRoute(hdr.Service, hdr.Object, hdr.Action), true,
+			direct) {
+			ret = nil
+		}
+	case Event:
+		if e.dispatchRoute(msg,
+			eventRoute(hdr.Service, hdr.Object, hdr.Action), false,
+			direct) {
+			ret = nil
 		}
-		matched, keep := h.filter(&msg.Header)
+	}
+	for i, h := range e.filters {
+		matched, keep := h.filter(hdr)
 		if matched {
 			h.queue <- msg
 			ret = nil
 		}
 		if !keep {
 			h.Stop(false)
-			e.handlers[i] = nil
+			e.remove(i)
 		}
 	}
 	return ret
@@ -378,23 +616,39 @@ func (e *endPoint) process() {
 
 	for {
 		msg := new(Message)
-		err = msg.Read(e.stream)
+		err = msg.read(e.reader, e.header[:], e.payloads.alloc)
 		if err != nil {
 			e.closeWith(err)
 			return
 		}
+		atomic.StoreInt64(&e.lastReceived, time.Now().UnixNano())
+		if err = decompress(msg); err != nil {
+			e.closeWith(err)
+			return
+		}
+		if isHeartbeat(&msg.Header) {
+			if msg.Header.Type == Ping {
+				msg.Header.Type = Pong
+				e.Send(*msg)
+			}
+			continue
+		}
 		err = e.dispatch(msg)
 		if err != nil {
-			if msg.Header.Type == Error {
-				log.Printf("%s: %v, %s", err, msg.Header,
-					readError(msg))
-			} else {
-				log.Printf("%s: %v", err, msg.Header)
-			}
+			logDropped(err, msg)
 		}
 	}
 }
 
+// logDropped logs a message which could not be dispatched.
+func logDropped(err error, msg *Message) {
+	if msg.Header.Type == Error {
+		log.Printf("%s: %v, %s", err, msg.Header, readError(msg))
+	} else {
+		log.Printf("%s: %v", err, msg.Header)
+	}
+}
+
 // ReceiveAny returns a chanel to receive one message. If the
 // connection close, the chanel is closed.
 func (e *endPoint) ReceiveAny() (chan *Message, error) {
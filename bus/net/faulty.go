@@ -0,0 +1,406 @@
+package net
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrFaultInjected is given to the Closer callbacks when a faulty
+// endpoint closes the connection (see FaultPolicy.CloseAfter).
+var ErrFaultInjected = errors.New("connection closed by fault injection")
+
+// FaultPolicy describes the faults injected by a faulty endpoint. The
+// faults are applied to the messages sent and to the messages
+// received. The heartbeats are handled by the inner endpoint: they
+// are not affected.
+type FaultPolicy struct {
+	// Seed initializes the random source: the same seed produces the
+	// same faults for the same sequence of messages.
+	Seed int64
+	// Latency delays each message.
+	Latency time.Duration
+	// Jitter adds a random delay between zero and Jitter to the
+	// latency. The order of the messages is preserved.
+	Jitter time.Duration
+	// Drop is the probability of a message to be dropped.
+	Drop float64
+	// Duplicate is the probability of a message to be delivered
+	// twice.
+	Duplicate float64
+	// Corrupt is the probability of a byte of the payload of a
+	// message to be modified.
+	Corrupt float64
+	// Types lists the types of message which can be dropped,
+	// duplicated or corrupted. An empty list selects all types.
+	Types []uint8
+	// Stall is the probability of the incoming messages to be
+	// blocked for StallDuration before a message is received.
+	Stall         float64
+	StallDuration time.Duration
+	// CloseAfter closes the connection once CloseAfter messages have
+	// been sent or received. Zero never closes the connection.
+	CloseAfter int
+}
+
+// selected returns true if the faults apply to the message type.
+func (p *FaultPolicy) selected(typ uint8) bool {
+	if len(p.Types) == 0 {
+		return true
+	}
+	for _, t := range p.Types {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// faultyStream is the stream of the endpoint dispatching the messages
+// received by a faulty endpoint. It does not transport messages.
+type faultyStream struct {
+	name string
+}
+
+func (s faultyStream) Read(p []byte) (int, error) {
+	return 0, io.EOF
+}
+
+func (s faultyStream) Write(p []byte) (int, error) {
+	return 0, fmt.Errorf("%s: not writable", s.name)
+}
+
+func (s faultyStream) Close() error {
+	return nil
+}
+
+func (s faultyStream) String() string {
+	return s.name
+}
+
+func (s faultyStream) Context() context.Context {
+	return context.Background()
+}
+
+// delayed is a message waiting for its delivery.
+type delayed struct {
+	msg Message
+	at  time.Time
+}
+
+// faultyEndPoint injects faults between an endpoint and its handlers.
+type faultyEndPoint struct {
+	inner  EndPoint
+	local  *endPoint
+	policy FaultPolicy
+	random *rand.Rand
+	mutex  sync.Mutex // protects random
+	count  int32
+	// sent and received contain the delayed messages.
+	sent      chan delayed
+	received  chan delayed
+	sendMutex sync.Mutex // protects lastSent
+	// last is the time of delivery of the previous message of each
+	// direction: it preserves the order of the messages.
+	lastSent     time.Time
+	lastReceived time.Time
+	closed       chan struct{}
+	closeOnce    sync.Once
+}
+
+// FaultyEndPoint returns an endpoint which injects the faults described
+// by policy in the communication of inner. It is meant to test the
+// behavior of the clients and the services on unreliable networks.
+func FaultyEndPoint(inner EndPoint, policy FaultPolicy) EndPoint {
+	name := "faulty://" + inner.String()
+	f := &faultyEndPoint{
+		inner:    inner,
+		local:    newEndPoint(faultyStream{name}),
+		policy:   policy,
+		random:   rand.New(rand.NewSource(policy.Seed)),
+		sent:     make(chan delayed, 100),
+		received: make(chan delayed, 100),
+		closed:   make(chan struct{}),
+	}
+	go f.deliver(f.sent, f.send)
+	go f.deliver(f.received, f.receive)
+	filter := func(hdr *Header) (bool, bool) {
+		return true, true
+	}
+	consumer := func(msg *Message) error {
+		f.stall()
+		f.schedule(f.received, &f.lastReceived, *msg)
+		return nil
+	}
+	closer := func(err error) {
+		f.closeWith(err)
+	}
+	inner.AddHandler(filter, consumer, closer)
+	return f
+}
+
+// float returns a random number in [0.0,1.0).
+func (f *faultyEndPoint) float() float64 {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.random.Float64()
+}
+
+// delay returns the latency of a message.
+func (f *faultyEndPoint) delay() time.Duration {
+	delay := f.policy.Latency
+	if f.policy.Jitter > 0 {
+		f.mutex.Lock()
+		delay += time.Duration(f.random.Int63n(int64(f.policy.Jitter)))
+		f.mutex.Unlock()
+	}
+	return delay
+}
+
+// stall blocks the incoming messages according to the policy.
+func (f *faultyEndPoint) stall() {
+	if f.policy.Stall > 0 && f.float() < f.policy.Stall {
+		select {
+		case <-time.After(f.policy.StallDuration):
+		case <-f.closed:
+		}
+	}
+}
+
+// schedule applies the faults to msg and queues the resulting
+// messages. The queue of a direction is only accessed by a single
+// goroutine.
+func (f *faultyEndPoint) schedule(queue chan delayed, last *time.Time, msg Message) {
+	copies := 1
+	if f.policy.selected(msg.Header.Type) {
+		if f.policy.Drop > 0 && f.float() < f.policy.Drop {
+			copies = 0
+		} else if f.policy.Duplicate > 0 && f.float() < f.policy.Duplicate {
+			copies = 2
+		}
+		if copies != 0 && f.policy.Corrupt > 0 {
+			msg.Payload = f.corrupt(msg.Payload)
+		}
+	}
+	for i := 0; i < copies; i++ {
+		at := time.Now().Add(f.delay())
+		if at.Before(*last) {
+			at = *last
+		}
+		*last = at
+		select {
+		case queue <- delayed{msg, at}:
+		case <-f.closed:
+			return
+		}
+	}
+}
+
+// corrupt returns a copy of the payload with random modifications.
+func (f *faultyEndPoint) corrupt(payload []byte) []byte {
+	var corrupted []byte
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for i := range payload {
+		if f.random.Float64() >= f.policy.Corrupt {
+			continue
+		}
+		if corrupted == nil {
+			corrupted = append([]byte(nil), payload...)
+		}
+		corrupted[i] ^= byte(1 + f.random.Intn(255))
+	}
+	if corrupted == nil {
+		return payload
+	}
+	return corrupted
+}
+
+// deliver processes the messages of a queue at their delivery time.
+func (f *faultyEndPoint) deliver(queue chan delayed, action func(*Message)) {
+	for {
+		select {
+		case d := <-queue:
+			if wait := time.Until(d.at); wait > 0 {
+				select {
+				case <-time.After(wait):
+				case <-f.closed:
+					return
+				}
+			}
+			action(&d.msg)
+			if f.policy.CloseAfter > 0 && int(atomic.AddInt32(&f.count,
+				1)) >= f.policy.CloseAfter {
+				f.closeWith(ErrFaultInjected)
+				return
+			}
+		case <-f.closed:
+			return
+		}
+	}
+}
+
+func (f *faultyEndPoint) send(msg *Message) {
+	f.inner.Send(*msg)
+}
+
+func (f *faultyEndPoint) receive(msg *Message) {
+	if err := f.local.dispatch(msg); err != nil {
+		logDropped(err, msg)
+	}
+}
+
+// closeWith closes the inner endpoint and gives err to the handlers.
+func (f *faultyEndPoint) closeWith(err error) (ret error) {
+	f.closeOnce.Do(func() {
+		close(f.closed)
+		ret = f.inner.Close()
+		f.local.closeWith(err)
+	})
+	return ret
+}
+
+// Send queues the message: the errors of the inner endpoint are not
+// reported unless the endpoint is closed.
+func (f *faultyEndPoint) Send(m Message) error {
+	select {
+	case <-f.closed:
+		return fmt.Errorf("%s: connection closed", f)
+	default:
+	}
+	f.sendMutex.Lock()
+	defer f.sendMutex.Unlock()
+	f.schedule(f.sent, &f.lastSent, m)
+	return nil
+}
+
+func (f *faultyEndPoint) ReceiveAny() (chan *Message, error) {
+	return f.local.ReceiveAny()
+}
+
+func (f *faultyEndPoint) AddHandler(fl Filter, c Consumer, cl Closer) int {
+	return f.local.AddHandler(fl, c, cl)
+}
+
+func (f *faultyEndPoint) AddReplyHandler(hdr Header, c Consumer, cl Closer) int {
+	return f.local.AddReplyHandler(hdr, c, cl)
+}
+
+func (f *faultyEndPoint) AddEventHandler(service, object, action uint32, c Consumer, cl Closer) int {
+	return f.local.AddEventHandler(service, object, action, c, cl)
+}
+
+func (f *faultyEndPoint) RemoveHandler(id int) error {
+	return f.local.RemoveHandler(id)
+}
+
+func (f *faultyEndPoint) Close() error {
+	return f.closeWith(nil)
+}
+
+func (f *faultyEndPoint) String() string {
+	return f.local.String()
+}
+
+// unwrap returns the endpoint in which a faulty endpoint injects
+// faults.
+func unwrap(e EndPoint) EndPoint {
+	if f, ok := e.(*faultyEndPoint); ok {
+		return f.inner
+	}
+	return e
+}
+
+// faultParams are the query parameters of a faulty:// address.
+var faultParams = []string{"seed", "latency", "jitter", "drop",
+	"duplicate", "corrupt", "types", "stall", "stallduration", "close"}
+
+// messageTypes contains the names of the message types.
+var messageTypes = map[string]uint8{
+	"call":       Call,
+	"reply":      Reply,
+	"error":      Error,
+	"post":       Post,
+	"event":      Event,
+	"capability": Capability,
+	"cancel":     Cancel,
+	"cancelled":  Cancelled,
+}
+
+// parseFaultPolicy reads the fault policy from the query parameters.
+func parseFaultPolicy(query url.Values) (p FaultPolicy, err error) {
+	duration := func(name string, d *time.Duration) {
+		if s := query.Get(name); s != "" && err == nil {
+			*d, err = time.ParseDuration(s)
+		}
+	}
+	probability := func(name string, f *float64) {
+		if s := query.Get(name); s != "" && err == nil {
+			*f, err = strconv.ParseFloat(s, 64)
+			if err == nil && (*f < 0 || *f > 1) {
+				err = fmt.Errorf("invalid probability: %s", s)
+			}
+		}
+	}
+	integer := func(name string, i *int64) {
+		if s := query.Get(name); s != "" && err == nil {
+			*i, err = strconv.ParseInt(s, 10, 64)
+		}
+	}
+	var closeAfter int64
+	integer("seed", &p.Seed)
+	integer("close", &closeAfter)
+	p.CloseAfter = int(closeAfter)
+	duration("latency", &p.Latency)
+	duration("jitter", &p.Jitter)
+	duration("stallduration", &p.StallDuration)
+	probability("drop", &p.Drop)
+	probability("duplicate", &p.Duplicate)
+	probability("corrupt", &p.Corrupt)
+	probability("stall", &p.Stall)
+	if err != nil {
+		return p, fmt.Errorf("invalid fault policy: %s", err)
+	}
+	if s := query.Get("types"); s != "" {
+		for _, name := range strings.Split(s, ",") {
+			typ, ok := messageTypes[name]
+			if !ok {
+				return p, fmt.Errorf("unknown message type: %s", name)
+			}
+			p.Types = append(p.Types, typ)
+		}
+	}
+	return p, nil
+}
+
+// dialFaulty connects the address following faulty:// and injects the
+// faults described in the query of the address. For example:
+// faulty://tcp://localhost:9559?latency=10ms&drop=0.1&types=call.
+func dialFaulty(addr string) (EndPoint, error) {
+	u, err := url.Parse(strings.TrimPrefix(addr, "faulty://"))
+	if err != nil {
+		return nil, fmt.Errorf("dial: invalid address: %s", err)
+	}
+	query := u.Query()
+	policy, err := parseFaultPolicy(query)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range faultParams {
+		query.Del(name)
+	}
+	u.RawQuery = query.Encode()
+	inner, err := DialEndPoint(u.String())
+	if err != nil {
+		return nil, err
+	}
+	return FaultyEndPoint(inner, policy), nil
+}
@@ -0,0 +1,227 @@
+package net_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/lugu/qiloop/bus/net"
+	"github.com/lugu/qiloop/bus/util"
+)
+
+// faultyPipe returns a faulty endpoint connected to an endpoint and
+// a channel receiving the messages of the latter.
+func faultyPipe(policy net.FaultPolicy) (net.EndPoint, net.EndPoint,
+	chan *net.Message, chan error) {
+	a, b := net.Pipe()
+	messages := make(chan *net.Message, 100)
+	return net.FaultyEndPoint(a, policy), b, messages, closed(b, messages)
+}
+
+// receiveAll returns the messages received until no message arrives
+// during the timeout.
+func receiveAll(messages chan *net.Message, timeout time.Duration) []*net.Message {
+	var received []*net.Message
+	for {
+		select {
+		case msg := <-messages:
+			received = append(received, msg)
+		case <-time.After(timeout):
+			return received
+		}
+	}
+}
+
+func sendN(t *testing.T, e net.EndPoint, typ uint8, n int) {
+	for i := 0; i < n; i++ {
+		hdr := net.NewHeader(typ, 1, 2, 3, uint32(i))
+		if err := e.Send(net.NewMessage(hdr, []byte("payload"))); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFaultyDrop(t *testing.T) {
+	policy := net.FaultPolicy{
+		Seed:  1,
+		Drop:  1,
+		Types: []uint8{net.Call},
+	}
+	f, b, messages, _ := faultyPipe(policy)
+	defer f.Close()
+	defer b.Close()
+	sendN(t, f, net.Call, 10)
+	sendN(t, f, net.Post, 10)
+	received := receiveAll(messages, 100*time.Millisecond)
+	if len(received) != 10 {
+		t.Fatalf("unexpected messages: %d", len(received))
+	}
+	for _, msg := range received {
+		if msg.Header.Type != net.Post {
+			t.Errorf("unexpected message: %v", msg.Header)
+		}
+	}
+}
+
+func TestFaultyReproducible(t *testing.T) {
+	policy := net.FaultPolicy{
+		Seed:      42,
+		Drop:      0.3,
+		Duplicate: 0.3,
+	}
+	ids := func() []uint32 {
+		f, b, messages, _ := faultyPipe(policy)
+		defer f.Close()
+		defer b.Close()
+		sendN(t, f, net.Post, 50)
+		var ids []uint32
+		for _, msg := range receiveAll(messages, 100*time.Millisecond) {
+			ids = append(ids, msg.Header.ID)
+		}
+		return ids
+	}
+	first, second := ids(), ids()
+	if len(first) == 50 {
+		t.Errorf("no fault injected")
+	}
+	if len(first) != len(second) {
+		t.Fatalf("different faults: %v, %v", first, second)
+	}
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("different faults: %v, %v", first, second)
+		}
+		if i > 0 && first[i] < first[i-1] {
+			t.Errorf("order not preserved: %v", first)
+		}
+	}
+}
+
+func TestFaultyCorrupt(t *testing.T) {
+	policy := net.FaultPolicy{
+		Seed:    1,
+		Corrupt: 0.5,
+	}
+	f, b, messages, _ := faultyPipe(policy)
+	defer f.Close()
+	defer b.Close()
+	payload := []byte("some payload which will be corrupted")
+	original := append([]byte(nil), payload...)
+	hdr := net.NewHeader(net.Post, 1, 2, 3, 4)
+	if err := f.Send(net.NewMessage(hdr, payload)); err != nil {
+		t.Fatal(err)
+	}
+	msg := <-messages
+	if bytes.Equal(msg.Payload, original) {
+		t.Errorf("payload not corrupted")
+	}
+	if !bytes.Equal(payload, original) {
+		t.Errorf("sender payload modified")
+	}
+}
+
+func TestFaultyLatency(t *testing.T) {
+	policy := net.FaultPolicy{
+		Latency: 50 * time.Millisecond,
+		Jitter:  20 * time.Millisecond,
+	}
+	f, b, messages, _ := faultyPipe(policy)
+	defer f.Close()
+	defer b.Close()
+	start := time.Now()
+	sendN(t, f, net.Post, 5)
+	if time.Since(start) > policy.Latency {
+		t.Errorf("send shall not block")
+	}
+	for i := 0; i < 5; i++ {
+		msg := <-messages
+		if msg.Header.ID != uint32(i) {
+			t.Errorf("unexpected message: %v", msg.Header)
+		}
+	}
+	if time.Since(start) < policy.Latency {
+		t.Errorf("latency not applied")
+	}
+
+	// the incoming messages are delayed too.
+	received := make(chan *net.Message, 1)
+	closed(f, received)
+	start = time.Now()
+	sendN(t, b, net.Post, 1)
+	<-received
+	if time.Since(start) < policy.Latency {
+		t.Errorf("latency not applied")
+	}
+}
+
+func TestFaultyCloseAfter(t *testing.T) {
+	policy := net.FaultPolicy{
+		CloseAfter: 3,
+	}
+	f, b, messages, closedB := faultyPipe(policy)
+	defer b.Close()
+	received := make(chan *net.Message, 10)
+	closedF := closed(f, received)
+	sendN(t, f, net.Post, 2)
+	sendN(t, b, net.Post, 1)
+	if err := <-closedF; err != net.ErrFaultInjected {
+		t.Errorf("unexpected error: %v", err)
+	}
+	<-closedB
+	if len(messages) != 2 || len(received) != 1 {
+		t.Errorf("unexpected messages: %d, %d", len(messages),
+			len(received))
+	}
+	hdr := net.NewHeader(net.Post, 1, 2, 3, 4)
+	if err := f.Send(net.NewMessage(hdr, nil)); err == nil {
+		t.Errorf("expecting an error")
+	}
+}
+
+func TestFaultyStall(t *testing.T) {
+	policy := net.FaultPolicy{
+		Stall:         1,
+		StallDuration: 50 * time.Millisecond,
+	}
+	f, b, _, _ := faultyPipe(policy)
+	defer f.Close()
+	defer b.Close()
+	received := make(chan *net.Message, 10)
+	closed(f, received)
+	start := time.Now()
+	sendN(t, b, net.Post, 2)
+	<-received
+	<-received
+	if time.Since(start) < 2*policy.StallDuration {
+		t.Errorf("reads not stalled")
+	}
+}
+
+func TestFaultyScheme(t *testing.T) {
+	addr := util.NewUnixAddr()
+	listener, err := net.Listen(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	peers := make(chan net.EndPoint, 1)
+	go echo(listener, peers)
+
+	_, err = net.DialEndPoint("faulty://" + addr + "?drop=2")
+	if err == nil {
+		t.Errorf("invalid probability accepted")
+	}
+	_, err = net.DialEndPoint("faulty://" + addr + "?types=unknown")
+	if err == nil {
+		t.Errorf("invalid type accepted")
+	}
+	e, err := net.DialEndPoint("faulty://" + addr +
+		"?seed=1&latency=10ms&jitter=5ms&duplicate=1&types=reply")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	if !ping(e) {
+		t.Errorf("ping failed")
+	}
+}
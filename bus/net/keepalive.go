@@ -0,0 +1,118 @@
+package net
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// ErrPeerUnresponsive is given to the Closer callbacks when the
+// endpoint is closed because nothing has been received during the
+// keep alive timeout.
+var ErrPeerUnresponsive = errors.New("peer unresponsive")
+
+// TCPKeepAlive is the period of the TCP keep alive probes of the TCP
+// and TLS connections. A negative value disables TCP keep alive.
+var TCPKeepAlive = 15 * time.Second
+
+// heartbeatAction is the action of the heartbeat calls sent to
+// ServiceZero when the peer does not support the Ping messages. It
+// does not exist: the peer replies with an error message.
+const heartbeatAction = ^uint32(0)
+
+// KeepAlive describes how an endpoint detects an unresponsive peer.
+type KeepAlive struct {
+	// Interval is the idle duration after which a heartbeat is sent.
+	// Zero disables the heartbeats.
+	Interval time.Duration
+	// Timeout is the idle duration after which the endpoint is
+	// closed with ErrPeerUnresponsive. Zero disables the timeout.
+	Timeout time.Duration
+	// Ping sends Ping messages as heartbeats. It requires the peer
+	// to support them (see the capability QiloopHeartbeat). Otherwise
+	// a call to an unknown action of ServiceZero is used.
+	Ping bool
+}
+
+// DefaultKeepAlive is used by the clients and the servers once the
+// connection is authenticated if both peers support the Ping messages.
+var DefaultKeepAlive = KeepAlive{
+	Interval: 10 * time.Second,
+	Timeout:  30 * time.Second,
+}
+
+// SetKeepAlive starts monitoring the activity of the endpoint: a
+// heartbeat is sent when the connection is idle and the endpoint is
+// closed if the peer does not respond. It can only be set once.
+func SetKeepAlive(e EndPoint, k KeepAlive) error {
+	end, ok := unwrap(e).(*endPoint)
+	if !ok {
+		return fmt.Errorf("keep alive not supported by %s", e)
+	}
+	if k.Interval < 0 || k.Timeout < 0 {
+		return fmt.Errorf("invalid keep alive: %v", k)
+	}
+	if k.Interval == 0 && k.Timeout == 0 {
+		return nil
+	}
+	if !atomic.CompareAndSwapInt32(&end.keepAlive, 0, 1) {
+		return fmt.Errorf("keep alive already set")
+	}
+	go end.monitor(k)
+	return nil
+}
+
+// heartbeat returns the message sent to check the peer.
+func heartbeat(ping bool) Message {
+	if ping {
+		return NewMessage(NewHeader(Ping, 0, 0, 0, 0), nil)
+	}
+	return NewMessage(NewHeader(Call, 0, 0, heartbeatAction, 0), nil)
+}
+
+// isHeartbeat returns true if the message is a heartbeat or a response
+// to a heartbeat. Those messages are not dispatched.
+func isHeartbeat(hdr *Header) bool {
+	switch hdr.Type {
+	case Ping, Pong:
+		return true
+	case Reply, Error:
+		return hdr.Service == 0 && hdr.Object == 0 &&
+			hdr.Action == heartbeatAction
+	}
+	return false
+}
+
+// idle returns the duration since the last message was received.
+func (e *endPoint) idle() time.Duration {
+	last := atomic.LoadInt64(&e.lastReceived)
+	return time.Since(time.Unix(0, last))
+}
+
+// monitor sends the heartbeats and closes the endpoint after the
+// timeout.
+func (e *endPoint) monitor(k KeepAlive) {
+	period := k.Interval
+	if period == 0 || (k.Timeout != 0 && k.Timeout/2 < period) {
+		period = k.Timeout / 2
+	}
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.closed:
+			return
+		case <-ticker.C:
+		}
+		idle := e.idle()
+		if k.Timeout != 0 && idle >= k.Timeout {
+			e.closeWith(ErrPeerUnresponsive)
+			return
+		}
+		if k.Interval != 0 && idle >= k.Interval {
+			// a failure is detected by the timeout.
+			e.Send(heartbeat(k.Ping))
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package net_test
+
+import (
+	"io"
+	"io/ioutil"
+	gonet "net"
+	"testing"
+	"time"
+
+	"github.com/lugu/qiloop/bus/net"
+)
+
+// closed returns a channel receiving the error of the endpoint
+// closure. Any other message is sent to messages.
+func closed(e net.EndPoint, messages chan *net.Message) chan error {
+	errors := make(chan error, 1)
+	filter := func(hdr *net.Header) (bool, bool) {
+		return true, true
+	}
+	consumer := func(msg *net.Message) error {
+		messages <- msg
+		return nil
+	}
+	closer := func(err error) {
+		errors <- err
+	}
+	e.AddHandler(filter, consumer, closer)
+	return errors
+}
+
+func TestKeepAlivePing(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	messages := make(chan *net.Message, 10)
+	closedA := closed(a, messages)
+	closedB := closed(b, messages)
+	err := net.SetKeepAlive(a, net.KeepAlive{
+		Interval: 10 * time.Millisecond,
+		Timeout:  50 * time.Millisecond,
+		Ping:     true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	select {
+	case err := <-closedA:
+		t.Fatalf("unexpected close: %v", err)
+	case err := <-closedB:
+		t.Fatalf("unexpected close: %v", err)
+	case msg := <-messages:
+		t.Fatalf("unexpected message: %v", msg.Header)
+	default:
+	}
+	err = net.SetKeepAlive(a, net.DefaultKeepAlive)
+	if err == nil {
+		t.Errorf("keep alive already set")
+	}
+}
+
+func TestKeepAliveServiceZero(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	messages := make(chan *net.Message, 10)
+	closedA := closed(a, messages)
+	// b replies an error to the calls.
+	b.AddHandler(func(hdr *net.Header) (bool, bool) {
+		return hdr.Type == net.Call, true
+	}, func(msg *net.Message) error {
+		hdr := msg.Header
+		hdr.Type = net.Error
+		payload := []byte{0, 0, 0, 0}
+		hdr.Size = uint32(len(payload))
+		return b.Send(net.NewMessage(hdr, payload))
+	}, func(err error) {})
+	err := net.SetKeepAlive(a, net.KeepAlive{
+		Interval: 10 * time.Millisecond,
+		Timeout:  50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	select {
+	case err := <-closedA:
+		t.Fatalf("unexpected close: %v", err)
+	case msg := <-messages:
+		t.Fatalf("unexpected message: %v", msg.Header)
+	default:
+	}
+}
+
+func TestKeepAliveTimeout(t *testing.T) {
+	conn, peer := gonet.Pipe()
+	defer peer.Close()
+	// the peer reads the heartbeats and never replies.
+	go io.Copy(ioutil.Discard, peer)
+
+	endpoint := net.ConnEndPoint(conn)
+	closed := closed(endpoint, make(chan *net.Message, 10))
+	err := net.SetKeepAlive(endpoint, net.KeepAlive{
+		Interval: 10 * time.Millisecond,
+		Timeout:  50 * time.Millisecond,
+		Ping:     true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-closed:
+		if err != net.ErrPeerUnresponsive {
+			t.Errorf("unexpected error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("unresponsive peer not detected")
+	}
+}
@@ -1,17 +1,15 @@
 package net
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
-	"log"
 	gonet "net"
 	"net/url"
 	"os"
 	"strings"
 
 	"github.com/ftrvxmtrx/fd"
-
-	"github.com/lugu/qiloop/bus/net/cert"
 )
 
 type connListener struct {
@@ -30,36 +28,30 @@ func (c connListener) Close() error {
 	return c.l.Close()
 }
 
+// listenKeepAlive listens at addr with the TCP keep alive period.
+func listenKeepAlive(addr string) (gonet.Listener, error) {
+	conf := gonet.ListenConfig{KeepAlive: TCPKeepAlive}
+	return conf.Listen(context.Background(), "tcp", addr)
+}
+
 func listenTCP(addr string) (Listener, error) {
-	conn, err := gonet.Listen("tcp", addr)
+	conn, err := listenKeepAlive(addr)
 	if err != nil {
 		return nil, err
 	}
 	return connListener{conn}, nil
 }
 
-func listenTLS(addr string) (Listener, error) {
-	var err1, err2 error
-	cer, err1 := cert.Certificate()
-	if err1 != nil {
-		log.Printf("Failed to read x509 certificate: %s", err1)
-		cer, err2 = cert.GenerateCertificate()
-		if err2 != nil {
-			log.Printf("Failed to create x509 certificate: %s", err2)
-			return nil, fmt.Errorf("no certificate available (%s, %s)",
-				err1, err2)
-		}
-	}
-
-	conf := &tls.Config{
-		Certificates: []tls.Certificate{cer},
+func listenTLS(u *url.URL) (Listener, error) {
+	conf, err := listenConfig(u)
+	if err != nil {
+		return nil, err
 	}
-
-	conn, err := tls.Listen("tcp", addr, conf)
+	conn, err := listenKeepAlive(u.Host)
 	if err != nil {
 		return nil, err
 	}
-	return connListener{conn}, nil
+	return connListener{tls.NewListener(conn, conf)}, nil
 }
 
 func listenUNIX(name string) (Listener, error) {
@@ -121,7 +113,10 @@ type Listener interface {
 }
 
 // Listen reads the transport of addr and listen at the address. addr
-// can be of the form: unix://, tcp:// or tcps://.
+// can be of the form: unix://, tcp://, tcps:// or fd://. The fd://
+// scheme serves a single inherited socket (see StartChild). TLS
+// transports (tcps:// and quic://) accept options in the query of the
+// URL such as ca=<file> to require client certificates.
 func Listen(addr string) (Listener, error) {
 	u, err := url.Parse(addr)
 	if err != nil {
@@ -131,13 +126,15 @@ func Listen(addr string) (Listener, error) {
 	case "tcp":
 		return listenTCP(u.Host)
 	case "tcps":
-		return listenTLS(u.Host)
+		return listenTLS(u)
 	case "quic":
-		return listenQUIC(u.Host)
+		return listenQUIC(u)
 	case "unix":
 		return listenUNIX(strings.TrimPrefix(addr, "unix://"))
 	case "pipe":
 		return listenPipe(strings.TrimPrefix(addr, "pipe://"))
+	case "fd":
+		return listenFD(u)
 	default:
 		return nil, fmt.Errorf("unknown URL scheme: %s", addr)
 	}
@@ -1,10 +1,11 @@
 package net
 
 import (
-	"bytes"
+	"bufio"
 	"encoding/binary"
 	"fmt"
 	"io"
+	"sync"
 
 	"github.com/lugu/qiloop/type/basic"
 )
@@ -31,6 +32,13 @@ const (
 	Cancelled
 )
 
+// Reserved message types used as heartbeats between peers supporting
+// them (see KeepAlive).
+const (
+	Ping uint8 = 0x80 + iota
+	Pong
+)
+
 // HeaderSize is the size of a message header. It is the
 // minimum size of a message.
 const HeaderSize = 28
@@ -128,7 +136,8 @@ func (h *Header) Read(r io.Reader) (err error) {
 	}
 	if h.Type, err = basic.ReadUint8(r); err != nil {
 		return fmt.Errorf("read message type: %s", err)
-	} else if h.Type == Unknown || h.Type > Cancelled {
+	} else if h.Type == Unknown || (h.Type > Cancelled &&
+		h.Type != Ping && h.Type != Pong) {
 		return fmt.Errorf("invalid message type: %d", h.Type)
 	}
 	if h.Flags, err = basic.ReadUint8(r); err != nil {
@@ -168,6 +177,10 @@ func (h Header) String() string {
 		typ = "cancel"
 	case Cancelled:
 		typ = "cancelled"
+	case Ping:
+		typ = "ping"
+	case Pong:
+		typ = "pong"
 	}
 	return fmt.Sprintf("[Type: %s, ID: %d, Service: %d, Object: %d, Action: %d, Size: %d]",
 		typ, h.ID, h.Service, h.Object, h.Action, h.Size)
@@ -179,6 +192,66 @@ type Message struct {
 	Payload []byte
 }
 
+// encode writes the header into b which must be at least HeaderSize
+// long.
+func (h *Header) encode(b []byte) {
+	binary.BigEndian.PutUint32(b[0:], h.Magic)
+	binary.LittleEndian.PutUint32(b[4:], h.ID)
+	binary.LittleEndian.PutUint32(b[8:], h.Size)
+	binary.LittleEndian.PutUint16(b[12:], h.Version)
+	b[14] = h.Type
+	b[15] = h.Flags
+	binary.LittleEndian.PutUint32(b[16:], h.Service)
+	binary.LittleEndian.PutUint32(b[20:], h.Object)
+	binary.LittleEndian.PutUint32(b[24:], h.Action)
+}
+
+// decode parses the header from b which must be at least HeaderSize
+// long. It performs the same verifications as Read.
+func (h *Header) decode(b []byte) error {
+	h.Magic = binary.BigEndian.Uint32(b[0:])
+	if h.Magic != Magic {
+		return fmt.Errorf("invalid message magic: %x", h.Magic)
+	}
+	h.ID = binary.LittleEndian.Uint32(b[4:])
+	h.Size = binary.LittleEndian.Uint32(b[8:])
+	h.Version = binary.LittleEndian.Uint16(b[12:])
+	if h.Version != Version {
+		return fmt.Errorf("invalid message version: %d", h.Version)
+	}
+	h.Type = b[14]
+	if h.Type == Unknown || (h.Type > Cancelled &&
+		h.Type != Ping && h.Type != Pong) {
+		return fmt.Errorf("invalid message type: %d", h.Type)
+	}
+	h.Flags = b[15]
+	h.Service = binary.LittleEndian.Uint32(b[16:])
+	h.Object = binary.LittleEndian.Uint32(b[20:])
+	h.Action = binary.LittleEndian.Uint32(b[24:])
+	return nil
+}
+
+// maxPooledBuffer is the size of the largest buffer kept in
+// writeBuffers.
+const maxPooledBuffer = 64 * 1024
+
+// writeBuffers contains the buffers used by Message.Write to pack the
+// header and the payload.
+var writeBuffers = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, 0, 512)
+		return &buf
+	},
+}
+
+// headerBuffers contains the buffers used by Message.Read to read the
+// header.
+var headerBuffers = sync.Pool{
+	New: func() interface{} {
+		return new([HeaderSize]byte)
+	},
+}
+
 // Write marshal a message into an io.Writer. The header and the
 // payload are written in a single write operation. Forwards io.EOF if
 // nothing was written.
@@ -190,27 +263,49 @@ func (m *Message) Write(w io.Writer) error {
 	}
 
 	// Pack header and payload in a buffer and then it to the network.
-	buf := bytes.NewBuffer(make([]byte, 0, HeaderSize+m.Header.Size))
+	size := int(HeaderSize + m.Header.Size)
+	pooled := writeBuffers.Get().(*[]byte)
+	buf := *pooled
+	if cap(buf) < size {
+		buf = make([]byte, size)
+	}
+	buf = buf[:size]
+	m.Header.encode(buf)
+	copy(buf[HeaderSize:], m.Payload)
 
-	if err := m.Header.Write(buf); err != nil {
-		return fmt.Errorf("serialize header: %s", err)
+	err := basic.WriteN(w, buf, size)
+	if cap(buf) <= maxPooledBuffer {
+		*pooled = buf[:0]
+		writeBuffers.Put(pooled)
 	}
+	return m.writeError(err)
+}
 
-	if err := basic.WriteN(buf, m.Payload, int(m.Header.Size)); err != nil {
-		return fmt.Errorf("write payload: %s", err)
+// writeError adds the context to a write error.
+func (m *Message) writeError(err error) error {
+	if err == nil || err == io.EOF {
+		return err
+	}
+	if m.Header.Type == Error {
+		err = fmt.Errorf("%v: %v", readError(m), err)
 	}
+	return fmt.Errorf("write message %v: %s", m.Header, err)
+}
 
-	err := basic.WriteN(w, buf.Bytes(), int(m.Header.Size+HeaderSize))
-	if err != nil {
-		if err == io.EOF {
-			return err
-		}
-		if m.Header.Type == Error {
-			err = fmt.Errorf("%v: %v", readError(m), err)
-		}
-		return fmt.Errorf("write message %v: %s", m.Header, err)
+// writeTo writes the header and the payload to a buffered writer
+// without copying the payload.
+func (m *Message) writeTo(w *bufio.Writer) error {
+	if uint32(len(m.Payload)) != m.Header.Size {
+		return fmt.Errorf("invalid message size: %d instead of %d",
+			len(m.Payload), m.Header.Size)
 	}
-	return nil
+	var hdr [HeaderSize]byte
+	m.Header.encode(hdr[:])
+	_, err := w.Write(hdr[:])
+	if err == nil {
+		_, err = w.Write(m.Payload)
+	}
+	return m.writeError(err)
 }
 
 // Read unmarshal a message from io.Reader. First the header is read,
@@ -218,9 +313,21 @@ func (m *Message) Write(w io.Writer) error {
 // if the header is not considerred well formatted. Forwards io.EOF if
 // nothing was read.
 func (m *Message) Read(r io.Reader) error {
+	b := headerBuffers.Get().(*[HeaderSize]byte)
+	defer headerBuffers.Put(b)
+	return m.read(r, b[:], allocPayload)
+}
+
+// allocPayload allocates a new payload.
+func allocPayload(size int) []byte {
+	return make([]byte, size)
+}
+
+// read unmarshal a message using b to read the header and alloc to
+// create the payload.
+func (m *Message) read(r io.Reader, b []byte, alloc func(int) []byte) error {
 
 	// Read the complete header, then parse the fields.
-	b := make([]byte, HeaderSize)
 	if err := basic.ReadN(r, b, HeaderSize); err != nil {
 		if err == io.EOF {
 			return err
@@ -228,7 +335,7 @@ func (m *Message) Read(r io.Reader) error {
 		return fmt.Errorf("read header: %s", err)
 	}
 
-	if err := m.Header.Read(bytes.NewBuffer(b)); err != nil {
+	if err := m.Header.decode(b); err != nil {
 		return fmt.Errorf("read message header: %s", err)
 	}
 	if m.Header.Size > MaxPayloadSize {
@@ -237,7 +344,7 @@ func (m *Message) Read(r io.Reader) error {
 		m.Payload = make([]byte, 0)
 		return nil
 	}
-	m.Payload = make([]byte, m.Header.Size)
+	m.Payload = alloc(int(m.Header.Size))
 	err := basic.ReadN(r, m.Payload, int(m.Header.Size))
 	if err != nil {
 		return fmt.Errorf("read payload %s", err)
@@ -245,6 +352,33 @@ func (m *Message) Read(r io.Reader) error {
 	return nil
 }
 
+// chunkSize is the size of the memory chunks shared by the payloads
+// of the small messages.
+const chunkSize = 32 * 1024
+
+// payloadPool allocates the payloads of the incoming messages of an
+// endpoint. Small payloads are carved out of a shared chunk: this
+// saves an allocation per message. A chunk is released by the garbage
+// collector once all its messages are released. It is not safe for
+// concurrent use.
+type payloadPool struct {
+	chunk []byte
+}
+
+func (p *payloadPool) alloc(size int) []byte {
+	if size > chunkSize/8 {
+		return make([]byte, size)
+	}
+	if len(p.chunk) < size {
+		p.chunk = make([]byte, chunkSize)
+	}
+	// limit the capacity so appending to a payload does not
+	// overwrite the next one.
+	payload := p.chunk[:size:size]
+	p.chunk = p.chunk[size:]
+	return payload
+}
+
 // NewMessage assemble an header and a payload to create a message.
 // The size filed of the header is adjusted if necessary.
 func NewMessage(header Header, payload []byte) Message {
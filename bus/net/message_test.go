@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/lugu/qiloop/bus/net"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"testing"
@@ -192,3 +193,30 @@ func TestReadHeaderError(t *testing.T) {
 		panic(err)
 	}
 }
+
+func BenchmarkMessageWrite(b *testing.B) {
+	msg := net.NewMessage(net.NewHeader(net.Call, 1, 2, 3, 4),
+		make([]byte, 128))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := msg.Write(ioutil.Discard); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMessageRead(b *testing.B) {
+	msg := net.NewMessage(net.NewHeader(net.Call, 1, 2, 3, 4),
+		make([]byte, 128))
+	var buf bytes.Buffer
+	msg.Write(&buf)
+	data := buf.Bytes()
+	r := bytes.NewReader(data)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		r.Reset(data)
+		if err := msg.Read(r); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
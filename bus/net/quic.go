@@ -0,0 +1,122 @@
+package net
+
+import (
+	"context"
+	"net/url"
+	"sync"
+
+	quic "github.com/lucas-clemente/quic-go"
+)
+
+// quicConfig is used by the clients and the servers. Keep alive
+// packets maintain the NAT bindings of idle sessions.
+var quicConfig = &quic.Config{
+	KeepAlive: true,
+}
+
+// quicSession is a client QUIC session shared by the endpoints
+// dialing the same address. Each endpoint uses its own stream.
+type quicSession struct {
+	key     string
+	session quic.Session
+	streams int
+}
+
+// alive returns false once the session is closed (for example after
+// an idle timeout).
+func (s *quicSession) alive() bool {
+	select {
+	case <-s.session.Context().Done():
+		return false
+	default:
+		return true
+	}
+}
+
+// quicCache contains the client QUIC sessions indexed by address
+// (including the TLS options). The session is closed when its last
+// stream is closed. A closed session is replaced by a new one on the
+// next dial.
+type quicCache struct {
+	sessions map[string]*quicSession
+	mutex    sync.Mutex
+}
+
+var quicSessions = &quicCache{
+	sessions: make(map[string]*quicSession),
+}
+
+// acquire returns the session associated with u. A new session is
+// created if needed. The session must be released.
+func (c *quicCache) acquire(u *url.URL) (*quicSession, error) {
+	key := u.String()
+	c.mutex.Lock()
+	s, ok := c.sessions[key]
+	if ok && s.alive() {
+		s.streams++
+		c.mutex.Unlock()
+		return s, nil
+	}
+	c.mutex.Unlock()
+
+	conf, err := dialConfig(u)
+	if err != nil {
+		return nil, err
+	}
+	conf.NextProtos = []string{"qi-messaging"}
+	session, err := quic.DialAddr(u.Host, conf, quicConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	// another session might have been created in the mean time.
+	s, ok = c.sessions[key]
+	if ok && s.alive() {
+		session.Close()
+		s.streams++
+		return s, nil
+	}
+	s = &quicSession{
+		key:     key,
+		session: session,
+		streams: 1,
+	}
+	c.sessions[key] = s
+	return s, nil
+}
+
+// release closes the session when it is not used anymore.
+func (c *quicCache) release(s *quicSession) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	s.streams--
+	if s.streams > 0 {
+		return
+	}
+	if c.sessions[s.key] == s {
+		delete(c.sessions, s.key)
+	}
+	s.session.Close()
+}
+
+// dialQUIC opens a new stream on the QUIC session associated with the
+// URL.
+func dialQUIC(u *url.URL) (EndPoint, error) {
+	s, err := quicSessions.acquire(u)
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.WithValue(context.TODO(), DialAddress, u.Host)
+	stream, err := s.session.OpenStreamSync(ctx)
+	if err != nil {
+		quicSessions.release(s)
+		return nil, err
+	}
+	qs := newQuicStream(s.session, stream)
+	qs.release = func() {
+		quicSessions.release(s)
+	}
+	return NewEndPoint(qs), nil
+}
@@ -0,0 +1,74 @@
+package net_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/lugu/qiloop/bus/net"
+)
+
+// remoteAddr returns the address of the QUIC session of an endpoint.
+func remoteAddr(e net.EndPoint) string {
+	return strings.SplitN(e.String(), "#", 2)[0]
+}
+
+func TestQUICMultiplex(t *testing.T) {
+	addr := "quic://localhost:54351"
+	listener, err := net.Listen(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	peers := make(chan net.EndPoint, 100)
+	go echo(listener, peers)
+
+	const count = 5
+	endpoints := make([]net.EndPoint, count)
+	for i := range endpoints {
+		endpoints[i], err = net.DialEndPoint(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ping(endpoints[i]) {
+			t.Fatalf("endpoint %d: no reply", i)
+		}
+	}
+	// all the streams belong to the same session.
+	session := ""
+	streams := make(map[string]bool)
+	for i := 0; i < count; i++ {
+		peer := <-peers
+		if session == "" {
+			session = remoteAddr(peer)
+		} else if remoteAddr(peer) != session {
+			t.Errorf("different sessions: %s and %s", session, peer)
+		}
+		streams[peer.String()] = true
+	}
+	if len(streams) != count {
+		t.Errorf("expecting %d streams, got %d", count, len(streams))
+	}
+
+	// the other streams are not affected by a closed endpoint.
+	endpoints[0].Close()
+	if !ping(endpoints[1]) {
+		t.Fatal("no reply after close")
+	}
+	<-peers
+	for _, e := range endpoints[1:] {
+		e.Close()
+	}
+
+	// the session is closed with its last stream.
+	endpoint, err := net.DialEndPoint(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer endpoint.Close()
+	if !ping(endpoint) {
+		t.Fatal("no reply")
+	}
+	if peer := <-peers; remoteAddr(peer) == session {
+		t.Errorf("session not closed: %s", peer)
+	}
+}
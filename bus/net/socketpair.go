@@ -0,0 +1,176 @@
+package net
+
+import (
+	"fmt"
+	"io"
+	gonet "net"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// ChildAddress is the environment variable set by StartChild. It
+// contains the address of the file descriptor inherited by the child
+// process (for example fd://3).
+const ChildAddress = "QILOOP_CHILD_ADDRESS"
+
+// fdStream is a connection backed by a file descriptor. Unlike the
+// connections of a listener, it has no remote address.
+type fdStream struct {
+	connStream
+	name string
+}
+
+func (s fdStream) String() string {
+	return s.name
+}
+
+// fileStream returns a stream using a copy of the socket file. The
+// file can be closed.
+func fileStream(file *os.File, name string) (Stream, error) {
+	conn, err := gonet.FileConn(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err)
+	}
+	return fdStream{
+		connStream: ConnStream(conn).(connStream),
+		name:       name,
+	}, nil
+}
+
+// socketPair returns two connected socket files.
+func socketPair() (*os.File, *os.File, error) {
+	fds, err := unix.Socketpair(unix.AF_UNIX,
+		unix.SOCK_STREAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("socketpair: %s", err)
+	}
+	return os.NewFile(uintptr(fds[0]), "socketpair"),
+		os.NewFile(uintptr(fds[1]), "socketpair"), nil
+}
+
+// SocketPair returns two connected endpoints. It allows two parts of
+// the same process to communicate without a socket file or a port.
+func SocketPair() (EndPoint, EndPoint, error) {
+	a, b, err := socketPair()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer a.Close()
+	defer b.Close()
+	streamA, err := fileStream(a, "socketpair://0")
+	if err != nil {
+		return nil, nil, err
+	}
+	streamB, err := fileStream(b, "socketpair://1")
+	if err != nil {
+		streamA.Close()
+		return nil, nil, err
+	}
+	return NewEndPoint(streamA), NewEndPoint(streamB), nil
+}
+
+// StartChild starts cmd with one side of a socket pair inherited as
+// a file descriptor and returns an endpoint connected to the other
+// side. The address of the file descriptor (such as fd://3) is given
+// to the child process with the environment variable ChildAddress:
+// the child can either dial it with DialEndPoint or serve it with
+// Listen.
+func StartChild(cmd *exec.Cmd) (EndPoint, error) {
+	parent, child, err := socketPair()
+	if err != nil {
+		return nil, err
+	}
+	defer parent.Close()
+	defer child.Close()
+	stream, err := fileStream(parent, "socketpair://"+cmd.Path)
+	if err != nil {
+		return nil, err
+	}
+	// the extra files of the child start at the descriptor 3.
+	addr := fmt.Sprintf("fd://%d", 3+len(cmd.ExtraFiles))
+	cmd.ExtraFiles = append(cmd.ExtraFiles, child)
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, ChildAddress+"="+addr)
+	if err := cmd.Start(); err != nil {
+		stream.Close()
+		return nil, fmt.Errorf("start %s: %s", cmd.Path, err)
+	}
+	return NewEndPoint(stream), nil
+}
+
+// fdFile returns the file descriptor of an fd:// URL.
+func fdFile(u *url.URL) (*os.File, error) {
+	fd, err := strconv.Atoi(u.Host)
+	if err != nil || fd < 0 {
+		return nil, fmt.Errorf("invalid file descriptor: %s", u.String())
+	}
+	return os.NewFile(uintptr(fd), u.String()), nil
+}
+
+// dialFD connects an inherited socket.
+func dialFD(u *url.URL) (EndPoint, error) {
+	file, err := fdFile(u)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	stream, err := fileStream(file, u.String())
+	if err != nil {
+		return nil, err
+	}
+	return NewEndPoint(stream), nil
+}
+
+// fdListener accepts the inherited socket once. The following calls
+// to Accept block until the listener is closed.
+type fdListener struct {
+	stream chan Stream
+	closer chan struct{}
+	once   sync.Once
+}
+
+func (l *fdListener) Accept() (Stream, error) {
+	select {
+	case stream := <-l.stream:
+		return stream, nil
+	case <-l.closer:
+		return nil, io.EOF
+	}
+}
+
+func (l *fdListener) Close() error {
+	l.once.Do(func() {
+		close(l.closer)
+		select {
+		case stream := <-l.stream:
+			stream.Close()
+		default:
+		}
+	})
+	return nil
+}
+
+func listenFD(u *url.URL) (Listener, error) {
+	file, err := fdFile(u)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	stream, err := fileStream(file, u.String())
+	if err != nil {
+		return nil, err
+	}
+	l := &fdListener{
+		stream: make(chan Stream, 1),
+		closer: make(chan struct{}),
+	}
+	l.stream <- stream
+	return l, nil
+}
@@ -0,0 +1,80 @@
+package net_test
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/lugu/qiloop/bus/net"
+)
+
+func TestSocketPair(t *testing.T) {
+	a, b, err := net.SocketPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	defer b.Close()
+	response, err := b.ReceiveAny()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := net.NewMessage(net.NewHeader(net.Call, 1, 2, 3, 4), []byte{1, 2})
+	if err := a.Send(msg); err != nil {
+		t.Fatal(err)
+	}
+	received := <-response
+	if received.Header != msg.Header || len(received.Payload) != 2 {
+		t.Errorf("unexpected message: %v", received)
+	}
+	a.Close()
+	if _, ok := <-response; ok {
+		t.Errorf("expecting a closed channel")
+	}
+}
+
+// TestChildHelper is the child process started by TestStartChild.
+func TestChildHelper(t *testing.T) {
+	addr := os.Getenv(net.ChildAddress)
+	if addr == "" {
+		t.Skip("not a child process")
+	}
+	listener, err := net.Listen(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peers := make(chan net.EndPoint, 100)
+	go echo(listener, peers)
+	// exit when the parent closes the connection.
+	e := <-peers
+	closed := make(chan struct{})
+	e.AddHandler(func(hdr *net.Header) (bool, bool) {
+		return false, true
+	}, func(msg *net.Message) error {
+		return nil
+	}, func(err error) {
+		close(closed)
+	})
+	<-closed
+	listener.Close()
+}
+
+func TestStartChild(t *testing.T) {
+	cmd := exec.Command(os.Args[0], "-test.run=TestChildHelper")
+	endpoint, err := net.StartChild(cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ping(endpoint) {
+		t.Error("no reply")
+	}
+	endpoint.Close()
+	if err := cmd.Wait(); err != nil {
+		t.Error(err)
+	}
+
+	_, err = net.DialEndPoint("fd://invalid")
+	if err == nil {
+		t.Error("shall fail")
+	}
+}
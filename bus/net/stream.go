@@ -6,13 +6,12 @@ import (
 	"fmt"
 	"io"
 	"log"
-	"net"
 	gonet "net"
+	"net/url"
 	"os"
 	"sync"
 
 	quic "github.com/lucas-clemente/quic-go"
-	"github.com/lugu/qiloop/bus/net/cert"
 )
 
 // Stream represents a network connection. Stream abstracts
@@ -39,6 +38,14 @@ func (c connStream) Context() context.Context {
 	return c.ctx
 }
 
+func (c connStream) ConnectionState() (tls.ConnectionState, bool) {
+	conn, ok := c.Conn.(*tls.Conn)
+	if !ok {
+		return tls.ConnectionState{}, false
+	}
+	return conn.ConnectionState(), true
+}
+
 // ConnStream construct a Stream from a connection.
 func ConnStream(conn gonet.Conn) Stream {
 	return connStream{
@@ -89,19 +96,31 @@ type quicStream struct {
 	// quic.Stream does not permit to call Close while Writing.
 	// Refer to go-quic documentation.
 	sync.RWMutex
+	session quic.Session
+	// release is called once when the stream is closed.
+	release func()
+	once    sync.Once
 }
 
-func newQuicStream(s quic.Stream) Stream {
+func newQuicStream(sess quic.Session, s quic.Stream) *quicStream {
 	return &quicStream{
-		s,
-		sync.RWMutex{},
+		Stream:  s,
+		session: sess,
 	}
 }
 
+func (s *quicStream) ConnectionState() (tls.ConnectionState, bool) {
+	return s.session.ConnectionState(), true
+}
+
 func (s *quicStream) Close() error {
 	s.Lock()
-	defer s.Unlock()
-	return s.Stream.Close()
+	err := s.Stream.Close()
+	s.Unlock()
+	if s.release != nil {
+		s.once.Do(s.release)
+	}
+	return err
 }
 
 func (s *quicStream) Read(p []byte) (int, error) {
@@ -115,7 +134,7 @@ func (s *quicStream) Write(p []byte) (int, error) {
 }
 
 func (s *quicStream) String() string {
-	return fmt.Sprintf("StreamID %d", s.StreamID())
+	return fmt.Sprintf("quic://%s#%d", s.session.RemoteAddr(), s.StreamID())
 }
 
 type quicListener struct {
@@ -178,48 +197,34 @@ func (q quicListener) handleSession(ctx context.Context, sess quic.Session) {
 		for {
 			stream, err := sess.AcceptStream(ctx)
 			if err != nil {
-				if err.Error() == "NO_ERROR" {
-					continue
+				select {
+				case <-sess.Context().Done():
+					// the client has closed the session.
+				default:
+					log.Printf("Session error: %s <-> %s : %s",
+						sess.LocalAddr().String(),
+						sess.RemoteAddr().String(), err)
 				}
-				netErr := err.(net.Error)
-				if netErr.Timeout() == true {
-					continue
-				}
-				log.Printf("Session error: %s <-> %s : %#v",
-					sess.LocalAddr().String(),
-					sess.RemoteAddr().String(),
-					err)
 				close(cancel)
 				return
 			}
 			select {
 			case <-q.closer:
 				return
-			case q.streams <- newQuicStream(stream):
+			case q.streams <- newQuicStream(sess, stream):
 			}
 		}
 	}()
 }
 
-func listenQUIC(addr string) (Listener, error) {
-	var err1, err2 error
-	cer, err1 := cert.Certificate()
-	if err1 != nil {
-		log.Printf("Failed to read x509 certificate: %s", err1)
-		cer, err2 = cert.GenerateCertificate()
-		if err2 != nil {
-			log.Printf("Failed to create x509 certificate: %s", err2)
-			return nil, fmt.Errorf("no certificate available (%s, %s)",
-				err1, err2)
-		}
-	}
-
-	conf := &tls.Config{
-		Certificates: []tls.Certificate{cer},
-		NextProtos:   []string{"qi-messaging"},
+func listenQUIC(u *url.URL) (Listener, error) {
+	conf, err := listenConfig(u)
+	if err != nil {
+		return nil, err
 	}
-
-	listener, err := quic.ListenAddr(addr, conf, nil)
+	conf.NextProtos = []string{"qi-messaging"}
+	addr := u.Host
+	listener, err := quic.ListenAddr(addr, conf, quicConfig)
 	if err != nil {
 		return nil, err
 	}
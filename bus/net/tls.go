@@ -0,0 +1,330 @@
+package net
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/lugu/qiloop/bus/net/cert"
+)
+
+// The TLS transports (tcps:// and quic://) accept options in the
+// query of the URL.
+//
+// When dialing:
+//
+//	ca=<file>           verify the server certificate with the CA of the PEM file
+//	fingerprint=<hex>   accept only the certificate with this SHA-256 fingerprint
+//	tofu=true           pin the server certificate on first use (see KnownHostsFile)
+//	cert=<file>&key=<file>  present a client certificate
+//
+// Without ca, fingerprint or tofu, the server certificate is not
+// verified.
+//
+// When listening:
+//
+//	cert=<file>&key=<file>  certificate of the server (default:
+//	                        $HOME/.qi-cert.conf or a generated one)
+//	ca=<file>               require client certificates signed by
+//	                        the CA of the PEM file
+//	clientauth=optional     only verify the client certificates when
+//	                        presented
+//
+// For example: tcps://robot.local:9503?ca=/etc/qiloop/ca.pem
+
+// KnownHostsFile contains the fingerprints of the certificates pinned
+// with the tofu option. Each line contains an address (host:port)
+// followed by a fingerprint.
+var KnownHostsFile = ".qiloop-known-hosts.conf"
+
+var knownHostsMutex sync.Mutex
+
+func init() {
+	usr, err := user.Current()
+	if err == nil {
+		KnownHostsFile = filepath.Join(usr.HomeDir, KnownHostsFile)
+	}
+}
+
+// Fingerprint returns the SHA-256 fingerprint of a certificate in
+// hexadecimal.
+func Fingerprint(c *x509.Certificate) string {
+	sum := sha256.Sum256(c.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// normalizeFingerprint accepts fingerprints with colons and upper case
+// letters.
+func normalizeFingerprint(f string) string {
+	return strings.ToLower(strings.Replace(f, ":", "", -1))
+}
+
+// knownHost returns the fingerprint pinned for addr.
+func knownHost(addr string) (string, bool, error) {
+	file, err := os.Open(KnownHostsFile)
+	if os.IsNotExist(err) {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == addr {
+			return fields[1], true, nil
+		}
+	}
+	return "", false, scanner.Err()
+}
+
+// pinHost adds the fingerprint of addr to the known hosts file.
+func pinHost(addr, fingerprint string) error {
+	var flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	file, err := os.OpenFile(KnownHostsFile, flag, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = fmt.Fprintf(file, "%s %s\n", addr, fingerprint)
+	return err
+}
+
+// verifyFingerprint returns a function which verifies the server
+// certificate is pinned.
+func verifyFingerprint(addr, pinned string, tofu bool) func([][]byte, [][]*x509.Certificate) error {
+	return func(raw [][]byte, chains [][]*x509.Certificate) error {
+		if len(raw) == 0 {
+			return fmt.Errorf("missing certificate")
+		}
+		c, err := x509.ParseCertificate(raw[0])
+		if err != nil {
+			return fmt.Errorf("invalid certificate: %s", err)
+		}
+		fingerprint := Fingerprint(c)
+		if pinned != "" {
+			if fingerprint != pinned {
+				return fmt.Errorf("certificate of %s does not match "+
+					"fingerprint (got %s)", addr, fingerprint)
+			}
+			return nil
+		}
+		if !tofu {
+			return nil
+		}
+		knownHostsMutex.Lock()
+		defer knownHostsMutex.Unlock()
+		known, ok, err := knownHost(addr)
+		if err != nil {
+			return fmt.Errorf("read known hosts: %s", err)
+		}
+		if !ok {
+			log.Printf("pinning certificate of %s: %s", addr, fingerprint)
+			return pinHost(addr, fingerprint)
+		}
+		if known != fingerprint {
+			return fmt.Errorf("certificate of %s has changed (got %s, "+
+				"expecting %s)", addr, fingerprint, known)
+		}
+		return nil
+	}
+}
+
+// certPool reads the certificates of a PEM file.
+func certPool(filename string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("read CA: %s", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificate found in %s", filename)
+	}
+	return pool, nil
+}
+
+// checkOptions returns an error if the query contains an unknown
+// option.
+func checkOptions(query url.Values, known ...string) error {
+	for option := range query {
+		found := false
+		for _, k := range known {
+			if option == k {
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("unknown TLS option: %s", option)
+		}
+	}
+	return nil
+}
+
+// keyPair reads the certificate and key options.
+func keyPair(query url.Values) (*tls.Certificate, error) {
+	certFile, keyFile := query.Get("cert"), query.Get("key")
+	if certFile == "" && keyFile == "" {
+		return nil, nil
+	} else if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("cert and key options go together")
+	}
+	c, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load certificate: %s", err)
+	}
+	return &c, nil
+}
+
+// dialConfig returns the TLS configuration used to dial u.
+func dialConfig(u *url.URL) (*tls.Config, error) {
+	query := u.Query()
+	err := checkOptions(query, "ca", "fingerprint", "tofu", "cert", "key")
+	if err != nil {
+		return nil, err
+	}
+	conf := &tls.Config{
+		InsecureSkipVerify: true,
+	}
+	if ca := query.Get("ca"); ca != "" {
+		conf.RootCAs, err = certPool(ca)
+		if err != nil {
+			return nil, err
+		}
+		conf.InsecureSkipVerify = false
+		conf.ServerName = u.Hostname()
+	}
+	pinned := normalizeFingerprint(query.Get("fingerprint"))
+	tofu := query.Get("tofu") == "true"
+	if pinned != "" || tofu {
+		conf.VerifyPeerCertificate = verifyFingerprint(u.Host, pinned, tofu)
+	}
+	c, err := keyPair(query)
+	if err != nil {
+		return nil, err
+	}
+	if c != nil {
+		conf.Certificates = []tls.Certificate{*c}
+	}
+	return conf, nil
+}
+
+// listenConfig returns the TLS configuration used to listen at u.
+func listenConfig(u *url.URL) (*tls.Config, error) {
+	query := u.Query()
+	err := checkOptions(query, "ca", "clientauth", "cert", "key")
+	if err != nil {
+		return nil, err
+	}
+	c, err := keyPair(query)
+	if err != nil {
+		return nil, err
+	}
+	if c == nil {
+		var err1, err2 error
+		cer, err1 := cert.Certificate()
+		if err1 != nil {
+			log.Printf("Failed to read x509 certificate: %s", err1)
+			cer, err2 = cert.GenerateCertificate()
+			if err2 != nil {
+				log.Printf("Failed to create x509 certificate: %s", err2)
+				return nil, fmt.Errorf("no certificate available (%s, %s)",
+					err1, err2)
+			}
+		}
+		c = &cer
+	}
+	conf := &tls.Config{
+		Certificates: []tls.Certificate{*c},
+	}
+	if ca := query.Get("ca"); ca != "" {
+		conf.ClientCAs, err = certPool(ca)
+		if err != nil {
+			return nil, err
+		}
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	switch query.Get("clientauth") {
+	case "":
+	case "optional":
+		if conf.ClientCAs == nil {
+			return nil, fmt.Errorf("clientauth option requires a CA")
+		}
+		conf.ClientAuth = tls.VerifyClientCertIfGiven
+	default:
+		return nil, fmt.Errorf("invalid clientauth option: %s",
+			query.Get("clientauth"))
+	}
+	return conf, nil
+}
+
+// tlsStream is implemented by the streams using TLS.
+type tlsStream interface {
+	ConnectionState() (tls.ConnectionState, bool)
+}
+
+// PeerCertificate returns the certificate presented by the other side
+// of the endpoint if it has been verified. It returns nil otherwise.
+func PeerCertificate(e EndPoint) *x509.Certificate {
+	end, ok := unwrap(e).(*endPoint)
+	if !ok {
+		return nil
+	}
+	stream, ok := end.stream.(tlsStream)
+	if !ok {
+		return nil
+	}
+	state, ok := stream.ConnectionState()
+	if !ok || len(state.VerifiedChains) == 0 ||
+		len(state.PeerCertificates) == 0 {
+		return nil
+	}
+	return state.PeerCertificates[0]
+}
+
+// PublicAddress returns addr without its options. This is the address
+// advertised to the clients.
+func PublicAddress(addr string) string {
+	u, err := url.Parse(addr)
+	if err != nil || u.RawQuery == "" {
+		return addr
+	}
+	u.RawQuery = ""
+	return u.String()
+}
+
+// WithOptions returns addr with the TLS options of ref if addr has no
+// options and both use TLS. The fingerprint option is only kept for
+// the same host.
+func WithOptions(addr, ref string) string {
+	r, err := url.Parse(ref)
+	if err != nil || r.RawQuery == "" {
+		return addr
+	}
+	u, err := url.Parse(addr)
+	if err != nil || u.RawQuery != "" {
+		return addr
+	}
+	isTLS := func(scheme string) bool {
+		return scheme == "tcps" || scheme == "quic"
+	}
+	if !isTLS(u.Scheme) || !isTLS(r.Scheme) {
+		return addr
+	}
+	query := r.Query()
+	if u.Host != r.Host {
+		query.Del("fingerprint")
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
@@ -0,0 +1,225 @@
+package net_test
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lugu/qiloop/bus/internal/testcert"
+	"github.com/lugu/qiloop/bus/net"
+)
+
+// writeCertificates creates in dir a CA (ca), a server certificate
+// (server) and a client certificate (client) signed by the CA and an
+// unrelated CA (other). It returns the fingerprint of the server
+// certificate.
+func writeCertificates(t *testing.T, dir string) string {
+	ca, err := testcert.GenerateAuthority("test CA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := testcert.GenerateAuthority("other CA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := testcert.GenerateSigned(ca, "server", "localhost", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := testcert.GenerateSigned(ca, "client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificates := map[string]tls.Certificate{
+		"ca":     ca,
+		"other":  other,
+		"server": server,
+		"client": client,
+	}
+	for name, c := range certificates {
+		err := testcert.WriteCertificate(c, filepath.Join(dir, name+".pem"),
+			filepath.Join(dir, name+".key"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return net.Fingerprint(server.Leaf)
+}
+
+// echo replies to the messages received by the listener. The
+// endpoints receiving a message are sent to peers.
+func echo(listener net.Listener, peers chan net.EndPoint) {
+	for {
+		stream, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		net.EndPointFinalizer(stream, func(e net.EndPoint) {
+			filter := func(hdr *net.Header) (bool, bool) {
+				return true, true
+			}
+			consumer := func(msg *net.Message) error {
+				peers <- e
+				return e.Send(*msg)
+			}
+			closer := func(err error) {}
+			e.AddHandler(filter, consumer, closer)
+		})
+	}
+}
+
+// ping sends a message and waits for the reply.
+func ping(endpoint net.EndPoint) bool {
+	response, err := endpoint.ReceiveAny()
+	if err != nil {
+		return false
+	}
+	msg := net.NewMessage(net.NewHeader(net.Call, 1, 1, 1, 1), nil)
+	if err := endpoint.Send(msg); err != nil {
+		return false
+	}
+	select {
+	case _, ok := <-response:
+		return ok
+	case <-time.After(5 * time.Second):
+		return false
+	}
+}
+
+func TestTLSVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "qiloop-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fingerprint := writeCertificates(t, dir)
+
+	addr := "tcps://localhost:54331"
+	listener, err := net.Listen(addr + "?cert=" + dir + "/server.pem&key=" +
+		dir + "/server.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go echo(listener, make(chan net.EndPoint, 100))
+
+	succeed := func(options string) {
+		endpoint, err := net.DialEndPoint(addr + "?" + options)
+		if err != nil {
+			t.Fatalf("%s: %s", options, err)
+		}
+		defer endpoint.Close()
+		if !ping(endpoint) {
+			t.Errorf("%s: no reply", options)
+		}
+	}
+	fail := func(options string) {
+		endpoint, err := net.DialEndPoint(addr + "?" + options)
+		if err == nil {
+			endpoint.Close()
+			t.Fatalf("%s: shall fail", options)
+		}
+	}
+
+	succeed("ca=" + dir + "/ca.pem")
+	fail("ca=" + dir + "/other.pem")
+	fail("ca=" + dir + "/missing.pem")
+	succeed("fingerprint=" + fingerprint)
+	succeed("fingerprint=" + strings.ToUpper(fingerprint))
+	fail("fingerprint=0123")
+	fail("unknown=true")
+	fail("cert=" + dir + "/client.pem")
+
+	knownHosts := net.KnownHostsFile
+	net.KnownHostsFile = filepath.Join(dir, "known-hosts")
+	defer func() { net.KnownHostsFile = knownHosts }()
+	succeed("tofu=true")
+	data, err := ioutil.ReadFile(net.KnownHostsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "localhost:54331 "+fingerprint+"\n" {
+		t.Errorf("unexpected known hosts: %s", data)
+	}
+	succeed("tofu=true")
+	err = ioutil.WriteFile(net.KnownHostsFile,
+		[]byte("localhost:54331 0123\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fail("tofu=true")
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "qiloop-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeCertificates(t, dir)
+
+	addr := "tcps://localhost:54332"
+	listener, err := net.Listen(addr + "?cert=" + dir + "/server.pem&key=" +
+		dir + "/server.key&ca=" + dir + "/ca.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	peers := make(chan net.EndPoint, 100)
+	go echo(listener, peers)
+
+	endpoint, err := net.DialEndPoint(addr + "?ca=" + dir + "/ca.pem&cert=" +
+		dir + "/client.pem&key=" + dir + "/client.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer endpoint.Close()
+	if !ping(endpoint) {
+		t.Fatal("no reply")
+	}
+	c := net.PeerCertificate(<-peers)
+	if c == nil || c.Subject.String() != "CN=client" {
+		t.Errorf("unexpected certificate: %v", c)
+	}
+
+	// without client certificate, the server closes the connection.
+	endpoint, err = net.DialEndPoint(addr + "?ca=" + dir + "/ca.pem")
+	if err == nil {
+		defer endpoint.Close()
+		if ping(endpoint) {
+			t.Errorf("shall fail")
+		}
+	}
+
+	_, err = net.Listen(addr + "?clientauth=optional")
+	if err == nil {
+		t.Errorf("clientauth requires a CA")
+	}
+}
+
+func TestWithOptions(t *testing.T) {
+	ref := "tcps://robot:9503?ca=ca.pem&fingerprint=01"
+	tests := []struct {
+		addr     string
+		expected string
+	}{
+		{"tcps://robot:9503", "tcps://robot:9503?ca=ca.pem&fingerprint=01"},
+		{"quic://robot:9503", "quic://robot:9503?ca=ca.pem&fingerprint=01"},
+		{"tcps://other:9503", "tcps://other:9503?ca=ca.pem"},
+		{"tcps://robot:9503?tofu=true", "tcps://robot:9503?tofu=true"},
+		{"tcp://robot:9559", "tcp://robot:9559"},
+	}
+	for _, test := range tests {
+		if addr := net.WithOptions(test.addr, ref); addr != test.expected {
+			t.Errorf("%s: expecting %s, got %s", test.addr,
+				test.expected, addr)
+		}
+	}
+	if addr := net.PublicAddress(ref); addr != "tcps://robot:9503" {
+		t.Errorf("unexpected public address: %s", addr)
+	}
+}
@@ -0,0 +1,264 @@
+package bus
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Credential is an entry of a credential file. The format of the file
+// is one user per line:
+//
+//	login:bcrypt hash[:disabled]
+//
+// Empty lines and lines starting with # are ignored.
+type Credential struct {
+	User     string
+	Hash     []byte
+	Disabled bool
+}
+
+// NewCredential returns a credential with the bcrypt hash of token.
+func NewCredential(user, token string) (Credential, error) {
+	if user == "" || strings.ContainsAny(user, ":\n") {
+		return Credential{}, fmt.Errorf("invalid user name: %q", user)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(token),
+		bcrypt.DefaultCost)
+	if err != nil {
+		return Credential{}, fmt.Errorf("hash token: %s", err)
+	}
+	return Credential{User: user, Hash: hash}, nil
+}
+
+// Verify returns true if the credential is enabled and matches the
+// token.
+func (c Credential) Verify(token string) bool {
+	if c.Disabled {
+		return false
+	}
+	return bcrypt.CompareHashAndPassword(c.Hash, []byte(token)) == nil
+}
+
+// ReadCredentials parses a credential file.
+func ReadCredentials(r io.Reader) ([]Credential, error) {
+	var creds []Credential
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Split(text, ":")
+		if len(fields) < 2 || len(fields) > 3 || fields[0] == "" {
+			return nil, fmt.Errorf("line %d: invalid credential", line)
+		}
+		cred := Credential{
+			User: fields[0],
+			Hash: []byte(fields[1]),
+		}
+		if _, err := bcrypt.Cost(cred.Hash); err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err)
+		}
+		if len(fields) == 3 {
+			if fields[2] != "disabled" {
+				return nil, fmt.Errorf("line %d: unknown flag: %s",
+					line, fields[2])
+			}
+			cred.Disabled = true
+		}
+		creds = append(creds, cred)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read credentials: %s", err)
+	}
+	return creds, nil
+}
+
+// WriteCredentials writes the credentials sorted by user.
+func WriteCredentials(w io.Writer, creds []Credential) error {
+	sorted := append([]Credential(nil), creds...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].User < sorted[j].User
+	})
+	for _, c := range sorted {
+		line := c.User + ":" + string(c.Hash)
+		if c.Disabled {
+			line += ":disabled"
+		}
+		if _, err := io.WriteString(w, line+"\n"); err != nil {
+			return fmt.Errorf("write credentials: %s", err)
+		}
+	}
+	return nil
+}
+
+// LoadCredentials reads a credential file. A missing file contains no
+// credential.
+func LoadCredentials(filename string) ([]Credential, error) {
+	file, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadCredentials(file)
+}
+
+// SaveCredentials replaces the credential file: the servers reading
+// it never see a partially written file.
+func SaveCredentials(filename string, creds []Credential) error {
+	var buf bytes.Buffer
+	if err := WriteCredentials(&buf, creds); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), ".qiloop-passwd")
+	if err != nil {
+		return fmt.Errorf("save credentials: %s", err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(buf.Bytes())
+	if err == nil {
+		err = tmp.Chmod(0600)
+	}
+	if err2 := tmp.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		return fmt.Errorf("save credentials: %s", err)
+	}
+	if err = os.Rename(tmp.Name(), filename); err != nil {
+		return fmt.Errorf("save credentials: %s", err)
+	}
+	return nil
+}
+
+// CredentialReloadPeriod is the interval at which a FileAuthenticator
+// checks if its file has changed.
+var CredentialReloadPeriod = time.Second
+
+// FileAuthenticator is an Authenticator which reads the hashed tokens
+// of the users from a credential file. The file is reloaded when it
+// changes.
+type FileAuthenticator struct {
+	filename string
+	mutex    sync.RWMutex
+	users    map[string]Credential
+	modTime  time.Time
+	size     int64
+	done     chan struct{}
+	once     sync.Once
+}
+
+// NewFileAuthenticator reads the credential file and watches it for
+// changes until Close is called.
+func NewFileAuthenticator(filename string) (*FileAuthenticator, error) {
+	f := &FileAuthenticator{
+		filename: filename,
+		done:     make(chan struct{}),
+	}
+	if err := f.Reload(); err != nil {
+		return nil, err
+	}
+	go f.watch(CredentialReloadPeriod)
+	return f, nil
+}
+
+// Reload reads the credential file. In case of error, the previous
+// credentials are kept. An empty file is ignored since it is how an
+// editor truncating the file before writing it is seen: a file with
+// only comments removes every user.
+func (f *FileAuthenticator) Reload() error {
+	info, err := os.Stat(f.filename)
+	if err != nil {
+		return fmt.Errorf("credentials: %s", err)
+	}
+	f.mutex.RLock()
+	loaded := f.users != nil
+	f.mutex.RUnlock()
+	if info.Size() == 0 && loaded {
+		return fmt.Errorf("credentials: %s: empty file ignored",
+			f.filename)
+	}
+	creds, err := LoadCredentials(f.filename)
+	if err != nil {
+		return fmt.Errorf("credentials: %s: %s", f.filename, err)
+	}
+	// the file must not change while it is read.
+	after, err := os.Stat(f.filename)
+	if err != nil {
+		return fmt.Errorf("credentials: %s", err)
+	} else if !after.ModTime().Equal(info.ModTime()) ||
+		after.Size() != info.Size() {
+		return fmt.Errorf("credentials: %s: modified during the read",
+			f.filename)
+	}
+	users := make(map[string]Credential, len(creds))
+	for _, c := range creds {
+		users[c.User] = c
+	}
+	f.mutex.Lock()
+	f.users = users
+	f.modTime = info.ModTime()
+	f.size = info.Size()
+	f.mutex.Unlock()
+	return nil
+}
+
+// changed returns true if the file was modified since the last load.
+func (f *FileAuthenticator) changed() bool {
+	info, err := os.Stat(f.filename)
+	if err != nil {
+		return false
+	}
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	return !info.ModTime().Equal(f.modTime) || info.Size() != f.size
+}
+
+func (f *FileAuthenticator) watch(period time.Duration) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if !f.changed() {
+				continue
+			}
+			if err := f.Reload(); err != nil {
+				log.Printf("reload %s", err)
+			}
+		case <-f.done:
+			return
+		}
+	}
+}
+
+// Authenticate returns true if the user is enabled and the token
+// matches its hash.
+func (f *FileAuthenticator) Authenticate(user, token string) bool {
+	f.mutex.RLock()
+	cred, ok := f.users[user]
+	f.mutex.RUnlock()
+	return ok && cred.Verify(token)
+}
+
+// Close stops watching the file.
+func (f *FileAuthenticator) Close() error {
+	f.once.Do(func() {
+		close(f.done)
+	})
+	return nil
+}
@@ -0,0 +1,156 @@
+package bus_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lugu/qiloop/bus"
+)
+
+func TestCredentials(t *testing.T) {
+	foo, err := bus.NewCredential("foo", "bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bazz, err := bus.NewCredential("bazz", "bozz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bazz.Disabled = true
+	if _, err = bus.NewCredential("a:b", "c"); err == nil {
+		t.Errorf("invalid user name accepted")
+	}
+	if strings.Contains(string(foo.Hash), "bar") {
+		t.Errorf("token not hashed")
+	}
+	var buf bytes.Buffer
+	if err = bus.WriteCredentials(&buf, []bus.Credential{foo, bazz}); err != nil {
+		t.Fatal(err)
+	}
+	creds, err := bus.ReadCredentials(strings.NewReader(
+		"# comment\n\n" + buf.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(creds) != 2 || creds[0].User != "bazz" || creds[1].User != "foo" {
+		t.Fatalf("unexpected credentials: %v", creds)
+	}
+	if !creds[1].Verify("bar") || creds[1].Verify("bozz") {
+		t.Errorf("invalid verification")
+	}
+	if !creds[0].Disabled || creds[0].Verify("bozz") {
+		t.Errorf("disabled user accepted")
+	}
+	invalid := []string{
+		"foo\n",
+		":" + string(foo.Hash) + "\n",
+		"foo:bar\n",
+		"foo:" + string(foo.Hash) + ":unknown\n",
+	}
+	for _, s := range invalid {
+		if _, err = bus.ReadCredentials(strings.NewReader(s)); err == nil {
+			t.Errorf("invalid file accepted: %q", s)
+		}
+	}
+}
+
+func TestFileAuthenticator(t *testing.T) {
+	dir, err := ioutil.TempDir("", "qiloop-passwd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "passwd")
+
+	defer func(period time.Duration) {
+		bus.CredentialReloadPeriod = period
+	}(bus.CredentialReloadPeriod)
+	bus.CredentialReloadPeriod = 10 * time.Millisecond
+
+	if _, err = bus.NewFileAuthenticator(filename); err == nil {
+		t.Errorf("missing file accepted")
+	}
+	foo, err := bus.NewCredential("foo", "bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = bus.SaveCredentials(filename, []bus.Credential{foo}); err != nil {
+		t.Fatal(err)
+	}
+	auth, err := bus.NewFileAuthenticator(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer auth.Close()
+	if !auth.Authenticate("foo", "bar") {
+		t.Errorf("user refused")
+	}
+	if auth.Authenticate("foo", "bozz") || auth.Authenticate("bazz", "bozz") {
+		t.Errorf("invalid credentials accepted")
+	}
+
+	// the file is reloaded when it changes.
+	bazz, err := bus.NewCredential("bazz", "bozz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	foo.Disabled = true
+	err = bus.SaveCredentials(filename, []bus.Credential{foo, bazz})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for !auth.Authenticate("bazz", "bozz") {
+		if time.Now().After(deadline) {
+			t.Fatalf("file not reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if auth.Authenticate("foo", "bar") {
+		t.Errorf("disabled user accepted")
+	}
+
+	// invalid files are ignored. The file is replaced atomically
+	// so that the watcher never reads an empty file.
+	err = ioutil.WriteFile(filename+".tmp", []byte("invalid\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Rename(filename+".tmp", filename); err != nil {
+		t.Fatal(err)
+	}
+	if err = auth.Reload(); err == nil {
+		t.Errorf("invalid file accepted")
+	}
+	if !auth.Authenticate("bazz", "bozz") {
+		t.Errorf("previous credentials lost")
+	}
+
+	// a file truncated by an editor is ignored.
+	if err = ioutil.WriteFile(filename, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = auth.Reload(); err == nil {
+		t.Errorf("empty file accepted")
+	}
+	if !auth.Authenticate("bazz", "bozz") {
+		t.Errorf("previous credentials lost")
+	}
+
+	// a file without credentials removes the users.
+	err = ioutil.WriteFile(filename, []byte("# no user\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = auth.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if auth.Authenticate("bazz", "bozz") {
+		t.Errorf("removed user accepted")
+	}
+}
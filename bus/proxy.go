@@ -128,3 +128,19 @@ func NewProxy(client Client, meta object.MetaObject, service, object uint32) Pro
 	}
 	return proxy{meta, methods, client, service, object}
 }
+
+// ObjectFrom returns a proxy to the object referenced by ref which
+// was received from origin. The objects of the service of origin are
+// reached with the connection of origin since the service IDs of
+// federated directories can collide (see directory.Federate). The
+// other references are resolved by the session.
+func ObjectFrom(sess Session, origin Proxy, ref object.ObjectReference) (Proxy, error) {
+	if o, ok := origin.(*proxyObject); ok {
+		origin = o.Proxy
+	}
+	if p, ok := origin.(proxy); ok && p.service == ref.ServiceID {
+		return NewProxy(p.client, ref.MetaObject, ref.ServiceID,
+			ref.ObjectID), nil
+	}
+	return sess.Object(ref)
+}
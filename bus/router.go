@@ -2,24 +2,29 @@ package bus
 
 import (
 	"fmt"
+	"log"
 	"sync"
 
 	"github.com/lugu/qiloop/bus/net"
+	"github.com/lugu/qiloop/type/object"
 )
 
 // Router dispatch the incomming messages. A Router shall be Activated
 // before calling NewService.
 type Router struct {
 	sync.RWMutex
-	services  map[uint32]ServiceReceiver
-	namespace Namespace
-	session   Session
+	services   map[uint32]ServiceReceiver
+	names      map[uint32]string
+	namespace  Namespace
+	session    Session
+	authorizer Authorizer
 }
 
 // NewRouter construct a router with the service zero passed.
 func NewRouter(authenticator Actor, namespace Namespace, session Session) *Router {
 	r := &Router{
 		services:  make(map[uint32]ServiceReceiver),
+		names:     make(map[uint32]string),
 		namespace: namespace,
 		session:   session,
 	}
@@ -37,6 +42,7 @@ func (r *Router) Terminate() error {
 	r.Lock()
 	services := r.services
 	r.services = make(map[uint32]ServiceReceiver)
+	r.names = make(map[uint32]string)
 	r.Unlock()
 
 	var ret error
@@ -70,14 +76,75 @@ func (r *Router) Remove(serviceID uint32) error {
 	defer r.Unlock()
 	if _, ok := r.services[serviceID]; ok {
 		delete(r.services, serviceID)
+		delete(r.names, serviceID)
 		return nil
 	}
 	return fmt.Errorf("Router: cannot remove service %d", serviceID)
 }
 
+// setName records the name of a service for the Authorizer.
+func (r *Router) setName(serviceID uint32, name string) {
+	r.Lock()
+	r.names[serviceID] = name
+	r.Unlock()
+}
+
+// SetAuthorizer sets the Authorizer consulted before a call or a post
+// is forwarded to a service. A nil Authorizer allows everything.
+func (r *Router) SetAuthorizer(a Authorizer) {
+	r.Lock()
+	r.authorizer = a
+	r.Unlock()
+}
+
+// authorize returns true if the Authorizer allows the message. The
+// denials are logged.
+func (r *Router) authorize(m *net.Message, from Channel, s ServiceReceiver,
+	name string, a Authorizer) bool {
+
+	if m.Header.Service == 0 ||
+		(m.Header.Type != net.Call && m.Header.Type != net.Post) {
+		return true
+	}
+	meta := object.FullMetaObject(object.MetaObject{})
+	if impl, ok := s.(*serviceImpl); ok {
+		if objMeta, ok := impl.metaObject(m.Header.Object); ok {
+			meta = objMeta
+		}
+	}
+	req := newRequest(m, from, name, meta)
+	if a.Authorize(req) {
+		return true
+	}
+	log.Printf("%s: %s denied for user %q (%s): %s.%s (%d)",
+		from.EndPoint(), req.Access, req.User, req.Subject,
+		req.Service, req.Action, req.ObjectID)
+	return false
+}
+
 // Receive process a message. The message will be replied if the
-// router can not found the destination.
+// router can not found the destination or if the Authorizer denies
+// it.
 func (r *Router) Receive(m *net.Message, from Channel) error {
+	r.RLock()
+	s, ok := r.services[m.Header.Service]
+	name := r.names[m.Header.Service]
+	a := r.authorizer
+	r.RUnlock()
+	if !ok {
+		return from.SendError(m, ErrServiceNotFound)
+	}
+	if a != nil && !r.authorize(m, from, s, name, a) {
+		if m.Header.Type == net.Post {
+			return nil
+		}
+		return from.SendError(m, ErrNotAuthorized)
+	}
+	return s.Receive(m, from)
+}
+
+// dispatch forwards a message to a service without authorization.
+func (r *Router) dispatch(m *net.Message, from Channel) error {
 	r.RLock()
 	s, ok := r.services[m.Header.Service]
 	r.RUnlock()
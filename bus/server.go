@@ -2,6 +2,7 @@ package bus
 
 import (
 	"errors"
+	"fmt"
 	"log"
 	gonet "net"
 	"sync"
@@ -69,6 +70,7 @@ type server struct {
 	Router        *Router
 	contexts      map[Channel]bool
 	contextsMutex sync.Mutex
+	limiter       *Limiter
 	closeChan     chan int
 	waitChan      chan error
 }
@@ -139,7 +141,10 @@ type ServiceReceiver interface {
 // 2. activate the service
 // 3. add the service to the router dispatcher
 // 4. advertize the service to the namespace (service directory)
-func (s *server) NewService(name string, object Actor) (Service, error) {
+// The metadata set by the options is recorded by the namespace if it
+// implements MetadataNamespace.
+func (s *server) NewService(name string, object Actor,
+	options ...ServiceOption) (Service, error) {
 
 	s.Router.RLock()
 	session := s.Router.session
@@ -150,6 +155,13 @@ func (s *server) NewService(name string, object Actor) (Service, error) {
 	if err != nil {
 		return nil, err
 	}
+	if len(options) != 0 {
+		err = s.setMetadata(serviceID, options)
+		if err != nil {
+			s.namespace.Remove(serviceID)
+			return nil, err
+		}
+	}
 
 	// 2. activate the service
 	activation := serviceActivation(s.Router, session, serviceID)
@@ -163,6 +175,7 @@ func (s *server) NewService(name string, object Actor) (Service, error) {
 	if err != nil {
 		return nil, err
 	}
+	s.Router.setName(serviceID, name)
 
 	// 4. advertize it
 	err = s.namespace.Enable(serviceID)
@@ -173,6 +186,18 @@ func (s *server) NewService(name string, object Actor) (Service, error) {
 	return service, nil
 }
 
+func (s *server) setMetadata(serviceID uint32, options []ServiceOption) error {
+	namespace, ok := s.namespace.(MetadataNamespace)
+	if !ok {
+		return fmt.Errorf("namespace does not support metadata")
+	}
+	metadata := make(map[string]string)
+	for _, option := range options {
+		option(metadata)
+	}
+	return namespace.SetMetadata(serviceID, metadata)
+}
+
 func (s *server) handle(stream net.Stream, authenticated bool) {
 
 	context := &channel{
@@ -181,6 +206,8 @@ func (s *server) handle(stream net.Stream, authenticated bool) {
 	if authenticated {
 		context.SetAuthenticated()
 	}
+	// limits apply to the remote clients.
+	var limited *limitedChannel
 	filter := func(hdr *net.Header) (matched bool, keep bool) {
 		if hdr.Type == net.Reply || hdr.Type == net.Error ||
 			hdr.Type == net.Event || hdr.Type == net.Cancelled {
@@ -195,9 +222,28 @@ func (s *server) handle(stream net.Stream, authenticated bool) {
 				context.EndPoint().String(), msg.Header)
 			return context.SendError(msg, err)
 		}
-		return s.Router.Receive(msg, context)
+		if authenticated {
+			// local clients are not subject to authorization.
+			return s.Router.dispatch(msg, context)
+		}
+		if limited == nil {
+			return s.Router.Receive(msg, context)
+		}
+		err = limited.limiter.admit(msg, context)
+		if err != nil {
+			log.Printf("limit exceeded by %s: %s",
+				context.EndPoint().String(), err)
+			if msg.Header.Type == net.Call {
+				return context.SendError(msg, err)
+			}
+			return nil
+		}
+		return s.Router.Receive(msg, limited)
 	}
 	closer := func(err error) {
+		if limited != nil {
+			limited.limiter.close()
+		}
 		s.contextsMutex.Lock()
 		defer s.contextsMutex.Unlock()
 		if _, ok := s.contexts[context]; ok {
@@ -206,10 +252,16 @@ func (s *server) handle(stream net.Stream, authenticated bool) {
 	}
 	finalize := func(e net.EndPoint) {
 		context.endpoint = e
-		e.AddHandler(filter, consumer, closer)
 		s.contextsMutex.Lock()
+		if s.limiter != nil && !authenticated {
+			limited = &limitedChannel{
+				Channel: context,
+				limiter: s.limiter.newConnection(),
+			}
+		}
 		s.contexts[context] = true
 		s.contextsMutex.Unlock()
+		e.AddHandler(filter, consumer, closer)
 	}
 	net.EndPointFinalizer(stream, finalize)
 }
@@ -254,6 +306,20 @@ func (s *server) closeAll() error {
 	return ret
 }
 
+// SetAuthorizer sets the Authorizer consulted for each call and post
+// of the authenticated connections.
+func (s *server) SetAuthorizer(a Authorizer) {
+	s.Router.SetAuthorizer(a)
+}
+
+// SetLimiter sets the Limiter enforcing the limits of the remote
+// clients. It applies to the new connections.
+func (s *server) SetLimiter(l *Limiter) {
+	s.contextsMutex.Lock()
+	defer s.contextsMutex.Unlock()
+	s.limiter = l
+}
+
 // WaitTerminate blocks until the server has terminated.
 func (s *server) WaitTerminate() chan error {
 	return s.waitChan
@@ -6,6 +6,7 @@ import (
 	"sync"
 
 	"github.com/lugu/qiloop/bus/net"
+	"github.com/lugu/qiloop/type/object"
 )
 
 func objectTerminator(service Service, objectID uint32) func() {
@@ -162,6 +163,23 @@ func (s *serviceImpl) Remove(objectID uint32) error {
 	return fmt.Errorf("cannot remove object %d", objectID)
 }
 
+// metaObject returns the meta object of an object created with
+// NewBasicObject.
+func (s *serviceImpl) metaObject(objectID uint32) (object.MetaObject, bool) {
+	s.RLock()
+	obj, ok := s.objects[objectID]
+	s.RUnlock()
+	if !ok {
+		return object.MetaObject{}, false
+	}
+	if stub, ok := obj.(*stubObject); ok {
+		if impl, ok := stub.impl.(*objectImpl); ok {
+			return impl.meta, true
+		}
+	}
+	return object.MetaObject{}, false
+}
+
 // Receive forwards the message to the appropriate object.
 func (s *serviceImpl) Receive(m *net.Message, from Channel) error {
 	s.RLock()
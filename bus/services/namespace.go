@@ -17,7 +17,7 @@ func NewServer(sess bus.Session, addr string, auth bus.Authenticator) (bus.Serve
 		return nil, err
 	}
 
-	addrs := []string{addr}
+	addrs := []string{net.PublicAddress(addr)}
 	namespace, err := Namespace(sess, addrs)
 	if err != nil {
 		return nil, err
@@ -76,6 +76,11 @@ func (ns *remoteNamespace) Enable(serviceID uint32) error {
 	return ns.Directory.ServiceReady(serviceID)
 }
 
+func (ns *remoteNamespace) SetMetadata(serviceID uint32,
+	metadata map[string]string) error {
+	return ns.Directory.SetServiceMetadata(serviceID, metadata)
+}
+
 func (ns *remoteNamespace) Resolve(name string) (uint32, error) {
 	info, err := ns.Directory.Service(name)
 	if err != nil {
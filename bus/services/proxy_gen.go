@@ -80,6 +80,41 @@ func writeServiceRemoved(s ServiceRemoved, w io.Writer) (err error) {
 	return nil
 }
 
+// ServiceStateChanged is serializable
+type ServiceStateChanged struct {
+	ServiceID uint32
+	Name      string
+	State     string
+}
+
+// readServiceStateChanged unmarshalls ServiceStateChanged
+func readServiceStateChanged(r io.Reader) (s ServiceStateChanged, err error) {
+	if s.ServiceID, err = basic.ReadUint32(r); err != nil {
+		return s, fmt.Errorf("read ServiceID field: %s", err)
+	}
+	if s.Name, err = basic.ReadString(r); err != nil {
+		return s, fmt.Errorf("read Name field: %s", err)
+	}
+	if s.State, err = basic.ReadString(r); err != nil {
+		return s, fmt.Errorf("read State field: %s", err)
+	}
+	return s, nil
+}
+
+// writeServiceStateChanged marshalls ServiceStateChanged
+func writeServiceStateChanged(s ServiceStateChanged, w io.Writer) (err error) {
+	if err := basic.WriteUint32(s.ServiceID, w); err != nil {
+		return fmt.Errorf("write ServiceID field: %s", err)
+	}
+	if err := basic.WriteString(s.Name, w); err != nil {
+		return fmt.Errorf("write Name field: %s", err)
+	}
+	if err := basic.WriteString(s.State, w); err != nil {
+		return fmt.Errorf("write State field: %s", err)
+	}
+	return nil
+}
+
 // ServiceDirectory is the abstract interface of the service
 type ServiceDirectory interface {
 	// Service calls the remote procedure
@@ -98,10 +133,22 @@ type ServiceDirectory interface {
 	MachineId() (string, error)
 	// _socketOfService calls the remote procedure
 	_socketOfService(serviceID uint32) (object.ObjectReference, error)
+	// ServiceHealth calls the remote procedure
+	ServiceHealth(name string) (ServiceHealth, error)
+	// ServicesByName calls the remote procedure
+	ServicesByName(name string) ([]ServiceInfo, error)
+	// SetServiceMetadata calls the remote procedure
+	SetServiceMetadata(serviceID uint32, metadata map[string]string) error
+	// ServiceMetadata calls the remote procedure
+	ServiceMetadata(serviceID uint32) (map[string]string, error)
+	// FindServices calls the remote procedure
+	FindServices(filter ServiceFilter) ([]ServiceInfo, error)
 	// SubscribeServiceAdded subscribe to a remote signal
 	SubscribeServiceAdded() (unsubscribe func(), updates chan ServiceAdded, err error)
 	// SubscribeServiceRemoved subscribe to a remote signal
 	SubscribeServiceRemoved() (unsubscribe func(), updates chan ServiceRemoved, err error)
+	// SubscribeServiceStateChanged subscribe to a remote signal
+	SubscribeServiceStateChanged() (unsubscribe func(), updates chan ServiceStateChanged, err error)
 }
 
 // ServiceDirectoryProxy represents a proxy object to the service
@@ -285,6 +332,163 @@ func (p *proxyServiceDirectory) _socketOfService(serviceID uint32) (object.Objec
 	return ret, nil
 }
 
+// ServiceHealth calls the remote procedure
+func (p *proxyServiceDirectory) ServiceHealth(name string) (ServiceHealth, error) {
+	var err error
+	var ret ServiceHealth
+	var buf bytes.Buffer
+	if err = basic.WriteString(name, &buf); err != nil {
+		return ret, fmt.Errorf("serialize name: %s", err)
+	}
+	response, err := p.Call("serviceHealth", buf.Bytes())
+	if err != nil {
+		return ret, fmt.Errorf("call serviceHealth failed: %s", err)
+	}
+	resp := bytes.NewBuffer(response)
+	ret, err = readServiceHealth(resp)
+	if err != nil {
+		return ret, fmt.Errorf("parse serviceHealth response: %s", err)
+	}
+	return ret, nil
+}
+
+// ServicesByName calls the remote procedure
+func (p *proxyServiceDirectory) ServicesByName(name string) ([]ServiceInfo, error) {
+	var err error
+	var ret []ServiceInfo
+	var buf bytes.Buffer
+	if err = basic.WriteString(name, &buf); err != nil {
+		return ret, fmt.Errorf("serialize name: %s", err)
+	}
+	response, err := p.Call("servicesByName", buf.Bytes())
+	if err != nil {
+		return ret, fmt.Errorf("call servicesByName failed: %s", err)
+	}
+	resp := bytes.NewBuffer(response)
+	ret, err = func() (b []ServiceInfo, err error) {
+		size, err := basic.ReadUint32(resp)
+		if err != nil {
+			return b, fmt.Errorf("read slice size: %s", err)
+		}
+		b = make([]ServiceInfo, size)
+		for i := 0; i < int(size); i++ {
+			b[i], err = readServiceInfo(resp)
+			if err != nil {
+				return b, fmt.Errorf("read slice value: %s", err)
+			}
+		}
+		return b, nil
+	}()
+	if err != nil {
+		return ret, fmt.Errorf("parse servicesByName response: %s", err)
+	}
+	return ret, nil
+}
+
+// SetServiceMetadata calls the remote procedure
+func (p *proxyServiceDirectory) SetServiceMetadata(serviceID uint32, metadata map[string]string) error {
+	var err error
+	var buf bytes.Buffer
+	if err = basic.WriteUint32(serviceID, &buf); err != nil {
+		return fmt.Errorf("serialize serviceID: %s", err)
+	}
+	if err = func() error {
+		err := basic.WriteUint32(uint32(len(metadata)), &buf)
+		if err != nil {
+			return fmt.Errorf("write map size: %s", err)
+		}
+		for k, v := range metadata {
+			err = basic.WriteString(k, &buf)
+			if err != nil {
+				return fmt.Errorf("write map key: %s", err)
+			}
+			err = basic.WriteString(v, &buf)
+			if err != nil {
+				return fmt.Errorf("write map value: %s", err)
+			}
+		}
+		return nil
+	}(); err != nil {
+		return fmt.Errorf("serialize metadata: %s", err)
+	}
+	_, err = p.Call("setServiceMetadata", buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("call setServiceMetadata failed: %s", err)
+	}
+	return nil
+}
+
+// ServiceMetadata calls the remote procedure
+func (p *proxyServiceDirectory) ServiceMetadata(serviceID uint32) (map[string]string, error) {
+	var err error
+	var ret map[string]string
+	var buf bytes.Buffer
+	if err = basic.WriteUint32(serviceID, &buf); err != nil {
+		return ret, fmt.Errorf("serialize serviceID: %s", err)
+	}
+	response, err := p.Call("serviceMetadata", buf.Bytes())
+	if err != nil {
+		return ret, fmt.Errorf("call serviceMetadata failed: %s", err)
+	}
+	resp := bytes.NewBuffer(response)
+	ret, err = func() (m map[string]string, err error) {
+		size, err := basic.ReadUint32(resp)
+		if err != nil {
+			return m, fmt.Errorf("read map size: %s", err)
+		}
+		m = make(map[string]string, size)
+		for i := 0; i < int(size); i++ {
+			k, err := basic.ReadString(resp)
+			if err != nil {
+				return m, fmt.Errorf("read map key (%d/%d): %s", i+1, size, err)
+			}
+			v, err := basic.ReadString(resp)
+			if err != nil {
+				return m, fmt.Errorf("read map value (%d/%d): %s", i+1, size, err)
+			}
+			m[k] = v
+		}
+		return m, nil
+	}()
+	if err != nil {
+		return ret, fmt.Errorf("parse serviceMetadata response: %s", err)
+	}
+	return ret, nil
+}
+
+// FindServices calls the remote procedure
+func (p *proxyServiceDirectory) FindServices(filter ServiceFilter) ([]ServiceInfo, error) {
+	var err error
+	var ret []ServiceInfo
+	var buf bytes.Buffer
+	if err = writeServiceFilter(filter, &buf); err != nil {
+		return ret, fmt.Errorf("serialize filter: %s", err)
+	}
+	response, err := p.Call("findServices", buf.Bytes())
+	if err != nil {
+		return ret, fmt.Errorf("call findServices failed: %s", err)
+	}
+	resp := bytes.NewBuffer(response)
+	ret, err = func() (b []ServiceInfo, err error) {
+		size, err := basic.ReadUint32(resp)
+		if err != nil {
+			return b, fmt.Errorf("read slice size: %s", err)
+		}
+		b = make([]ServiceInfo, size)
+		for i := 0; i < int(size); i++ {
+			b[i], err = readServiceInfo(resp)
+			if err != nil {
+				return b, fmt.Errorf("read slice value: %s", err)
+			}
+		}
+		return b, nil
+	}()
+	if err != nil {
+		return ret, fmt.Errorf("parse findServices response: %s", err)
+	}
+	return ret, nil
+}
+
 // SubscribeServiceAdded subscribe to a remote property
 func (p *proxyServiceDirectory) SubscribeServiceAdded() (func(), chan ServiceAdded, error) {
 	propertyID, err := p.SignalID("serviceAdded")
@@ -349,6 +553,38 @@ func (p *proxyServiceDirectory) SubscribeServiceRemoved() (func(), chan ServiceR
 	return cancel, ch, nil
 }
 
+// SubscribeServiceStateChanged subscribe to a remote property
+func (p *proxyServiceDirectory) SubscribeServiceStateChanged() (func(), chan ServiceStateChanged, error) {
+	propertyID, err := p.SignalID("serviceStateChanged")
+	if err != nil {
+		return nil, nil, fmt.Errorf("property %s not available: %s", "serviceStateChanged", err)
+	}
+	ch := make(chan ServiceStateChanged)
+	cancel, chPay, err := p.SubscribeID(propertyID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("request property: %s", err)
+	}
+	go func() {
+		for {
+			payload, ok := <-chPay
+			if !ok {
+				// connection lost or cancellation.
+				close(ch)
+				return
+			}
+			buf := bytes.NewBuffer(payload)
+			_ = buf // discard unused variable error
+			e, err := readServiceStateChanged(buf)
+			if err != nil {
+				log.Printf("unmarshall tuple: %s", err)
+				continue
+			}
+			ch <- e
+		}
+	}()
+	return cancel, ch, nil
+}
+
 // ServiceInfo is serializable
 type ServiceInfo struct {
 	Name      string
@@ -430,6 +666,142 @@ func writeServiceInfo(s ServiceInfo, w io.Writer) (err error) {
 	return nil
 }
 
+// ServiceHealth is serializable
+type ServiceHealth struct {
+	State     string
+	Failures  uint32
+	Latency   int64
+	LastProbe int64
+}
+
+// readServiceHealth unmarshalls ServiceHealth
+func readServiceHealth(r io.Reader) (s ServiceHealth, err error) {
+	if s.State, err = basic.ReadString(r); err != nil {
+		return s, fmt.Errorf("read State field: %s", err)
+	}
+	if s.Failures, err = basic.ReadUint32(r); err != nil {
+		return s, fmt.Errorf("read Failures field: %s", err)
+	}
+	if s.Latency, err = basic.ReadInt64(r); err != nil {
+		return s, fmt.Errorf("read Latency field: %s", err)
+	}
+	if s.LastProbe, err = basic.ReadInt64(r); err != nil {
+		return s, fmt.Errorf("read LastProbe field: %s", err)
+	}
+	return s, nil
+}
+
+// writeServiceHealth marshalls ServiceHealth
+func writeServiceHealth(s ServiceHealth, w io.Writer) (err error) {
+	if err := basic.WriteString(s.State, w); err != nil {
+		return fmt.Errorf("write State field: %s", err)
+	}
+	if err := basic.WriteUint32(s.Failures, w); err != nil {
+		return fmt.Errorf("write Failures field: %s", err)
+	}
+	if err := basic.WriteInt64(s.Latency, w); err != nil {
+		return fmt.Errorf("write Latency field: %s", err)
+	}
+	if err := basic.WriteInt64(s.LastProbe, w); err != nil {
+		return fmt.Errorf("write LastProbe field: %s", err)
+	}
+	return nil
+}
+
+// ServiceFilter is serializable
+type ServiceFilter struct {
+	Name     string
+	Tags     []string
+	Metadata map[string]string
+}
+
+// readServiceFilter unmarshalls ServiceFilter
+func readServiceFilter(r io.Reader) (s ServiceFilter, err error) {
+	if s.Name, err = basic.ReadString(r); err != nil {
+		return s, fmt.Errorf("read Name field: %s", err)
+	}
+	if s.Tags, err = func() (b []string, err error) {
+		size, err := basic.ReadUint32(r)
+		if err != nil {
+			return b, fmt.Errorf("read slice size: %s", err)
+		}
+		b = make([]string, size)
+		for i := 0; i < int(size); i++ {
+			b[i], err = basic.ReadString(r)
+			if err != nil {
+				return b, fmt.Errorf("read slice value: %s", err)
+			}
+		}
+		return b, nil
+	}(); err != nil {
+		return s, fmt.Errorf("read Tags field: %s", err)
+	}
+	if s.Metadata, err = func() (m map[string]string, err error) {
+		size, err := basic.ReadUint32(r)
+		if err != nil {
+			return m, fmt.Errorf("read map size: %s", err)
+		}
+		m = make(map[string]string, size)
+		for i := 0; i < int(size); i++ {
+			k, err := basic.ReadString(r)
+			if err != nil {
+				return m, fmt.Errorf("read map key (%d/%d): %s", i+1, size, err)
+			}
+			v, err := basic.ReadString(r)
+			if err != nil {
+				return m, fmt.Errorf("read map value (%d/%d): %s", i+1, size, err)
+			}
+			m[k] = v
+		}
+		return m, nil
+	}(); err != nil {
+		return s, fmt.Errorf("read Metadata field: %s", err)
+	}
+	return s, nil
+}
+
+// writeServiceFilter marshalls ServiceFilter
+func writeServiceFilter(s ServiceFilter, w io.Writer) (err error) {
+	if err := basic.WriteString(s.Name, w); err != nil {
+		return fmt.Errorf("write Name field: %s", err)
+	}
+	if err := func() error {
+		err := basic.WriteUint32(uint32(len(s.Tags)), w)
+		if err != nil {
+			return fmt.Errorf("write slice size: %s", err)
+		}
+		for _, v := range s.Tags {
+			err = basic.WriteString(v, w)
+			if err != nil {
+				return fmt.Errorf("write slice value: %s", err)
+			}
+		}
+		return nil
+	}(); err != nil {
+		return fmt.Errorf("write Tags field: %s", err)
+	}
+	if err := func() error {
+		err := basic.WriteUint32(uint32(len(s.Metadata)), w)
+		if err != nil {
+			return fmt.Errorf("write map size: %s", err)
+		}
+		for k, v := range s.Metadata {
+			err = basic.WriteString(k, w)
+			if err != nil {
+				return fmt.Errorf("write map key: %s", err)
+			}
+			err = basic.WriteString(v, w)
+			if err != nil {
+				return fmt.Errorf("write map value: %s", err)
+			}
+		}
+		return nil
+	}(); err != nil {
+		return fmt.Errorf("write Metadata field: %s", err)
+	}
+	return nil
+}
+
 // LogLevel is serializable
 type LogLevel struct {
 	Level int32
@@ -1038,7 +1410,7 @@ func (p *proxyLogManager) CreateListener() (LogListenerProxy, error) {
 		if err != nil {
 			return nil, fmt.Errorf("get meta: %s", err)
 		}
-		proxy, err := p.session.Object(ref)
+		proxy, err := bus.ObjectFrom(p.session, p.ObjectProxy, ref)
 		if err != nil {
 			return nil, fmt.Errorf("get proxy: %s", err)
 		}
@@ -1065,7 +1437,7 @@ func (p *proxyLogManager) GetListener() (LogListenerProxy, error) {
 		if err != nil {
 			return nil, fmt.Errorf("get meta: %s", err)
 		}
-		proxy, err := p.session.Object(ref)
+		proxy, err := bus.ObjectFrom(p.session, p.ObjectProxy, ref)
 		if err != nil {
 			return nil, fmt.Errorf("get proxy: %s", err)
 		}
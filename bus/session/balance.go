@@ -0,0 +1,258 @@
+package session
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/lugu/qiloop/bus"
+	"github.com/lugu/qiloop/bus/services"
+	"github.com/lugu/qiloop/bus/util"
+)
+
+// Balancing selects the instance of a service called by the proxies
+// of a session when several instances are registered with the same
+// name.
+type Balancing int
+
+const (
+	// FirstInstance calls the instance with the lowest service ID.
+	FirstInstance Balancing = iota
+	// RoundRobin calls the instances in turn.
+	RoundRobin
+	// LeastInFlight calls the instance with the fewest calls
+	// waiting for a reply.
+	LeastInFlight
+	// Locality calls the instances of the machine of the session
+	// first.
+	Locality
+)
+
+// instanceKey identifies an instance of a service.
+type instanceKey struct {
+	name string
+	id   uint32
+}
+
+// findServiceInstances returns the instances of a service in the
+// order of the service directory.
+func (s *Session) findServiceInstances(name string) []services.ServiceInfo {
+	s.serviceListMutex.Lock()
+	defer s.serviceListMutex.Unlock()
+	list := make([]services.ServiceInfo, 0, 1)
+	for _, service := range s.serviceList {
+		if service.Name == name {
+			list = append(list, service)
+		}
+	}
+	return list
+}
+
+// instances returns the instances of a service in the order they
+// shall be tried according to the balancing policy.
+func (s *Session) instances(name string) []services.ServiceInfo {
+	list := s.findServiceInstances(name)
+	if len(list) < 2 {
+		return list
+	}
+	s.balanceMutex.Lock()
+	defer s.balanceMutex.Unlock()
+	switch s.balancing {
+	case RoundRobin:
+		next := s.next[name] % len(list)
+		s.next[name] = next + 1
+		rotated := make([]services.ServiceInfo, 0, len(list))
+		rotated = append(rotated, list[next:]...)
+		list = append(rotated, list[:next]...)
+	case LeastInFlight:
+		sort.SliceStable(list, func(i, j int) bool {
+			a := instanceKey{list[i].Name, list[i].ServiceId}
+			b := instanceKey{list[j].Name, list[j].ServiceId}
+			return s.inFlight[a] < s.inFlight[b]
+		})
+	case Locality:
+		machineID := util.MachineID()
+		sort.SliceStable(list, func(i, j int) bool {
+			return list[i].MachineId == machineID &&
+				list[j].MachineId != machineID
+		})
+	}
+	return list
+}
+
+// track updates the number of calls waiting for a reply.
+func (s *Session) track(info services.ServiceInfo, increment int) {
+	key := instanceKey{info.Name, info.ServiceId}
+	s.balanceMutex.Lock()
+	defer s.balanceMutex.Unlock()
+	s.inFlight[key] += increment
+	if s.inFlight[key] == 0 {
+		delete(s.inFlight, key)
+	}
+}
+
+// balancedProxy calls the instances of a service according to the
+// balancing policy of the session. A call which cannot be sent is
+// sent to the next instance. The signals and the properties are
+// subscribed on a single instance.
+type balancedProxy struct {
+	session  *Session
+	name     string
+	objectID uint32
+	mutex    sync.Mutex
+	proxies  map[instanceKey]bus.Proxy
+	// current is the last proxy created. It is reset when its
+	// instance (currentKey) is dropped.
+	current    bus.Proxy
+	currentKey instanceKey
+	// last is the last proxy used. It is used when no instance is
+	// available.
+	last bus.Proxy
+}
+
+func (s *Session) newBalancedProxy(name string, objectID uint32) (bus.Proxy, error) {
+	p := &balancedProxy{
+		session:  s,
+		name:     name,
+		objectID: objectID,
+		proxies:  make(map[instanceKey]bus.Proxy),
+	}
+	err := fmt.Errorf("Service not found: %s", name)
+	for _, info := range s.instances(name) {
+		if _, err = p.proxy(info); err == nil {
+			return p, nil
+		}
+	}
+	return nil, err
+}
+
+// proxy returns the proxy of an instance.
+func (p *balancedProxy) proxy(info services.ServiceInfo) (bus.Proxy, error) {
+	key := instanceKey{info.Name, info.ServiceId}
+	p.mutex.Lock()
+	proxy, ok := p.proxies[key]
+	p.mutex.Unlock()
+	if ok {
+		return proxy, nil
+	}
+	proxy, err := p.session.newService(info, p.objectID)
+	if err != nil {
+		return nil, err
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.proxies[key] = proxy
+	p.current, p.currentKey = proxy, key
+	p.last = proxy
+	return proxy, nil
+}
+
+// drop forgets the proxy of an instance after a connection error.
+func (p *balancedProxy) drop(info services.ServiceInfo) {
+	key := instanceKey{info.Name, info.ServiceId}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if key == p.currentKey {
+		p.current = nil
+	}
+	delete(p.proxies, key)
+}
+
+// first returns the proxy of the last instance created. If this
+// instance was dropped, it selects another instance. If none is
+// available, it returns the last proxy used whose calls fail.
+func (p *balancedProxy) first() bus.Proxy {
+	p.mutex.Lock()
+	current := p.current
+	p.mutex.Unlock()
+	if current != nil {
+		return current
+	}
+	for _, info := range p.session.instances(p.name) {
+		proxy, err := p.proxy(info)
+		if err != nil {
+			continue
+		}
+		p.mutex.Lock()
+		defer p.mutex.Unlock()
+		if p.current == nil {
+			p.current = proxy
+			p.currentKey = instanceKey{info.Name, info.ServiceId}
+		}
+		return p.current
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.last
+}
+
+// CallID calls the instances until one receives the call. A call
+// interrupted by a disconnection is not sent again since it may have
+// been executed.
+func (p *balancedProxy) CallID(action uint32, payload []byte) ([]byte, error) {
+	err := fmt.Errorf("Service not found: %s", p.name)
+	for _, info := range p.session.instances(p.name) {
+		proxy, err2 := p.proxy(info)
+		if err2 != nil {
+			err = err2
+			continue
+		}
+		p.session.track(info, 1)
+		response, err2 := proxy.CallID(action, payload)
+		p.session.track(info, -1)
+		if bus.IsConnectionError(err2) {
+			p.drop(info)
+			err = err2
+			continue
+		}
+		if bus.IsDisconnected(err2) {
+			p.drop(info)
+		}
+		return response, err2
+	}
+	return nil, err
+}
+
+func (p *balancedProxy) Call(action string, payload []byte) ([]byte, error) {
+	id, err := p.MethodID(action)
+	if err != nil {
+		return nil, err
+	}
+	return p.CallID(id, payload)
+}
+
+func (p *balancedProxy) Subscribe(action string) (func(), chan []byte, error) {
+	return p.first().Subscribe(action)
+}
+
+func (p *balancedProxy) SubscribeID(action uint32) (func(), chan []byte, error) {
+	return p.first().SubscribeID(action)
+}
+
+func (p *balancedProxy) MethodID(name string) (uint32, error) {
+	return p.first().MethodID(name)
+}
+
+func (p *balancedProxy) SignalID(name string) (uint32, error) {
+	return p.first().SignalID(name)
+}
+
+func (p *balancedProxy) PropertyID(name string) (uint32, error) {
+	return p.first().PropertyID(name)
+}
+
+func (p *balancedProxy) ServiceID() uint32 {
+	return p.first().ServiceID()
+}
+
+func (p *balancedProxy) ObjectID() uint32 {
+	return p.objectID
+}
+
+func (p *balancedProxy) OnDisconnect(cb func(error)) error {
+	return p.first().OnDisconnect(cb)
+}
+
+func (p *balancedProxy) ProxyService(sess bus.Session) bus.Service {
+	return p.first().ProxyService(sess)
+}
@@ -0,0 +1,214 @@
+package session
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/lugu/qiloop/bus"
+	dir "github.com/lugu/qiloop/bus/directory"
+	"github.com/lugu/qiloop/bus/net"
+	"github.com/lugu/qiloop/bus/services"
+	"github.com/lugu/qiloop/bus/util"
+	"github.com/lugu/qiloop/examples/space"
+)
+
+func TestInstancesOrder(t *testing.T) {
+	s := &Session{
+		serviceList: []services.ServiceInfo{
+			{Name: "A", ServiceId: 2, MachineId: "remote"},
+			{Name: "B", ServiceId: 3, MachineId: "remote"},
+			{Name: "A", ServiceId: 4, MachineId: util.MachineID()},
+			{Name: "A", ServiceId: 5, MachineId: "remote"},
+		},
+		inFlight: make(map[instanceKey]int),
+		next:     make(map[string]int),
+	}
+	ids := func() (ids []uint32) {
+		for _, info := range s.instances("A") {
+			ids = append(ids, info.ServiceId)
+		}
+		return ids
+	}
+	expect := func(expected ...uint32) {
+		observed := ids()
+		if fmt.Sprint(observed) != fmt.Sprint(expected) {
+			t.Errorf("policy %d: unexpected order %v (expected %v)",
+				s.balancing, observed, expected)
+		}
+	}
+	expect(2, 4, 5)
+	s.balancing = RoundRobin
+	expect(2, 4, 5)
+	expect(4, 5, 2)
+	expect(5, 2, 4)
+	expect(2, 4, 5)
+	s.balancing = Locality
+	expect(4, 2, 5)
+	s.balancing = LeastInFlight
+	s.track(services.ServiceInfo{Name: "A", ServiceId: 2}, 1)
+	s.track(services.ServiceInfo{Name: "A", ServiceId: 4}, 2)
+	expect(5, 2, 4)
+	s.track(services.ServiceInfo{Name: "A", ServiceId: 4}, -2)
+	expect(4, 5, 2)
+}
+
+// countProxy counts the calls and fails them with err.
+type countProxy struct {
+	bus.Proxy
+	calls int
+	err   error
+}
+
+func (p *countProxy) CallID(action uint32, payload []byte) ([]byte, error) {
+	p.calls++
+	return nil, p.err
+}
+
+func TestBalancedProxyDrop(t *testing.T) {
+	s := &Session{
+		serviceList: []services.ServiceInfo{
+			{Name: "A", ServiceId: 2},
+			{Name: "A", ServiceId: 4},
+		},
+		inFlight: make(map[instanceKey]int),
+		next:     make(map[string]int),
+	}
+	proxy2 := &countProxy{err: bus.ErrConnectionClosed}
+	proxy4 := &countProxy{}
+	p := &balancedProxy{
+		session: s,
+		name:    "A",
+		proxies: map[instanceKey]bus.Proxy{
+			{"A", 2}: proxy2,
+			{"A", 4}: proxy4,
+		},
+		current:    proxy2,
+		currentKey: instanceKey{"A", 2},
+		last:       proxy2,
+	}
+	// the call may have been executed: it is not sent again.
+	if _, err := p.CallID(1, nil); err != bus.ErrConnectionClosed {
+		t.Errorf("unexpected error: %v", err)
+	} else if proxy2.calls != 1 || proxy4.calls != 0 {
+		t.Errorf("unexpected calls: %d, %d", proxy2.calls, proxy4.calls)
+	}
+	if _, ok := p.proxies[instanceKey{"A", 2}]; ok {
+		t.Errorf("proxy not dropped")
+	}
+	if p.first() != proxy4 {
+		t.Errorf("dropped proxy still used")
+	}
+	if _, err := p.CallID(1, nil); err != nil {
+		t.Error(err)
+	} else if proxy2.calls != 1 || proxy4.calls != 1 {
+		t.Errorf("unexpected calls: %d, %d", proxy2.calls, proxy4.calls)
+	}
+}
+
+// startBomb starts a server with a bomb and registers it as an
+// instance of the service Bomb from another process.
+func startBomb(t *testing.T, directory services.ServiceDirectoryProxy,
+	delay int32) bus.Server {
+
+	addr := util.NewUnixAddr()
+	id, err := directory.RegisterService(services.ServiceInfo{
+		Name:      "Bomb",
+		MachineId: util.MachineID(),
+		ProcessId: util.ProcessID() + uint32(delay),
+		Endpoints: []string{addr},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the service ID of the bomb is the one of the directory.
+	namespace := bus.PrivateNamespace()
+	for i := uint32(1); i < id; i++ {
+		namespace.Reserve(fmt.Sprintf("reserved-%d", i))
+	}
+	server, err := bus.StandAloneServer(listener, bus.Yes{}, namespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	service, err := server.NewService("Bomb", space.NewBombObject())
+	if err != nil {
+		t.Fatal(err)
+	} else if service.ServiceID() != id {
+		t.Fatalf("unexpected service ID: %d", service.ServiceID())
+	}
+	bomb, err := space.Services(server.Session()).Bomb(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = bomb.SetDelay(delay); err != nil {
+		t.Fatal(err)
+	}
+	if err = directory.ServiceReady(id); err != nil {
+		t.Fatal(err)
+	}
+	return server
+}
+
+func TestBalancing(t *testing.T) {
+	addr := util.NewUnixAddr()
+	server, err := dir.NewServer(addr, bus.Yes{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Terminate()
+
+	sess, err := NewSession(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sess.Terminate()
+	directory, err := services.Services(sess).ServiceDirectory(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bomb1 := startBomb(t, directory, 1)
+	bomb2 := startBomb(t, directory, 2)
+	defer bomb2.Terminate()
+
+	list, err := directory.ServicesByName("Bomb")
+	if err != nil {
+		t.Fatal(err)
+	} else if len(list) != 2 {
+		t.Fatalf("unexpected instances: %v", list)
+	}
+
+	balanced, err := NewAuthSession(addr, Options{Balancing: RoundRobin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer balanced.Terminate()
+	bomb, err := space.Services(balanced).Bomb(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	delays := make(map[int32]int)
+	for i := 0; i < 4; i++ {
+		delay, err := bomb.GetDelay()
+		if err != nil {
+			t.Fatal(err)
+		}
+		delays[delay]++
+	}
+	if delays[1] != 2 || delays[2] != 2 {
+		t.Errorf("unexpected distribution: %v", delays)
+	}
+
+	// the calls to a terminated instance are sent to the other.
+	bomb1.Terminate()
+	for i := 0; i < 4; i++ {
+		delay, err := bomb.GetDelay()
+		if err != nil {
+			t.Fatal(err)
+		} else if delay != 2 {
+			t.Errorf("unexpected delay: %d", delay)
+		}
+	}
+}
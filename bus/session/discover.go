@@ -0,0 +1,28 @@
+package session
+
+import (
+	"time"
+
+	"github.com/lugu/qiloop/bus/mdns"
+)
+
+// Discover searches the local network for the service directories
+// advertised with mDNS (see directory.NewAdvertisedServer) and returns
+// their URLs. It waits for the responses during timeout.
+func Discover(timeout time.Duration) ([]string, error) {
+	entries, err := mdns.Browse(timeout)
+	if err != nil {
+		return nil, err
+	}
+	urls := make([]string, 0, len(entries))
+	known := make(map[string]bool)
+	for _, entry := range entries {
+		for _, addr := range entry.URLs {
+			if !known[addr] {
+				known[addr] = true
+				urls = append(urls, addr)
+			}
+		}
+	}
+	return urls, nil
+}
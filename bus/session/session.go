@@ -8,6 +8,7 @@ import (
 	"github.com/lugu/qiloop/bus"
 	"github.com/lugu/qiloop/bus/net"
 	"github.com/lugu/qiloop/bus/services"
+	"github.com/lugu/qiloop/bus/session/token"
 	"github.com/lugu/qiloop/type/object"
 )
 
@@ -21,10 +22,18 @@ type Session struct {
 	cancel           func()
 	added            chan services.ServiceAdded
 	removed          chan services.ServiceRemoved
-	userName         string
-	userToken        string
+	credentials      token.Credentials
+	credentialsMutex sync.Mutex
+	addr             string
 	poll             map[string]bus.Client
 	pollMutex        sync.RWMutex
+	balancing        Balancing
+	balanceMutex     sync.Mutex
+	inFlight         map[instanceKey]int
+	next             map[string]int
+	// watchers are protected by serviceListMutex (see
+	// WatchServices).
+	watchers map[*watcher]bool
 }
 
 func (s *Session) newObject(info services.ServiceInfo, ref object.ObjectReference) (bus.ObjectProxy, error) {
@@ -49,6 +58,16 @@ func (s *Session) newService(info services.ServiceInfo, objectID uint32) (p bus.
 	return proxy, nil
 }
 
+// endpoints returns the addresses of a service. The TLS options of
+// the session address are applied to the TLS addresses.
+func (s *Session) endpoints(info services.ServiceInfo) []string {
+	addrs := make([]string, len(info.Endpoints))
+	for i, addr := range info.Endpoints {
+		addrs[i] = net.WithOptions(addr, s.addr)
+	}
+	return addrs
+}
+
 // endpoint returns an net.EndPoint matching the info description. If
 // an existing connection exists, it reuse the connection, otherwise
 // it establish a new connection.
@@ -56,8 +75,9 @@ func (s *Session) client(info services.ServiceInfo) (bus.Client, error) {
 	if len(info.Endpoints) == 0 {
 		return nil, fmt.Errorf("empty address list")
 	}
+	endpoints := s.endpoints(info)
 	s.pollMutex.RLock()
-	for _, addr := range info.Endpoints {
+	for _, addr := range endpoints {
 		c, ok := s.poll[addr]
 		if ok {
 			s.pollMutex.RUnlock()
@@ -65,10 +85,17 @@ func (s *Session) client(info services.ServiceInfo) (bus.Client, error) {
 		}
 	}
 	s.pollMutex.RUnlock()
-	addr, endpoint, err := bus.SelectEndPoint(info.Endpoints, s.userName, s.userToken)
+	s.credentialsMutex.Lock()
+	creds := s.credentials
+	s.credentialsMutex.Unlock()
+	addr, endpoint, err := bus.SelectEndPoint(endpoints, &creds)
 	if err != nil {
 		return nil, fmt.Errorf("service connection error (%s): %s", info.Name, err)
 	}
+	// the server might have issued a new token.
+	s.credentialsMutex.Lock()
+	s.credentials = creds
+	s.credentialsMutex.Unlock()
 	filter := func(hdr *net.Header) (matched bool, keep bool) { return false, true }
 	consumer := func(msg *net.Message) error { panic("unexpected") }
 	closer := func(err error) {
@@ -112,12 +139,19 @@ func (s *Session) findServiceID(uid uint32) (i services.ServiceInfo, err error)
 	return i, fmt.Errorf("Service ID not found: %d", uid)
 }
 
-// Proxy resolve the service name and returns a proxy to it.
+// Proxy resolve the service name and returns a proxy to it. If
+// several instances of the service are registered or if a balancing
+// policy is set, the calls are distributed among the instances (see
+// Options.Balancing).
 func (s *Session) Proxy(name string, objectID uint32) (p bus.Proxy, err error) {
 	info, err := s.findServiceName(name)
 	if err != nil {
 		return p, err
 	}
+	if s.balancing != FirstInstance ||
+		len(s.findServiceInstances(name)) > 1 {
+		return s.newBalancedProxy(name, objectID)
+	}
 	return s.newService(info, objectID)
 }
 
@@ -142,13 +176,32 @@ func metaProxy(c bus.Client, serviceID, objectID uint32) (p bus.Proxy, err error
 	return bus.NewProxy(c, meta, serviceID, objectID), nil
 }
 
+// Options configures a session.
+type Options struct {
+	// Credentials are used to authenticate to the services. If nil,
+	// the credentials of the profile of the session address are
+	// used (see token.Lookup).
+	Credentials *token.Credentials
+	// Balancing selects the instance of a service called when
+	// several instances share the same name.
+	Balancing Balancing
+}
+
 // NewAuthSession connects an address and return a new session.
-func NewAuthSession(addr, user, token string) (bus.Session, error) {
+func NewAuthSession(addr string, opts Options) (bus.Session, error) {
 
 	s := new(Session)
-	s.userName = user
-	s.userToken = token
+	if opts.Credentials != nil {
+		s.credentials = *opts.Credentials
+	} else {
+		s.credentials = token.Lookup(addr)
+	}
+	s.addr = addr
 	s.poll = map[string]bus.Client{}
+	s.balancing = opts.Balancing
+	s.inFlight = make(map[instanceKey]int)
+	s.next = make(map[string]int)
+	s.watchers = make(map[*watcher]bool)
 	// Manually create a serviceList with just the ServiceInfo
 	// needed to contact ServiceDirectory.
 	s.serviceList = []services.ServiceInfo{
@@ -166,10 +219,8 @@ func NewAuthSession(addr, user, token string) (bus.Session, error) {
 		return nil, fmt.Errorf("contact server: %s", err)
 	}
 
-	s.serviceList, err = s.Directory.Services()
-	if err != nil {
-		return nil, fmt.Errorf("list services: %s", err)
-	}
+	// subscribe before listing the services: the services
+	// registered meanwhile are merged by updateServiceList.
 	var cancelRemoved, cancelAdded func()
 	cancelRemoved, s.removed, err = s.Directory.SubscribeServiceRemoved()
 	if err != nil {
@@ -177,21 +228,29 @@ func NewAuthSession(addr, user, token string) (bus.Session, error) {
 	}
 	cancelAdded, s.added, err = s.Directory.SubscribeServiceAdded()
 	if err != nil {
+		cancelRemoved()
 		return nil, fmt.Errorf("subscribe added signal: %s", err)
 	}
 	s.cancel = func() {
 		cancelRemoved()
 		cancelAdded()
 	}
+	s.serviceList, err = s.Directory.Services()
+	if err != nil {
+		s.cancel()
+		return nil, fmt.Errorf("list services: %s", err)
+	}
 	go s.updateLoop()
 	return s, nil
 }
 
 // NewSession connects an address and return a new session.
 func NewSession(addr string) (bus.Session, error) {
-	return NewAuthSession(addr, "", "")
+	return NewAuthSession(addr, Options{})
 }
 
+// updateServiceList refreshes the list of services and notifies the
+// watchers of the changes.
 func (s *Session) updateServiceList() {
 	services, err := s.Directory.Services()
 	if err != nil {
@@ -200,10 +259,14 @@ func (s *Session) updateServiceList() {
 		if err := s.Terminate(); err != nil {
 			log.Printf("error: session destruction: %s", err)
 		}
+		return
 	}
 	s.serviceListMutex.Lock()
+	defer s.serviceListMutex.Unlock()
+	for _, event := range changes(s.serviceList, services) {
+		s.notify(event)
+	}
 	s.serviceList = services
-	s.serviceListMutex.Unlock()
 }
 
 // Terminate close the session.
@@ -213,6 +276,7 @@ func (s *Session) Terminate() error {
 }
 
 func (s *Session) updateLoop() {
+	defer s.closeWatchers()
 	for {
 		select {
 		case _, ok := <-s.removed:
@@ -1,10 +1,19 @@
 package session
 
 import (
+	"io/ioutil"
+	gonet "net"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/lugu/qiloop/bus"
 	dir "github.com/lugu/qiloop/bus/directory"
+	"github.com/lugu/qiloop/bus/internal/testcert"
+	"github.com/lugu/qiloop/bus/mdns"
+	"github.com/lugu/qiloop/bus/services"
+	"github.com/lugu/qiloop/bus/session/token"
 	"github.com/lugu/qiloop/bus/util"
 )
 
@@ -36,3 +45,178 @@ func TestNewSessionError(t *testing.T) {
 		t.Fatal("expecting an error")
 	}
 }
+
+func TestSessionCredentials(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "qiloop-profiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	defer func(name string) {
+		token.ProfileFile = name
+	}(token.ProfileFile)
+	token.ProfileFile = filepath.Join(tmp, "profiles.conf")
+
+	addr := util.NewUnixAddr()
+	login := bus.Dictionary(map[string]string{"nao": "secret"})
+	store := bus.NewTokenStore(login, time.Hour, 0)
+	server, err := dir.NewServer(addr, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Terminate()
+
+	_, err = NewAuthSession(addr, Options{
+		Credentials: &token.Credentials{User: "nao", Token: "wrong"},
+	})
+	if err == nil {
+		t.Fatal("expecting an error")
+	}
+	sess, err := NewAuthSession(addr, Options{
+		Credentials: &token.Credentials{User: "nao", Token: "secret"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess.Terminate()
+
+	// the token issued by the server is saved in the profile.
+	err = token.WriteProfile(token.Credentials{
+		User:    "nao",
+		Token:   "secret",
+		Profile: token.ProfileKey(addr),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess, err = NewSession(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess.Terminate()
+	c := token.Lookup(addr)
+	if c.User != "nao" || c.Token == "secret" {
+		t.Fatalf("token not saved: %#v", c)
+	}
+	sess, err = NewSession(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess.Terminate()
+}
+
+func TestCertificateSession(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "qiloop-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	ca, err := testcert.GenerateAuthority("test CA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	write := func(name string, hosts ...string) {
+		c, err := testcert.GenerateSigned(ca, name, hosts...)
+		if err == nil {
+			err = testcert.WriteCertificate(c, filepath.Join(tmp, name+".pem"),
+				filepath.Join(tmp, name+".key"))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("server", "localhost")
+	write("client")
+	write("intruder")
+	err = testcert.WriteCertificate(ca, filepath.Join(tmp, "ca.pem"),
+		filepath.Join(tmp, "ca.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := func(name string) string {
+		return "cert=" + filepath.Join(tmp, name+".pem") +
+			"&key=" + filepath.Join(tmp, name+".key") +
+			"&ca=" + filepath.Join(tmp, "ca.pem")
+	}
+
+	addr := "tcps://localhost:54333"
+	server, err := dir.NewServer(addr+"?"+options("server"),
+		bus.CertificateSubjects("CN=client"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Terminate()
+	// the clients authenticated by certificate can not claim a
+	// user name.
+	server.SetAuthorizer(bus.Policy{
+		{Allow: false, Principal: "user:nao", Service: "*", Action: "*"},
+		{Allow: true, Principal: "cert:CN=client", Service: "*", Action: "*"},
+	})
+
+	sess, err := NewAuthSession(addr+"?"+options("client"), Options{
+		Credentials: &token.Credentials{User: "nao"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sess.Terminate()
+	// the service directory advertises the address without the
+	// options: the session adds its own.
+	_, err = sess.Proxy("ServiceDirectory", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = NewSession(addr + "?" + options("intruder"))
+	if err == nil {
+		t.Fatal("expecting an error")
+	}
+}
+
+func TestFaultySession(t *testing.T) {
+	addr := util.NewUnixAddr()
+	server, err := dir.NewServer(addr, bus.Yes{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Terminate()
+
+	sess, err := NewSession("faulty://" + addr +
+		"?seed=2&latency=2ms&jitter=2ms&duplicate=0.3&types=reply,event")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sess.Terminate()
+	directory, err := services.Services(sess).ServiceDirectory(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if _, err := directory.Services(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	mdns.Group = &gonet.UDPAddr{IP: gonet.IPv4(224, 0, 0, 251), Port: 55354}
+	addr := "tcp://127.0.0.1:54361"
+	server, err := dir.NewAdvertisedServer(addr, bus.Yes{})
+	if err != nil {
+		t.Skipf("advertise: %s", err)
+	}
+	defer server.Terminate()
+
+	urls, err := Discover(500 * time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(urls) != 1 {
+		t.Fatalf("unexpected URLs: %v", urls)
+	}
+	sess, err := NewSession(urls[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess.Terminate()
+}
@@ -0,0 +1,176 @@
+package token
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ProfileFile contains the credentials of each server. The format is
+// one profile per line:
+//
+//	<profile> <user> [<token>]
+//
+// The profile is the scheme and the host of the server URL (for
+// example tcp://192.168.1.2:9559) or DefaultProfile. Empty lines and
+// lines starting with # are ignored.
+var ProfileFile = ".qiloop-profiles.conf"
+
+// DefaultProfile is the profile used when no profile matches the
+// server URL.
+const DefaultProfile = "default"
+
+// Environment variables which override the profiles.
+const (
+	EnvUser  = "QILOOP_USER"
+	EnvToken = "QILOOP_TOKEN"
+)
+
+func init() {
+	usr, err := user.Current()
+	if err == nil {
+		ProfileFile = filepath.Join(usr.HomeDir, ProfileFile)
+	}
+}
+
+// Credentials are used to authenticate to a server.
+type Credentials struct {
+	User  string
+	Token string
+	// Profile is the profile from which the credentials are read.
+	// The tokens issued by a server are saved in this profile. An
+	// empty profile is not saved.
+	Profile string
+}
+
+// ProfileKey returns the profile associated with a server URL: its
+// scheme and its host. The URL of a UNIX socket is returned as is.
+func ProfileKey(addr string) string {
+	u, err := url.Parse(addr)
+	if err != nil || u.Host == "" {
+		return addr
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// ReadProfiles returns the credentials of the profile file indexed by
+// profile. A missing file contains no profile.
+func ReadProfiles() (map[string]Credentials, error) {
+	profiles := make(map[string]Credentials)
+	file, err := os.Open(ProfileFile)
+	if os.IsNotExist(err) {
+		return profiles, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("read profiles: %s", err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 && len(fields) != 3 {
+			return nil, fmt.Errorf("%s: line %d: invalid profile",
+				ProfileFile, line)
+		}
+		c := Credentials{
+			User:    fields[1],
+			Profile: fields[0],
+		}
+		if len(fields) == 3 {
+			c.Token = fields[2]
+		}
+		profiles[c.Profile] = c
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read profiles: %s", err)
+	}
+	return profiles, nil
+}
+
+// profileMutex serializes the updates of the profile file.
+var profileMutex sync.Mutex
+
+// WriteProfile saves the credentials in the profile c.Profile. The
+// profile file is replaced: the readers never see a partially written
+// file.
+func WriteProfile(c Credentials) error {
+	if c.Profile == "" || strings.ContainsAny(c.Profile+c.User+c.Token,
+		" \t\n") || c.User == "" {
+		return fmt.Errorf("invalid profile: %q", c.Profile)
+	}
+	profileMutex.Lock()
+	defer profileMutex.Unlock()
+	profiles, err := ReadProfiles()
+	if err != nil {
+		return err
+	}
+	profiles[c.Profile] = c
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf strings.Builder
+	for _, name := range names {
+		p := profiles[name]
+		fmt.Fprintf(&buf, "%s %s %s\n", p.Profile, p.User, p.Token)
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(ProfileFile), ".qiloop-profiles")
+	if err != nil {
+		return fmt.Errorf("write profile: %s", err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.WriteString(buf.String())
+	if err == nil {
+		err = tmp.Chmod(0600)
+	}
+	if err2 := tmp.Close(); err == nil {
+		err = err2
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), ProfileFile)
+	}
+	if err != nil {
+		return fmt.Errorf("write profile: %s", err)
+	}
+	return nil
+}
+
+// Lookup returns the credentials to authenticate to the server at
+// addr. By order of priority, the credentials are read from:
+//   - the environment variables QILOOP_USER and QILOOP_TOKEN,
+//   - the profile of the server URL,
+//   - the default profile,
+//   - AuthFile, in which case the default profile is used to save
+//     the new tokens.
+func Lookup(addr string) Credentials {
+	login, token := os.Getenv(EnvUser), os.Getenv(EnvToken)
+	if login != "" || token != "" {
+		return Credentials{User: login, Token: token}
+	}
+	profiles, err := ReadProfiles()
+	if err == nil {
+		if c, ok := profiles[ProfileKey(addr)]; ok {
+			return c
+		}
+		if c, ok := profiles[DefaultProfile]; ok {
+			return c
+		}
+	}
+	login, token = GetUserToken()
+	return Credentials{
+		User:    login,
+		Token:   token,
+		Profile: DefaultProfile,
+	}
+}
@@ -0,0 +1,143 @@
+package token_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/lugu/qiloop/bus/session/token"
+)
+
+// tempFiles redirects the profile file and the auth file to a
+// temporary directory.
+func tempFiles(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "qiloop-profiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	profileFile, authFile := token.ProfileFile, token.AuthFile
+	token.ProfileFile = filepath.Join(dir, "profiles.conf")
+	token.AuthFile = filepath.Join(dir, "auth.conf")
+	os.Unsetenv(token.EnvUser)
+	os.Unsetenv(token.EnvToken)
+	return func() {
+		token.ProfileFile, token.AuthFile = profileFile, authFile
+		os.RemoveAll(dir)
+	}
+}
+
+func TestProfileKey(t *testing.T) {
+	keys := map[string]string{
+		"tcp://localhost:9559":              "tcp://localhost:9559",
+		"tcps://10.0.0.2:9443?ca=ca.pem":    "tcps://10.0.0.2:9443",
+		"unix:///tmp/qiloop.sock":           "unix:///tmp/qiloop.sock",
+		"quic://robot.local:9443/some/path": "quic://robot.local:9443",
+	}
+	for addr, key := range keys {
+		if k := token.ProfileKey(addr); k != key {
+			t.Errorf("%s: unexpected key %s", addr, k)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	defer tempFiles(t)()
+
+	if c := token.Lookup("tcp://robot1:9559"); c.User != "" ||
+		c.Token != "" || c.Profile != token.DefaultProfile {
+		t.Errorf("unexpected credentials: %#v", c)
+	}
+	if err := token.WriteUserToken("legacy", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if c := token.Lookup("tcp://robot1:9559"); c.User != "legacy" ||
+		c.Token != "secret" {
+		t.Errorf("auth file ignored: %#v", c)
+	}
+
+	profiles := []token.Credentials{
+		{User: "nao", Token: "default", Profile: token.DefaultProfile},
+		{User: "nao", Token: "robot1", Profile: "tcp://robot1:9559"},
+		{User: "admin", Token: "robot2", Profile: "tcp://robot2:9559"},
+	}
+	for _, c := range profiles {
+		if err := token.WriteProfile(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := token.WriteProfile(token.Credentials{User: "a b",
+		Profile: "tcp://robot3:9559"}); err == nil {
+		t.Errorf("invalid profile saved")
+	}
+	read, err := token.ReadProfiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read) != 3 || read["tcp://robot2:9559"] != profiles[2] {
+		t.Errorf("unexpected profiles: %v", read)
+	}
+	tests := map[string]token.Credentials{
+		"tcp://robot1:9559":       profiles[1],
+		"tcp://robot2:9559?x=1":   profiles[2],
+		"tcp://robot1:9560":       profiles[0],
+		"unix:///tmp/qiloop.sock": profiles[0],
+	}
+	for addr, expected := range tests {
+		if c := token.Lookup(addr); c != expected {
+			t.Errorf("%s: unexpected credentials: %#v", addr, c)
+		}
+	}
+
+	os.Setenv(token.EnvUser, "env")
+	defer os.Unsetenv(token.EnvUser)
+	c := token.Lookup("tcp://robot1:9559")
+	if c.User != "env" || c.Token != "" || c.Profile != "" {
+		t.Errorf("environment ignored: %#v", c)
+	}
+}
+
+func TestWriteProfileConcurrent(t *testing.T) {
+	defer tempFiles(t)()
+	err := token.WriteProfile(token.Credentials{
+		Profile: token.DefaultProfile,
+		User:    "nao",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wait sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wait.Add(2)
+		go func(i int) {
+			defer wait.Done()
+			err := token.WriteProfile(token.Credentials{
+				Profile: fmt.Sprintf("tcp://robot%d:9559", i),
+				User:    "nao",
+				Token:   fmt.Sprintf("token%d", i),
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}(i)
+		// the readers never see a truncated file.
+		go func() {
+			defer wait.Done()
+			profiles, err := token.ReadProfiles()
+			if err != nil {
+				t.Error(err)
+			} else if _, ok := profiles[token.DefaultProfile]; !ok {
+				t.Errorf("default profile missing")
+			}
+		}()
+	}
+	wait.Wait()
+	profiles, err := token.ReadProfiles()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(profiles) != 21 {
+		t.Errorf("profiles lost: %v", profiles)
+	}
+}
@@ -0,0 +1,282 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/lugu/qiloop/bus"
+	"github.com/lugu/qiloop/bus/services"
+)
+
+// ServiceEventType distinguishes the services appearing from the
+// services disappearing.
+type ServiceEventType int
+
+const (
+	// ServiceAvailable is sent when a service is ready.
+	ServiceAvailable ServiceEventType = iota
+	// ServiceUnavailable is sent when a service is unregistered.
+	ServiceUnavailable
+)
+
+// ServiceEvent describes a change of the services of the directory.
+// The Info of an unavailable service is the last one known by the
+// session: at least its name and its ID are set.
+type ServiceEvent struct {
+	Type ServiceEventType
+	Info services.ServiceInfo
+}
+
+// watcher queues the events of a WatchServices channel so the
+// session is never blocked by a slow reader.
+type watcher struct {
+	events chan ServiceEvent
+	wake   chan struct{}
+	mutex  sync.Mutex
+	queue  []ServiceEvent
+	closed bool
+}
+
+func newWatcher() *watcher {
+	return &watcher{
+		events: make(chan ServiceEvent),
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+func (w *watcher) push(event ServiceEvent) {
+	w.mutex.Lock()
+	w.queue = append(w.queue, event)
+	w.mutex.Unlock()
+	w.signal()
+}
+
+// close stops the watcher once the queued events are delivered.
+func (w *watcher) close() {
+	w.mutex.Lock()
+	w.closed = true
+	w.mutex.Unlock()
+	w.signal()
+}
+
+func (w *watcher) signal() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// pop returns the next event. ok is false when the queue is empty and
+// closed is true if no event will be pushed anymore.
+func (w *watcher) pop() (event ServiceEvent, ok, closed bool) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if len(w.queue) == 0 {
+		return event, false, w.closed
+	}
+	event = w.queue[0]
+	w.queue = w.queue[1:]
+	return event, true, false
+}
+
+// run delivers the events until ctx is done or the watcher is closed.
+func (w *watcher) run(ctx context.Context, done func()) {
+	defer close(w.events)
+	defer done()
+	for {
+		event, ok, closed := w.pop()
+		if closed {
+			return
+		}
+		if !ok {
+			select {
+			case <-w.wake:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+		select {
+		case w.events <- event:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// changes returns the events describing the transition from the list
+// of services previous to the list current.
+func changes(previous, current []services.ServiceInfo) []ServiceEvent {
+	key := func(info services.ServiceInfo) instanceKey {
+		return instanceKey{info.Name, info.ServiceId}
+	}
+	known := make(map[instanceKey]bool, len(previous))
+	for _, info := range previous {
+		known[key(info)] = true
+	}
+	events := make([]ServiceEvent, 0)
+	for _, info := range current {
+		if !known[key(info)] {
+			events = append(events, ServiceEvent{ServiceAvailable, info})
+		}
+		delete(known, key(info))
+	}
+	for _, info := range previous {
+		if known[key(info)] {
+			events = append(events, ServiceEvent{ServiceUnavailable, info})
+		}
+	}
+	return events
+}
+
+// WatchServices returns a channel of the changes of the services of
+// sess. It starts with a ServiceAvailable event for each service
+// already registered. The channel is closed when ctx is done or when
+// the session terminates.
+func WatchServices(ctx context.Context, sess bus.Session) <-chan ServiceEvent {
+	if s, ok := sess.(*Session); ok {
+		return s.WatchServices(ctx)
+	}
+	return watchDirectory(ctx, sess)
+}
+
+// WaitForService blocks until a service named name is available in
+// sess and returns its description. It fails if ctx is done or if the
+// session terminates before.
+func WaitForService(ctx context.Context, sess bus.Session, name string) (
+	services.ServiceInfo, error) {
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for event := range WatchServices(ctx, sess) {
+		if event.Type == ServiceAvailable && event.Info.Name == name {
+			return event.Info, nil
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return services.ServiceInfo{}, fmt.Errorf("wait for %s: %s",
+			name, err)
+	}
+	return services.ServiceInfo{}, fmt.Errorf("wait for %s: session terminated",
+		name)
+}
+
+// watchDirectory watches the services with the signals of the service
+// directory. It is used by the sessions which do not maintain a list
+// of the services (such as the sessions of the servers).
+func watchDirectory(ctx context.Context, sess bus.Session) <-chan ServiceEvent {
+	w := newWatcher()
+	directory, err := services.Services(sess).ServiceDirectory(nil)
+	if err != nil {
+		log.Printf("watch services: %s", err)
+		w.close()
+		go w.run(ctx, func() {})
+		return w.events
+	}
+	cancelRemoved, removed, err := directory.SubscribeServiceRemoved()
+	if err != nil {
+		log.Printf("watch services: %s", err)
+		w.close()
+		go w.run(ctx, func() {})
+		return w.events
+	}
+	cancelAdded, added, err := directory.SubscribeServiceAdded()
+	if err != nil {
+		log.Printf("watch services: %s", err)
+		cancelRemoved()
+		w.close()
+		go w.run(ctx, func() {})
+		return w.events
+	}
+	stop := make(chan struct{})
+	go w.run(ctx, func() {
+		close(stop)
+		cancelRemoved()
+		cancelAdded()
+	})
+	go func() {
+		defer w.close()
+		var list []services.ServiceInfo
+		update := func() bool {
+			current, err := directory.Services()
+			if err != nil {
+				return false
+			}
+			for _, event := range changes(list, current) {
+				w.push(event)
+			}
+			list = current
+			return true
+		}
+		if !update() {
+			return
+		}
+		for {
+			select {
+			case _, ok := <-removed:
+				if !ok || !update() {
+					return
+				}
+			case _, ok := <-added:
+				if !ok || !update() {
+					return
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return w.events
+}
+
+// WatchServices returns a channel of the changes of the services. It
+// starts with a ServiceAvailable event for each service already
+// registered. The channel is closed when ctx is done or when the
+// session terminates.
+func (s *Session) WatchServices(ctx context.Context) <-chan ServiceEvent {
+	w := newWatcher()
+	s.serviceListMutex.Lock()
+	for _, info := range s.serviceList {
+		w.push(ServiceEvent{ServiceAvailable, info})
+	}
+	if s.watchers == nil {
+		w.close()
+	} else {
+		s.watchers[w] = true
+	}
+	s.serviceListMutex.Unlock()
+	go w.run(ctx, func() {
+		s.serviceListMutex.Lock()
+		delete(s.watchers, w)
+		s.serviceListMutex.Unlock()
+	})
+	return w.events
+}
+
+// WaitForService blocks until a service named name is available and
+// returns its description. It fails if ctx is done or if the session
+// terminates before.
+func (s *Session) WaitForService(ctx context.Context, name string) (
+	services.ServiceInfo, error) {
+	return WaitForService(ctx, s, name)
+}
+
+// notify sends an event to the watchers. It must be called with
+// serviceListMutex locked.
+func (s *Session) notify(event ServiceEvent) {
+	for w := range s.watchers {
+		w.push(event)
+	}
+}
+
+// closeWatchers closes the watchers when the session terminates.
+func (s *Session) closeWatchers() {
+	s.serviceListMutex.Lock()
+	defer s.serviceListMutex.Unlock()
+	for w := range s.watchers {
+		w.close()
+	}
+	s.watchers = nil
+}
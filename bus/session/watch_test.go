@@ -0,0 +1,135 @@
+package session
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/lugu/qiloop/bus"
+	dir "github.com/lugu/qiloop/bus/directory"
+	"github.com/lugu/qiloop/bus/services"
+	"github.com/lugu/qiloop/bus/util"
+	"github.com/lugu/qiloop/examples/space"
+)
+
+func TestWatchServices(t *testing.T) {
+	addr := util.NewUnixAddr()
+	server, err := dir.NewServer(addr, bus.Yes{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Terminate()
+
+	sess, err := NewSession(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := WatchServices(ctx, sess)
+	next := func() ServiceEvent {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				t.Fatal("unexpected close")
+			}
+			return event
+		case <-time.After(5 * time.Second):
+			t.Fatal("missing event")
+			return ServiceEvent{}
+		}
+	}
+	event := next()
+	if event.Type != ServiceAvailable ||
+		event.Info.Name != "ServiceDirectory" {
+		t.Errorf("unexpected event: %#v", event)
+	}
+
+	waited := make(chan error)
+	go func() {
+		info, err := WaitForService(context.Background(), sess, "Bomb")
+		if err == nil && info.Name != "Bomb" {
+			t.Errorf("unexpected service: %#v", info)
+		}
+		waited <- err
+	}()
+
+	service, err := server.NewService("Bomb", space.NewBombObject())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = <-waited; err != nil {
+		t.Fatal(err)
+	}
+	event = next()
+	if event.Type != ServiceAvailable || event.Info.Name != "Bomb" ||
+		event.Info.ServiceId != service.ServiceID() {
+		t.Errorf("unexpected event: %#v", event)
+	}
+
+	if err = service.Terminate(); err != nil {
+		t.Fatal(err)
+	}
+	event = next()
+	if event.Type != ServiceUnavailable || event.Info.Name != "Bomb" ||
+		len(event.Info.Endpoints) == 0 {
+		t.Errorf("unexpected event: %#v", event)
+	}
+
+	cancel()
+	for range events {
+	}
+
+	timeout, cancel := context.WithTimeout(context.Background(),
+		50*time.Millisecond)
+	defer cancel()
+	if _, err = WaitForService(timeout, sess, "Bomb"); err == nil {
+		t.Errorf("shall fail")
+	}
+
+	// the session termination closes the channels.
+	events = WatchServices(context.Background(), sess)
+	sess.Terminate()
+	for range events {
+	}
+}
+
+func TestWatchServerServices(t *testing.T) {
+	addr := util.NewUnixAddr()
+	server, err := dir.NewServer(addr, bus.Yes{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Terminate()
+
+	ctx, cancel := context.WithTimeout(context.Background(),
+		5*time.Second)
+	defer cancel()
+	waited := make(chan error)
+	go func() {
+		_, err := WaitForService(ctx, server.Session(), "Bomb")
+		waited <- err
+	}()
+	if _, err = server.NewService("Bomb", space.NewBombObject()); err != nil {
+		t.Fatal(err)
+	}
+	if err = <-waited; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestChanges(t *testing.T) {
+	a := services.ServiceInfo{Name: "A", ServiceId: 2}
+	b := services.ServiceInfo{Name: "B", ServiceId: 3}
+	c := services.ServiceInfo{Name: "C", ServiceId: 4}
+	events := changes([]services.ServiceInfo{a, b},
+		[]services.ServiceInfo{b, c})
+	expected := []ServiceEvent{
+		{ServiceAvailable, c},
+		{ServiceUnavailable, a},
+	}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("unexpected events: %v", events)
+	}
+}
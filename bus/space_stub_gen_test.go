@@ -509,7 +509,7 @@ func (p *proxySpacecraft) Shoot() (BombProxy, error) {
 		if err != nil {
 			return nil, fmt.Errorf("get meta: %s", err)
 		}
-		proxy, err := p.session.Object(ref)
+		proxy, err := bus.ObjectFrom(p.session, p.ObjectProxy, ref)
 		if err != nil {
 			return nil, fmt.Errorf("get proxy: %s", err)
 		}
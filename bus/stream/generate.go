@@ -0,0 +1,3 @@
+//go:generate go run ../../meta/cmd/stub --idl stream.idl --output stream_stub_gen.go
+
+package stream
@@ -0,0 +1,234 @@
+// Package stream transfers data larger than the maximum size of a
+// message.
+//
+// The data is exchanged in chunks with a Stream object: the receiver
+// pulls the chunks of a reader object and the sender pushes the chunks
+// to a writer object. Each chunk is acknowledged before the next one
+// is sent which limits the memory used by a transfer.
+//
+// A service gives a stream to its clients by returning (as an obj) the
+// object created with ReaderObject or WriterObject. The client creates
+// a proxy with MakeStream and uses Reader or Writer to access it. The
+// generated proxies and stubs do not expose the streams as io.Reader
+// and io.Writer: the objects are wired with these helpers.
+package stream
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/lugu/qiloop/bus"
+	"github.com/lugu/qiloop/bus/net"
+)
+
+// ChunkSize is the size of the chunks transferred by Reader and Writer.
+var ChunkSize = 1024 * 1024
+
+// MaxChunkSize is the size of the largest chunk which can be pulled:
+// the chunk is sent in a reply with its 4 bytes length prefix.
+const MaxChunkSize = net.MaxPayloadSize - 4
+
+// ErrWrongDirection is returned when pulling from a writer object or
+// pushing to a reader object.
+var ErrWrongDirection = errors.New("wrong stream direction")
+
+// ErrClosed is returned when the stream is used after Finish.
+var ErrClosed = errors.New("stream closed")
+
+// readerObject implements StreamImplementor with an io.ReadCloser.
+type readerObject struct {
+	sync.Mutex
+	reader io.ReadCloser
+	seq    uint32
+	last   []byte
+	closed bool
+}
+
+// ReaderObject returns an object whose chunks are read from r. r is
+// closed when the object is finished or terminated.
+func ReaderObject(r io.ReadCloser) bus.Actor {
+	return StreamObject(&readerObject{reader: r})
+}
+
+func (o *readerObject) Activate(activation bus.Activation,
+	helper StreamSignalHelper) error {
+	return nil
+}
+
+func (o *readerObject) OnTerminate() {
+	o.Finish()
+}
+
+// Pull returns the chunk seq. The previous chunk can be requested
+// again in case the response was lost.
+func (o *readerObject) Pull(seq uint32, size int32) ([]byte, error) {
+	o.Lock()
+	defer o.Unlock()
+	if o.closed {
+		return nil, ErrClosed
+	}
+	if seq+1 == o.seq {
+		return o.last, nil
+	}
+	if seq != o.seq {
+		return nil, fmt.Errorf("unexpected chunk %d, expecting %d",
+			seq, o.seq)
+	}
+	if size <= 0 || uint32(size) > MaxChunkSize {
+		return nil, fmt.Errorf("invalid chunk size: %d", size)
+	}
+	data := make([]byte, size)
+	count, err := io.ReadFull(o.reader, data)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("read chunk %d: %s", seq, err)
+	}
+	o.seq++
+	o.last = data[:count]
+	return o.last, nil
+}
+
+func (o *readerObject) Push(seq uint32, data []byte) error {
+	return ErrWrongDirection
+}
+
+func (o *readerObject) Finish() error {
+	o.Lock()
+	defer o.Unlock()
+	if o.closed {
+		return nil
+	}
+	o.closed = true
+	o.last = nil
+	return o.reader.Close()
+}
+
+// writerObject implements StreamImplementor with an io.WriteCloser.
+type writerObject struct {
+	sync.Mutex
+	writer io.WriteCloser
+	seq    uint32
+	closed bool
+}
+
+// WriterObject returns an object whose chunks are written to w. w is
+// closed when the object is finished or terminated.
+func WriterObject(w io.WriteCloser) bus.Actor {
+	return StreamObject(&writerObject{writer: w})
+}
+
+func (o *writerObject) Activate(activation bus.Activation,
+	helper StreamSignalHelper) error {
+	return nil
+}
+
+func (o *writerObject) OnTerminate() {
+	o.Finish()
+}
+
+func (o *writerObject) Pull(seq uint32, size int32) ([]byte, error) {
+	return nil, ErrWrongDirection
+}
+
+// Push writes the chunk seq. A chunk already written is ignored in
+// case its acknowledgment was lost.
+func (o *writerObject) Push(seq uint32, data []byte) error {
+	o.Lock()
+	defer o.Unlock()
+	if o.closed {
+		return ErrClosed
+	}
+	if seq+1 == o.seq {
+		return nil
+	}
+	if seq != o.seq {
+		return fmt.Errorf("unexpected chunk %d, expecting %d",
+			seq, o.seq)
+	}
+	if _, err := o.writer.Write(data); err != nil {
+		return fmt.Errorf("write chunk %d: %s", seq, err)
+	}
+	o.seq++
+	return nil
+}
+
+func (o *writerObject) Finish() error {
+	o.Lock()
+	defer o.Unlock()
+	if o.closed {
+		return nil
+	}
+	o.closed = true
+	return o.writer.Close()
+}
+
+// reader pulls the chunks of a remote stream.
+type reader struct {
+	stream Stream
+	seq    uint32
+	chunk  []byte
+	eof    bool
+}
+
+// Reader returns an io.ReadCloser which pulls the chunks of a reader
+// object. Close finishes the remote stream.
+func Reader(s Stream) io.ReadCloser {
+	return &reader{stream: s}
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	for len(r.chunk) == 0 {
+		if r.eof {
+			return 0, io.EOF
+		}
+		chunk, err := r.stream.Pull(r.seq, int32(ChunkSize))
+		if err != nil {
+			return 0, err
+		}
+		r.seq++
+		r.chunk = chunk
+		r.eof = len(chunk) == 0
+	}
+	count := copy(p, r.chunk)
+	r.chunk = r.chunk[count:]
+	return count, nil
+}
+
+func (r *reader) Close() error {
+	return r.stream.Finish()
+}
+
+// writer pushes chunks to a remote stream.
+type writer struct {
+	stream Stream
+	seq    uint32
+}
+
+// Writer returns an io.WriteCloser which pushes the data to a writer
+// object. Close finishes the remote stream: it must be called to
+// complete the transfer.
+func Writer(s Stream) io.WriteCloser {
+	return &writer{stream: s}
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		size := len(p) - written
+		if size > ChunkSize {
+			size = ChunkSize
+		}
+		err := w.stream.Push(w.seq, p[written:written+size])
+		if err != nil {
+			return written, err
+		}
+		w.seq++
+		written += size
+	}
+	return written, nil
+}
+
+func (w *writer) Close() error {
+	return w.stream.Finish()
+}
@@ -0,0 +1,282 @@
+package stream
+
+import (
+	"bytes"
+	"fmt"
+	bus "github.com/lugu/qiloop/bus"
+	net "github.com/lugu/qiloop/bus/net"
+	basic "github.com/lugu/qiloop/type/basic"
+	object "github.com/lugu/qiloop/type/object"
+)
+
+// StreamImplementor interface of the service implementation
+type StreamImplementor interface {
+	// Activate is called before any other method.
+	// It shall be used to initialize the interface.
+	// activation provides runtime informations.
+	// activation.Terminate() unregisters the object.
+	// activation.Session can access other services.
+	// helper enables signals and properties updates.
+	// Properties must be initialized using helper,
+	// during the Activate call.
+	Activate(activation bus.Activation, helper StreamSignalHelper) error
+	OnTerminate()
+	Pull(seq uint32, size int32) ([]byte, error)
+	Push(seq uint32, data []byte) error
+	Finish() error
+}
+
+// StreamSignalHelper provided to Stream a companion object
+type StreamSignalHelper interface{}
+
+// stubStream implements server.Actor.
+type stubStream struct {
+	impl      StreamImplementor
+	session   bus.Session
+	service   bus.Service
+	serviceID uint32
+	signal    bus.SignalHandler
+}
+
+// StreamObject returns an object using StreamImplementor
+func StreamObject(impl StreamImplementor) bus.Actor {
+	var stb stubStream
+	stb.impl = impl
+	obj := bus.NewBasicObject(&stb, stb.metaObject(), stb.onPropertyChange)
+	stb.signal = obj
+	return obj
+}
+
+// NewStream registers a new object to a service
+// and returns a proxy to the newly created object
+func (c Constructor) NewStream(service bus.Service, impl StreamImplementor) (StreamProxy, error) {
+	obj := StreamObject(impl)
+	objectID, err := service.Add(obj)
+	if err != nil {
+		return nil, err
+	}
+	stb := &stubStream{}
+	meta := object.FullMetaObject(stb.metaObject())
+	client := bus.DirectClient(obj)
+	proxy := bus.NewProxy(client, meta, service.ServiceID(), objectID)
+	return MakeStream(c.session, proxy), nil
+}
+func (p *stubStream) Activate(activation bus.Activation) error {
+	p.session = activation.Session
+	p.service = activation.Service
+	p.serviceID = activation.ServiceID
+	return p.impl.Activate(activation, p)
+}
+func (p *stubStream) OnTerminate() {
+	p.impl.OnTerminate()
+}
+func (p *stubStream) Receive(msg *net.Message, from bus.Channel) error {
+	// action dispatch
+	switch msg.Header.Action {
+	case 100:
+		return p.Pull(msg, from)
+	case 101:
+		return p.Push(msg, from)
+	case 102:
+		return p.Finish(msg, from)
+	default:
+		return from.SendError(msg, bus.ErrActionNotFound)
+	}
+}
+func (p *stubStream) onPropertyChange(name string, data []byte) error {
+	switch name {
+	default:
+		return fmt.Errorf("unknown property %s", name)
+	}
+}
+func (p *stubStream) Pull(msg *net.Message, c bus.Channel) error {
+	buf := bytes.NewBuffer(msg.Payload)
+	seq, err := basic.ReadUint32(buf)
+	if err != nil {
+		return c.SendError(msg, fmt.Errorf("cannot read seq: %s", err))
+	}
+	size, err := basic.ReadInt32(buf)
+	if err != nil {
+		return c.SendError(msg, fmt.Errorf("cannot read size: %s", err))
+	}
+	ret, callErr := p.impl.Pull(seq, size)
+
+	// do not respond to post messages.
+	if msg.Header.Type == net.Post {
+		return nil
+	}
+	if callErr != nil {
+		return c.SendError(msg, callErr)
+	}
+	var out bytes.Buffer
+	errOut := basic.WriteRaw(ret, &out)
+	if errOut != nil {
+		return c.SendError(msg, fmt.Errorf("cannot write response: %s", errOut))
+	}
+	return c.SendReply(msg, out.Bytes())
+}
+func (p *stubStream) Push(msg *net.Message, c bus.Channel) error {
+	buf := bytes.NewBuffer(msg.Payload)
+	seq, err := basic.ReadUint32(buf)
+	if err != nil {
+		return c.SendError(msg, fmt.Errorf("cannot read seq: %s", err))
+	}
+	data, err := basic.ReadRaw(buf)
+	if err != nil {
+		return c.SendError(msg, fmt.Errorf("cannot read data: %s", err))
+	}
+	callErr := p.impl.Push(seq, data)
+
+	// do not respond to post messages.
+	if msg.Header.Type == net.Post {
+		return nil
+	}
+	if callErr != nil {
+		return c.SendError(msg, callErr)
+	}
+	var out bytes.Buffer
+	return c.SendReply(msg, out.Bytes())
+}
+func (p *stubStream) Finish(msg *net.Message, c bus.Channel) error {
+	callErr := p.impl.Finish()
+
+	// do not respond to post messages.
+	if msg.Header.Type == net.Post {
+		return nil
+	}
+	if callErr != nil {
+		return c.SendError(msg, callErr)
+	}
+	var out bytes.Buffer
+	return c.SendReply(msg, out.Bytes())
+}
+func (p *stubStream) metaObject() object.MetaObject {
+	return object.MetaObject{
+		Description: "Stream",
+		Methods: map[uint32]object.MetaMethod{
+			100: {
+				Name:                "pull",
+				ParametersSignature: "(Ii)",
+				ReturnSignature:     "r",
+				Uid:                 100,
+			},
+			101: {
+				Name:                "push",
+				ParametersSignature: "(Ir)",
+				ReturnSignature:     "v",
+				Uid:                 101,
+			},
+			102: {
+				Name:                "finish",
+				ParametersSignature: "()",
+				ReturnSignature:     "v",
+				Uid:                 102,
+			},
+		},
+		Properties: map[uint32]object.MetaProperty{},
+		Signals:    map[uint32]object.MetaSignal{},
+	}
+}
+
+// Constructor gives access to remote services
+type Constructor struct {
+	session bus.Session
+}
+
+// Services gives access to the services constructor
+func Services(s bus.Session) Constructor {
+	return Constructor{session: s}
+}
+
+// Stream is the abstract interface of the service
+type Stream interface {
+	// Pull calls the remote procedure
+	Pull(seq uint32, size int32) ([]byte, error)
+	// Push calls the remote procedure
+	Push(seq uint32, data []byte) error
+	// Finish calls the remote procedure
+	Finish() error
+}
+
+// StreamProxy represents a proxy object to the service
+type StreamProxy interface {
+	object.Object
+	bus.Proxy
+	Stream
+}
+
+// proxyStream implements StreamProxy
+type proxyStream struct {
+	bus.ObjectProxy
+	session bus.Session
+}
+
+// MakeStream returns a specialized proxy.
+func MakeStream(sess bus.Session, proxy bus.Proxy) StreamProxy {
+	return &proxyStream{bus.MakeObject(proxy), sess}
+}
+
+// Stream returns a proxy to a remote service. A nil closer is accepted.
+func (c Constructor) Stream(closer func(error)) (StreamProxy, error) {
+	proxy, err := c.session.Proxy("Stream", 1)
+	if err != nil {
+		return nil, fmt.Errorf("contact service: %s", err)
+	}
+
+	err = proxy.OnDisconnect(closer)
+	if err != nil {
+		return nil, err
+	}
+	return MakeStream(c.session, proxy), nil
+}
+
+// Pull calls the remote procedure
+func (p *proxyStream) Pull(seq uint32, size int32) ([]byte, error) {
+	var err error
+	var ret []byte
+	var buf bytes.Buffer
+	if err = basic.WriteUint32(seq, &buf); err != nil {
+		return ret, fmt.Errorf("serialize seq: %s", err)
+	}
+	if err = basic.WriteInt32(size, &buf); err != nil {
+		return ret, fmt.Errorf("serialize size: %s", err)
+	}
+	response, err := p.Call("pull", buf.Bytes())
+	if err != nil {
+		return ret, fmt.Errorf("call pull failed: %s", err)
+	}
+	resp := bytes.NewBuffer(response)
+	ret, err = basic.ReadRaw(resp)
+	if err != nil {
+		return ret, fmt.Errorf("parse pull response: %s", err)
+	}
+	return ret, nil
+}
+
+// Push calls the remote procedure
+func (p *proxyStream) Push(seq uint32, data []byte) error {
+	var err error
+	var buf bytes.Buffer
+	if err = basic.WriteUint32(seq, &buf); err != nil {
+		return fmt.Errorf("serialize seq: %s", err)
+	}
+	if err = basic.WriteRaw(data, &buf); err != nil {
+		return fmt.Errorf("serialize data: %s", err)
+	}
+	_, err = p.Call("push", buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("call push failed: %s", err)
+	}
+	return nil
+}
+
+// Finish calls the remote procedure
+func (p *proxyStream) Finish() error {
+	var err error
+	var buf bytes.Buffer
+	_, err = p.Call("finish", buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("call finish failed: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package stream_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/lugu/qiloop/bus"
+	"github.com/lugu/qiloop/bus/net"
+	"github.com/lugu/qiloop/bus/stream"
+	"github.com/lugu/qiloop/bus/util"
+)
+
+// buffer records the data written and when it is closed.
+type buffer struct {
+	bytes.Buffer
+	closed chan struct{}
+}
+
+func (b *buffer) Close() error {
+	close(b.closed)
+	return nil
+}
+
+// largePayload returns more data than a message can carry.
+func largePayload(t *testing.T) []byte {
+	data := make([]byte, 25*1024*1024)
+	rand.New(rand.NewSource(1)).Read(data)
+	if uint32(len(data)) <= net.MaxPayloadSize {
+		t.Fatalf("payload too small")
+	}
+	return data
+}
+
+func newServer(t *testing.T, obj bus.Actor) (bus.Server, stream.StreamProxy) {
+	listener, err := net.Listen(util.NewUnixAddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, err := bus.StandAloneServer(listener, bus.Yes{},
+		bus.PrivateNamespace())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = srv.NewService("Stream", obj)
+	if err != nil {
+		srv.Terminate()
+		t.Fatal(err)
+	}
+	proxy, err := stream.Services(srv.Session()).Stream(nil)
+	if err != nil {
+		srv.Terminate()
+		t.Fatal(err)
+	}
+	return srv, proxy
+}
+
+func TestReader(t *testing.T) {
+	data := largePayload(t)
+	srv, proxy := newServer(t, stream.ReaderObject(
+		ioutil.NopCloser(bytes.NewReader(data))))
+	defer srv.Terminate()
+
+	r := stream.Reader(proxy)
+	received, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, received) {
+		t.Errorf("corrupted data: %d bytes received", len(received))
+	}
+	if err = r.Close(); err != nil {
+		t.Error(err)
+	}
+	if _, err = proxy.Pull(0, 10); err == nil {
+		t.Errorf("expecting an error after finish")
+	}
+}
+
+func TestWriter(t *testing.T) {
+	data := largePayload(t)
+	buf := &buffer{closed: make(chan struct{})}
+	srv, proxy := newServer(t, stream.WriterObject(buf))
+	defer srv.Terminate()
+
+	w := stream.Writer(proxy)
+	if _, err := io.Copy(w, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	<-buf.closed
+	if !bytes.Equal(data, buf.Bytes()) {
+		t.Errorf("corrupted data: %d bytes received", buf.Len())
+	}
+}
+
+func TestSequence(t *testing.T) {
+	srv, proxy := newServer(t, stream.ReaderObject(
+		ioutil.NopCloser(bytes.NewReader([]byte("abcdef")))))
+	defer srv.Terminate()
+
+	if err := proxy.Push(0, []byte("abc")); err == nil {
+		t.Errorf("expecting a direction error")
+	}
+	if _, err := proxy.Pull(1, 3); err == nil {
+		t.Errorf("expecting a sequence error")
+	}
+	if _, err := proxy.Pull(0, int32(stream.MaxChunkSize+1)); err == nil {
+		t.Errorf("expecting a size error")
+	}
+	chunk, err := proxy.Pull(0, 3)
+	if err != nil || string(chunk) != "abc" {
+		t.Fatalf("unexpected chunk: %s, %v", chunk, err)
+	}
+	// the last chunk can be pulled again.
+	chunk, err = proxy.Pull(0, 3)
+	if err != nil || string(chunk) != "abc" {
+		t.Fatalf("unexpected chunk: %s, %v", chunk, err)
+	}
+	chunk, err = proxy.Pull(1, 3)
+	if err != nil || string(chunk) != "def" {
+		t.Fatalf("unexpected chunk: %s, %v", chunk, err)
+	}
+	chunk, err = proxy.Pull(2, 3)
+	if err != nil || len(chunk) != 0 {
+		t.Fatalf("expecting end of stream: %s, %v", chunk, err)
+	}
+}
+
+func TestMaxChunkSize(t *testing.T) {
+	data := largePayload(t)
+	srv, proxy := newServer(t, stream.ReaderObject(
+		ioutil.NopCloser(bytes.NewReader(data))))
+	defer srv.Terminate()
+
+	if _, err := proxy.Pull(0, int32(stream.MaxChunkSize+1)); err == nil {
+		t.Errorf("expecting a size error")
+	}
+	chunk, err := proxy.Pull(0, int32(stream.MaxChunkSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(chunk, data[:stream.MaxChunkSize]) {
+		t.Errorf("corrupted chunk: %d bytes received", len(chunk))
+	}
+}
@@ -0,0 +1,167 @@
+package bus
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// TokenAuthenticator is an Authenticator which issues the tokens of
+// its users. When IssueToken returns a new token, the service server
+// replies StateContinue with the token (KeyNewToken): the client is
+// expected to authenticate again using it.
+type TokenAuthenticator interface {
+	Authenticator
+	// IssueToken returns a new token if the credentials allow the
+	// user to obtain one: on first login or when the token must be
+	// rotated. It returns false otherwise.
+	IssueToken(user, token string) (string, bool)
+}
+
+// MaxPendingTokens is the number of tokens issued to a user and not
+// yet used which are kept. Beyond, the oldest ones are revoked.
+const MaxPendingTokens = 8
+
+// storedToken is the hash of a token issued to a user.
+type storedToken struct {
+	hash   [sha256.Size]byte
+	issued time.Time
+	used   bool
+}
+
+// TokenStore is a TokenAuthenticator which issues random tokens. Only
+// the hash of the tokens are kept in memory.
+type TokenStore struct {
+	// Login authorizes the users to obtain a token (for example a
+	// Dictionary of initial passwords). Yes accepts the first login
+	// of anyone.
+	Login Authenticator
+	// Lifetime is the duration after which a token expires. Zero
+	// never expires the tokens.
+	Lifetime time.Duration
+	// Rotation is the age after which a new token is issued to a
+	// user authenticated with a valid token. Zero never rotates the
+	// tokens.
+	Rotation time.Duration
+	mutex    sync.Mutex
+	tokens   map[string][]storedToken
+}
+
+// NewTokenStore returns a TokenStore issuing tokens to the users
+// accepted by login.
+func NewTokenStore(login Authenticator, lifetime, rotation time.Duration) *TokenStore {
+	return &TokenStore{
+		Login:    login,
+		Lifetime: lifetime,
+		Rotation: rotation,
+		tokens:   make(map[string][]storedToken),
+	}
+}
+
+func hashToken(token string) [sha256.Size]byte {
+	return sha256.Sum256([]byte(token))
+}
+
+// newToken returns a random token.
+func newToken() (string, error) {
+	var buf [32]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf[:]), nil
+}
+
+// expired returns true if the token is expired.
+func (s *TokenStore) expired(t storedToken, now time.Time) bool {
+	return s.Lifetime > 0 && now.Sub(t.issued) >= s.Lifetime
+}
+
+// find returns the index of the valid token of user. The expired
+// tokens are removed. It returns -1 if the token is unknown.
+func (s *TokenStore) find(user, token string) int {
+	now := time.Now()
+	hash := hashToken(token)
+	valid := s.tokens[user][:0]
+	for _, t := range s.tokens[user] {
+		if !s.expired(t, now) {
+			valid = append(valid, t)
+		}
+	}
+	s.tokens[user] = valid
+	for i, t := range valid {
+		if subtle.ConstantTimeCompare(t.hash[:], hash[:]) == 1 {
+			return i
+		}
+	}
+	return -1
+}
+
+// Authenticate returns true if token is a valid token issued to user.
+// Once a user authenticates with a token, the tokens issued before it
+// are revoked.
+func (s *TokenStore) Authenticate(user, token string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.tokens == nil {
+		s.tokens = make(map[string][]storedToken)
+	}
+	i := s.find(user, token)
+	if i == -1 {
+		return false
+	}
+	s.tokens[user] = s.tokens[user][i:]
+	s.tokens[user][0].used = true
+	return true
+}
+
+// IssueToken issues a new token when the user is authenticated by
+// Login or when its token is older than Rotation.
+func (s *TokenStore) IssueToken(user, token string) (string, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.tokens == nil {
+		s.tokens = make(map[string][]storedToken)
+	}
+	i := s.find(user, token)
+	if i != -1 {
+		if s.Rotation == 0 ||
+			time.Since(s.tokens[user][i].issued) < s.Rotation {
+			return "", false
+		}
+	} else if s.Login == nil || !s.Login.Authenticate(user, token) {
+		return "", false
+	}
+	newToken, err := newToken()
+	if err != nil {
+		return "", false
+	}
+	s.tokens[user] = append(s.tokens[user], storedToken{
+		hash:   hashToken(newToken),
+		issued: time.Now(),
+	})
+	s.revokePending(user)
+	return newToken, true
+}
+
+// revokePending removes the oldest tokens of user never used until
+// MaxPendingTokens remain.
+func (s *TokenStore) revokePending(user string) {
+	pending := 0
+	for _, t := range s.tokens[user] {
+		if !t.used {
+			pending++
+		}
+	}
+	kept := s.tokens[user][:0]
+	for _, t := range s.tokens[user] {
+		if !t.used && pending > MaxPendingTokens {
+			pending--
+			continue
+		}
+		kept = append(kept, t)
+	}
+	s.tokens[user] = kept
+}
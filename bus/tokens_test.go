@@ -0,0 +1,173 @@
+package bus_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/lugu/qiloop/bus"
+	"github.com/lugu/qiloop/bus/net"
+	"github.com/lugu/qiloop/bus/session/token"
+	"github.com/lugu/qiloop/bus/util"
+	"github.com/lugu/qiloop/type/value"
+)
+
+func TestTokenStore(t *testing.T) {
+	login := bus.Dictionary(map[string]string{"nao": "password"})
+	store := bus.NewTokenStore(login, time.Hour, 0)
+
+	if store.Authenticate("nao", "password") {
+		t.Errorf("password accepted as a token")
+	}
+	if _, ok := store.IssueToken("nao", "wrong"); ok {
+		t.Errorf("token issued with a wrong password")
+	}
+	first, ok := store.IssueToken("nao", "password")
+	if !ok || first == "" {
+		t.Fatalf("token not issued")
+	}
+	if !store.Authenticate("nao", first) {
+		t.Errorf("token refused")
+	}
+	if store.Authenticate("other", first) {
+		t.Errorf("token accepted for another user")
+	}
+	if _, ok := store.IssueToken("nao", first); ok {
+		t.Errorf("token renewed before rotation")
+	}
+
+	// using a new token revokes the previous ones.
+	second, ok := store.IssueToken("nao", "password")
+	if !ok || second == first {
+		t.Fatalf("new token not issued")
+	}
+	if !store.Authenticate("nao", first) {
+		t.Errorf("previous token refused before the new one is used")
+	}
+	if !store.Authenticate("nao", second) {
+		t.Errorf("new token refused")
+	}
+	if store.Authenticate("nao", first) {
+		t.Errorf("previous token not revoked")
+	}
+}
+
+func TestTokenStorePending(t *testing.T) {
+	login := bus.Dictionary(map[string]string{"nao": "password"})
+	store := bus.NewTokenStore(login, 0, 0)
+	used, _ := store.IssueToken("nao", "password")
+	if !store.Authenticate("nao", used) {
+		t.Fatalf("token refused")
+	}
+	tokens := make([]string, 0)
+	for i := 0; i < bus.MaxPendingTokens+2; i++ {
+		tok, ok := store.IssueToken("nao", "password")
+		if !ok {
+			t.Fatalf("token not issued")
+		}
+		tokens = append(tokens, tok)
+	}
+	// the oldest tokens never used are revoked.
+	if store.Authenticate("nao", tokens[0]) ||
+		store.Authenticate("nao", tokens[1]) {
+		t.Errorf("oldest pending tokens not revoked")
+	}
+	if !store.Authenticate("nao", used) {
+		t.Errorf("used token revoked")
+	}
+	if !store.Authenticate("nao", tokens[len(tokens)-1]) {
+		t.Errorf("last token refused")
+	}
+}
+
+func TestTokenStoreExpiration(t *testing.T) {
+	store := bus.NewTokenStore(bus.Yes{}, 50*time.Millisecond,
+		20*time.Millisecond)
+	tok, ok := store.IssueToken("nao", "")
+	if !ok {
+		t.Fatalf("first login refused")
+	}
+	if _, ok := store.IssueToken("nao", tok); ok {
+		t.Errorf("token rotated too early")
+	}
+	time.Sleep(30 * time.Millisecond)
+	rotated, ok := store.IssueToken("nao", tok)
+	if !ok || rotated == tok {
+		t.Errorf("token not rotated")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if store.Authenticate("nao", tok) {
+		t.Errorf("expired token accepted")
+	}
+	if !store.Authenticate("nao", rotated) {
+		t.Errorf("rotated token refused")
+	}
+}
+
+func TestTokenIssuance(t *testing.T) {
+	file, err := ioutil.TempFile("", "qiloop-auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+	defer func(name string) {
+		token.AuthFile = name
+	}(token.AuthFile)
+	token.AuthFile = file.Name()
+	if err = token.WriteUserToken("nao", "password"); err != nil {
+		t.Fatal(err)
+	}
+
+	addr := util.NewUnixAddr()
+	listener, err := net.Listen(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	login := bus.Dictionary(map[string]string{"nao": "password"})
+	store := bus.NewTokenStore(login, time.Hour, 0)
+	srv, err := bus.StandAloneServer(listener, store, bus.PrivateNamespace())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Terminate()
+
+	// first login: the server issues a token.
+	cap := bus.PreferedCap("nao", "password")
+	resp := rawAuthenticate(t, addr, cap)
+	if resp[bus.KeyState] != value.Uint(bus.StateContinue) {
+		t.Fatalf("unexpected state: %v", resp[bus.KeyState])
+	}
+	if _, ok := resp[bus.KeyNewToken].(value.StringValue); !ok {
+		t.Fatalf("missing new token: %v", resp)
+	}
+
+	// the client saves the new token.
+	ep, err := net.DialEndPoint(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep.Close()
+	if err = bus.Authenticate(ep); err != nil {
+		t.Fatal(err)
+	}
+	user, tok := token.GetUserToken()
+	if user != "nao" || tok == "password" || tok == "" {
+		t.Fatalf("token not saved: %s, %s", user, tok)
+	}
+
+	// the token is accepted without renewal.
+	ep2, err := net.DialEndPoint(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep2.Close()
+	cap = bus.PreferedCap(user, tok)
+	if err = bus.Authentication(ep2, cap); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cap[bus.KeyNewToken]; ok {
+		t.Errorf("unexpected new token")
+	}
+}
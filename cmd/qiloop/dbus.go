@@ -0,0 +1,57 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/lugu/qiloop/bus/dbusbridge"
+	"github.com/lugu/qiloop/bus/services"
+	"github.com/lugu/qiloop/bus/session"
+)
+
+func dbusBridge(serverURL, dbusAddr, dbusName string, serviceList []string) {
+	sess, err := session.NewSession(serverURL)
+	if err != nil {
+		log.Fatalf("connect: %s", err)
+	}
+	defer sess.Terminate()
+
+	if len(serviceList) == 0 {
+		directory, err := services.Services(sess).ServiceDirectory(nil)
+		if err != nil {
+			log.Fatalf("service directory: %s", err)
+		}
+		list, err := directory.Services()
+		if err != nil {
+			log.Fatalf("list services: %s", err)
+		}
+		for _, info := range list {
+			serviceList = append(serviceList, info.Name)
+		}
+	}
+
+	bridge, err := dbusbridge.NewBridge(sess, dbusAddr)
+	if err != nil {
+		log.Fatalf("D-Bus: %s", err)
+	}
+	defer bridge.Terminate()
+
+	if dbusName != "" {
+		if err := bridge.RequestName(dbusName); err != nil {
+			log.Fatalf("D-Bus: %s", err)
+		}
+	}
+	for _, name := range serviceList {
+		path, err := bridge.Export(name)
+		if err != nil {
+			log.Fatalf("export %s: %s", name, err)
+		}
+		log.Printf("%s exported at %s", name, path)
+	}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	s := <-interrupt
+	log.Printf("%v: quitting.", s)
+}
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/lugu/qiloop/bus/mdns"
+)
+
+func discover(timeout time.Duration) {
+	entries, err := mdns.Browse(timeout)
+	if err != nil {
+		log.Fatalf("discover: %s", err)
+	}
+	Print(entries)
+}
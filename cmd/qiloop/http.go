@@ -0,0 +1,23 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/lugu/qiloop/bus/httpbridge"
+	"github.com/lugu/qiloop/bus/session"
+)
+
+func httpBridge(serverURL, listenAddr string) {
+	sess, err := session.NewSession(serverURL)
+	if err != nil {
+		log.Fatalf("connect: %s", err)
+	}
+	defer sess.Terminate()
+
+	log.Printf("Listening at http://%s", listenAddr)
+	err = http.ListenAndServe(listenAddr, httpbridge.NewBridge(sess))
+	if err != nil {
+		log.Fatalf("HTTP server: %s", err)
+	}
+}
@@ -0,0 +1,10 @@
+package main
+
+import (
+	"github.com/lugu/qiloop/meta/idlgen"
+)
+
+// idlGen writes the IDL of Go interfaces declared in a package.
+func idlGen(pkgDir, idlFileName string, typeNames []string) {
+	idlgen.GenerateIDLFile(pkgDir, idlFileName, typeNames)
+}
@@ -20,7 +20,19 @@ func Print(i interface{}) {
 	fmt.Println(string(json))
 }
 
-func info(serverURL, serviceName string) {
+// serviceState is the description of a service with its health and
+// its metadata. The health is omitted if the directory does not probe
+// the services.
+type serviceState struct {
+	services.ServiceInfo
+	Health   *services.ServiceHealth `json:",omitempty"`
+	Metadata map[string]string       `json:",omitempty"`
+}
+
+// info lists the services or describes the service named serviceName.
+// With tags, it lists the services having the tags whose name matches
+// serviceName as a glob pattern.
+func info(serverURL, serviceName string, tags []string) {
 
 	sess, err := session.NewSession(serverURL)
 	if err != nil {
@@ -28,16 +40,36 @@ func info(serverURL, serviceName string) {
 	}
 	srv := services.Services(sess)
 
-	if serviceName == "" {
+	if serviceName == "" || len(tags) != 0 {
 		directory, err := srv.ServiceDirectory(nil)
 		if err != nil {
 			log.Fatalf("directory creation failed: %s", err)
 		}
-		services, err := directory.Services()
+		var list []services.ServiceInfo
+		if len(tags) == 0 {
+			list, err = directory.Services()
+		} else {
+			list, err = directory.FindServices(services.ServiceFilter{
+				Name: serviceName,
+				Tags: tags,
+			})
+		}
 		if err != nil {
 			log.Fatalf("list services: %s", err)
 		}
-		Print(services)
+		states := make([]serviceState, len(list))
+		for i, info := range list {
+			states[i].ServiceInfo = info
+			health, err := directory.ServiceHealth(info.Name)
+			if err == nil {
+				states[i].Health = &health
+			}
+			metadata, err := directory.ServiceMetadata(info.ServiceId)
+			if err == nil && len(metadata) != 0 {
+				states[i].Metadata = metadata
+			}
+		}
+		Print(states)
 	} else {
 		proxy, err := sess.Proxy(serviceName, 1)
 		if err != nil {
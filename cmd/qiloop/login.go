@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/lugu/qiloop/bus"
+	"github.com/lugu/qiloop/bus/net"
+	"github.com/lugu/qiloop/bus/session/token"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// readToken prompts a token. It is not echoed when read from a
+// terminal.
+func readToken(input *bufio.Reader) string {
+	fmt.Fprint(os.Stderr, "Token: ")
+	if terminal.IsTerminal(int(syscall.Stdin)) {
+		bytePassword, err := terminal.ReadPassword(int(syscall.Stdin))
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			log.Fatalf("token input error: %s", err)
+		}
+		return strings.TrimSpace(string(bytePassword))
+	}
+	secret, err := input.ReadString('\n')
+	if err != nil && secret == "" {
+		log.Fatalf("token input error: %s", err)
+	}
+	return strings.TrimSpace(secret)
+}
+
+// login prompts the user credentials, verifies them with the server
+// and saves them in the profile of the server (or in the default
+// profile).
+func login(serverURL string, defaultProfile bool) {
+	input := bufio.NewReader(os.Stdin)
+	fmt.Fprint(os.Stderr, "User: ")
+	user, err := input.ReadString('\n')
+	if err != nil {
+		log.Fatalf("user input error: %s", err)
+	}
+	creds := token.Credentials{
+		User:    strings.TrimSpace(user),
+		Token:   readToken(input),
+		Profile: token.ProfileKey(serverURL),
+	}
+	if defaultProfile {
+		creds.Profile = token.DefaultProfile
+	}
+
+	endpoint, err := net.DialEndPoint(serverURL)
+	if err != nil {
+		log.Fatalf("connect: %s", err)
+	}
+	defer endpoint.Close()
+	// the token issued by the server, if any, is saved.
+	if err = bus.AuthenticateCredentials(endpoint, &creds); err != nil {
+		log.Fatalf("login: %s", err)
+	}
+	if err = token.WriteProfile(creds); err != nil {
+		log.Fatalf("login: %s", err)
+	}
+	log.Printf("profile %s saved in %s", creds.Profile, token.ProfileFile)
+}
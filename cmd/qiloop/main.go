@@ -3,8 +3,11 @@ package main
 import (
 	"fmt"
 	"log"
+	"time"
 
 	"github.com/integrii/flaggy"
+	"github.com/lugu/qiloop/bus"
+	dir "github.com/lugu/qiloop/bus/directory"
 	"github.com/lugu/qiloop/bus/session/token"
 	asciibot "github.com/mattes/go-asciibot"
 )
@@ -14,13 +17,20 @@ import (
 var version = "0.7"
 
 var (
-	infoCommand   *flaggy.Subcommand
-	logCommand    *flaggy.Subcommand
-	scanCommand   *flaggy.Subcommand
-	proxyCommand  *flaggy.Subcommand
-	stubCommand   *flaggy.Subcommand
-	serverCommand *flaggy.Subcommand
-	traceCommand  *flaggy.Subcommand
+	infoCommand     *flaggy.Subcommand
+	logCommand      *flaggy.Subcommand
+	scanCommand     *flaggy.Subcommand
+	proxyCommand    *flaggy.Subcommand
+	stubCommand     *flaggy.Subcommand
+	serverCommand   *flaggy.Subcommand
+	traceCommand    *flaggy.Subcommand
+	idlgenCommand   *flaggy.Subcommand
+	schemaCommand   *flaggy.Subcommand
+	httpCommand     *flaggy.Subcommand
+	dbusCommand     *flaggy.Subcommand
+	discoverCommand *flaggy.Subcommand
+	passwdCommand   *flaggy.Subcommand
+	loginCommand    *flaggy.Subcommand
 
 	serverURL   = "tcp://localhost:9559"
 	serviceName = ""
@@ -29,6 +39,28 @@ var (
 	inputFile   = ""
 	outputFile  = "-"
 	packageName = ""
+	packageDir  = "."
+	typeNames   = []string{}
+	format      = "openapi"
+	httpAddr    = "localhost:8080"
+	serviceList = []string{}
+	dbusName    = ""
+	dbusAddr    = ""
+	advertise   = false
+	tokenLife   = time.Duration(0)
+	passwdFile  = ""
+	policyFile  = ""
+	limits      = bus.Limits{}
+	peers       = []string{}
+	tags        = []string{}
+	defaultProf = false
+	userName    = ""
+	promptToken = false
+	probeProf   = ""
+	removeUser  = false
+	disableUser = false
+	enableUser  = false
+	timeout     = 2 * time.Second
 )
 
 func init() {
@@ -45,6 +77,8 @@ func init() {
 	infoCommand.String(&serverURL, "r", "qi-url", "server URL")
 	infoCommand.String(&serviceName, "s", "service", "optional service name")
 	infoCommand.String(&token.AuthFile, "a", "auth-file", authDescription)
+	infoCommand.StringSlice(&tags, "t", "tag",
+		"list the services with the tag (name as a glob pattern)")
 
 	logCommand = flaggy.NewSubcommand("log")
 	logCommand.Description = "Connect a server and prints logs"
@@ -81,6 +115,30 @@ func init() {
 		"Start a service directory and a log manager"
 	serverCommand.String(&serverURL, "l", "qi-listen-url", "Listening URL")
 	serverCommand.String(&token.AuthFile, "a", "auth-file", authDescription)
+	serverCommand.Bool(&advertise, "m", "mdns",
+		"advertise the server on the local network")
+	serverCommand.Duration(&tokenLife, "k", "token-lifetime",
+		"issue tokens valid for this duration to the clients")
+	serverCommand.String(&passwdFile, "p", "passwd-file",
+		"credential file with hashed tokens (see passwd)")
+	serverCommand.String(&policyFile, "y", "policy",
+		"authorization policy file")
+	serverCommand.Float64(&limits.CallsPerSecond, "r", "rate",
+		"calls per second of each user")
+	serverCommand.Int(&limits.InFlight, "c", "max-calls",
+		"concurrent calls of each user")
+	serverCommand.Int(&limits.Subscriptions, "s", "max-subscriptions",
+		"signal subscriptions of each user")
+	serverCommand.UInt32(&limits.PayloadSize, "z", "max-payload",
+		"largest payload accepted in bytes")
+	serverCommand.StringSlice(&peers, "e", "peer",
+		"mirror the services of a peer directory ([prefix=]url)")
+	serverCommand.Duration(&dir.DefaultHealthCheck.Period, "b", "probe",
+		"probe the services at this interval (disabled by default)")
+	serverCommand.String(&probeProf, "u", "probe-profile",
+		"profile of the credentials of the probes (see login)")
+	serverCommand.Int(&dir.DefaultHealthCheck.Evict, "x", "evict",
+		"unregister the services after this number of failed probes")
 
 	traceCommand = flaggy.NewSubcommand("trace")
 	traceCommand.Description = "Connect a server and traces services"
@@ -89,6 +147,62 @@ func init() {
 	traceCommand.UInt32(&objectID, "o", "object", "optional object id")
 	traceCommand.String(&token.AuthFile, "a", "auth-file", authDescription)
 
+	idlgenCommand = flaggy.NewSubcommand("idlgen")
+	idlgenCommand.Description =
+		"Parse a Go package and generate the IDL of its interfaces"
+	idlgenCommand.String(&packageDir, "p", "pkg", "Go package directory")
+	idlgenCommand.StringSlice(&typeNames, "t", "type", "interface name")
+	idlgenCommand.String(&outputFile, "i", "idl", "output IDL file")
+
+	schemaCommand = flaggy.NewSubcommand("schema")
+	schemaCommand.Description =
+		"Parse an IDL file and generate its JSON Schema or OpenAPI document"
+	schemaCommand.String(&inputFile, "i", "idl", "input IDL file")
+	schemaCommand.String(&outputFile, "o", "output", "output JSON file")
+	schemaCommand.String(&format, "f", "format", "jsonschema or openapi")
+
+	httpCommand = flaggy.NewSubcommand("http")
+	httpCommand.Description =
+		"Connect a server and expose its services over HTTP/JSON"
+	httpCommand.String(&serverURL, "r", "qi-url", "server URL")
+	httpCommand.String(&httpAddr, "l", "http-listen", "HTTP listening address")
+	httpCommand.String(&token.AuthFile, "a", "auth-file", authDescription)
+
+	dbusCommand = flaggy.NewSubcommand("dbus")
+	dbusCommand.Description =
+		"Connect a server and export its services on a D-Bus bus"
+	dbusCommand.String(&serverURL, "r", "qi-url", "server URL")
+	dbusCommand.StringSlice(&serviceList, "s", "service",
+		"service name (default: all services)")
+	dbusCommand.String(&dbusName, "n", "name", "optional D-Bus name")
+	dbusCommand.String(&dbusAddr, "d", "dbus-address",
+		"D-Bus address (default: session bus)")
+	dbusCommand.String(&token.AuthFile, "a", "auth-file", authDescription)
+
+	discoverCommand = flaggy.NewSubcommand("discover")
+	discoverCommand.Description =
+		"Search the service directories of the local network (mDNS)"
+	discoverCommand.Duration(&timeout, "t", "timeout",
+		"duration of the search")
+
+	passwdCommand = flaggy.NewSubcommand("passwd")
+	passwdCommand.Description =
+		"Add, remove, disable or rotate the users of a credential file"
+	passwdCommand.String(&passwdFile, "p", "passwd-file", "credential file")
+	passwdCommand.String(&userName, "u", "user", "user name")
+	passwdCommand.Bool(&promptToken, "t", "token",
+		"prompt the new token (default: random token)")
+	passwdCommand.Bool(&removeUser, "r", "remove", "remove the user")
+	passwdCommand.Bool(&disableUser, "d", "disable", "disable the user")
+	passwdCommand.Bool(&enableUser, "e", "enable", "enable the user")
+
+	loginCommand = flaggy.NewSubcommand("login")
+	loginCommand.Description =
+		"Prompt the credentials of a server and save them in a profile"
+	loginCommand.String(&serverURL, "r", "qi-url", "server URL")
+	loginCommand.Bool(&defaultProf, "d", "default",
+		"save the credentials in the default profile")
+
 	flaggy.AttachSubcommand(infoCommand, 1)
 	flaggy.AttachSubcommand(logCommand, 1)
 	flaggy.AttachSubcommand(scanCommand, 1)
@@ -96,6 +210,13 @@ func init() {
 	flaggy.AttachSubcommand(stubCommand, 1)
 	flaggy.AttachSubcommand(serverCommand, 1)
 	flaggy.AttachSubcommand(traceCommand, 1)
+	flaggy.AttachSubcommand(idlgenCommand, 1)
+	flaggy.AttachSubcommand(schemaCommand, 1)
+	flaggy.AttachSubcommand(httpCommand, 1)
+	flaggy.AttachSubcommand(dbusCommand, 1)
+	flaggy.AttachSubcommand(discoverCommand, 1)
+	flaggy.AttachSubcommand(passwdCommand, 1)
+	flaggy.AttachSubcommand(loginCommand, 1)
 
 	flaggy.DefaultParser.ShowHelpOnUnexpected = true
 	flaggy.SetVersion(version)
@@ -106,7 +227,7 @@ func main() {
 	log.SetFlags(0)
 
 	if infoCommand.Used {
-		info(serverURL, serviceName)
+		info(serverURL, serviceName, tags)
 	} else if scanCommand.Used {
 		scan(serverURL, packageName, serviceName, outputFile)
 	} else if proxyCommand.Used {
@@ -116,9 +237,25 @@ func main() {
 	} else if logCommand.Used {
 		logger(serverURL, logLevel)
 	} else if serverCommand.Used {
-		server(serverURL)
+		server(serverURL, advertise, tokenLife, passwdFile,
+			policyFile, limits, peers, probeProf)
 	} else if traceCommand.Used {
 		trace(serverURL, serviceName, objectID)
+	} else if idlgenCommand.Used {
+		idlGen(packageDir, outputFile, typeNames)
+	} else if schemaCommand.Used {
+		schemaGen(inputFile, outputFile, format)
+	} else if httpCommand.Used {
+		httpBridge(serverURL, httpAddr)
+	} else if dbusCommand.Used {
+		dbusBridge(serverURL, dbusAddr, dbusName, serviceList)
+	} else if discoverCommand.Used {
+		discover(timeout)
+	} else if passwdCommand.Used {
+		passwd(passwdFile, userName, promptToken, removeUser,
+			disableUser, enableUser)
+	} else if loginCommand.Used {
+		login(serverURL, defaultProf)
 	} else {
 		flaggy.DefaultParser.ShowHelpAndExit("missing command")
 	}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/lugu/qiloop/bus"
+)
+
+// randomToken returns a token suitable for a new user.
+func randomToken() string {
+	var buf [16]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		log.Fatalf("generate token: %s", err)
+	}
+	return hex.EncodeToString(buf[:])
+}
+
+// passwd updates the credentials of user in a credential file. Unless
+// the user is removed, disabled or enabled, its token is replaced. If
+// prompt, the token is read from the standard input, otherwise a
+// random token is generated and printed.
+func passwd(filename, user string, prompt, remove, disable, enable bool) {
+	if filename == "" {
+		log.Fatalf("missing credential file")
+	} else if user == "" {
+		log.Fatalf("missing user name")
+	}
+	creds, err := bus.LoadCredentials(filename)
+	if err != nil {
+		log.Fatalf("%s: %s", filename, err)
+	}
+	index := -1
+	for i, c := range creds {
+		if c.User == user {
+			index = i
+		}
+	}
+	switch {
+	case remove || disable || enable:
+		if index == -1 {
+			log.Fatalf("unknown user: %s", user)
+		}
+		if remove {
+			creds = append(creds[:index], creds[index+1:]...)
+		} else {
+			creds[index].Disabled = disable
+		}
+	default:
+		token := randomToken()
+		if prompt {
+			token = readToken(bufio.NewReader(os.Stdin))
+			if token == "" {
+				log.Fatalf("empty token")
+			}
+		} else {
+			fmt.Println(token)
+		}
+		cred, err := bus.NewCredential(user, token)
+		if err != nil {
+			log.Fatalf("%s", err)
+		}
+		if index == -1 {
+			creds = append(creds, cred)
+		} else {
+			creds[index] = cred
+		}
+	}
+	if err = bus.SaveCredentials(filename, creds); err != nil {
+		log.Fatalf("%s", err)
+	}
+}
@@ -0,0 +1,9 @@
+package main
+
+import (
+	"github.com/lugu/qiloop/meta/schema"
+)
+
+func schemaGen(idlFileName, outputFileName, format string) {
+	schema.GenerateSchema(idlFileName, outputFileName, format)
+}
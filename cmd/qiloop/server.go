@@ -4,6 +4,7 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"time"
 
 	"github.com/lugu/qiloop/bus"
 	dir "github.com/lugu/qiloop/bus/directory"
@@ -11,16 +12,73 @@ import (
 	"github.com/lugu/qiloop/bus/session/token"
 )
 
-func server(serverURL string) {
+// probeCredentials returns the credentials of the probes saved in
+// profile.
+func probeCredentials(profile string) token.Credentials {
+	profiles, err := token.ReadProfiles()
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+	creds, ok := profiles[profile]
+	if !ok {
+		log.Fatalf("unknown profile: %s", profile)
+	}
+	return creds
+}
+
+func server(serverURL string, advertise bool, tokenLife time.Duration,
+	passwdFile, policyFile string, limits bus.Limits, peers []string,
+	probeProfile string) {
+
+	if probeProfile != "" {
+		dir.DefaultHealthCheck.Credentials = probeCredentials(probeProfile)
+	}
 	user, token := token.GetUserToken()
-	server, err := dir.NewServer(serverURL, bus.Dictionary(
-		map[string]string{
-			user: token,
-		}))
+	newServer := dir.NewServer
+	if advertise {
+		newServer = dir.NewAdvertisedServer
+	}
+	auth := bus.Dictionary(map[string]string{
+		user: token,
+	})
+	if passwdFile != "" {
+		file, err := bus.NewFileAuthenticator(passwdFile)
+		if err != nil {
+			log.Fatalf("%s", err)
+		}
+		defer file.Close()
+		auth = file
+	}
+	if tokenLife > 0 {
+		// the credentials are exchanged for a token which is
+		// rotated at half of its lifetime.
+		auth = bus.NewTokenStore(auth, tokenLife, tokenLife/2)
+	}
+	var policy bus.Policy
+	if policyFile != "" {
+		var err error
+		policy, err = bus.LoadPolicy(policyFile)
+		if err != nil {
+			log.Fatalf("%s", err)
+		}
+	}
+	server, err := newServer(serverURL, auth)
 	if err != nil {
 		log.Fatalf("Failed to start server: %s", err)
 	}
+	if len(peers) != 0 {
+		server, err = dir.Federate(server, peers)
+		if err != nil {
+			log.Fatalf("Failed to federate: %s", err)
+		}
+	}
 	defer server.Terminate()
+	if policyFile != "" {
+		server.SetAuthorizer(policy)
+	}
+	if limits != (bus.Limits{}) {
+		server.SetLimiter(bus.NewLimiter(bus.Limits{}, limits))
+	}
 
 	_, err = server.NewService("LogManager", qilog.NewLogManager())
 	if err != nil {
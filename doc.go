@@ -73,14 +73,23 @@ import (
 	"github.com/lugu/qiloop/bus"
 	"github.com/lugu/qiloop/bus/directory"
 	"github.com/lugu/qiloop/bus/session"
+	credentials "github.com/lugu/qiloop/bus/session/token"
 )
 
 // NewSession creates a new connection to the service directory
 // located at address addr. Use non empty string if credentials are
-// required, else provide empty strings. Example of address:
-// "tcp://localhost:9559", "tcps://localhost:9443".
+// required, else provide empty strings to read the credentials of
+// the profile of addr. Example of address: "tcp://localhost:9559",
+// "tcps://localhost:9443".
 func NewSession(addr, user, token string) (bus.Session, error) {
-	return session.NewAuthSession(addr, user, token)
+	var opts session.Options
+	if user != "" || token != "" {
+		opts.Credentials = &credentials.Credentials{
+			User:  user,
+			Token: token,
+		}
+	}
+	return session.NewAuthSession(addr, opts)
 }
 
 // Authenticator decides if a user/token tuple is valid. It is used to
@@ -94,7 +103,7 @@ type Authenticator interface {
 // dispatches the message to the services and objects.
 type Server interface {
 	// NewService register a new service to the service directory.
-	NewService(name string, object bus.Actor) (bus.Service, error)
+	NewService(name string, object bus.Actor, options ...bus.ServiceOption) (bus.Service, error)
 	// Session returns a local session object which can be used to
 	// access the server without authentication.
 	Session() bus.Session
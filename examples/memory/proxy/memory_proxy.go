@@ -164,7 +164,7 @@ func (p *proxyALMemory) Subscriber(eventName string) (SubscriberProxy, error) {
 		if err != nil {
 			return nil, fmt.Errorf("get meta: %s", err)
 		}
-		proxy, err := p.session.Object(ref)
+		proxy, err := bus.ObjectFrom(p.session, p.ObjectProxy, ref)
 		if err != nil {
 			return nil, fmt.Errorf("get proxy: %s", err)
 		}
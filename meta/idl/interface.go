@@ -282,7 +282,10 @@ var InterfaceTypeForStub = false
 func (s *InterfaceType) Unmarshal(reader string) *jen.Statement {
 
 	var extra string
+	// the proxies resolve the references with their connection.
+	resolve := `bus.ObjectFrom(p.session, p.ObjectProxy, ref)`
 	if InterfaceTypeForStub {
+		resolve = `p.session.Object(ref)`
 		extra = `
 	    if ref.ServiceID == p.serviceID && ref.ObjectID >= (1<<31) {
 		actor := bus.NewClientObject(ref.ObjectID, c)
@@ -297,7 +300,7 @@ func (s *InterfaceType) Unmarshal(reader string) *jen.Statement {
 	    if err != nil {
 		return nil, fmt.Errorf("get meta: %s", err)
 	    }` + extra + `
-	    proxy, err := p.session.Object(ref)
+	    proxy, err := ` + resolve + `
 	    if err != nil {
 		    return nil, fmt.Errorf("get proxy: %s", err)
 	    }
@@ -28,6 +28,7 @@ func basicType() parsec.Parser {
 		parsec.Atom("uint64", ""),
 		parsec.Atom("bool", ""),
 		parsec.Atom("str", ""),
+		parsec.Atom("raw", ""),
 		parsec.Atom("obj", ""),
 		parsec.Atom("any", ""),
 		parsec.Atom("unknown", ""))
@@ -663,6 +664,8 @@ func nodifyBasicType(nodes []signature.Node) signature.Node {
 		return signature.NewDoubleType()
 	case "str":
 		return signature.NewStringType()
+	case "raw":
+		return signature.NewRawType()
 	case "bool":
 		return signature.NewBoolType()
 	case "any":
@@ -77,6 +77,7 @@ func TestParseBasicType(t *testing.T) {
 	helpParseType(t, "bool", "b")
 	helpParseType(t, "str", "s")
 	helpParseType(t, "any", "m")
+	helpParseType(t, "raw", "r")
 }
 
 func TestParseCompoundType(t *testing.T) {
@@ -87,6 +88,7 @@ func TestParseCompoundType(t *testing.T) {
 	helpParseType(t, "Vec<Tuple<uint64,str>>", "[(Ls)]")
 	helpParseType(t, "Map<str,bool>", "{sb}")
 	helpParseType(t, "Map<float32,any>", "{fm}")
+	helpParseType(t, "Vec<raw>", "[r]")
 }
 
 func TestParseMethod0(t *testing.T) {
@@ -0,0 +1,385 @@
+package idlgen
+
+import (
+	"fmt"
+	"go/ast"
+	"go/types"
+	"io"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/lugu/qiloop/meta/idl"
+	"github.com/lugu/qiloop/meta/signature"
+	"github.com/lugu/qiloop/type/object"
+)
+
+const (
+	valuePkg  = "github.com/lugu/qiloop/type/value"
+	objectPkg = "github.com/lugu/qiloop/type/object"
+)
+
+// Go interfaces are described using the conventions of the generated
+// proxies:
+//
+//	Method(a A, b B) (R, error)                            -> fn method(a: A, b: B) -> R
+//	SubscribeName() (func(), chan T, error)                -> sig name(param: T)
+//	GetName() (T, error) + SetName(T) error + SubscribeName -> prop name(param: T)
+//
+// The error returned by a method is dropped from the IDL signature.
+
+// lowerFirst returns name with its first letter in lower case. It is
+// the inverse of the transformation made by signature.CleanName.
+func lowerFirst(name string) string {
+	r, n := utf8.DecodeRuneInString(name)
+	return string(unicode.ToLower(r)) + name[n:]
+}
+
+// converter translates Go types into signature.Type. Named structures
+// are cached in order to return the same StructType each time they
+// are referenced.
+type converter struct {
+	structs map[*types.Named]*signature.StructType
+	// converting contains the structures whose members are being
+	// converted.
+	converting map[*types.Named]bool
+}
+
+func newConverter() *converter {
+	return &converter{
+		structs:    make(map[*types.Named]*signature.StructType),
+		converting: make(map[*types.Named]bool),
+	}
+}
+
+func isError(t types.Type) bool {
+	return types.Identical(t, types.Universe.Lookup("error").Type())
+}
+
+func basicType(b *types.Basic) (signature.Type, error) {
+	switch b.Kind() {
+	case types.Bool:
+		return signature.NewBoolType(), nil
+	case types.Int8:
+		return signature.NewInt8Type(), nil
+	case types.Uint8:
+		return signature.NewUint8Type(), nil
+	case types.Int16:
+		return signature.NewInt16Type(), nil
+	case types.Uint16:
+		return signature.NewUint16Type(), nil
+	case types.Int32:
+		return signature.NewIntType(), nil
+	case types.Uint32:
+		return signature.NewUintType(), nil
+	case types.Int, types.Int64:
+		return signature.NewLongType(), nil
+	case types.Uint, types.Uint64:
+		return signature.NewULongType(), nil
+	case types.Float32:
+		return signature.NewFloatType(), nil
+	case types.Float64:
+		return signature.NewDoubleType(), nil
+	case types.String:
+		return signature.NewStringType(), nil
+	default:
+		return nil, fmt.Errorf("unsupported basic type: %s", b)
+	}
+}
+
+// Type returns the signature.Type associated with a Go type.
+func (c *converter) Type(t types.Type) (signature.Type, error) {
+	switch typ := t.(type) {
+	case *types.Basic:
+		return basicType(typ)
+	case *types.Slice:
+		if b, ok := typ.Elem().(*types.Basic); ok && b.Kind() == types.Byte {
+			return signature.NewRawType(), nil
+		}
+		elem, err := c.Type(typ.Elem())
+		if err != nil {
+			return nil, fmt.Errorf("slice: %s", err)
+		}
+		return signature.NewListType(elem), nil
+	case *types.Map:
+		key, err := c.Type(typ.Key())
+		if err != nil {
+			return nil, fmt.Errorf("map key: %s", err)
+		}
+		value, err := c.Type(typ.Elem())
+		if err != nil {
+			return nil, fmt.Errorf("map value: %s", err)
+		}
+		return signature.NewMapType(key, value), nil
+	case *types.Interface:
+		return signature.NewObjectType(), nil
+	case *types.Named:
+		return c.named(typ)
+	default:
+		return nil, fmt.Errorf("unsupported type: %s", t)
+	}
+}
+
+func (c *converter) named(typ *types.Named) (signature.Type, error) {
+	obj := typ.Obj()
+	if obj.Pkg() != nil {
+		switch obj.Pkg().Path() + "." + obj.Name() {
+		case valuePkg + ".Value":
+			return signature.NewValueType(), nil
+		case objectPkg + ".ObjectReference":
+			return signature.NewObjectType(), nil
+		case objectPkg + ".MetaObject":
+			return signature.NewMetaObjectType(), nil
+		}
+	}
+	if isError(typ) {
+		return nil, fmt.Errorf("unexpected error type")
+	}
+	switch underlying := typ.Underlying().(type) {
+	case *types.Struct:
+		return c.structure(typ, underlying)
+	case *types.Interface:
+		// interfaces are object references.
+		return signature.NewObjectType(), nil
+	default:
+		// named basic types (ex: enums) are represented by
+		// their underlying type.
+		return c.Type(underlying)
+	}
+}
+
+func (c *converter) structure(typ *types.Named, s *types.Struct) (signature.Type, error) {
+	if c.converting[typ] {
+		return nil, fmt.Errorf("struct %s: recursive type not supported",
+			typ.Obj().Name())
+	}
+	if st, ok := c.structs[typ]; ok {
+		return st, nil
+	}
+	st := signature.NewStructType(typ.Obj().Name(), nil)
+	// a structure reached while converting its members is recursive.
+	c.converting[typ] = true
+	defer delete(c.converting, typ)
+	members := make([]signature.MemberType, 0, s.NumFields())
+	for i := 0; i < s.NumFields(); i++ {
+		field := s.Field(i)
+		if !field.Exported() {
+			continue
+		}
+		if _, ok := field.Type().Underlying().(*types.Pointer); ok {
+			return nil, fmt.Errorf("struct %s: pointer field %s not supported",
+				st.Name, field.Name())
+		}
+		fieldType, err := c.Type(field.Type())
+		if err != nil {
+			return nil, fmt.Errorf("struct %s, field %s: %s",
+				st.Name, field.Name(), err)
+		}
+		members = append(members, signature.NewMemberType(
+			lowerFirst(field.Name()), fieldType))
+	}
+	st.Members = members
+	c.structs[typ] = st
+	return st, nil
+}
+
+// tuple returns the list of types and names of a Go tuple. If the
+// last element is an error, it is removed from the list.
+func (c *converter) tuple(t *types.Tuple, dropError bool) ([]signature.Type, []string, error) {
+	size := t.Len()
+	if dropError && size > 0 && isError(t.At(size-1).Type()) {
+		size--
+	}
+	list := make([]signature.Type, size)
+	names := make([]string, size)
+	for i := 0; i < size; i++ {
+		v := t.At(i)
+		typ, err := c.Type(v.Type())
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %s", v.Name(), err)
+		}
+		list[i] = typ
+		names[i] = v.Name()
+	}
+	return list, names, nil
+}
+
+// subscription returns the type of the event if sig looks like a
+// SubscribeXXX method.
+func subscription(sig *types.Signature) (types.Type, bool) {
+	if sig.Params().Len() != 0 || sig.Results().Len() != 3 {
+		return nil, false
+	}
+	cancel, ok := sig.Results().At(0).Type().(*types.Signature)
+	if !ok || cancel.Params().Len() != 0 || cancel.Results().Len() != 0 {
+		return nil, false
+	}
+	ch, ok := sig.Results().At(1).Type().(*types.Chan)
+	if !ok || !isError(sig.Results().At(2).Type()) {
+		return nil, false
+	}
+	return ch.Elem(), true
+}
+
+type method struct {
+	name string
+	sig  *types.Signature
+}
+
+// methods returns the methods of an interface in the order of their
+// declaration.
+func methods(itf *types.Interface) []method {
+	funcs := make([]*types.Func, itf.NumMethods())
+	for i := range funcs {
+		funcs[i] = itf.Method(i)
+	}
+	sort.SliceStable(funcs, func(i, j int) bool {
+		return funcs[i].Pos() < funcs[j].Pos()
+	})
+	list := make([]method, len(funcs))
+	for i, f := range funcs {
+		list[i] = method{f.Name(), f.Type().(*types.Signature)}
+	}
+	return list
+}
+
+// isProperty returns true if the interface has a getter and a setter
+// whose types matches the subscription of the property.
+func isProperty(name string, typ types.Type, list []method) bool {
+	var getter, setter bool
+	for _, m := range list {
+		switch m.name {
+		case "Get" + name:
+			res := m.sig.Results()
+			getter = m.sig.Params().Len() == 0 && res.Len() == 2 &&
+				types.Identical(res.At(0).Type(), typ) &&
+				isError(res.At(1).Type())
+		case "Set" + name:
+			params := m.sig.Params()
+			res := m.sig.Results()
+			setter = params.Len() == 1 && res.Len() == 1 &&
+				types.Identical(params.At(0).Type(), typ) &&
+				isError(res.At(0).Type())
+		}
+	}
+	return getter && setter
+}
+
+// MetaObject returns the MetaObject describing the Go interface itf.
+func (c *converter) MetaObject(name string, itf *types.Interface) (object.MetaObject, error) {
+	meta := object.MetaObject{
+		Description: name,
+		Methods:     make(map[uint32]object.MetaMethod),
+		Signals:     make(map[uint32]object.MetaSignal),
+		Properties:  make(map[uint32]object.MetaProperty),
+	}
+	list := methods(itf)
+
+	// first pass: identify signals and properties.
+	properties := make(map[string]bool)
+	skip := make(map[string]bool)
+	for _, m := range list {
+		typ, ok := subscription(m.sig)
+		if !ok || !strings.HasPrefix(m.name, "Subscribe") {
+			continue
+		}
+		action := strings.TrimPrefix(m.name, "Subscribe")
+		if isProperty(action, typ, list) {
+			properties[action] = true
+			skip["Get"+action] = true
+			skip["Set"+action] = true
+		}
+	}
+
+	id := uint32(object.MinUserActionID)
+	for _, m := range list {
+		if skip[m.name] {
+			continue
+		}
+		if typ, ok := subscription(m.sig); ok &&
+			strings.HasPrefix(m.name, "Subscribe") {
+
+			action := strings.TrimPrefix(m.name, "Subscribe")
+			sigType, err := c.Type(typ)
+			if err != nil {
+				return meta, fmt.Errorf("%s: %s", m.name, err)
+			}
+			tuple := signature.NewTupleType([]signature.Type{sigType})
+			if properties[action] {
+				meta.Properties[id] = object.MetaProperty{
+					Uid:       id,
+					Name:      lowerFirst(action),
+					Signature: tuple.Signature(),
+				}
+			} else {
+				meta.Signals[id] = object.MetaSignal{
+					Uid:       id,
+					Name:      lowerFirst(action),
+					Signature: tuple.Signature(),
+				}
+			}
+			id++
+			continue
+		}
+		params, names, err := c.tuple(m.sig.Params(), false)
+		if err != nil {
+			return meta, fmt.Errorf("%s parameters: %s", m.name, err)
+		}
+		results, _, err := c.tuple(m.sig.Results(), true)
+		if err != nil {
+			return meta, fmt.Errorf("%s results: %s", m.name, err)
+		}
+		var ret signature.Type
+		switch len(results) {
+		case 0:
+			ret = signature.NewVoidType()
+		case 1:
+			ret = results[0]
+		default:
+			ret = signature.NewTupleType(results)
+		}
+		parameters := make([]object.MetaMethodParameter, len(names))
+		for i, name := range names {
+			parameters[i] = object.MetaMethodParameter{
+				Name: name,
+			}
+		}
+		meta.Methods[id] = object.MetaMethod{
+			Uid:                 id,
+			Name:                lowerFirst(m.name),
+			ReturnSignature:     ret.Signature(),
+			ParametersSignature: signature.NewTupleType(params).Signature(),
+			Parameters:          parameters,
+		}
+		id++
+	}
+	return meta, nil
+}
+
+// GenerateIDL writes the IDL definition of the interfaces named
+// typeNames declared in the type checked package pkg.
+func GenerateIDL(w io.Writer, pkg *types.Package, typeNames []string) error {
+	c := newConverter()
+	metas := make(map[string]object.MetaObject, len(typeNames))
+	for _, name := range typeNames {
+		obj := pkg.Scope().Lookup(name)
+		if obj == nil {
+			return fmt.Errorf("%s not found in package %s", name,
+				pkg.Path())
+		}
+		if !ast.IsExported(name) {
+			return fmt.Errorf("%s is not exported", name)
+		}
+		itf, ok := obj.Type().Underlying().(*types.Interface)
+		if !ok {
+			return fmt.Errorf("%s is not an interface", name)
+		}
+		meta, err := c.MetaObject(name, itf)
+		if err != nil {
+			return fmt.Errorf("interface %s: %s", name, err)
+		}
+		metas[name] = meta
+	}
+	return idl.GenerateIDL(w, pkg.Name(), metas)
+}
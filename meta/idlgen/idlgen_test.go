@@ -0,0 +1,62 @@
+package idlgen_test
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lugu/qiloop/meta/idl"
+	"github.com/lugu/qiloop/meta/idlgen"
+)
+
+func TestGenerateIDL(t *testing.T) {
+	pkg, err := idlgen.LoadPackage(filepath.Join("testdata", "robot"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var w strings.Builder
+	err = idlgen.GenerateIDL(&w, pkg, []string{"Robot"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `package robot
+interface Robot
+	fn say(text: str) //uid:100
+	fn moveTo(target: Position,speed: float64) -> bool //uid:101
+	fn status() -> Map<str,any> //uid:102
+	fn history(limit: uint32) -> Vec<Position> //uid:103
+	fn setMode(mode: int32) //uid:104
+	sig moved(P0: Position) //uid:105
+	prop battery(P0: int32) //uid:106
+end
+struct Position
+	x: float32
+	y: float32
+end
+`
+	if w.String() != expected {
+		t.Errorf("Got:\n->%s<-\nExpecting:\n->%s<-\n", w.String(), expected)
+	}
+
+	decl, err := idl.ParsePackage([]byte(w.String()))
+	if err != nil {
+		t.Fatalf("parse generated IDL: %s", err)
+	}
+	if len(decl.Types) != 2 {
+		t.Errorf("unexpected number of types: %d", len(decl.Types))
+	}
+}
+
+func TestGenerateIDLError(t *testing.T) {
+	pkg, err := idlgen.LoadPackage(filepath.Join("testdata", "robot"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var w strings.Builder
+	for _, name := range []string{"Invalid", "Position", "Unknown", "Recursive"} {
+		err = idlgen.GenerateIDL(&w, pkg, []string{name})
+		if err == nil {
+			t.Errorf("%s: error expected", name)
+		}
+	}
+}
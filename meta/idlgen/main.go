@@ -0,0 +1,71 @@
+package idlgen
+
+import (
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// LoadPackage parses and type checks the Go package located in the
+// directory dir.
+func LoadPackage(dir string) (*types.Package, error) {
+	buildPkg, err := build.ImportDir(dir, 0)
+	if err != nil {
+		return nil, fmt.Errorf("import %s: %s", dir, err)
+	}
+	fset := token.NewFileSet()
+	files := make([]*ast.File, 0, len(buildPkg.GoFiles))
+	for _, name := range buildPkg.GoFiles {
+		path := filepath.Join(dir, name)
+		file, err := parser.ParseFile(fset, path, nil, 0)
+		if err != nil {
+			return nil, fmt.Errorf("parse %s: %s", path, err)
+		}
+		files = append(files, file)
+	}
+	conf := types.Config{
+		Importer: importer.ForCompiler(fset, "source", nil),
+	}
+	path := buildPkg.ImportPath
+	if path == "" || path == "." {
+		path = buildPkg.Name
+	}
+	pkg, err := conf.Check(path, fset, files, nil)
+	if err != nil {
+		return nil, fmt.Errorf("type check %s: %s", dir, err)
+	}
+	return pkg, nil
+}
+
+// GenerateIDLFile loads the Go package in the directory pkgDir and
+// writes the IDL of the interfaces named typeNames into idlFileName.
+func GenerateIDLFile(pkgDir, idlFileName string, typeNames []string) {
+
+	if len(typeNames) == 0 {
+		log.Fatalf("missing interface name")
+	}
+	pkg, err := LoadPackage(pkgDir)
+	if err != nil {
+		log.Fatalf("load %s: %s", pkgDir, err)
+	}
+
+	output := os.Stdout
+	if idlFileName != "-" {
+		output, err = os.Create(idlFileName)
+		if err != nil {
+			log.Fatalf("create %s: %s", idlFileName, err)
+		}
+		defer output.Close()
+	}
+
+	if err := GenerateIDL(output, pkg, typeNames); err != nil {
+		log.Fatalf("generate IDL: %s", err)
+	}
+}
@@ -0,0 +1,44 @@
+package robot
+
+import (
+	"github.com/lugu/qiloop/type/value"
+)
+
+// Position is a 2D position.
+type Position struct {
+	X        float32
+	Y        float32
+	internal int
+}
+
+// Mode is an enum.
+type Mode int32
+
+// Robot is a Go-first service definition.
+type Robot interface {
+	Say(text string) error
+	MoveTo(target Position, speed float64) (bool, error)
+	Status() (map[string]value.Value, error)
+	History(limit uint32) ([]Position, error)
+	SetMode(mode Mode) error
+	SubscribeMoved() (unsubscribe func(), updates chan Position, err error)
+	GetBattery() (int32, error)
+	SetBattery(level int32) error
+	SubscribeBattery() (unsubscribe func(), updates chan int32, err error)
+}
+
+// Invalid uses unsupported types.
+type Invalid interface {
+	Pointer(p *Position) error
+}
+
+// Tree is a recursive structure.
+type Tree struct {
+	Name     string
+	Children []Tree
+}
+
+// Recursive uses a recursive structure.
+type Recursive interface {
+	Root() (Tree, error)
+}
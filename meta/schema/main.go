@@ -0,0 +1,56 @@
+package schema
+
+import (
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/lugu/qiloop/meta/idl"
+)
+
+// GenerateSchema parses an IDL file and writes the JSON Schema of its
+// types (format "jsonschema") or its OpenAPI document (format
+// "openapi") into outputFileName.
+func GenerateSchema(idlFileName, outputFileName, format string) {
+
+	file, err := os.Open(idlFileName)
+	if err != nil {
+		log.Fatalf("open %s: %s", idlFileName, err)
+	}
+	input, err := ioutil.ReadAll(file)
+	file.Close()
+	if err != nil {
+		log.Fatalf("cannot read %s: %s", idlFileName, err)
+	}
+
+	pkg, err := idl.ParsePackage(input)
+	if err != nil {
+		log.Fatalf("parse %s: %s", idlFileName, err)
+	}
+	if len(pkg.Types) == 0 {
+		log.Fatalf("parse error: missing type")
+	}
+
+	var generate func(io.Writer, *idl.PackageDeclaration) error
+	switch format {
+	case "jsonschema":
+		generate = GenerateJSONSchema
+	case "openapi":
+		generate = GenerateOpenAPI
+	default:
+		log.Fatalf("unknown format: %s (jsonschema or openapi)", format)
+	}
+
+	output := os.Stdout
+	if outputFileName != "-" {
+		output, err = os.Create(outputFileName)
+		if err != nil {
+			log.Fatalf("create %s: %s", outputFileName, err)
+		}
+		defer output.Close()
+	}
+	if err := generate(output, pkg); err != nil {
+		log.Fatalf("generate %s: %s", format, err)
+	}
+}
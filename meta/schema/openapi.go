@@ -0,0 +1,241 @@
+package schema
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/lugu/qiloop/meta/idl"
+	"github.com/lugu/qiloop/meta/signature"
+)
+
+func jsonContent(s Schema) map[string]interface{} {
+	return map[string]interface{}{
+		"application/json": map[string]interface{}{
+			"schema": s,
+		},
+	}
+}
+
+// eventContent describes a stream of server-sent events whose data
+// are JSON values matching s.
+func eventContent(s Schema) map[string]interface{} {
+	return map[string]interface{}{
+		"text/event-stream": map[string]interface{}{
+			"schema": Schema{
+				"type":             "string",
+				"contentMediaType": "application/json",
+				"contentSchema":    s,
+			},
+		},
+	}
+}
+
+func (g *generator) errorResponse() map[string]interface{} {
+	if _, ok := g.defs[errorName]; !ok {
+		g.defs[errorName] = Schema{
+			"type": "object",
+			"properties": map[string]Schema{
+				"error": {"type": "string"},
+			},
+			"required": []string{"error"},
+		}
+	}
+	return map[string]interface{}{
+		"description": "error",
+		"content":     jsonContent(g.ref(errorName)),
+	}
+}
+
+func objectParameter() map[string]interface{} {
+	return map[string]interface{}{
+		"name":        "object",
+		"in":          "path",
+		"required":    true,
+		"description": "object id, 1 for the main object of a service",
+		"schema":      integer("uint32", true),
+	}
+}
+
+func (g *generator) method(itf string, m idl.Method) (map[string]interface{}, error) {
+	request, err := g.tuple(params(m.Params))
+	if err != nil {
+		return nil, err
+	}
+	responses := map[string]interface{}{
+		"default": g.errorResponse(),
+	}
+	if m.Return.Signature() == "v" {
+		responses["204"] = map[string]interface{}{
+			"description": "no content",
+		}
+	} else {
+		ret, err := g.Type(m.Return)
+		if err != nil {
+			return nil, err
+		}
+		responses["200"] = map[string]interface{}{
+			"description": "returned value",
+			"content":     jsonContent(ret),
+		}
+	}
+	return map[string]interface{}{
+		"post": map[string]interface{}{
+			"operationId": itf + "." + m.Name,
+			"requestBody": map[string]interface{}{
+				"description": "array of the method parameters",
+				"required":    true,
+				"content":     jsonContent(request),
+			},
+			"responses": responses,
+		},
+	}, nil
+}
+
+func (g *generator) signal(itf string, s idl.Signal) (map[string]interface{}, error) {
+	event, err := g.tuple(params(s.Params))
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"get": map[string]interface{}{
+			"operationId": itf + "." + s.Name,
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "stream of signal events",
+					"content":     eventContent(event),
+				},
+				"default": g.errorResponse(),
+			},
+		},
+	}, nil
+}
+
+func (g *generator) property(itf string, p idl.Property) (map[string]interface{}, error) {
+	value, err := g.Type(p.Type())
+	if err != nil {
+		return nil, err
+	}
+	content := jsonContent(value)
+	for k, v := range eventContent(value) {
+		content[k] = v
+	}
+	return map[string]interface{}{
+		"get": map[string]interface{}{
+			"operationId": itf + ".get" + signature.CleanName(p.Name),
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "property value, or stream of updates with text/event-stream",
+					"content":     content,
+				},
+				"default": g.errorResponse(),
+			},
+		},
+		"put": map[string]interface{}{
+			"operationId": itf + ".set" + signature.CleanName(p.Name),
+			"requestBody": map[string]interface{}{
+				"required": true,
+				"content":  jsonContent(value),
+			},
+			"responses": map[string]interface{}{
+				"204": map[string]interface{}{
+					"description": "property updated",
+				},
+				"default": g.errorResponse(),
+			},
+		},
+	}, nil
+}
+
+func sortedIDs(m map[uint32]bool) []uint32 {
+	ids := make([]uint32, 0, len(m))
+	for id := range m {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// paths returns the operations of an interface. Overloaded actions
+// share a path: only the one with the lowest id is described.
+func (g *generator) paths(itf *idl.InterfaceType, paths map[string]interface{}) error {
+	ids := make(map[uint32]bool)
+	for id := range itf.Methods {
+		ids[id] = true
+	}
+	for id := range itf.Signals {
+		ids[id] = true
+	}
+	for id := range itf.Properties {
+		ids[id] = true
+	}
+	for _, id := range sortedIDs(ids) {
+		var name string
+		var item map[string]interface{}
+		var err error
+		if m, ok := itf.Methods[id]; ok {
+			name = m.Name
+			item, err = g.method(itf.Name, m)
+		} else if s, ok := itf.Signals[id]; ok {
+			name = s.Name
+			item, err = g.signal(itf.Name, s)
+		} else {
+			p := itf.Properties[id]
+			name = p.Name
+			item, err = g.property(itf.Name, p)
+		}
+		if err != nil {
+			return fmt.Errorf("%s.%s: %s", itf.Name, name, err)
+		}
+		path := "/services/" + itf.Name + "/{object}/" + name
+		if _, ok := paths[path]; ok {
+			continue
+		}
+		item["parameters"] = []interface{}{objectParameter()}
+		paths[path] = item
+	}
+	return nil
+}
+
+// OpenAPI returns an OpenAPI document describing the interfaces of
+// the package as HTTP operations.
+func OpenAPI(pkg *idl.PackageDeclaration) (Schema, error) {
+	g := newGenerator("#/components/schemas/")
+	if err := g.declare(pkg); err != nil {
+		return nil, err
+	}
+	paths := make(map[string]interface{})
+	for _, t := range pkg.Types {
+		if itf, ok := t.(*idl.InterfaceType); ok {
+			if err := g.paths(itf, paths); err != nil {
+				return nil, err
+			}
+		}
+	}
+	title := pkg.Name
+	if title == "" {
+		title = "qiloop"
+	}
+	return Schema{
+		"openapi": OpenAPIVersion,
+		"info": map[string]interface{}{
+			"title":   title,
+			"version": "1.0",
+		},
+		"jsonSchemaDialect": JSONSchemaVersion,
+		"paths":             paths,
+		"components": map[string]interface{}{
+			"schemas": g.defs,
+		},
+	}, nil
+}
+
+// GenerateOpenAPI writes the OpenAPI document of the interfaces
+// declared in pkg.
+func GenerateOpenAPI(w io.Writer, pkg *idl.PackageDeclaration) error {
+	doc, err := OpenAPI(pkg)
+	if err != nil {
+		return err
+	}
+	return write(w, doc)
+}
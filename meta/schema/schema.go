@@ -0,0 +1,359 @@
+// Package schema translates IDL packages into JSON Schema and
+// OpenAPI documents.
+//
+// Values are represented in JSON as follows:
+//
+//	bool                        -> boolean
+//	int8 ... uint64             -> integer
+//	float32, float64            -> number
+//	str                         -> string
+//	Vec<T>                      -> array
+//	Map<str,V>, Map<intX,V>     -> object (integer keys as decimal strings)
+//	Map<K,V>                    -> array of [key, value] pairs
+//	Tuple<A,B>                  -> array of fixed length
+//	struct                      -> object
+//	enum                        -> integer
+//	obj and interfaces          -> ObjectReference
+//	any                         -> any JSON value
+//
+// Methods, signals and properties of the interfaces are exposed
+// under /services/{Interface}/{object}/{action}: methods are POST
+// operations taking the array of their parameters, properties are
+// read with GET and written with PUT, signals are streams of
+// server-sent events.
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/lugu/qiloop/meta/idl"
+	"github.com/lugu/qiloop/meta/signature"
+)
+
+const (
+	// JSONSchemaVersion is the dialect of the generated schemas.
+	JSONSchemaVersion = "https://json-schema.org/draft/2020-12/schema"
+	// OpenAPIVersion is the version of the generated OpenAPI
+	// documents. OpenAPI 3.1 shares its schema dialect with
+	// JSON Schema 2020-12.
+	OpenAPIVersion = "3.1.0"
+
+	objectReferenceName = "ObjectReference"
+	errorName           = "Error"
+)
+
+// Schema represents a JSON Schema object.
+type Schema map[string]interface{}
+
+// generator collects the definitions of the named types (structures,
+// enums and object references) while translating types.
+type generator struct {
+	prefix string
+	defs   map[string]Schema
+}
+
+func newGenerator(prefix string) *generator {
+	return &generator{
+		prefix: prefix,
+		defs:   make(map[string]Schema),
+	}
+}
+
+func (g *generator) ref(name string) Schema {
+	return Schema{"$ref": g.prefix + name}
+}
+
+func integer(format string, unsigned bool) Schema {
+	s := Schema{
+		"type":   "integer",
+		"format": format,
+	}
+	if unsigned {
+		s["minimum"] = 0
+	}
+	return s
+}
+
+// basicSchema returns the schema of the types identified by their
+// signature.
+func basicSchema(sig string) (Schema, bool) {
+	switch sig {
+	case "b":
+		return Schema{"type": "boolean"}, true
+	case "c":
+		return integer("int8", false), true
+	case "C":
+		return integer("uint8", true), true
+	case "w":
+		return integer("int16", false), true
+	case "W":
+		return integer("uint16", true), true
+	case "i":
+		return integer("int32", false), true
+	case "I":
+		return integer("uint32", true), true
+	case "l":
+		return integer("int64", false), true
+	case "L":
+		return integer("uint64", true), true
+	case "f":
+		return Schema{"type": "number", "format": "float"}, true
+	case "d":
+		return Schema{"type": "number", "format": "double"}, true
+	case "s":
+		return Schema{"type": "string"}, true
+	case "r":
+		return Schema{"type": "string", "contentEncoding": "base64"}, true
+	case "v":
+		return Schema{"type": "null"}, true
+	case "m":
+		return Schema{"description": "dynamic value"}, true
+	case "X":
+		return Schema{"description": "unknown value"}, true
+	case signature.MetaObjectSignature:
+		return Schema{
+			"type":        "object",
+			"description": "meta object",
+		}, true
+	}
+	return nil, false
+}
+
+func isIntegerKey(sig string) bool {
+	switch sig {
+	case "c", "C", "w", "W", "i", "I", "l", "L":
+		return true
+	}
+	return false
+}
+
+func (g *generator) objectReference() Schema {
+	if _, ok := g.defs[objectReferenceName]; !ok {
+		g.defs[objectReferenceName] = Schema{
+			"type":        "object",
+			"description": "reference to a remote object",
+			"properties": map[string]Schema{
+				"serviceID": integer("uint32", true),
+				"objectID":  integer("uint32", true),
+				"href": {
+					"type":   "string",
+					"format": "uri-reference",
+				},
+			},
+			"required": []string{"serviceID", "objectID"},
+		}
+	}
+	return g.ref(objectReferenceName)
+}
+
+func (g *generator) tuple(types []signature.Type) (Schema, error) {
+	items := make([]Schema, len(types))
+	for i, t := range types {
+		s, err := g.Type(t)
+		if err != nil {
+			return nil, err
+		}
+		items[i] = s
+	}
+	return Schema{
+		"type":        "array",
+		"prefixItems": items,
+		"items":       false,
+		"minItems":    len(items),
+	}, nil
+}
+
+func (g *generator) structure(s *signature.StructType) (Schema, error) {
+	if _, ok := g.defs[s.Name]; ok {
+		return g.ref(s.Name), nil
+	}
+	// register before the members to support recursive types.
+	def := Schema{
+		"type":  "object",
+		"title": s.Name,
+	}
+	g.defs[s.Name] = def
+	properties := make(map[string]Schema, len(s.Members))
+	required := make([]string, len(s.Members))
+	for i, m := range s.Members {
+		member, err := g.Type(m.Type)
+		if err != nil {
+			return nil, fmt.Errorf("struct %s, member %s: %s",
+				s.Name, m.Name, err)
+		}
+		properties[m.Name] = member
+		required[i] = m.Name
+	}
+	def["properties"] = properties
+	def["required"] = required
+	return g.ref(s.Name), nil
+}
+
+func (g *generator) enum(e *signature.EnumType) Schema {
+	if _, ok := g.defs[e.Name]; ok {
+		return g.ref(e.Name)
+	}
+	names := make([]string, 0, len(e.Values))
+	for name := range e.Values {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if e.Values[names[i]] == e.Values[names[j]] {
+			return names[i] < names[j]
+		}
+		return e.Values[names[i]] < e.Values[names[j]]
+	})
+	values := make([]int, len(names))
+	for i, name := range names {
+		values[i] = e.Values[name]
+	}
+	g.defs[e.Name] = Schema{
+		"type":            "integer",
+		"format":          "int32",
+		"title":           e.Name,
+		"enum":            values,
+		"x-enum-varnames": names,
+	}
+	return g.ref(e.Name)
+}
+
+// Type returns the schema of the JSON representation of t.
+func (g *generator) Type(t signature.Type) (Schema, error) {
+	switch typ := t.(type) {
+	case *idl.RefType:
+		resolved, err := typ.Scope.Search(typ.Name)
+		if err != nil {
+			return nil, fmt.Errorf("reference %s: %s", typ.Name, err)
+		}
+		return g.Type(resolved)
+	case *idl.InterfaceType:
+		return g.objectReference(), nil
+	case *signature.StructType:
+		return g.structure(typ)
+	case *signature.EnumType:
+		return g.enum(typ), nil
+	case *signature.ListType:
+		elem, err := g.Type(typ.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return Schema{"type": "array", "items": elem}, nil
+	case *signature.MapType:
+		value, err := g.Type(typ.Value())
+		if err != nil {
+			return nil, err
+		}
+		key := typ.Key().Signature()
+		if key == "s" {
+			return Schema{
+				"type":                 "object",
+				"additionalProperties": value,
+			}, nil
+		}
+		if isIntegerKey(key) {
+			return Schema{
+				"type": "object",
+				"propertyNames": Schema{
+					"pattern": "^-?[0-9]+$",
+				},
+				"additionalProperties": value,
+			}, nil
+		}
+		pair, err := g.tuple([]signature.Type{typ.Key(), typ.Value()})
+		if err != nil {
+			return nil, err
+		}
+		return Schema{"type": "array", "items": pair}, nil
+	case *signature.TupleType:
+		types := make([]signature.Type, len(typ.Members))
+		for i, m := range typ.Members {
+			types[i] = m.Type
+		}
+		return g.tuple(types)
+	}
+	sig := t.Signature()
+	if sig == "o" {
+		return g.objectReference(), nil
+	}
+	if s, ok := basicSchema(sig); ok {
+		return s, nil
+	}
+	return nil, fmt.Errorf("unsupported type: %s", t.SignatureIDL())
+}
+
+func params(list []idl.Parameter) []signature.Type {
+	types := make([]signature.Type, len(list))
+	for i, p := range list {
+		types[i] = p.Type
+	}
+	return types
+}
+
+// declare registers the definitions of the types used by the
+// package declarations.
+func (g *generator) declare(pkg *idl.PackageDeclaration) error {
+	for _, t := range pkg.Types {
+		itf, ok := t.(*idl.InterfaceType)
+		if !ok {
+			if _, err := g.Type(t); err != nil {
+				return err
+			}
+			continue
+		}
+		for _, m := range itf.Methods {
+			if _, err := g.Type(m.Return); err != nil {
+				return fmt.Errorf("%s.%s: %s", itf.Name, m.Name, err)
+			}
+			if _, err := g.tuple(params(m.Params)); err != nil {
+				return fmt.Errorf("%s.%s: %s", itf.Name, m.Name, err)
+			}
+		}
+		for _, s := range itf.Signals {
+			if _, err := g.tuple(params(s.Params)); err != nil {
+				return fmt.Errorf("%s.%s: %s", itf.Name, s.Name, err)
+			}
+		}
+		for _, p := range itf.Properties {
+			if _, err := g.Type(p.Type()); err != nil {
+				return fmt.Errorf("%s.%s: %s", itf.Name, p.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func write(w io.Writer, doc interface{}) error {
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode JSON: %s", err)
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// JSONSchema returns a JSON Schema document whose definitions
+// ($defs) contain the structures and enums of the package.
+func JSONSchema(pkg *idl.PackageDeclaration) (Schema, error) {
+	g := newGenerator("#/$defs/")
+	if err := g.declare(pkg); err != nil {
+		return nil, err
+	}
+	return Schema{
+		"$schema": JSONSchemaVersion,
+		"title":   pkg.Name,
+		"$defs":   g.defs,
+	}, nil
+}
+
+// GenerateJSONSchema writes the JSON Schema of the types declared in
+// pkg.
+func GenerateJSONSchema(w io.Writer, pkg *idl.PackageDeclaration) error {
+	doc, err := JSONSchema(pkg)
+	if err != nil {
+		return err
+	}
+	return write(w, doc)
+}
@@ -0,0 +1,168 @@
+package schema_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/lugu/qiloop/meta/idl"
+	"github.com/lugu/qiloop/meta/schema"
+)
+
+const input = `package robot
+interface Robot
+	fn say(text: str, volume: float32) //uid:100
+	fn position() -> Position //uid:101
+	fn battery() -> Battery //uid:102
+	fn joints() -> Map<str,float64> //uid:103
+	fn cells() -> Map<int32,Vec<Position>> //uid:104
+	sig moved(x: float64, y: float64) //uid:105
+	prop mode(param: int32) //uid:106
+end
+interface Battery
+	fn level() -> int8 //uid:100
+end
+struct Position
+	x: float64
+	y: float64
+end
+enum Mode
+	idle = 0
+	walking = 2
+	running = 1
+end
+`
+
+// decode marshals and unmarshals a schema in order to compare it
+// with the JSON representation of the expected value.
+func decode(t *testing.T, doc interface{}) map[string]interface{} {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(doc); err != nil {
+		t.Fatal(err)
+	}
+	var out map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func lookup(t *testing.T, doc interface{}, path ...string) interface{} {
+	for _, key := range path {
+		m, ok := doc.(map[string]interface{})
+		if !ok {
+			t.Fatalf("%v: not an object at %s", path, key)
+		}
+		if doc, ok = m[key]; !ok {
+			t.Fatalf("%v: missing %s", path, key)
+		}
+	}
+	return doc
+}
+
+func expect(t *testing.T, doc interface{}, expected string, path ...string) {
+	t.Helper()
+	var want interface{}
+	if err := json.Unmarshal([]byte(expected), &want); err != nil {
+		t.Fatal(err)
+	}
+	got := lookup(t, doc, path...)
+	if !reflect.DeepEqual(got, want) {
+		data, _ := json.Marshal(got)
+		t.Errorf("%v:\nexpected: %s\nobserved: %s", path, expected, data)
+	}
+}
+
+func parse(t *testing.T) *idl.PackageDeclaration {
+	pkg, err := idl.ParsePackage([]byte(input))
+	if err != nil {
+		t.Fatalf("parse: %s", err)
+	}
+	return pkg
+}
+
+func TestJSONSchema(t *testing.T) {
+	doc, err := schema.JSONSchema(parse(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := decode(t, doc)
+	expect(t, out, `"robot"`, "title")
+	expect(t, out, `{
+		"type": "object",
+		"title": "Position",
+		"properties": {
+			"x": {"type": "number", "format": "double"},
+			"y": {"type": "number", "format": "double"}
+		},
+		"required": ["x", "y"]
+	}`, "$defs", "Position")
+	expect(t, out, `{
+		"type": "integer",
+		"format": "int32",
+		"title": "Mode",
+		"enum": [0, 1, 2],
+		"x-enum-varnames": ["idle", "running", "walking"]
+	}`, "$defs", "Mode")
+	expect(t, out, `"object"`, "$defs", "ObjectReference", "type")
+	if _, ok := lookup(t, out, "$defs").(map[string]interface{})["Robot"]; ok {
+		t.Errorf("unexpected interface definition")
+	}
+}
+
+func TestOpenAPI(t *testing.T) {
+	doc, err := schema.OpenAPI(parse(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := decode(t, doc)
+	expect(t, out, `"3.1.0"`, "openapi")
+	paths := lookup(t, out, "paths").(map[string]interface{})
+	if len(paths) != 8 {
+		t.Errorf("unexpected number of paths: %d", len(paths))
+	}
+	say := []string{"paths", "/services/Robot/{object}/say", "post"}
+	expect(t, out, `{
+		"type": "array",
+		"prefixItems": [
+			{"type": "string"},
+			{"type": "number", "format": "float"}
+		],
+		"items": false,
+		"minItems": 2
+	}`, append(say, "requestBody", "content", "application/json", "schema")...)
+	expect(t, out, `{"description": "no content"}`,
+		append(say, "responses", "204")...)
+
+	expect(t, out, `{"$ref": "#/components/schemas/ObjectReference"}`,
+		"paths", "/services/Robot/{object}/battery", "post", "responses",
+		"200", "content", "application/json", "schema")
+	expect(t, out, `{"type": "object", "additionalProperties": {"type": "number", "format": "double"}}`,
+		"paths", "/services/Robot/{object}/joints", "post", "responses",
+		"200", "content", "application/json", "schema")
+	expect(t, out, `{
+		"type": "object",
+		"propertyNames": {"pattern": "^-?[0-9]+$"},
+		"additionalProperties": {
+			"type": "array",
+			"items": {"$ref": "#/components/schemas/Position"}
+		}
+	}`, "paths", "/services/Robot/{object}/cells", "post", "responses",
+		"200", "content", "application/json", "schema")
+
+	expect(t, out, `"application/json"`, "paths",
+		"/services/Robot/{object}/moved", "get", "responses", "200",
+		"content", "text/event-stream", "schema", "contentMediaType")
+
+	mode := []string{"paths", "/services/Robot/{object}/mode"}
+	expect(t, out, `{"type": "integer", "format": "int32"}`,
+		append(mode, "put", "requestBody", "content", "application/json", "schema")...)
+	expect(t, out, `{"type": "integer", "format": "int32"}`,
+		append(mode, "get", "responses", "200", "content", "application/json", "schema")...)
+
+	expect(t, out, `{"type": "integer", "format": "int8"}`,
+		"paths", "/services/Battery/{object}/level", "post", "responses",
+		"200", "content", "application/json", "schema")
+	expect(t, out, `"object"`, "components", "schemas", "Position", "type")
+}
@@ -34,6 +34,18 @@ func (v stringReader) Read(r io.Reader) ([]byte, error) {
 	return buf.Bytes(), err
 }
 
+type rawReader struct{}
+
+func (v rawReader) Read(r io.Reader) ([]byte, error) {
+	data, err := basic.ReadRaw(r)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	err = basic.WriteRaw(data, &buf)
+	return buf.Bytes(), err
+}
+
 // UnknownReader is a TypeReader which returns an error.
 type UnknownReader string
 
@@ -42,6 +42,7 @@ func basicType() parsec.Parser {
 		parsec.Atom("C", "uint8"),
 		parsec.Atom("w", "int16"),
 		parsec.Atom("W", "uint16"),
+		parsec.Atom("r", "[]byte"),
 	)
 }
 
@@ -103,6 +104,8 @@ func nodifyBasicType(nodes []Node) Node {
 		return NewInt16Type()
 	case "W":
 		return NewUint16Type()
+	case "r":
+		return NewRawType()
 	default:
 		return fmt.Errorf("wrong signature %s", signature)
 	}
@@ -74,6 +74,7 @@ func TestParseBasics(t *testing.T) {
 	testUtil(t, "d", NewDoubleType())
 	testUtil(t, "m", NewValueType())
 	testUtil(t, "X", NewUnknownType())
+	testUtil(t, "r", NewRawType())
 }
 
 func TestParseMultipleString(t *testing.T) {
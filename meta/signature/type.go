@@ -321,6 +321,24 @@ func NewStringType() Type {
 	}
 }
 
+// NewRawType is a contructor for the representation of raw data.
+func NewRawType() Type {
+	return &typeConstructor{
+		signature:    "r",
+		signatureIDL: "raw",
+		typeName:     jen.Index().Byte(),
+		marshal: func(id string, writer string) *Statement {
+			return jen.Qual("github.com/lugu/qiloop/type/basic",
+				"WriteRaw").Call(jen.Id(id), jen.Id(writer))
+		},
+		unmarshal: func(reader string) *Statement {
+			return jen.Qual("github.com/lugu/qiloop/type/basic",
+				"ReadRaw").Call(jen.Id(reader))
+		},
+		reader: rawReader{},
+	}
+}
+
 // NewVoidType is a contructor for the representation of the
 // absence of a return type. Only used in the context of a returned
 // type.
@@ -529,6 +547,11 @@ func (l *ListType) Reader() TypeReader {
 	}
 }
 
+// Elem returns the type of the elements of the list.
+func (l *ListType) Elem() Type {
+	return l.value
+}
+
 // NewMapType is a contructor for the representation of a map.
 func NewMapType(key, value Type) *MapType {
 	return &MapType{key, value}
@@ -587,6 +610,16 @@ func (m *MapType) Reader() TypeReader {
 	}
 }
 
+// Key returns the type of the keys of the map.
+func (m *MapType) Key() Type {
+	return m.key
+}
+
+// Value returns the type of the values of the map.
+func (m *MapType) Value() Type {
+	return m.value
+}
+
 // Marshal returns a statement which represent the code needed to put
 // the variable "id" into the io.Writer "writer" while returning an
 // error.
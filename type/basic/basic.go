@@ -10,6 +10,9 @@ import (
 // MaxStringSize the longest string allowed.
 const MaxStringSize = uint32(10 * 1024 * 1024)
 
+// MaxRawSize the longest raw data allowed.
+const MaxRawSize = uint32(10 * 1024 * 1024)
+
 // ReadN tries and retries to read length bytes from r. Reading length
 // with io.EOF is not considered an error. Forwards io.EOF if nothing
 // was read.
@@ -292,3 +295,41 @@ func WriteString(s string, w io.Writer) error {
 	}
 	return nil
 }
+
+// ReadRaw reads raw data: first the size of the data is read using
+// ReadUint32, then the bytes.
+func ReadRaw(r io.Reader) ([]byte, error) {
+	size, err := ReadUint32(r)
+	if err != nil {
+		return nil, fmt.Errorf("read raw size: %s", err)
+	}
+	if size > MaxRawSize {
+		return nil, fmt.Errorf("invalid raw size: %d", size)
+	}
+	buf := make([]byte, size)
+	if size == 0 {
+		return buf, nil
+	}
+	err = ReadN(r, buf, int(size))
+	if err != nil {
+		return nil, fmt.Errorf("read raw: %s", err)
+	}
+	return buf, nil
+}
+
+// WriteRaw writes raw data: first the size of the data is written
+// using WriteUint32, then the bytes.
+func WriteRaw(b []byte, w io.Writer) error {
+	size := len(b)
+	if size > int(MaxRawSize) {
+		return fmt.Errorf("invalid raw size: %d", size)
+	}
+	err := WriteUint32(uint32(size), w)
+	if err != nil {
+		return fmt.Errorf("write raw size: %s", err)
+	}
+	if size == 0 {
+		return nil
+	}
+	return WriteN(w, b, size)
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"strings"
 
 	"github.com/lugu/qiloop/meta/signature"
 	"github.com/lugu/qiloop/type/basic"
@@ -22,10 +23,13 @@ var (
 	ErrListValueTooLong = errors.New("list value too long")
 	// ErrRawValueTooLong is returned when reading a raw value.
 	ErrRawValueTooLong = errors.New("raw value too long")
+	// ErrMapValueTooLong is returned when reading a map value.
+	ErrMapValueTooLong = errors.New("map value too long")
 )
 
 // Value represents a value whose type in unknown at compile time. The
-// value can be an integer, a float, a boolean, a long or a string.
+// value can be an integer, a float, a boolean, a long, a string, a
+// list, a map, a tuple or a structure.
 // When serialized, the signature of the true type is sent followed by
 // the actual value.
 type Value interface {
@@ -45,33 +49,148 @@ func Bytes(v Value) []byte {
 // NewValue reads a value from a reader. The value is constructed in
 // two times: first the signature of the value is read from the
 // reader, then depending on the actual type, the value is read.
+// Lists, maps, tuples and structures are decoded recursively, other
+// types and unknown signatures are read as OpaqueValue.
 func NewValue(r io.Reader) (Value, error) {
 	s, err := basic.ReadString(r)
 	if err != nil {
 		return nil, fmt.Errorf("value signature: %s", err)
 	}
-	solve := map[string]func(io.Reader) (Value, error){
-		"c":   newInt8,
-		"C":   newUint8,
-		"w":   newInt16,
-		"W":   newUint16,
-		"i":   newInt,
-		"I":   newUint,
-		"l":   newLong,
-		"L":   newUlong,
-		"s":   newString,
-		"b":   newBool,
-		"f":   newFloat,
-		"[m]": newList,
-		"r":   newRaw,
-		"v":   newVoid,
-		"m":   NewValue,
-	}
-	f, ok := solve[s]
-	if ok {
+	if f, ok := basicReader(s); ok {
 		return f(r)
 	}
-	return newOpaque(s, r)
+	switch s {
+	case "[m]":
+		return newList(r)
+	case "r":
+		return newRaw(r)
+	case "o":
+		return newOpaque(s, r)
+	}
+	t, err := signature.Parse(s)
+	if err != nil {
+		return newOpaque(s, r)
+	}
+	return readValue(t, r)
+}
+
+// basicReader returns the function reading the content of a value of
+// basic type.
+func basicReader(sig string) (func(io.Reader) (Value, error), bool) {
+	switch sig {
+	case "c":
+		return newInt8, true
+	case "C":
+		return newUint8, true
+	case "w":
+		return newInt16, true
+	case "W":
+		return newUint16, true
+	case "i":
+		return newInt, true
+	case "I":
+		return newUint, true
+	case "l":
+		return newLong, true
+	case "L":
+		return newUlong, true
+	case "s":
+		return newString, true
+	case "b":
+		return newBool, true
+	case "f":
+		return newFloat, true
+	case "v":
+		return newVoid, true
+	case "m":
+		return NewValue, true
+	}
+	return nil, false
+}
+
+// readValue reads the content of a value of type t.
+func readValue(t signature.Type, r io.Reader) (Value, error) {
+	switch typ := t.(type) {
+	case *signature.ListType:
+		if typ.Elem().Signature() == "m" {
+			return newList(r)
+		}
+		return newTypedList(typ, r)
+	case *signature.MapType:
+		return newMap(typ, r)
+	case *signature.TupleType:
+		return newTuple(typ, r)
+	case *signature.StructType:
+		return newStruct(typ, r)
+	}
+	sig := t.Signature()
+	if f, ok := basicReader(sig); ok {
+		return f(r)
+	}
+	data, err := t.Reader().Read(r)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read value %s: %s", sig, err)
+	}
+	return Opaque(sig, data), nil
+}
+
+// writeMember writes v as a member of type sig: the signature of v
+// is only written if sig is a dynamic value ("m").
+func writeMember(sig string, v Value, w io.Writer) error {
+	if sig == "m" {
+		return v.Write(w)
+	}
+	if v.Signature() != sig {
+		return fmt.Errorf("invalid value type: %s (expecting %s)",
+			v.Signature(), sig)
+	}
+	var buf bytes.Buffer
+	if err := v.Write(&buf); err != nil {
+		return err
+	}
+	if _, err := basic.ReadString(&buf); err != nil {
+		return err
+	}
+	return basic.WriteN(w, buf.Bytes(), buf.Len())
+}
+
+// readMembers reads the content of a list of members.
+func readMembers(members []signature.MemberType, r io.Reader) ([]string,
+	[]Value, error) {
+
+	types := make([]string, len(members))
+	values := make([]Value, len(members))
+	for i, m := range members {
+		v, err := readValue(m.Type, r)
+		if err != nil {
+			return nil, nil, fmt.Errorf("read %s: %s", m.Name, err)
+		}
+		types[i] = m.Type.Signature()
+		values[i] = v
+	}
+	return types, values, nil
+}
+
+// writeMembers writes the content of a list of members.
+func writeMembers(types []string, values []Value, w io.Writer) error {
+	if len(types) != len(values) {
+		return fmt.Errorf("invalid number of members: %d (expecting %d)",
+			len(values), len(types))
+	}
+	for i, v := range values {
+		if err := writeMember(types[i], v, w); err != nil {
+			return fmt.Errorf("write member %d: %s", i, err)
+		}
+	}
+	return nil
+}
+
+func signatures(values []Value) []string {
+	types := make([]string, len(values))
+	for i, v := range values {
+		types[i] = v.Signature()
+	}
+	return types
 }
 
 // OpaqueValue represents a value using a signature and the data.
@@ -575,3 +694,312 @@ func (b VoidValue) Write(w io.Writer) error {
 	}
 	return nil
 }
+
+// TypedListValue represents a list whose elements have the same
+// type.
+type TypedListValue struct {
+	elem   string
+	values []Value
+}
+
+func newTypedList(t *signature.ListType, r io.Reader) (Value, error) {
+	size, err := basic.ReadUint32(r)
+	if err != nil {
+		return nil, err
+	}
+	if size > listValueMaxSize {
+		return nil, ErrListValueTooLong
+	}
+	values := make([]Value, 0, size)
+	for i := uint32(0); i < size; i++ {
+		v, err := readValue(t.Elem(), r)
+		if err != nil {
+			return nil, fmt.Errorf("read %d/%d: %s", i+1, size, err)
+		}
+		values = append(values, v)
+	}
+	return TypedListValue{
+		elem:   t.Elem().Signature(),
+		values: values,
+	}, nil
+}
+
+// ListOf constructs a list of elements of signature elem. If elem is
+// "m", it returns a ListValue.
+func ListOf(elem string, values []Value) Value {
+	if elem == "m" {
+		return List(values)
+	}
+	return TypedListValue{
+		elem:   elem,
+		values: values,
+	}
+}
+
+// Signature returns the signature of the list.
+func (l TypedListValue) Signature() string {
+	return "[" + l.elem + "]"
+}
+
+func (l TypedListValue) Write(w io.Writer) error {
+	if err := basic.WriteString(l.Signature(), w); err != nil {
+		return err
+	}
+	if err := basic.WriteUint32(uint32(len(l.values)), w); err != nil {
+		return err
+	}
+	for i, v := range l.values {
+		if err := writeMember(l.elem, v, w); err != nil {
+			return fmt.Errorf("write element %d: %s", i, err)
+		}
+	}
+	return nil
+}
+
+// Elem returns the signature of the elements.
+func (l TypedListValue) Elem() string {
+	return l.elem
+}
+
+// Value returns the elements of the list.
+func (l TypedListValue) Value() []Value {
+	return l.values
+}
+
+// MapEntry is a key and its associated value.
+type MapEntry struct {
+	Key   Value
+	Value Value
+}
+
+// MapValue represents a map. The order of the entries is preserved.
+type MapValue struct {
+	key     string
+	value   string
+	entries []MapEntry
+}
+
+func newMap(t *signature.MapType, r io.Reader) (Value, error) {
+	size, err := basic.ReadUint32(r)
+	if err != nil {
+		return nil, err
+	}
+	if size > listValueMaxSize {
+		return nil, ErrMapValueTooLong
+	}
+	entries := make([]MapEntry, 0, size)
+	for i := uint32(0); i < size; i++ {
+		k, err := readValue(t.Key(), r)
+		if err != nil {
+			return nil, fmt.Errorf("read key %d/%d: %s", i+1, size, err)
+		}
+		v, err := readValue(t.Value(), r)
+		if err != nil {
+			return nil, fmt.Errorf("read value %d/%d: %s", i+1, size, err)
+		}
+		entries = append(entries, MapEntry{k, v})
+	}
+	return MapValue{
+		key:     t.Key().Signature(),
+		value:   t.Value().Signature(),
+		entries: entries,
+	}, nil
+}
+
+// Map constructs a map whose keys have the signature key and whose
+// values have the signature value.
+func Map(key, value string, entries []MapEntry) Value {
+	return MapValue{
+		key:     key,
+		value:   value,
+		entries: entries,
+	}
+}
+
+// Signature returns the signature of the map.
+func (m MapValue) Signature() string {
+	return "{" + m.key + m.value + "}"
+}
+
+func (m MapValue) Write(w io.Writer) error {
+	if err := basic.WriteString(m.Signature(), w); err != nil {
+		return err
+	}
+	if err := basic.WriteUint32(uint32(len(m.entries)), w); err != nil {
+		return err
+	}
+	for i, e := range m.entries {
+		if err := writeMember(m.key, e.Key, w); err != nil {
+			return fmt.Errorf("write key %d: %s", i, err)
+		}
+		if err := writeMember(m.value, e.Value, w); err != nil {
+			return fmt.Errorf("write value %d: %s", i, err)
+		}
+	}
+	return nil
+}
+
+// Key returns the signature of the keys.
+func (m MapValue) Key() string {
+	return m.key
+}
+
+// Elem returns the signature of the values.
+func (m MapValue) Elem() string {
+	return m.value
+}
+
+// Value returns the entries of the map.
+func (m MapValue) Value() []MapEntry {
+	return m.entries
+}
+
+// Get returns the value associated with key.
+func (m MapValue) Get(key Value) (Value, bool) {
+	var expected bytes.Buffer
+	if err := key.Write(&expected); err != nil {
+		return nil, false
+	}
+	for _, e := range m.entries {
+		var buf bytes.Buffer
+		if err := e.Key.Write(&buf); err != nil {
+			continue
+		}
+		if bytes.Equal(buf.Bytes(), expected.Bytes()) {
+			return e.Value, true
+		}
+	}
+	return nil, false
+}
+
+// TupleValue represents a tuple of values.
+type TupleValue struct {
+	types  []string
+	values []Value
+}
+
+func newTuple(t *signature.TupleType, r io.Reader) (Value, error) {
+	types, values, err := readMembers(t.Members, r)
+	if err != nil {
+		return nil, err
+	}
+	return TupleValue{
+		types:  types,
+		values: values,
+	}, nil
+}
+
+// Tuple constructs a tuple. The type of the members is the type of
+// the values.
+func Tuple(values []Value) Value {
+	return TupleValue{
+		types:  signatures(values),
+		values: values,
+	}
+}
+
+// Signature returns the signature of the tuple.
+func (t TupleValue) Signature() string {
+	return "(" + strings.Join(t.types, "") + ")"
+}
+
+func (t TupleValue) Write(w io.Writer) error {
+	if err := basic.WriteString(t.Signature(), w); err != nil {
+		return err
+	}
+	return writeMembers(t.types, t.values, w)
+}
+
+// Value returns the members of the tuple.
+func (t TupleValue) Value() []Value {
+	return t.values
+}
+
+// StructField is a named member of a structure.
+type StructField struct {
+	Name  string
+	Value Value
+}
+
+// StructValue represents a structure: a tuple whose members are
+// named.
+type StructValue struct {
+	name   string
+	types  []string
+	fields []StructField
+}
+
+func newStruct(t *signature.StructType, r io.Reader) (Value, error) {
+	types, values, err := readMembers(t.Members, r)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %s", t.Name, err)
+	}
+	fields := make([]StructField, len(values))
+	for i, v := range values {
+		fields[i] = StructField{t.Members[i].Name, v}
+	}
+	return StructValue{
+		name:   t.Name,
+		types:  types,
+		fields: fields,
+	}, nil
+}
+
+// Struct constructs a structure. The type of the fields is the type
+// of the values.
+func Struct(name string, fields []StructField) Value {
+	types := make([]string, len(fields))
+	for i, f := range fields {
+		types[i] = f.Value.Signature()
+	}
+	return StructValue{
+		name:   name,
+		types:  types,
+		fields: fields,
+	}
+}
+
+// Signature returns the signature of the structure including its
+// annotation (name and field names).
+func (s StructValue) Signature() string {
+	if len(s.fields) == 0 {
+		return "()<" + s.name + ">"
+	}
+	names := make([]string, len(s.fields))
+	for i, f := range s.fields {
+		names[i] = f.Name
+	}
+	return "(" + strings.Join(s.types, "") + ")<" + s.name + "," +
+		strings.Join(names, ",") + ">"
+}
+
+func (s StructValue) Write(w io.Writer) error {
+	if err := basic.WriteString(s.Signature(), w); err != nil {
+		return err
+	}
+	values := make([]Value, len(s.fields))
+	for i, f := range s.fields {
+		values[i] = f.Value
+	}
+	return writeMembers(s.types, values, w)
+}
+
+// Name returns the name of the structure.
+func (s StructValue) Name() string {
+	return s.name
+}
+
+// Value returns the fields of the structure.
+func (s StructValue) Value() []StructField {
+	return s.fields
+}
+
+// Field returns the value of the field called name.
+func (s StructValue) Field(name string) (Value, bool) {
+	for _, f := range s.fields {
+		if f.Name == name {
+			return f.Value, true
+		}
+	}
+	return nil, false
+}
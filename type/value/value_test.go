@@ -78,6 +78,28 @@ func helpValueWrite(t *testing.T, expected value.Value) {
 	}
 }
 
+// helpOpaqueWrite verifies an opaque value is decoded into a value
+// with the same signature and the same content.
+func helpOpaqueWrite(t *testing.T, expected value.Value) {
+	var buf bytes.Buffer
+	err := expected.Write(&buf)
+	if err != nil {
+		t.Fatalf("write: %s", err)
+	}
+	val, err := value.NewValue(&buf)
+	if err != nil {
+		t.Fatalf("read: %s\n%#v", err, expected)
+	}
+	if val.Signature() != expected.Signature() {
+		t.Fatalf("signature: expecting %s, got %s", expected.Signature(),
+			val.Signature())
+	}
+	if !bytes.Equal(value.Bytes(val), value.Bytes(expected)) {
+		t.Fatalf("content: expecting %#v, got %#v", value.Bytes(expected),
+			value.Bytes(val))
+	}
+}
+
 func TestValueWriteRead(t *testing.T) {
 	helpValueWrite(t, value.Bool(true))
 	helpValueWrite(t, value.Bool(false))
@@ -107,37 +129,63 @@ func TestValueWriteRead(t *testing.T) {
 			value.Int(0),
 		}),
 	}))
-	helpValueWrite(t, value.Opaque("(b)", []byte{1}))
-	helpValueWrite(t, value.Opaque("(b)<Foo,a>", []byte{1}))
-	helpValueWrite(t, value.Opaque("(bi)<Foo,a,b>", []byte{1, 1, 2, 3, 4}))
-	helpValueWrite(t, value.Opaque("(b(i)<Bar,b>)<Foo,a,b>", []byte{1, 1, 2, 3, 4}))
-	helpValueWrite(t, value.Opaque("(s)<Foo,a>", []byte{3, 0, 0, 0, 'a', 'b', 'c'}))
-	helpValueWrite(t, value.Opaque("[(s)<Foo,a>]", []byte{
+	helpValueWrite(t, value.Tuple([]value.Value{
+		value.Int(1),
+		value.String("abc"),
+		value.List([]value.Value{value.Bool(true)}),
+	}))
+	helpValueWrite(t, value.Struct("Foo", []value.StructField{
+		{Name: "a", Value: value.Float(1.5)},
+		{Name: "b", Value: value.Struct("Bar", []value.StructField{
+			{Name: "c", Value: value.Ulong(42)},
+		})},
+	}))
+	helpValueWrite(t, value.Struct("Empty", []value.StructField{}))
+	helpValueWrite(t, value.Map("s", "m", []value.MapEntry{
+		{Key: value.String("a"), Value: value.Int(1)},
+		{Key: value.String("b"), Value: value.String("two")},
+	}))
+	helpValueWrite(t, value.Map("i", "[b]", []value.MapEntry{
+		{Key: value.Int(1), Value: value.ListOf("b", []value.Value{
+			value.Bool(true),
+			value.Bool(false),
+		})},
+	}))
+	helpValueWrite(t, value.ListOf("(is)", []value.Value{
+		value.Tuple([]value.Value{value.Int(1), value.String("a")}),
+		value.Tuple([]value.Value{value.Int(2), value.String("b")}),
+	}))
+	helpOpaqueWrite(t, value.Opaque("(b)", []byte{1}))
+	helpOpaqueWrite(t, value.Opaque("(b)<Foo,a>", []byte{1}))
+	helpOpaqueWrite(t, value.Opaque("(bi)<Foo,a,b>", []byte{1, 1, 2, 3, 4}))
+	helpOpaqueWrite(t, value.Opaque("(b(i)<Bar,b>)<Foo,a,b>", []byte{1, 1, 2, 3, 4}))
+	helpOpaqueWrite(t, value.Opaque("(s)<Foo,a>", []byte{3, 0, 0, 0, 'a', 'b', 'c'}))
+	helpOpaqueWrite(t, value.Opaque("[(s)<Foo,a>]", []byte{
 		3, 0, 0, 0,
 		3, 0, 0, 0, 'a', 'b', 'c',
 		3, 0, 0, 0, 'a', 'b', 'c',
 		3, 0, 0, 0, 'a', 'b', 'c',
 	}))
-	helpValueWrite(t, value.Opaque("[b]", []byte{
+	helpOpaqueWrite(t, value.Opaque("[b]", []byte{
 		0, 0, 0, 0,
 	}))
-	helpValueWrite(t, value.Opaque("[b]", []byte{
+	helpOpaqueWrite(t, value.Opaque("[b]", []byte{
 		5, 0, 0, 0,
 		1, 1, 1, 1, 1,
 	}))
-	helpValueWrite(t, value.Opaque("{ib}", []byte{
+	helpOpaqueWrite(t, value.Opaque("{ib}", []byte{
 		0, 0, 0, 0,
 	}))
-	helpValueWrite(t, value.Opaque("{ib}", []byte{
+	helpOpaqueWrite(t, value.Opaque("{ib}", []byte{
 		1, 0, 0, 0,
 		1, 0, 0, 1, 1,
 	}))
-	helpValueWrite(t, value.Opaque("{ib}", []byte{
+	helpOpaqueWrite(t, value.Opaque("{ib}", []byte{
 		2, 0, 0, 0,
 		1, 0, 0, 1, 0,
 		2, 0, 0, 2, 1,
 	}))
-	helpValueWrite(t, value.Opaque("{ib}", []byte{
+	helpOpaqueWrite(t, value.Opaque("{ib}", []byte{
 		6, 0, 0, 0,
 		1, 0, 0, 1, 0,
 		2, 0, 0, 2, 1,
@@ -146,7 +194,7 @@ func TestValueWriteRead(t *testing.T) {
 		5, 0, 0, 2, 0,
 		6, 0, 0, 2, 1,
 	}))
-	helpValueWrite(t, value.Opaque("{i(s)<Foo,a>}", []byte{
+	helpOpaqueWrite(t, value.Opaque("{i(s)<Foo,a>}", []byte{
 		4, 0, 0, 0,
 		1, 0, 0, 0,
 		3, 0, 0, 0, 'a', 'b', 'c',
@@ -279,3 +327,97 @@ func TestBytes(t *testing.T) {
 		t.Errorf("data does not match")
 	}
 }
+
+func TestStructValue(t *testing.T) {
+	data := []byte{
+		1,
+		3, 0, 0, 0, 'a', 'b', 'c',
+		2, 0, 0, 0,
+		1, 0, 0, 0, 'i', 42, 0, 0, 0,
+		1, 0, 0, 0, 'b', 1,
+	}
+	var buf bytes.Buffer
+	value.Opaque("(bs[m])<Foo,a,b,c>", data).Write(&buf)
+	v, err := value.NewValue(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, ok := v.(value.StructValue)
+	if !ok {
+		t.Fatalf("unexpected value: %#v", v)
+	}
+	if s.Name() != "Foo" || len(s.Value()) != 3 {
+		t.Errorf("unexpected structure: %#v", s)
+	}
+	b, ok := s.Field("b")
+	if !ok || b != value.String("abc") {
+		t.Errorf("unexpected field b: %#v", b)
+	}
+	c, ok := s.Field("c")
+	if !ok || !reflect.DeepEqual(c, value.List([]value.Value{
+		value.Int(42), value.Bool(true),
+	})) {
+		t.Errorf("unexpected field c: %#v", c)
+	}
+	if _, ok := s.Field("d"); ok {
+		t.Errorf("unexpected field d")
+	}
+}
+
+func TestMapValue(t *testing.T) {
+	data := []byte{
+		2, 0, 0, 0,
+		1, 0, 0, 0, 1, 0, 0, 0, 'i', 10, 0, 0, 0,
+		2, 0, 0, 0, 1, 0, 0, 0, 's', 1, 0, 0, 0, 'x',
+	}
+	var buf bytes.Buffer
+	value.Opaque("{Im}", data).Write(&buf)
+	v, err := value.NewValue(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, ok := v.(value.MapValue)
+	if !ok {
+		t.Fatalf("unexpected value: %#v", v)
+	}
+	if m.Key() != "I" || m.Elem() != "m" || len(m.Value()) != 2 {
+		t.Errorf("unexpected map: %#v", m)
+	}
+	if e, ok := m.Get(value.Uint(2)); !ok || e != value.String("x") {
+		t.Errorf("unexpected entry: %#v", e)
+	}
+	if _, ok := m.Get(value.Int(2)); ok {
+		t.Errorf("unexpected entry")
+	}
+}
+
+func TestInvalidMember(t *testing.T) {
+	values := []value.Value{
+		value.Map("s", "i", []value.MapEntry{
+			{Key: value.String("a"), Value: value.String("b")},
+		}),
+		value.ListOf("i", []value.Value{value.Bool(true)}),
+		value.Tuple([]value.Value{
+			value.ListOf("s", []value.Value{value.Int(1)}),
+		}),
+	}
+	for _, v := range values {
+		var buf bytes.Buffer
+		if err := v.Write(&buf); err == nil {
+			t.Errorf("%s: expecting an error", v.Signature())
+		}
+	}
+}
+
+func TestParseTooLong(t *testing.T) {
+	// 50,000,000 elements announced without content.
+	size := []byte{0x80, 0xf0, 0xfa, 0x02}
+	list := append([]byte{3, 0, 0, 0, '[', 'v', ']'}, size...)
+	if _, err := value.NewValue(bytes.NewBuffer(list)); err != value.ErrListValueTooLong {
+		t.Errorf("list: unexpected error: %v", err)
+	}
+	entries := append([]byte{4, 0, 0, 0, '{', 'v', 'v', '}'}, size...)
+	if _, err := value.NewValue(bytes.NewBuffer(entries)); err != value.ErrMapValueTooLong {
+		t.Errorf("map: unexpected error: %v", err)
+	}
+}